@@ -1,6 +1,9 @@
 const int NUM_SLIDERS = 5;
 const int analogInputs[NUM_SLIDERS] = {A0, A1, A2, A3, A4};
 
+// Set to true along with `checksum: crc8` under connection_info in deej's config
+const bool SEND_CHECKSUM = false;
+
 int analogSliderValues[NUM_SLIDERS];
 
 void setup() { 
@@ -34,10 +37,31 @@ void sendSliderValues() {
       builtString += String("|");
     }
   }
+
+  if (SEND_CHECKSUM) {
+    char checksum[4];
+    sprintf(checksum, "*%02X", crc8(builtString));
+    builtString += checksum;
+  }
   
   Serial.println(builtString);
 }
 
+// CRC-8 (polynomial 0x07, starting from 0) of everything in the line, the way deej checks it
+byte crc8(const String& line) {
+  byte crc = 0;
+
+  for (unsigned int i = 0; i < line.length(); i++) {
+    crc ^= line[i];
+
+    for (int bit = 0; bit < 8; bit++) {
+      crc = (crc & 0x80) ? (crc << 1) ^ 0x07 : crc << 1;
+    }
+  }
+
+  return crc;
+}
+
 void printSliderValues() {
   for (int i = 0; i < NUM_SLIDERS; i++) {
     String printedString = String("Slider #") + String(i + 1) + String(": ") + String(analogSliderValues[i]) + String(" mV");
@@ -15,6 +15,16 @@ volatile int lastState = 0;        // To store the last state of the encoder
 volatile bool direction = false;   // false = left, true = right
 volatile bool newDirectionAvailable = false;  // To signal if a new rotation has occurred
 
+// deej says hello ("deej:<version>") when it connects, or when it's looking for boards.
+// Answering with an ID lets it find this board by itself (serial_port: auto)
+const char helloPrefix[] = "deej:";
+const char *boardID = "id:rotary-encoder\n";
+int helloPrefixMatched = 0;  // How much of the prefix has been received so far
+
+// deej may also ping ("ping") to make sure the board is still alive (connection_info.heartbeat_timeout)
+const char pingLine[] = "ping\n";
+int pingMatched = 0;  // How much of the ping has been received so far
+
 void setup() {
   // Set up pins
   pinMode(encoderPinA, INPUT);
@@ -31,6 +41,33 @@ void setup() {
 }
 
 void loop() {
+  // Answer deej's hello with our ID, and its pings with a pong
+  while (Serial.available() > 0) {
+    char received = Serial.read();
+
+    if (received == pingLine[pingMatched]) {
+      pingMatched++;
+    } else {
+      pingMatched = (received == pingLine[0]) ? 1 : 0;
+    }
+
+    if (pingLine[pingMatched] == '\0') {
+      Serial.print("pong\n");
+      pingMatched = 0;
+    }
+
+    if (received == helloPrefix[helloPrefixMatched]) {
+      helloPrefixMatched++;
+    } else {
+      helloPrefixMatched = (received == helloPrefix[0]) ? 1 : 0;
+    }
+
+    if (helloPrefix[helloPrefixMatched] == '\0') {
+      Serial.print(boardID);
+      helloPrefixMatched = 0;
+    }
+  }
+
   // Handle button press and release with debouncing
   if ((millis() - lastDebounceTime) > debounceDelay) {
     if (buttonState == LOW && lastButtonState == HIGH) {
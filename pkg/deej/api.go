@@ -0,0 +1,656 @@
+package deej
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+)
+
+const (
+
+	// how long a long-poll request may hang around before it's answered with nothing new. this stays
+	// below common proxy idle timeouts, which are the reason anyone would be long-polling to begin with
+	defaultPollTimeout = 25 * time.Second
+	maxPollTimeout     = 60 * time.Second
+
+	apiShutdownTimeout = 2 * time.Second
+
+	// no legitimate request body (not even a whole config) comes anywhere near this
+	maxAPIRequestSize = 1 << 20
+)
+
+// apiServer exposes deej's state and events over HTTP, for remotes and other companion apps
+type apiServer struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+	events *eventLog
+	server *http.Server
+
+	// closed when the server stops, to end responses that would otherwise stay open (see handleStatusBar)
+	done chan bool
+}
+
+type pollResponse struct {
+	Events []LoggedEvent `json:"events"`
+
+	// pass this back as "after" on the next poll
+	Next uint64 `json:"next"`
+}
+
+type volumeRequest struct {
+	Volume float32 `json:"volume"`
+}
+
+// muteRequest sets a slider's mute state. without one, the slider's mute is toggled
+type muteRequest struct {
+	Muted *bool `json:"muted"`
+}
+
+// lockRequest locks or unlocks a slider. without one, the slider's lock is toggled
+type lockRequest struct {
+	Locked *bool `json:"locked"`
+}
+
+// testSignalRequest plays a test signal at a slider's level. Signal is "tone" or "pink_noise", and
+// Device optionally picks the output device to play it on
+type testSignalRequest struct {
+	Signal string `json:"signal"`
+	Device string `json:"device"`
+}
+
+// APISlider is a slider as the API shows it
+type APISlider struct {
+	Name    string   `json:"name"`
+	Volume  float32  `json:"volume"`
+	Muted   bool     `json:"muted"`
+	Targets []string `json:"targets"`
+	Virtual bool     `json:"virtual"`
+
+	// how many seconds the slider's boost has left, while it's boosted
+	Boost int `json:"boost,omitempty"`
+
+	// whether the slider ignores the board
+	Locked bool `json:"locked"`
+
+	// the slider's color, for UIs to draw it with
+	Color string `json:"color,omitempty"`
+}
+
+// APIProfiles lists the config's profiles as the API shows them, the default one first
+type APIProfiles struct {
+	Active   string   `json:"active"`
+	Profiles []string `json:"profiles"`
+}
+
+// APISession is an audio session as the API shows it
+type APISession struct {
+	Key    string  `json:"key"`
+	Volume float32 `json:"volume"`
+	Muted  bool    `json:"muted"`
+}
+
+func newAPIServer(deej *Deej, logger *zap.SugaredLogger) *apiServer {
+	logger = logger.Named("api")
+
+	api := &apiServer{
+		deej:   deej,
+		logger: logger,
+		events: newEventLog(),
+	}
+
+	logger.Debug("Created API server instance")
+
+	return api
+}
+
+// start records slider events and serves the API on the given address, until stop is called
+func (api *apiServer) start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", api.requireScope(apiScopeRead, api.handleStatus))
+	mux.HandleFunc("/api/stats", api.requireScope(apiScopeRead, api.handleStats))
+	mux.HandleFunc("/api/statusbar", api.requireScope(apiScopeRead, api.handleStatusBar))
+	mux.HandleFunc("/api/events", api.requireScope(apiScopeRead, api.handlePollEvents))
+	mux.HandleFunc("/api/sessions", api.requireScope(apiScopeRead, api.handleSessions))
+	mux.HandleFunc("/api/sliders", api.requireScope(apiScopeRead, api.handleSliders))
+	mux.HandleFunc("/api/mapping", api.requireScope(apiScopeRead, api.handleMapping))
+	mux.HandleFunc("/api/sliders/", api.handleSlider)
+	mux.HandleFunc("/api/profiles", api.requireScope(apiScopeRead, api.handleProfiles))
+	mux.HandleFunc("/api/profiles/", api.requireScope(apiScopeVolumeControl, api.handleSwitchProfile))
+	mux.HandleFunc("/api/config", api.requireScope(apiScopeConfigWrite, api.handleConfig))
+
+	api.server = &http.Server{Handler: mux}
+	api.done = make(chan bool)
+
+	sliderEvents := api.deej.serial.SubscribeToSliderMoveEvents(PriorityBackground)
+
+	go func() {
+		for event := range sliderEvents.C {
+			api.events.append(event)
+		}
+	}()
+
+	go func() {
+		if err := api.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			api.logger.Warnw("API server stopped unexpectedly", "error", err)
+		}
+	}()
+
+	api.logger.Infow("Serving API",
+		"address", listener.Addr().String(),
+		"tokens", len(api.deej.configManager.getAPITokens()))
+
+	return nil
+}
+
+func (api *apiServer) stop() {
+	if api.server == nil {
+		return
+	}
+
+	close(api.done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+
+	if err := api.server.Shutdown(ctx); err != nil {
+		api.logger.Warnw("Failed to shut down API server", "error", err)
+	}
+}
+
+func (api *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.writeJSON(w, api.deej.Status())
+}
+
+// handleStats serves the performance counters, with latencies in nanoseconds: GET /api/stats
+func (api *apiServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.writeJSON(w, api.deej.Stats())
+}
+
+// handleStatusBar returns the selected slider's state for status bar modules: GET /api/statusbar. With
+// ?follow=true, the response stays open and gets another line whenever that state changes
+func (api *apiServer) handleStatusBar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Query().Get("follow") != "true" {
+		api.writeJSON(w, api.deej.statusBarLine())
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	changed, stopWatching := api.deej.feedback.watch()
+	defer stopWatching()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+
+	encoder := json.NewEncoder(w)
+	var lastSent *StatusBarLine
+
+	for {
+		line := api.deej.statusBarLine()
+
+		// most changes (i.e. another slider moving) don't change what the bar shows
+		if lastSent == nil || line != *lastSent {
+			if err := encoder.Encode(line); err != nil {
+				return
+			}
+
+			flusher.Flush()
+			lastSent = &line
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-api.done:
+			return
+		}
+
+		<-time.After(statusBarMinInterval)
+	}
+}
+
+// handlePollEvents is the long-poll flavor of the event stream: GET /api/events?after=<seq>&timeout=<duration>
+// answers right away if there's anything newer than seq, or as soon as something arrives (or the timeout passes)
+func (api *apiServer) handlePollEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var after uint64
+	if raw := r.URL.Query().Get("after"); raw != "" {
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid after", http.StatusBadRequest)
+			return
+		}
+
+		after = parsed
+	}
+
+	timeout := defaultPollTimeout
+	if raw := r.URL.Query().Get("timeout"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid timeout", http.StatusBadRequest)
+			return
+		}
+
+		if parsed > maxPollTimeout {
+			parsed = maxPollTimeout
+		}
+
+		timeout = parsed
+	}
+
+	events, next := api.events.wait(after, timeout)
+
+	// don't let intermediate proxies hold on to poll responses
+	w.Header().Set("Cache-Control", "no-store")
+	api.writeJSON(w, pollResponse{Events: events, Next: next})
+}
+
+// handleSliders lists every slider in config order, virtual ones included: GET /api/sliders
+func (api *apiServer) handleSliders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sliders := []APISlider{}
+
+	keys, _ := api.deej.configManager.getSliderMappingKeys()
+	for _, key := range keys {
+		if slider, ok := api.slider(key); ok {
+			sliders = append(sliders, slider)
+		}
+	}
+
+	api.writeJSON(w, sliders)
+}
+
+// handleProfiles lists the profiles, and which one is active: GET /api/profiles
+func (api *apiServer) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	active, profiles := api.deej.configManager.getProfiles()
+	api.writeJSON(w, APIProfiles{Active: active, Profiles: profiles})
+}
+
+// handleSwitchProfile makes a profile the active one: POST /api/profiles/<name>. every slider is re-applied
+// with the profile's mappings, like with any other switch
+func (api *apiServer) handleSwitchProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/api/profiles/")
+
+	if _, profiles := api.deej.configManager.getProfiles(); !funk.ContainsString(profiles, name) {
+		http.Error(w, "unknown profile", http.StatusNotFound)
+		return
+	}
+
+	if err := api.deej.configManager.switchProfile(name); err != nil {
+		api.logger.Warnw("Failed to switch profile", "profile", name, "error", err)
+		http.Error(w, "failed to switch profile", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessions lists every audio session deej knows about, ordered by key: GET /api/sessions
+func (api *apiServer) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sessions := []APISession{}
+
+	for _, session := range api.deej.sessions.all() {
+		sessions = append(sessions, APISession{
+			Key:    session.Key(),
+			Volume: session.GetVolume(),
+			Muted:  session.GetMute(),
+		})
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Key < sessions[j].Key })
+
+	api.writeJSON(w, sessions)
+}
+
+// handleMapping tells which sliders control a process: GET /api/mapping?process=<name>
+func (api *apiServer) handleMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	process := r.URL.Query().Get("process")
+	if process == "" {
+		http.Error(w, "process is required", http.StatusBadRequest)
+		return
+	}
+
+	api.writeJSON(w, api.deej.configManager.resolveProcess(process))
+}
+
+// handleSlider routes requests for a single slider by what comes after its key. reading it only takes the read
+// scope, while changing it takes volume_control
+func (api *apiServer) handleSlider(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/api/sliders/")
+
+	// slider keys may have slashes in them too, so only the last part can be an action
+	action := ""
+	if idx := strings.LastIndex(key, "/"); idx != -1 {
+		key, action = key[:idx], key[idx+1:]
+	}
+
+	switch action {
+	case "":
+		api.requireScope(apiScopeRead, func(w http.ResponseWriter, r *http.Request) { api.handleGetSlider(w, r, key) })(w, r)
+	case "volume":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleSetVolume(w, r, key) })(w, r)
+	case "mute":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleSetMute(w, r, key) })(w, r)
+	case "boost":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleBoost(w, r, key) })(w, r)
+	case "preset":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleTogglePreset(w, r, key) })(w, r)
+	case "lock":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleSetLock(w, r, key) })(w, r)
+	case "test_signal":
+		api.requireScope(apiScopeVolumeControl, func(w http.ResponseWriter, r *http.Request) { api.handleTestSignal(w, r, key) })(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleGetSlider returns a single slider: GET /api/sliders/<key>
+func (api *apiServer) handleGetSlider(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slider, ok := api.slider(key)
+	if !ok {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	api.writeJSON(w, slider)
+}
+
+// handleSetVolume moves a slider: POST /api/sliders/<key>/volume with {"volume": 0.5}
+func (api *apiServer) handleSetVolume(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request volumeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&request); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Volume < 0 || request.Volume > 1 {
+		http.Error(w, "volume must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.deej.SetSliderValue(key, request.Volume); err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetMute mutes or unmutes a slider: POST /api/sliders/<key>/mute with {"muted": true}.
+// an empty body toggles it instead, like a mute button on the board
+func (api *apiServer) handleSetMute(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request muteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	mapping, err := api.deej.configManager.getSliderMappingByKey(key)
+	if err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	if request.Muted == nil || *request.Muted != mapping.Muted {
+		api.deej.serial.toggleMute(api.logger, key)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetLock locks or unlocks a slider against the board: POST /api/sliders/<key>/lock with {"locked": true}.
+// without a body, the slider's lock is toggled
+func (api *apiServer) handleSetLock(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request lockRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := api.deej.configManager.getSliderMappingByKey(key); err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	if request.Locked == nil {
+		_, err = api.deej.toggleSliderLock(key)
+	} else {
+		err = api.deej.setSliderLocked(key, *request.Locked)
+	}
+
+	if err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleBoost turns a slider up for a little while, as the config's boost settings say: POST /api/sliders/<key>/boost
+func (api *apiServer) handleBoost(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := api.deej.boosts.boost(key); err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTogglePreset flips a slider between its two presets: POST /api/sliders/<key>/preset. it answers with
+// the slider as it is afterwards
+func (api *apiServer) handleTogglePreset(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if _, err := api.deej.togglePreset(key); err != nil {
+		if errors.Is(err, errNoPresets) {
+			http.Error(w, "slider has no presets", http.StatusConflict)
+			return
+		}
+
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	slider, _ := api.slider(key)
+	api.writeJSON(w, slider)
+}
+
+// handleTestSignal plays a test signal at a slider's level: POST /api/sliders/<key>/test_signal with
+// {"signal": "tone"}. it answers right away, while the signal plays for a couple of seconds
+func (api *apiServer) handleTestSignal(w http.ResponseWriter, r *http.Request, key string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request := testSignalRequest{Signal: testSignalTone}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&request); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if request.Signal != testSignalTone && request.Signal != testSignalPinkNoise {
+		http.Error(w, "signal must be tone or pink_noise", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := api.deej.configManager.getSliderMappingByKey(key); err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	// the only thing left to go wrong is another signal that's still playing
+	if err := api.deej.testSignals.play(key, request.Signal, request.Device); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleConfig reads or replaces the config. the config holds API tokens and other secrets,
+// so reading it takes the same scope as replacing it
+func (api *apiServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.handleReadConfig(w, r)
+	case http.MethodPut:
+		api.handleWriteConfig(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleReadConfig returns the config deej is running with as YAML: GET /api/config
+func (api *apiServer) handleReadConfig(w http.ResponseWriter, r *http.Request) {
+	buf := &bytes.Buffer{}
+	if err := api.deej.configManager.encodeConfig(buf); err != nil {
+		api.logger.Warnw("Failed to encode config for API", "error", err)
+		http.Error(w, "failed to encode config", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(buf.Bytes())
+}
+
+// handleWriteConfig replaces the config file: PUT /api/config with the new config as YAML.
+// it's only written if it decodes cleanly, and the config watcher takes it from there
+func (api *apiServer) handleWriteConfig(w http.ResponseWriter, r *http.Request) {
+	contents, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAPIRequestSize))
+	if err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.deej.configManager.replaceConfigFile(contents); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	api.logger.Info("Config replaced through API")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (api *apiServer) slider(key string) (APISlider, bool) {
+	mapping, err := api.deej.configManager.getSliderMappingByKey(key)
+	if err != nil {
+		return APISlider{}, false
+	}
+
+	return APISlider{
+		Name:    key,
+		Volume:  mapping.Volume,
+		Muted:   mapping.Muted,
+		Targets: mapping.Targets,
+		Virtual: mapping.Virtual,
+		Boost:   api.deej.boosts.remaining(key),
+		Locked:  mapping.Locked,
+		Color:   mapping.Color,
+	}, true
+}
+
+func (api *apiServer) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		api.logger.Warnw("Failed to write API response", "error", err)
+	}
+}
@@ -0,0 +1,154 @@
+package deej
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// what an API token may be used for. every scope includes reading, since a client that can change
+// volumes or the config will want to see what it's doing
+const (
+
+	// deej's state and events, but nothing that changes them (i.e. dashboard widgets)
+	apiScopeRead = "read"
+
+	// moving sliders, on top of reading
+	apiScopeVolumeControl = "volume_control"
+
+	// replacing the config file, on top of reading
+	apiScopeConfigWrite = "config_write"
+)
+
+// APIToken lets an API client in, with the given scopes. Token may refer to a stored secret ("secret:<name>").
+// Clients send it as "Authorization: Bearer <token>", or as a "token" query parameter where they can't set headers
+type APIToken struct {
+	Name   string   `yaml:"name" doc:"What the token is for"`
+	Token  string   `yaml:"token" doc:"The token itself, or secret:<name>"`
+	Scopes []string `yaml:"scopes" doc:"What it may do: read, volume_control, config_write"`
+}
+
+func (t APIToken) allows(scope string) bool {
+	if scope == apiScopeRead {
+		return true
+	}
+
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validAPITokens drops (and complains about) tokens that can't be used, or have scopes deej doesn't know
+func validAPITokens(logger *zap.SugaredLogger, tokens []APIToken) []APIToken {
+	valid := make([]APIToken, 0, len(tokens))
+
+	for idx, token := range tokens {
+		if token.Token == "" {
+			logger.Warnw("Ignoring API token without a token", "token", idx, "name", token.Name)
+			continue
+		}
+
+		if len(token.Scopes) == 0 {
+			logger.Warnw("Ignoring API token without scopes", "token", idx, "name", token.Name)
+			continue
+		}
+
+		known := true
+		for _, scope := range token.Scopes {
+			switch scope {
+			case apiScopeRead, apiScopeVolumeControl, apiScopeConfigWrite:
+			default:
+				logger.Warnw("Ignoring API token with unknown scope", "token", idx, "name", token.Name, "scope", scope)
+				known = false
+			}
+		}
+
+		if known {
+			valid = append(valid, token)
+		}
+	}
+
+	return valid
+}
+
+// openWithoutTokens tells whether requests that need the given scope are let through while the config has no
+// tokens at all. only reading deej's state is: anything that changes something takes a token that allows it,
+// and so does the config, which holds secrets
+func openWithoutTokens(scope string) bool {
+	return scope == apiScopeRead
+}
+
+// requireScope only lets requests through to the handler if they carry a token with the given scope.
+// without any tokens in the config, deej's state can be read by everyone, but nothing else can be done
+func (api *apiServer) requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokens := api.deej.configManager.getAPITokens()
+		if len(tokens) == 0 {
+			if !openWithoutTokens(scope) {
+				http.Error(w, "this needs a token with the "+scope+" scope, and api_tokens has none", http.StatusForbidden)
+				return
+			}
+
+			handler(w, r)
+			return
+		}
+
+		presented := requestToken(r)
+		if presented == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="deej"`)
+			http.Error(w, "missing token", http.StatusUnauthorized)
+			return
+		}
+
+		token, ok := matchAPIToken(api.logger, tokens, presented)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="deej", error="invalid_token"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		if !token.allows(scope) {
+			api.logger.Debugw("Refused API request outside of token's scopes",
+				"token", token.Name,
+				"path", r.URL.Path,
+				"required", scope)
+
+			http.Error(w, "token doesn't have the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// matchAPIToken finds the configured token the request presented. secret references are resolved every time,
+// so changing a token in the keychain takes effect without restarting deej
+func matchAPIToken(logger *zap.SugaredLogger, tokens []APIToken, presented string) (APIToken, bool) {
+	for _, token := range tokens {
+		value, err := resolveSecret(token.Token)
+		if err != nil {
+			logger.Warnw("Failed to resolve API token", "token", token.Name, "error", err)
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(value), []byte(presented)) == 1 {
+			return token, true
+		}
+	}
+
+	return APIToken{}, false
+}
+
+func requestToken(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+
+	return r.URL.Query().Get("token")
+}
@@ -0,0 +1,351 @@
+package deej
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sync"
+	"text/template"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// one line for the selected slider, then the active profile's (if the config has profiles), then one per
+	// slider: "vol:<name>:<percent>:<muted>", and one per boosted slider: "boost:<name>:<seconds left>". with idle
+	// tracking on, another line per slider follows: "idle:<name>:<idle>"
+	defaultBoardFeedbackFormat = "sel:{{.Selected}}\n" +
+		"{{if .Profile}}profile:{{.Profile}}\n{{end}}" +
+		"{{range .Sliders}}vol:{{.Name}}:{{.Percent}}:{{if .Muted}}1{{else}}0{{end}}\n{{end}}" +
+		"{{range .Sliders}}{{if .Boost}}boost:{{.Name}}:{{.Boost}}\n{{end}}{{end}}" +
+		"{{range .Sliders}}{{if .Color}}color:{{.Name}}:{{.Color}}\n{{end}}{{end}}" +
+		"{{if .IdleTracking}}{{range .Sliders}}idle:{{.Name}}:{{if .Idle}}1{{else}}0{{end}}\n{{end}}{{end}}"
+
+	// small boards with slow serial links choke on much more than this
+	defaultBoardFeedbackMinIntervalMs = 100
+
+	// how often sliders' targets are checked for audio, when idle tracking is on
+	idleCheckInterval = time.Second
+)
+
+// BoardState is what gets pushed back to the board, for displays and LED rings.
+// The board feedback format is a text/template that's executed with it
+type BoardState struct {
+
+	// the name of the currently selected slider (the first encoder's, on boards with several)
+	Selected string
+
+	// the name of the slider each encoder has selected, by the encoder's ID
+	Encoders map[int]string
+
+	Sliders []BoardSliderState
+
+	// the active profile's name, only set when the config has profiles
+	Profile string
+
+	// set when the config has an idle timeout, so formats can leave idle states out otherwise
+	IdleTracking bool
+}
+
+// BoardSliderState is a single slider's part of BoardState
+type BoardSliderState struct {
+	Index   int
+	Name    string
+	Volume  float32
+	Percent int
+	Muted   bool
+
+	// set when none of the slider's targets has made a sound for the idle timeout
+	Idle bool
+
+	// how many seconds the slider's boost has left, while it's boosted
+	Boost int
+
+	// set while the slider ignores the board
+	Locked bool
+
+	// the slider's color as "#ff8800", or empty if it has none
+	Color string
+}
+
+// boardFeedback pushes deej's state back to the board whenever it changes. Changes are coalesced,
+// so the board gets the latest state at most once per interval no matter how fast things move
+type boardFeedback struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	// signaled whenever something the board might display changes
+	changed chan bool
+
+	// signaled along with changed, for others that show the same state (i.e. status bars, through the API)
+	watchersLock sync.Mutex
+	watchers     map[chan bool]bool
+
+	// set when the board (re)connects, since it doesn't remember what it was sent before
+	resendLock sync.Mutex
+	resend     bool
+
+	// when each slider's targets last made a sound, and which sliders have been quiet for too long since
+	idleLock   sync.Mutex
+	lastActive map[string]time.Time
+	idle       map[string]bool
+}
+
+func newBoardFeedback(deej *Deej, logger *zap.SugaredLogger) *boardFeedback {
+	logger = logger.Named("board_feedback")
+
+	bf := &boardFeedback{
+		deej:       deej,
+		logger:     logger,
+		changed:    make(chan bool, 1),
+		watchers:   map[chan bool]bool{},
+		lastActive: map[string]time.Time{},
+		idle:       map[string]bool{},
+	}
+
+	logger.Debug("Created board feedback instance")
+
+	return bf
+}
+
+// run watches for state changes and sends them to the board, for as long as deej runs
+func (bf *boardFeedback) run() {
+	sliderEvents := bf.deej.serial.SubscribeToSliderMoveEvents(PriorityFeedback)
+	configReloaded := bf.deej.configManager.SubscribeToChanges()
+
+	go func() {
+		for {
+			select {
+			case <-sliderEvents.C:
+			case <-configReloaded:
+			}
+
+			bf.stateChanged()
+		}
+	}()
+
+	go bf.trackIdle()
+
+	var lastSent string
+	var lastSentAt time.Time
+
+	for range bf.changed {
+		bf.deej.wakeups.record("board_feedback")
+
+		settings := bf.deej.configManager.getBoardFeedback()
+		if !settings.Enabled {
+			continue
+		}
+
+		// rate limit by waiting out the rest of the interval. whatever else changes meanwhile
+		// is picked up by this same send, since the state is rendered only afterwards
+		interval := time.Duration(settings.MinIntervalMs) * time.Millisecond
+		if wait := interval - time.Since(lastSentAt); wait > 0 {
+			<-time.After(wait)
+		}
+
+		// drop the signal for changes we're about to include anyway
+		select {
+		case <-bf.changed:
+		default:
+		}
+
+		message, err := bf.render(settings.Format)
+		if err != nil {
+			bf.logger.Warnw("Failed to render board feedback", "error", err)
+			continue
+		}
+
+		// the board has seen this already, unless it reconnected since
+		if resend := bf.takeResend(); message == lastSent && !resend {
+			continue
+		}
+
+		if err := bf.deej.serial.Write([]byte(message)); err != nil {
+			bf.logger.Debugw("Failed to send board feedback", "error", err)
+			continue
+		}
+
+		lastSent = message
+		lastSentAt = time.Now()
+	}
+}
+
+// trackIdle checks which sliders' targets are making noise, while board feedback has an idle timeout. a slider
+// going idle (or live again) is a state change like any other
+func (bf *boardFeedback) trackIdle() {
+	configReloaded := bf.deej.configManager.SubscribeToChanges()
+
+	for {
+		settings := bf.deej.configManager.getBoardFeedback()
+		if !settings.Enabled || settings.IdleTimeout <= 0 {
+			<-configReloaded
+			continue
+		}
+
+		select {
+		case <-time.After(idleCheckInterval):
+		case <-configReloaded:
+			continue
+		}
+
+		bf.deej.wakeups.record("board_feedback_idle")
+
+		if bf.updateIdle(time.Duration(settings.IdleTimeout) * time.Second) {
+			bf.stateChanged()
+		}
+	}
+}
+
+// updateIdle refreshes every slider's idle state, and tells whether any of them changed
+func (bf *boardFeedback) updateIdle(timeout time.Duration) bool {
+	keys, _ := bf.deej.configManager.getSliderMappingKeys()
+
+	// the sessions are asked before taking the lock, since that can take a while
+	active := map[string]bool{}
+	for _, key := range keys {
+		active[key] = bf.deej.sessions.sliderActive(key)
+	}
+
+	bf.idleLock.Lock()
+	defer bf.idleLock.Unlock()
+
+	now := time.Now()
+	changed := false
+	idle := map[string]bool{}
+
+	for _, key := range keys {
+
+		// sliders are given the whole timeout from when they're first seen, instead of starting out idle
+		if lastActive, ok := bf.lastActive[key]; active[key] || !ok {
+			bf.lastActive[key] = now
+		} else if now.Sub(lastActive) >= timeout {
+			idle[key] = true
+		}
+
+		if idle[key] != bf.idle[key] {
+			changed = true
+		}
+	}
+
+	bf.idle = idle
+
+	return changed
+}
+
+func (bf *boardFeedback) sliderIdle(name string) bool {
+	bf.idleLock.Lock()
+	defer bf.idleLock.Unlock()
+
+	return bf.idle[name]
+}
+
+// stateChanged lets board feedback (and its watchers) know there's something new to send
+func (bf *boardFeedback) stateChanged() {
+	select {
+	case bf.changed <- true:
+	default:
+	}
+
+	bf.watchersLock.Lock()
+	defer bf.watchersLock.Unlock()
+
+	for watcher := range bf.watchers {
+		select {
+		case watcher <- true:
+		default:
+		}
+	}
+}
+
+// watch returns a channel that's signaled whenever the state changes (a burst of changes may signal it once),
+// and a function to stop watching
+func (bf *boardFeedback) watch() (<-chan bool, func()) {
+	watcher := make(chan bool, 1)
+
+	bf.watchersLock.Lock()
+	bf.watchers[watcher] = true
+	bf.watchersLock.Unlock()
+
+	return watcher, func() {
+		bf.watchersLock.Lock()
+		delete(bf.watchers, watcher)
+		bf.watchersLock.Unlock()
+	}
+}
+
+// boardConnected makes sure a freshly connected board gets the current state, even if it didn't change
+func (bf *boardFeedback) boardConnected() {
+	bf.resendLock.Lock()
+	bf.resend = true
+	bf.resendLock.Unlock()
+
+	bf.stateChanged()
+}
+
+func (bf *boardFeedback) takeResend() bool {
+	bf.resendLock.Lock()
+	defer bf.resendLock.Unlock()
+
+	resend := bf.resend
+	bf.resend = false
+
+	return resend
+}
+
+func (bf *boardFeedback) render(format string) (string, error) {
+	tmpl, err := template.New("board_feedback").Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("parse format: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, bf.state()); err != nil {
+		return "", fmt.Errorf("execute format: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+func (bf *boardFeedback) state() BoardState {
+	selected := bf.deej.serial.Encoders().Selections()
+
+	state := BoardState{
+		Selected:     selected[0],
+		Encoders:     selected,
+		Sliders:      []BoardSliderState{},
+		IdleTracking: bf.deej.configManager.getBoardFeedback().IdleTimeout > 0,
+	}
+
+	if active, profiles := bf.deej.configManager.getProfiles(); len(profiles) > 1 {
+		state.Profile = active
+	}
+
+	for idx := 0; idx < bf.deej.configManager.getSliderMappingCount(); idx++ {
+		name, err := bf.deej.configManager.getSliderMappingKeyByIndex(idx)
+		if err != nil {
+			continue
+		}
+
+		mapping, err := bf.deej.configManager.getSliderMappingByKey(name)
+		if err != nil {
+			continue
+		}
+
+		state.Sliders = append(state.Sliders, BoardSliderState{
+			Index:   idx,
+			Name:    name,
+			Volume:  mapping.Volume,
+			Percent: int(math.Round(float64(mapping.Volume) * 100)),
+			Muted:   mapping.Muted,
+			Idle:    bf.sliderIdle(name),
+			Boost:   bf.deej.boosts.remaining(name),
+			Locked:  mapping.Locked,
+			Color:   mapping.Color,
+		})
+	}
+
+	return state
+}
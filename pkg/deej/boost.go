@@ -0,0 +1,186 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// a boost turns a slider up for a little while (i.e. voice chat, during a callout) and then back down
+// on its own, unless the slider was moved in the meantime
+const (
+	defaultBoostAmount  = 0.2
+	defaultBoostSeconds = 10
+)
+
+// activeBoost is a slider's boost in progress: where it was and where the boost took it, and when it ends
+type activeBoost struct {
+	from  float32
+	to    float32
+	until time.Time
+}
+
+// booster runs slider boosts, and counts them down on boards with displays (through the board feedback)
+type booster struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	lock   sync.Mutex
+	boosts map[string]*activeBoost
+}
+
+func newBooster(deej *Deej, logger *zap.SugaredLogger) *booster {
+	logger = logger.Named("boost")
+
+	b := &booster{
+		deej:   deej,
+		logger: logger,
+		boosts: map[string]*activeBoost{},
+	}
+
+	logger.Debug("Created booster instance")
+
+	return b
+}
+
+// boost turns the given slider up by the config's boost amount, for as long as the config says. boosting a slider
+// that's already boosted starts its countdown over, rather than turning it up further
+func (b *booster) boost(sliderID string) error {
+	mapping, err := b.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return fmt.Errorf("get slider mapping: %w", err)
+	}
+
+	settings := b.deej.configManager.getBoost()
+	until := time.Now().Add(time.Duration(settings.Seconds) * time.Second)
+
+	b.lock.Lock()
+	if active, ok := b.boosts[sliderID]; ok {
+		active.until = until
+		b.lock.Unlock()
+
+		b.logger.Debugw("Extended slider boost", "slider", sliderID, "until", until)
+		b.deej.feedback.stateChanged()
+
+		return nil
+	}
+	b.lock.Unlock()
+
+	if err := b.deej.SetSliderValue(sliderID, float32(math.Min(1, float64(mapping.Volume+settings.Amount)))); err != nil {
+		return fmt.Errorf("set slider volume: %w", err)
+	}
+
+	// rules and quantization have their say on the volume, so the boost is wherever the slider ended up
+	boosted, err := b.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return fmt.Errorf("get slider mapping: %w", err)
+	}
+
+	active := &activeBoost{from: mapping.Volume, to: boosted.Volume, until: until}
+
+	b.lock.Lock()
+	b.boosts[sliderID] = active
+	b.lock.Unlock()
+
+	b.logger.Infow("Boosted slider", "slider", sliderID, "from", active.from, "to", active.to, "seconds", settings.Seconds)
+	b.deej.feedback.stateChanged()
+
+	go b.countDown(sliderID, active)
+
+	return nil
+}
+
+// countDown lets the board know how long the boost has left every second, and ends it when it runs out
+func (b *booster) countDown(sliderID string, active *activeBoost) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.lock.Lock()
+
+		// ended early (i.e. deej is stopping)
+		if b.boosts[sliderID] != active {
+			b.lock.Unlock()
+			return
+		}
+
+		if time.Now().Before(active.until) {
+			b.lock.Unlock()
+			b.deej.feedback.stateChanged()
+
+			continue
+		}
+
+		delete(b.boosts, sliderID)
+		b.lock.Unlock()
+
+		b.revert(sliderID, active)
+
+		return
+	}
+}
+
+// revert puts a boosted slider back where it was, unless it was moved while boosted - wherever it was moved to wins
+func (b *booster) revert(sliderID string, active *activeBoost) {
+	defer b.deej.feedback.stateChanged()
+
+	mapping, err := b.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return
+	}
+
+	if mapping.Volume != active.to {
+		b.logger.Debugw("Boosted slider moved since, leaving it there", "slider", sliderID, "volume", mapping.Volume)
+		return
+	}
+
+	if err := b.deej.SetSliderValue(sliderID, active.from); err != nil {
+		b.logger.Warnw("Failed to end slider boost", "slider", sliderID, "error", err)
+		return
+	}
+
+	b.logger.Infow("Ended slider boost", "slider", sliderID, "volume", active.from)
+}
+
+// remaining returns how many seconds a slider's boost has left, or 0 if it isn't boosted
+func (b *booster) remaining(sliderID string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	active, ok := b.boosts[sliderID]
+	if !ok {
+		return 0
+	}
+
+	return int(math.Ceil(time.Until(active.until).Seconds()))
+}
+
+// stop ends every boost right away, so none of them outlives deej
+func (b *booster) stop() {
+	b.lock.Lock()
+	boosts := b.boosts
+	b.boosts = map[string]*activeBoost{}
+	b.lock.Unlock()
+
+	for sliderID, active := range boosts {
+		b.revert(sliderID, active)
+	}
+}
+
+// validBoost returns the boost settings with anything that doesn't make sense replaced by its default
+func validBoost(logger *zap.SugaredLogger, boost Boost) Boost {
+	if boost.Amount <= 0 || boost.Amount > 1 {
+		logger.Warnw("Invalid boost amount, using default", "amount", boost.Amount, "default", defaultBoostAmount)
+		boost.Amount = defaultBoostAmount
+	}
+
+	if boost.Seconds <= 0 {
+		logger.Warnw("Invalid boost duration, using default", "seconds", boost.Seconds, "default", defaultBoostSeconds)
+		boost.Seconds = defaultBoostSeconds
+	}
+
+	return boost
+}
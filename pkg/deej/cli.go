@@ -0,0 +1,333 @@
+package deej
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// the commands that report on deej for scripts and status bars (waybar, polybar, Rainmeter...). validating works
+// from the config file alone, while the status and sessions come from the running deej, through its API
+const (
+
+	// a token for the API, for when the config's own can't be used (i.e. they're in another user's keychain)
+	apiTokenEnvVar = "DEEJ_API_TOKEN"
+
+	// a running deej answers right away, so anything slower than this isn't going to answer at all
+	apiClientTimeout = 3 * time.Second
+)
+
+// ConfigValidation is what checking a config file found, the way deej would when loading it
+type ConfigValidation struct {
+	Path  string `json:"path"`
+	Valid bool   `json:"valid"`
+
+	// why the config can't be loaded at all, if it can't
+	Error string `json:"error,omitempty"`
+
+	// what deej would complain about and work around, i.e. by falling back to a default
+	Warnings []ConfigWarning `json:"warnings"`
+}
+
+// ConfigWarning is a single complaint about the config, with the details deej would log along with it
+type ConfigWarning struct {
+	Message string                 `json:"message"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// ValidateConfig loads the given config file without running deej (or its sync hooks), and reports what's wrong
+// with it. A config with warnings is still valid, since deej works around them
+func ValidateConfig(path string) ConfigValidation {
+	validation := ConfigValidation{Path: path, Warnings: []ConfigWarning{}}
+
+	logger := zap.New(&warningCollector{warnings: &validation.Warnings}).Sugar()
+
+	if _, err := loadConfigQuietly(logger, path); err != nil {
+		validation.Error = err.Error()
+		return validation
+	}
+
+	validation.Valid = true
+
+	return validation
+}
+
+// loadConfigQuietly loads a config file outside of a running deej: no notifications, and no sync hooks
+func loadConfigQuietly(logger *zap.SugaredLogger, path string) (*ConfigManager, error) {
+	cm, err := NewConfigManager(logger, silentNotifier{}, path)
+	if err != nil {
+		return nil, fmt.Errorf("create config manager: %w", err)
+	}
+
+	cm.readOnly = true
+
+	if err := cm.Load(); err != nil {
+		return nil, err
+	}
+
+	return cm, nil
+}
+
+// silentNotifier drops every notification, for loading the config from the command line
+type silentNotifier struct{}
+
+func (silentNotifier) Notify(title string, message string) {}
+
+func (silentNotifier) Flush(timeout time.Duration) {}
+
+// warningCollector is a logger core that keeps the warnings logged through it, rather than writing them anywhere
+type warningCollector struct {
+	fields   []zapcore.Field
+	warnings *[]ConfigWarning
+}
+
+func (wc *warningCollector) Enabled(level zapcore.Level) bool {
+	return level >= zapcore.WarnLevel
+}
+
+func (wc *warningCollector) With(fields []zapcore.Field) zapcore.Core {
+	combined := make([]zapcore.Field, 0, len(wc.fields)+len(fields))
+	combined = append(combined, wc.fields...)
+	combined = append(combined, fields...)
+
+	return &warningCollector{fields: combined, warnings: wc.warnings}
+}
+
+func (wc *warningCollector) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if wc.Enabled(entry.Level) {
+		return checked.AddCore(entry, wc)
+	}
+
+	return checked
+}
+
+func (wc *warningCollector) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+
+	for _, field := range wc.fields {
+		field.AddTo(encoder)
+	}
+
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	*wc.warnings = append(*wc.warnings, ConfigWarning{Message: entry.Message, Fields: encoder.Fields})
+
+	return nil
+}
+
+func (wc *warningCollector) Sync() error {
+	return nil
+}
+
+// LiveStatus is the running deej's state, along with its sliders'
+type LiveStatus struct {
+	Status  Status      `json:"status"`
+	Sliders []APISlider `json:"sliders"`
+}
+
+// APIClient asks the running deej about its state, through the API its config sets up
+type APIClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+
+	// for responses that stay open, which the regular client's timeout would cut short
+	streamClient *http.Client
+}
+
+// NewAPIClient returns a client for the API of the deej that runs with the given config. With API tokens in
+// the config, it uses the first of them (they can all read), unless DEEJ_API_TOKEN has another one
+func NewAPIClient(configPath string) (*APIClient, error) {
+	cm, err := loadConfigQuietly(zap.NewNop().Sugar(), configPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	address := cm.getAPIAddress()
+	if address == "" {
+		return nil, errors.New("the API is off, set api_address in the config to use it")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("parse api_address: %w", err)
+	}
+
+	// an API that listens everywhere listens locally, too
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+
+	token := os.Getenv(apiTokenEnvVar)
+	if tokens := cm.getAPITokens(); token == "" && len(tokens) > 0 {
+		if token, err = resolveSecret(tokens[0].Token); err != nil {
+			return nil, fmt.Errorf("resolve API token %s: %w", tokens[0].Name, err)
+		}
+	}
+
+	return &APIClient{
+		baseURL: "http://" + net.JoinHostPort(host, port),
+		token:   token,
+		client:  &http.Client{Timeout: apiClientTimeout},
+
+		streamClient: &http.Client{},
+	}, nil
+}
+
+// Status returns the running deej's state, and its sliders'
+func (c *APIClient) Status() (*LiveStatus, error) {
+	status := &LiveStatus{}
+
+	if err := c.get("/api/status", &status.Status); err != nil {
+		return nil, err
+	}
+
+	if err := c.get("/api/sliders", &status.Sliders); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+// Sessions returns every audio session the running deej knows about, ordered by key
+func (c *APIClient) Sessions() ([]APISession, error) {
+	sessions := []APISession{}
+
+	if err := c.get("/api/sessions", &sessions); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// StatusBar returns the selected slider's state, for status bar modules
+func (c *APIClient) StatusBar() (*StatusBarLine, error) {
+	line := &StatusBarLine{}
+
+	if err := c.get("/api/statusbar", line); err != nil {
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// FollowStatusBar writes a status bar line (as JSON) to w whenever the selected slider's state changes. It keeps
+// going while deej isn't running (or restarts), with an offline line in the meantime, and only returns when
+// deej refuses it (i.e. over its token) or w can't be written to
+func (c *APIClient) FollowStatusBar(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	offline := false
+
+	for {
+		streamed, err := c.streamStatusBar(w)
+		if err != nil {
+			return err
+		}
+
+		if streamed {
+			offline = false
+		}
+
+		if !offline {
+			if err := encoder.Encode(StatusBarLine{Tooltip: "deej isn't running", Class: statusBarClassOffline}); err != nil {
+				return fmt.Errorf("write status bar line: %w", err)
+			}
+
+			offline = true
+		}
+
+		<-time.After(statusBarRetryInterval)
+	}
+}
+
+// streamStatusBar copies status bar lines to w until the stream ends, and tells whether there were any. deej
+// going away (or not being there to begin with) isn't an error, since it may come back
+func (c *APIClient) streamStatusBar(w io.Writer) (bool, error) {
+	response, err := c.do(c.streamClient, "/api/statusbar?follow=true")
+	if err != nil {
+		var statusErr *apiStatusError
+		if errors.As(err, &statusErr) {
+			return false, err
+		}
+
+		return false, nil
+	}
+	defer response.Body.Close()
+
+	streamed := false
+	lines := bufio.NewScanner(response.Body)
+
+	for lines.Scan() {
+		if _, err := fmt.Fprintln(w, lines.Text()); err != nil {
+			return streamed, fmt.Errorf("write status bar line: %w", err)
+		}
+
+		streamed = true
+	}
+
+	return streamed, nil
+}
+
+// apiStatusError is for requests deej answered, but not with what was asked for
+type apiStatusError struct {
+	path   string
+	status string
+	body   string
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("get %s: %s: %s", e.path, e.status, e.body)
+}
+
+func (c *APIClient) get(path string, v interface{}) error {
+	response, err := c.do(c.client, path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// do sends a GET request to the API, and returns the response if it's a successful one
+func (c *APIClient) do(client *http.Client, path string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("reach deej (is it running?): %w", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+
+		return nil, &apiStatusError{path: path, status: response.Status, body: strings.TrimSpace(string(body))}
+	}
+
+	return response, nil
+}
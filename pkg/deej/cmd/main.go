@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/omriharel/deej/pkg/deej"
 )
@@ -11,17 +17,111 @@ var (
 	gitCommit  string
 	versionTag string
 	buildType  string
+	buildDate  string
 
-	verbose bool
+	verbose      bool
+	virtualAudio bool
+	testScript   string
+	safeMode     bool
+	showVersion  bool
+	auditWakeups bool
+	captureFile  string
+	replayFile   string
+
+	// config values to run with instead of the config file's, from --set and the shortcut flags
+	configOverrides = deej.ConfigOverrides{}
 )
 
 func init() {
 	flag.BoolVar(&verbose, "verbose", false, "show verbose logs (useful for debugging serial)")
 	flag.BoolVar(&verbose, "v", false, "shorthand for --verbose")
+	flag.BoolVar(&virtualAudio, "virtual-audio", false, "use in-memory audio sessions instead of real ones (for testing)")
+	flag.BoolVar(&showVersion, "version", false, "print version and build info, then exit")
+	flag.BoolVar(&safeMode, "safe-mode", false, "start with the board and integrations disabled, to fix a broken config")
+	flag.BoolVar(&auditWakeups, "audit-wakeups", false, "log how often deej wakes up in the background, once a minute (for keeping it idle)")
+	flag.StringVar(&captureFile, "capture", "", "record the raw serial traffic to the given file, with timestamps (for reproducing board bugs)")
+	flag.StringVar(&replayFile, "replay", "", "play back a file recorded with --capture instead of connecting to the board")
+	flag.StringVar(&testScript, "test-script", "", "run the given test script against virtual audio and exit (implies --virtual-audio)")
+	flag.Func("set", "override a config value for this run, as key=value (i.e. connection_info.baud_rate=115200), can be repeated", setConfigOverride)
+
+	for shortcut, key := range deej.ConfigOverrideShortcuts {
+		key := key
+
+		flag.Func(strings.ReplaceAll(shortcut, "_", "-"), fmt.Sprintf("override the config's %s for this run", key), func(value string) error {
+			configOverrides[key] = value
+			return nil
+		})
+	}
+
 	flag.Parse()
 }
 
+// setConfigOverride takes a --set flag's key=value apart
+func setConfigOverride(flagValue string) error {
+	key, value, ok := strings.Cut(flagValue, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", flagValue)
+	}
+
+	configOverrides[key] = value
+
+	return nil
+}
+
 func main() {
+	buildInfo := deej.BuildInfo{
+		GitCommit:  gitCommit,
+		VersionTag: versionTag,
+		BuildType:  buildType,
+		BuildDate:  buildDate,
+	}
+
+	if showVersion {
+		fmt.Println(buildInfo)
+		return
+	}
+
+	// "deej report" bundles up logs and config for a bug report, and doesn't run deej itself
+	if flag.Arg(0) == "report" {
+		runReport(buildInfo)
+		return
+	}
+
+	// "deej profile export/import" shares setups between users, and doesn't run deej itself either
+	if flag.Arg(0) == "profile" {
+		runProfile(buildInfo)
+		return
+	}
+
+	// "deej secret set/delete" manages secrets in the OS keychain, for the config to refer to
+	if flag.Arg(0) == "secret" {
+		runSecret()
+		return
+	}
+
+	// "deej status/sessions/validate/mapping" report on deej (the running one, for the first two), with --json for scripts.
+	// "deej statusbar" is the running deej's selected slider as a status bar module, and "deej config-schema" lists
+	// every config option
+	switch flag.Arg(0) {
+	case "status":
+		runStatus()
+		return
+	case "statusbar":
+		runStatusBar()
+		return
+	case "sessions":
+		runSessions()
+		return
+	case "validate":
+		runValidate()
+		return
+	case "mapping":
+		runMapping()
+		return
+	case "config-schema":
+		runConfigSchema()
+		return
+	}
 
 	// first we need a logger
 	logger, err := deej.NewLogger(buildType)
@@ -35,7 +135,8 @@ func main() {
 	named.Infow("Version info",
 		"gitCommit", gitCommit,
 		"versionTag", versionTag,
-		"buildType", buildType)
+		"buildType", buildType,
+		"buildDate", buildDate)
 
 	// provide a fair warning if the user's running in verbose mode
 	if verbose {
@@ -43,20 +144,29 @@ func main() {
 	}
 
 	// create the deej instance
-	d, err := deej.NewDeej(logger, verbose)
+	d, err := deej.NewDeej(logger, deej.Options{
+		Verbose:      verbose,
+		VirtualAudio: virtualAudio || testScript != "",
+		SafeMode:     safeMode,
+		Build:        buildInfo,
+		AuditWakeups: auditWakeups,
+		CaptureFile:  captureFile,
+		ReplayFile:   replayFile,
+
+		ConfigOverrides: configOverrides,
+	})
 	if err != nil {
 		named.Fatalw("Failed to create deej object", "error", err)
 	}
 
-	// if injected by build process, set version info to show up in the tray
-	if buildType != "" && (versionTag != "" || gitCommit != "") {
-		identifier := gitCommit
-		if versionTag != "" {
-			identifier = versionTag
+	// test scripts run the pipeline headlessly and exit with their result
+	if testScript != "" {
+		if err := d.RunTestScript(testScript); err != nil {
+			named.Errorw("Test script failed", "error", err)
+			os.Exit(1)
 		}
 
-		versionString := fmt.Sprintf("Version %s-%s", buildType, identifier)
-		d.SetVersion(versionString)
+		os.Exit(0)
 	}
 
 	// onwards, to glory
@@ -64,3 +174,328 @@ func main() {
 		named.Fatalw("Failed to initialize deej", "error", err)
 	}
 }
+
+func runReport(buildInfo deej.BuildInfo) {
+	path, err := deej.WriteReport(flag.Arg(1), buildInfo)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create report: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Report saved to %s - attach it to your GitHub issue\n", path)
+}
+
+func runProfile(buildInfo deej.BuildInfo) {
+	switch flag.Arg(1) {
+	case "export":
+		path, err := deej.ExportProfile("config.yaml", flag.Arg(2), buildInfo)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export profile: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Profile saved to %s\n", path)
+
+	case "import":
+		if flag.Arg(2) == "" {
+			fmt.Fprintln(os.Stderr, "Usage: deej profile import <file>")
+			os.Exit(2)
+		}
+
+		profile, err := deej.ImportProfile(flag.Arg(2), "config.yaml")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to import profile: %v\n", err)
+			os.Exit(1)
+		}
+
+		if profile.Description != "" {
+			fmt.Println(profile.Description)
+		}
+
+		fmt.Printf("Imported %d slider mappings into config.yaml (the previous config is in config.yaml.bak)\n",
+			len(profile.SliderMappings))
+
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: deej profile export [file] | deej profile import <file>")
+		os.Exit(2)
+	}
+}
+
+func runSecret() {
+	name := flag.Arg(2)
+
+	switch {
+	case flag.Arg(1) == "set" && name != "":
+
+		// read from stdin rather than an argument, so the secret stays out of shell history and process lists
+		fmt.Fprintf(os.Stderr, "Enter the value for %s: ", name)
+
+		value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && value == "" {
+			fmt.Fprintf(os.Stderr, "Failed to read secret: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := deej.StoreSecret(name, strings.TrimRight(value, "\r\n")); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to store secret: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Secret stored, refer to it in config.yaml as \"secret:%s\"\n", name)
+
+	case flag.Arg(1) == "delete" && name != "":
+		if err := deej.DeleteSecret(name); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to delete secret: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("Secret %s deleted\n", name)
+
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: deej secret set <name> | deej secret delete <name>")
+		os.Exit(2)
+	}
+}
+
+// commandFlags parses the flags that come after a command (i.e. "deej status --json"), and returns whether
+// to print JSON along with the command's other arguments
+func commandFlags(command string) (bool, []string) {
+	flags := flag.NewFlagSet("deej "+command, flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print JSON instead of text, for scripts and status bars")
+	flags.Parse(flag.Args()[1:])
+
+	return *asJSON, flags.Args()
+}
+
+func printJSON(v interface{}) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write JSON: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runStatus() {
+	asJSON, _ := commandFlags("status")
+
+	client, err := deej.NewAPIClient("config.yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get status: %v\n", err)
+		os.Exit(1)
+	}
+
+	status, err := client.Status()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get status: %v\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(status)
+		return
+	}
+
+	if status.Status.Connected {
+		fmt.Printf("Connected to %s (connection: %s)\n", status.Status.SerialPort, status.Status.LinkQuality)
+	} else {
+		fmt.Println("Not connected to a board")
+	}
+
+	for _, slider := range status.Sliders {
+		fmt.Printf("  %-20s %s\n", slider.Name, formatLevel(slider.Volume, slider.Muted))
+	}
+
+	// only the last few connection events, --json has all of them
+	history := status.Status.ConnectionHistory
+	if len(history) > recentConnectionEvents {
+		history = history[len(history)-recentConnectionEvents:]
+	}
+
+	if len(history) > 0 {
+		fmt.Println("Recent connection events:")
+	}
+
+	for _, entry := range history {
+		fmt.Printf("  %s  %s\n", entry.At.Format("2006-01-02 15:04:05"), formatConnectionEvent(entry))
+	}
+}
+
+// how many connection events deej status prints
+const recentConnectionEvents = 10
+
+func formatConnectionEvent(entry deej.ConnectionHistoryEntry) string {
+	switch entry.Event {
+	case deej.ConnectionDisconnected:
+		return fmt.Sprintf("%s disconnected after %s: %s", entry.Transport, entry.Duration.Round(time.Second), entry.Reason)
+	case deej.ConnectionFailed:
+		if entry.Attempts > 1 {
+			return fmt.Sprintf("%s failed to connect (%d times): %s", entry.Transport, entry.Attempts, entry.Reason)
+		}
+
+		return fmt.Sprintf("%s failed to connect: %s", entry.Transport, entry.Reason)
+	}
+
+	return fmt.Sprintf("%s connected", entry.Transport)
+}
+
+func runStatusBar() {
+	flags := flag.NewFlagSet("deej statusbar", flag.ExitOnError)
+	follow := flags.Bool("follow", false, "keep running, and print another line whenever the selected slider changes")
+	flags.Parse(flag.Args()[1:])
+
+	client, err := deej.NewAPIClient("config.yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get status: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *follow {
+		if err := client.FollowStatusBar(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to follow status: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	line, err := client.StatusBar()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get status: %v\n", err)
+		os.Exit(1)
+	}
+
+	printJSON(line)
+}
+
+func runSessions() {
+	asJSON, _ := commandFlags("sessions")
+
+	client, err := deej.NewAPIClient("config.yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	sessions, err := client.Sessions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get sessions: %v\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(sessions)
+		return
+	}
+
+	for _, session := range sessions {
+		fmt.Printf("%-30s %s\n", session.Key, formatLevel(session.Volume, session.Muted))
+	}
+}
+
+func runValidate() {
+	asJSON, args := commandFlags("validate")
+
+	path := "config.yaml"
+	if len(args) > 0 {
+		path = args[0]
+	}
+
+	validation := deej.ValidateConfig(path)
+
+	if asJSON {
+		printJSON(validation)
+	} else {
+		switch {
+		case !validation.Valid:
+			fmt.Printf("%s can't be loaded: %s\n", path, validation.Error)
+		case len(validation.Warnings) == 0:
+			fmt.Printf("%s is valid\n", path)
+		default:
+			fmt.Printf("%s is valid, but deej will work around %d problem(s):\n", path, len(validation.Warnings))
+		}
+
+		for _, warning := range validation.Warnings {
+			fmt.Printf("  %s", warning.Message)
+
+			keys := make([]string, 0, len(warning.Fields))
+			for key := range warning.Fields {
+				keys = append(keys, key)
+			}
+
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				fmt.Printf(" %s=%v", key, warning.Fields[key])
+			}
+
+			fmt.Println()
+		}
+	}
+
+	if !validation.Valid {
+		os.Exit(1)
+	}
+}
+
+func runMapping() {
+	asJSON, args := commandFlags("mapping")
+
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: deej mapping [--json] <process>")
+		os.Exit(2)
+	}
+
+	resolution, err := deej.ResolveMapping("config.yaml", args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to resolve mapping: %v\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		printJSON(resolution)
+		return
+	}
+
+	switch len(resolution.Sliders) {
+	case 0:
+		fmt.Printf("%s isn't controlled by any slider\n", resolution.Process)
+	case 1:
+		fmt.Printf("%s is controlled by %s\n", resolution.Process, resolution.Sliders[0])
+	default:
+		fmt.Printf("%s is controlled by %s, whichever moves last wins\n", resolution.Process, strings.Join(resolution.Sliders, ", "))
+	}
+
+	for _, match := range resolution.Matches {
+		fmt.Printf("  %-20s %-20s %s\n", match.Slider, match.Target, match.Match)
+	}
+}
+
+func runConfigSchema() {
+	asJSON, _ := commandFlags("config-schema")
+
+	options := deej.ConfigSchema()
+
+	if asJSON {
+		printJSON(options)
+		return
+	}
+
+	for _, option := range options {
+		if option.Default != "" {
+			fmt.Printf("%s (%s, default %s)\n", option.Key, option.Type, option.Default)
+		} else {
+			fmt.Printf("%s (%s)\n", option.Key, option.Type)
+		}
+
+		fmt.Printf("    %s\n", option.Description)
+	}
+}
+
+func formatLevel(volume float32, muted bool) string {
+	level := fmt.Sprintf("%3.0f%%", volume*100)
+	if muted {
+		level += " (muted)"
+	}
+
+	return level
+}
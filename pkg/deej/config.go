@@ -1,292 +1,1464 @@
 package deej
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/thoas/go-funk"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 )
 
+// by default, snap volumes to whole percents
+const defaultQuantizationStep = 1.0
+
+// what happens to volumes when deej starts, before any input arrives
+const (
+
+	// leave everything as it is until a slider moves
+	startupVolumesNone = "none"
+
+	// apply the volumes stored in the config to their sessions
+	startupVolumesApply = "apply"
+
+	// take the sessions' current volumes into the config, so the first move continues from them
+	startupVolumesAdopt = "adopt"
+
+	// put back the volumes the sliders' sessions had when deej last exited, starting before all sessions are in
+	// (see session_state.go)
+	startupVolumesRestore = "restore"
+)
+
+// what happens while the workstation is locked
+const (
+
+	// keep controlling audio as usual
+	lockScreenKeep = "keep"
+
+	// mute every slider, and unmute them again on unlock
+	lockScreenMute = "mute"
+
+	// ignore slider moves and mutes until unlock
+	lockScreenFreeze = "freeze"
+)
+
 // ConnectionInfo represents the settings for connecting to the Arduino board
 type ConnectionInfo struct {
-	SerialPort string `yaml:"serial_port"`
-	BaudRate   uint   `yaml:"baud_rate"`
+	SerialPort string `yaml:"serial_port" doc:"The board's serial port (i.e. COM4 or /dev/ttyUSB0), or auto to find it"`
+	BaudRate   uint   `yaml:"baud_rate" doc:"The serial connection's speed, which must match the board's"`
+
+	// with a heartbeat timeout (in seconds), a board that sends nothing for that long (not even an answer
+	// to deej's pings) is considered wedged, and its connection is renewed. 0 (the default) turns this off
+	HeartbeatTimeout int `yaml:"heartbeat_timeout,omitempty" doc:"Seconds of silence after which the board is considered stuck and reconnected, 0 for never"`
+
+	// framing, for boards and USB-UART bridges that don't use the usual 8N1. left out, these are 8 data bits,
+	// 1 stop bit, no parity and no flow control
+	DataBits uint   `yaml:"data_bits,omitempty" doc:"Data bits per character, 8 if unset"`
+	StopBits uint   `yaml:"stop_bits,omitempty" doc:"Stop bits per character, 1 if unset"`
+	Parity   string `yaml:"parity,omitempty" doc:"Parity checking: none, odd or even"`
+	RTSCTS   bool   `yaml:"rts_cts,omitempty" doc:"Use hardware (RTS/CTS) flow control"`
+
+	// how many bytes a read waits for. left out, it's 1 on Linux and 0 on Windows (where waiting for bytes
+	// congests reads, resulting in significant lag)
+	MinReadSize uint `yaml:"min_read_size,omitempty" doc:"How many bytes a read waits for, 1 on Linux and 0 on Windows if unset"`
+
+	// with a checksum mode ("crc8"), lines whose checksum is missing or wrong are dropped rather than handled
+	// (see serial_checksum.go). left out, lines aren't checked
+	Checksum string `yaml:"checksum,omitempty" doc:"Drop lines whose checksum is missing or wrong (crc8), unchecked if unset"`
 }
 
+// SerialConnection is one more board on a port of its own, i.e. a mute button pad next to the fader box.
+// Its channels drive the sliders it lists, in order, and these aren't on the main board's channels anymore
+type SerialConnection struct {
+	Name           string         `yaml:"name" doc:"The board's name, for logs and notifications"`
+	ConnectionInfo ConnectionInfo `yaml:"connection_info" doc:"How to connect to the board"`
+	Sliders        []string       `yaml:"sliders" doc:"The sliders the board's channels drive, in order"`
+}
+
+// serial parity modes
+const (
+	parityNone = "none"
+	parityOdd  = "odd"
+	parityEven = "even"
+)
+
 // SliderMapping represents the mapping of sliders
 type SliderMapping struct {
-	Volume  float32  `yaml:"volume"`
-	Muted   bool     `yaml:"muted"`
-	Targets []string `yaml:"targets"`
+	Volume  float32  `yaml:"volume" doc:"The volume (0-1) the slider starts out at. deej keeps track of it in state.yaml from there"`
+	Muted   bool     `yaml:"muted" doc:"Whether the slider starts out muted. deej keeps track of it in state.yaml from there"`
+	Targets []string `yaml:"targets" doc:"What the slider controls: process names, devices, or special targets like master and deej.unmapped"`
+
+	// optional per-target offsets in percents, relative to the slider's value (i.e. discord.exe: -10)
+	Offsets map[string]float32 `yaml:"offsets,omitempty" doc:"Percents to keep some targets above or below the slider, by target"`
+
+	// virtual sliders aren't bound to any hardware channel, and can only be moved from software (tray, API)
+	Virtual bool `yaml:"virtual,omitempty" doc:"Not bound to a hardware channel, moved from software only"`
+
+	// for a slider mounted the other way around from the rest. unset, the board's invert setting applies
+	Invert *bool `yaml:"invert,omitempty" doc:"Flip this slider's direction, whatever the board's invert setting says"`
+
+	// how the slider's position translates to its sessions' volume, linear unless set (see volume_curve.go)
+	Curve VolumeCurve `yaml:"curve,omitempty" doc:"How position translates to volume: linear, log, exponential or a list of [position, volume] points"`
+
+	// the range the slider's full travel covers (i.e. a mic that never goes above 0.8). an unset max is 1
+	Min float32 `yaml:"min,omitempty" doc:"The volume at the bottom of the slider's travel (0-1)"`
+	Max float32 `yaml:"max,omitempty" doc:"The volume at the top of the slider's travel (0-1), 1 if unset"`
+
+	// the slider this one's volume is relative to (see slider_groups.go), i.e. music and video under media
+	Group string `yaml:"group,omitempty" doc:"The slider that scales this one's volume, as the master of its group"`
+
+	// two volumes to toggle between (see slider_presets.go), i.e. [0.6, 0.15] to drop the music when someone walks in
+	Presets []float32 `yaml:"presets,omitempty" doc:"Two volumes (0-1) the slider's preset toggle flips between"`
+
+	// where the slider goes among the others (see config_order.go), rather than where the config file lists it
+	Order int `yaml:"order,omitempty" doc:"The slider's place among the others (1 and up), the config file's order if unset"`
+
+	// a locked slider ignores the board (see slider_lock.go), i.e. a mic that's been dialed in
+	Locked bool `yaml:"locked,omitempty" doc:"Ignore the board's input for this slider from the start, toggling it is kept in state.yaml"`
+
+	// how the tray, the mini mixer and the board show the slider (see slider_colors.go)
+	Color string `yaml:"color,omitempty" doc:"The slider's color as a hex color (i.e. #ff8800), for the tray, the mini mixer and the board's LEDs"`
+}
+
+// MappingProfile is a named set of slider mappings (i.e. one for gaming, one for work) that takes the place of the
+// config's own slider mappings while it's the active profile (see config_profiles.go)
+type MappingProfile struct {
+	SliderMappings map[string]SliderMapping `yaml:"slider_mappings" doc:"The profile's sliders, in place of the config's own while it's active"`
+}
+
+// DeviceSettings represents settings tied to a specific board (by its handshake ID or USB serial number),
+// so they follow it around regardless of which port it's plugged into
+type DeviceSettings struct {
+	Label  string `yaml:"label,omitempty" doc:"A name for the board, for logs"`
+	Invert *bool  `yaml:"invert,omitempty" doc:"Flip the board's sliders, whatever invert_sliders says"`
+}
+
+// RemoteControl represents the settings for sharing one board between machines. A deej instance
+// with ForwardTo set sends its slider events to another instance, which accepts them on Listen.
+// Targets are named instances (by address) that the board can switch between, KVM-style.
+// Both sides authenticate each other with certificates signed by the same CA (mutual TLS)
+type RemoteControl struct {
+	Listen    string            `yaml:"listen,omitempty" doc:"Address to accept another instance's slider events on"`
+	ForwardTo string            `yaml:"forward_to,omitempty" doc:"Address of the instance to send slider events to"`
+	Targets   map[string]string `yaml:"targets,omitempty" doc:"Instances the board can switch between, by name"`
+	CertFile  string            `yaml:"cert_file,omitempty" doc:"This instance's TLS certificate"`
+	KeyFile   string            `yaml:"key_file,omitempty" doc:"This instance's TLS key"`
+	CAFile    string            `yaml:"ca_file,omitempty" doc:"The CA both sides' certificates are signed by"`
+}
+
+// NotificationDigest controls how bursts of repeated notifications (i.e. reconnect storms) are collapsed.
+// Up to Threshold notifications within WindowSeconds are shown as usual, anything beyond that is summarized
+type NotificationDigest struct {
+	Threshold     int `yaml:"threshold" doc:"How many notifications are shown as usual within the window"`
+	WindowSeconds int `yaml:"window_seconds" doc:"How long (in seconds) notifications are counted for"`
+}
+
+// Reconnect controls how deej gets a board back after losing it (unplugged, failed to open, stopped responding).
+// Attempts start InitialDelay seconds apart, doubling each time up to MaxDelay, and give up (with a notification)
+// after MaxRetries of them. 0 retries means never giving up
+type Reconnect struct {
+	InitialDelay int `yaml:"initial_delay" doc:"Seconds before the first reconnect attempt"`
+	MaxDelay     int `yaml:"max_delay" doc:"The most seconds between attempts, as the delay doubles"`
+	MaxRetries   int `yaml:"max_retries" doc:"Attempts before giving up, 0 for never"`
+}
+
+// Variables are values slider targets can refer to as "${NAME}" (see config_variables.go), i.e. a browser that's
+// used by several sliders. HostVariables override them on the machine with that hostname
+type Variables map[string]string
+
+// PulseServer points deej at a PulseAudio (or PipeWire) server other than the local default, i.e. when
+// deej runs in a container or controls another machine's audio. The cookie authenticates deej with the
+// server, and is only needed if it differs from the local one. Linux only
+type PulseServer struct {
+	Address    string `yaml:"address,omitempty" doc:"The server's address, i.e. tcp:192.168.1.10"`
+	CookieFile string `yaml:"cookie_file,omitempty" doc:"The cookie to authenticate with, if not the local one"`
+}
+
+// BoardFeedback controls pushing deej's state (volumes, mute states, the selected slider) back to the board,
+// for boards with a display or LEDs. Format is a text/template executed with a BoardState (see board_feedback.go),
+// and MinIntervalMs rate limits how often it's sent. With IdleTimeout (in seconds) set, sliders whose targets
+// haven't made a sound for that long are reported as idle, i.e. for dimming their LEDs
+type BoardFeedback struct {
+	Enabled       bool   `yaml:"enabled" doc:"Send state back to the board"`
+	Format        string `yaml:"format,omitempty" doc:"A Go template for what's sent, executed with the board state"`
+	MinIntervalMs int    `yaml:"min_interval_ms,omitempty" doc:"The fewest milliseconds between updates"`
+	IdleTimeout   int    `yaml:"idle_timeout,omitempty" doc:"Seconds of silence after which a slider's targets count as idle, 0 for never"`
+}
+
+// ButtonGestures sets the timing that tells an encoder button's gestures apart: a second click within
+// DoubleClickMs makes a double click, and holding the button for LongPressMs (without turning) a long press.
+// The button changing again within DebounceMs of its last change is taken for bounce, and ignored (0 turns that off).
+// LongPress picks what a long press does: switch to the next profile (the default), or lock the slider
+type ButtonGestures struct {
+	DoubleClickMs int    `yaml:"double_click_ms,omitempty" doc:"How soon (in milliseconds) a second click makes a double click"`
+	LongPressMs   int    `yaml:"long_press_ms,omitempty" doc:"How long (in milliseconds) the button must be held for a long press"`
+	DebounceMs    int    `yaml:"debounce_ms" doc:"Button changes within this many milliseconds of the last one are ignored as bounce, 0 for none"`
+	LongPress     string `yaml:"long_press,omitempty" doc:"What a long press does: profile (switch to the next one) or lock (lock or unlock the slider)"`
+}
+
+// Telemetry controls the opt-in anonymous usage statistics (see telemetry.go for exactly what's in them).
+// Nothing is ever sent unless Enabled is set and an Endpoint is given
+type Telemetry struct {
+	Enabled  bool   `yaml:"enabled" doc:"Send anonymous usage statistics"`
+	Endpoint string `yaml:"endpoint,omitempty" doc:"Where to send them"`
+}
+
+// SyncHooks are shell commands that keep the config in sync elsewhere, i.e. in a git repo or a cloud folder.
+// BeforeLoad runs before every load (to pull in a newer config), AfterSave after deej saves its own changes (to push them).
+// If SyncedCopy points at the synced config, deej won't save over it when it changed since deej last loaded it
+type SyncHooks struct {
+	BeforeLoad string `yaml:"before_load,omitempty" doc:"Command to run before every load, i.e. to pull a newer config"`
+	AfterSave  string `yaml:"after_save,omitempty" doc:"Command to run after deej saves the config, i.e. to push it"`
+	SyncedCopy string `yaml:"synced_copy,omitempty" doc:"The synced config, which deej won't save over if it changed since deej loaded it"`
+}
+
+// MiniMixer controls the mini mixer window, a compact set of faders mirroring the board (see mini_mixer.go).
+// It can always be opened from the tray menu, OpenOnStartup also opens it whenever deej starts
+type MiniMixer struct {
+	OpenOnStartup bool `yaml:"open_on_startup" doc:"Open the mini mixer whenever deej starts"`
+	AlwaysOnTop   bool `yaml:"always_on_top" doc:"Keep the mini mixer above other windows"`
+}
+
+// WebSocket lets boards with Wi-Fi (i.e. ESP32 and ESP8266 based ones) connect over the network instead of USB serial,
+// as many as needed at once. If Token is set (it may refer to a stored secret), boards pass it as a "token" query parameter
+type WebSocket struct {
+	Listen string `yaml:"listen,omitempty" doc:"Address to accept boards on"`
+	Token  string `yaml:"token,omitempty" doc:"Token boards must pass, if any"`
+}
+
+// MQTT connects deej to an MQTT broker (see mqtt.go), publishing every slider's volume and mute state under StatePrefix
+// and taking commands to change them. Lines published to Topic are handled like a board's, and Discovery announces
+// the sliders to Home Assistant. Password may refer to a stored secret
+type MQTT struct {
+	Broker          string `yaml:"broker,omitempty" doc:"The broker's address, i.e. tcp://localhost:1883"`
+	Username        string `yaml:"username,omitempty" doc:"Username for the broker"`
+	Password        string `yaml:"password,omitempty" doc:"Password for the broker, or secret:<name>"`
+	Topic           string `yaml:"topic,omitempty" doc:"Topic to take board lines from"`
+	StatePrefix     string `yaml:"state_prefix,omitempty" doc:"Topic prefix for slider states and commands"`
+	Discovery       bool   `yaml:"discovery,omitempty" doc:"Announce the sliders to Home Assistant"`
+	DiscoveryPrefix string `yaml:"discovery_prefix,omitempty" doc:"Home Assistant's discovery topic prefix"`
+}
+
+// Meeting adjusts sliders while a conferencing app is in a call, which deej tells by the app recording audio
+// (see meeting.go). Levels sets sliders' volumes and Mute mutes sliders for as long as the call goes on, and both
+// are undone when it ends. Apps are the process names that count as conferencing apps, Zoom, Teams and Discord by default
+type Meeting struct {
+	Apps   []string           `yaml:"apps,omitempty" doc:"Process names of conferencing apps, Zoom, Teams and Discord if unset"`
+	Levels map[string]float32 `yaml:"levels,omitempty" doc:"Slider volumes during a call, by slider"`
+	Mute   []string           `yaml:"mute,omitempty" doc:"Sliders to mute during a call"`
+}
+
+// Boost is how much boosting a slider turns it up, and for how long before it goes back (see boost.go)
+type Boost struct {
+	Amount  float32 `yaml:"amount,omitempty" doc:"How much to turn the slider up by (0-1)"`
+	Seconds int     `yaml:"seconds,omitempty" doc:"How long the boost lasts, in seconds"`
+}
+
+// IPC accepts the same lines a board sends from local scripts and tools (see ipc.go), over a Unix socket on Linux
+// or a named pipe on Windows. Path defaults to $XDG_RUNTIME_DIR/deej.sock and \\.\pipe\deej respectively
+type IPC struct {
+	Enabled bool   `yaml:"enabled,omitempty" doc:"Accept lines from local scripts"`
+	Path    string `yaml:"path,omitempty" doc:"The socket or pipe, $XDG_RUNTIME_DIR/deej.sock or \\\\.\\pipe\\deej if unset"`
+}
+
+// Widget lets desktop widgets (i.e. a Rainmeter skin) show and change sliders over UDP (see widget.go). Listen is
+// the address deej takes their datagrams on. Anyone who can reach it could change volumes, so an address other than
+// loopback takes a Token, which widgets say hello with. Token may refer to a stored secret ("secret:<name>")
+type Widget struct {
+	Listen string `yaml:"listen,omitempty" doc:"Address to take widgets' UDP datagrams on"`
+	Token  string `yaml:"token,omitempty" doc:"Token widgets must say hello with, required off loopback"`
 }
 
-// Config represents the entire configuration structure
+// Config represents the entire configuration structure. Templates is anything at all, deej doesn't read it: it's
+// a place to define yaml anchors (i.e. a base slider mapping) for the rest of the config to reuse
 type Config struct {
-	SliderMappings      map[string]SliderMapping `yaml:"slider_mappings"`
-	InvertSliders       bool                     `yaml:"invert_sliders"`
-	ConnectionInfo      ConnectionInfo           `yaml:"connection_info"`
-	NoiseReductionLevel string                   `yaml:"noise_reduction_level"`
-	ConfigSaveInterval  int                      `yaml:"config_save_interval"`
+	ConfigVersion       int                       `yaml:"config_version" doc:"Which format the config is in, managed by deej"`
+	Templates           yaml.Node                 `yaml:"templates,omitempty" doc:"Anything at all, ignored by deej: a place to define YAML anchors for the rest of the config"`
+	SliderMappings      map[string]SliderMapping  `yaml:"slider_mappings" doc:"Sliders by name, and what each one controls"`
+	InvertSliders       bool                      `yaml:"invert_sliders" doc:"Flip all sliders, for boards whose sliders go the other way"`
+	ConnectionInfo      ConnectionInfo            `yaml:"connection_info" doc:"How to connect to the board"`
+	NoiseReductionLevel string                    `yaml:"noise_reduction_level" doc:"How much analog slider jitter to filter out: low, default or high"`
+	QuantizationStep    float32                   `yaml:"quantization_step" doc:"Snap volumes to steps of this many percents (0-100)"`
+	ConfigSaveInterval  int                       `yaml:"config_save_interval" doc:"Not used anymore, deej saves its changes shortly after making them"`
+	TraceLatency        bool                      `yaml:"trace_latency,omitempty" doc:"Measure how long slider moves take to apply"`
+	AggregateChildren   bool                      `yaml:"aggregate_child_processes,omitempty" doc:"Let a slider control its targets' child processes too"`
+	Rules               []Rule                    `yaml:"rules,omitempty" doc:"Adjust or drop slider moves before they're applied"`
+	APIAddress          string                    `yaml:"api_address,omitempty" doc:"Address to serve the HTTP API on, i.e. 127.0.0.1:5005"`
+	RemoteControl       RemoteControl             `yaml:"remote_control,omitempty" doc:"Share one board between machines"`
+	NotificationDigest  NotificationDigest        `yaml:"notification_digest,omitempty" doc:"Collapse bursts of repeated notifications"`
+	NumberLocale        string                    `yaml:"number_locale,omitempty" doc:"How percentages are written in the tray (i.e. de-DE), the system's locale if unset"`
+	StartupVolumes      string                    `yaml:"startup_volumes,omitempty" doc:"What happens to volumes when deej starts: none, apply, adopt or restore"`
+	Telemetry           Telemetry                 `yaml:"telemetry,omitempty" doc:"Opt-in anonymous usage statistics"`
+	PulseServer         PulseServer               `yaml:"pulse_server,omitempty" doc:"A PulseAudio server to use instead of the local default (Linux only)"`
+	BoardFeedback       BoardFeedback             `yaml:"board_feedback,omitempty" doc:"Send deej's state back to boards with displays or LEDs"`
+	ShutdownTimeout     int                       `yaml:"shutdown_timeout,omitempty" doc:"How long (in seconds) shutting down may take"`
+	Protocol            string                    `yaml:"protocol,omitempty" doc:"What the board sends: analog, encoder or mixed"`
+	SyncHooks           SyncHooks                 `yaml:"sync_hooks,omitempty" doc:"Commands that keep the config in sync elsewhere"`
+	APITokens           []APIToken                `yaml:"api_tokens,omitempty" doc:"Tokens that grant access to the API"`
+	MiniMixer           MiniMixer                 `yaml:"mini_mixer,omitempty" doc:"The mini mixer window"`
+	WebSocket           WebSocket                 `yaml:"websocket,omitempty" doc:"Let boards connect over the network"`
+	MQTT                MQTT                      `yaml:"mqtt,omitempty" doc:"Connect to an MQTT broker"`
+	Meeting             Meeting                   `yaml:"meeting,omitempty" doc:"Adjust sliders while a conferencing app is in a call"`
+	Boost               Boost                     `yaml:"boost,omitempty" doc:"How boosting a slider turns it up for a while"`
+	IPC                 IPC                       `yaml:"ipc,omitempty" doc:"Take board lines from local scripts"`
+	MIDIDevice          string                    `yaml:"midi_device,omitempty" doc:"A MIDI controller to use as a board"`
+	MIDIMappings        []MIDIMapping             `yaml:"midi_mappings,omitempty" doc:"Which MIDI controls move which sliders"`
+	MotorizedFaders     bool                      `yaml:"motorized_faders,omitempty" doc:"Move motorized faders to volumes that change from elsewhere"`
+	GRPCAddress         string                    `yaml:"grpc_address,omitempty" doc:"Address to serve the gRPC API on"`
+	Reconnect           Reconnect                 `yaml:"reconnect,omitempty" doc:"How deej gets a lost board back"`
+	Variables           Variables                 `yaml:"variables,omitempty" doc:"Values slider targets can refer to as ${NAME}"`
+	HostVariables       map[string]Variables      `yaml:"host_variables,omitempty" doc:"Variables that override the others on the machine with that hostname"`
+	LockScreen          string                    `yaml:"lock_screen,omitempty" doc:"What happens while the workstation is locked: keep, mute or freeze"`
+	Connections         []SerialConnection        `yaml:"connections,omitempty" doc:"More boards, each on a port of its own"`
+	ButtonGestures      ButtonGestures            `yaml:"button_gestures,omitempty" doc:"Timing of encoder button gestures"`
+	Widget              Widget                    `yaml:"widget,omitempty" doc:"Let desktop widgets show and change sliders"`
+	Profiles            map[string]MappingProfile `yaml:"profiles,omitempty" doc:"Named sets of slider mappings to switch between"`
+	ActiveProfile       string                    `yaml:"active_profile,omitempty" doc:"The profile deej runs with, the config's own mappings if unset"`
+	OnStartup           []StartupAction           `yaml:"on_startup,omitempty" doc:"Actions to run once deej is up, in order"`
+	ConfigBackups       int                       `yaml:"config_backups" doc:"How many previous versions of the config to keep (config.yaml.bak.1 and on), 0 for none"`
+	Include             ConfigIncludes            `yaml:"include,omitempty" doc:"Config files this one builds on (i.e. one shared between machines), its own settings winning over theirs"`
+	Devices             map[string]DeviceSettings `yaml:"devices,omitempty" doc:"Settings for specific boards, by their ID"`
 }
 
 // ConfigManager manages config loading, watching, and notifying subscribers on changes
 type ConfigManager struct {
 	Config             *Config
 	orderedSliderKeys  []string
+	hardwareSliderKeys []string
 	logger             *zap.SugaredLogger
 	notifier           Notifier
 	stopWatcherChannel chan bool
+	stopOnce           sync.Once
 	reloadConsumers    []chan bool
+	reloadConsumerLock sync.Mutex
 	configFilePath     string
 	lock               sync.Locker
 	configModified     bool
+	stateModified      bool
+	modifiedChannel    chan bool
+
+	// counts the saver's and watcher's wakeups, when auditing them
+	wakeups *wakeupAudit
+
+	// when the synced copy of the config (if any) was last modified, as far as deej knows
+	syncedCopyModTime time.Time
+
+	// slider keys whose mapping differs from the previously loaded config (including added and removed ones)
+	changedSliderKeys []string
+
+	// slider values as the config file has them for the active mappings, and the slider keys whose volume the
+	// latest load found changed in the file (i.e. by a profile import). deej's own changes only go to the state
+	configValues    map[string]sliderValues
+	movedSliderKeys []string
+
+	// the slider values deej keeps out of the config file (see config_state.go), read on the first load
+	state *runtimeState
+
+	// what the files the config includes add up to, and their paths (see config_include.go)
+	includedConfig *yaml.Node
+	includedFiles  []string
+
+	// values to run with instead of the config file's, and what the file had in their place as of the latest
+	// load, by the override's key (see config_overrides.go)
+	overrides        []configOverride
+	overriddenValues map[string]*yaml.Node
+
+	// slider mappings whose targets use variables, as the config file has them (see config_variables.go)
+	templatedSliderMappings map[string]SliderMapping
+
+	// the profile whose mappings deej runs with, and the config's own mappings while that's not the default one
+	activeProfile      string
+	baseSliderMappings map[string]SliderMapping
+
+	// set while the config has no slider mappings of its own, and deej makes do with one (see config_implicit.go)
+	implicitMapping         bool
+	implicitMappingNotified bool
+
+	// for loading the config without running deej (i.e. "deej validate"), which shouldn't run the user's hooks
+	// or rewrite the config file (i.e. to migrate it)
+	readOnly bool
+}
+
+// NewConfigManager creates a new ConfigManager instance
+func NewConfigManager(logger *zap.SugaredLogger, notifier Notifier, configFilePath string) (*ConfigManager, error) {
+	logger = logger.Named("config")
+
+	cm := &ConfigManager{
+		logger:             logger,
+		notifier:           notifier,
+		stopWatcherChannel: make(chan bool),
+		modifiedChannel:    make(chan bool, 1),
+		reloadConsumers:    []chan bool{},
+		configFilePath:     configFilePath,
+		lock:               &sync.Mutex{},
+	}
+
+	logger.Debug("Created config manager instance")
+	return cm, nil
+}
+
+// newDefaultConfig returns the config that a config file is decoded on top of
+func newDefaultConfig() *Config {
+
+	// What's the point of having defaults? It could be different on any system.
+	return &Config{
+		ConfigSaveInterval: 60,
+		ConfigBackups:      defaultConfigBackups,
+		Boost: Boost{
+			Amount:  defaultBoostAmount,
+			Seconds: defaultBoostSeconds,
+		},
+		ShutdownTimeout:  defaultShutdownTimeout,
+		QuantizationStep: defaultQuantizationStep,
+		StartupVolumes:   startupVolumesNone,
+		LockScreen:       lockScreenKeep,
+		Protocol:         protocolMixed,
+		NotificationDigest: NotificationDigest{
+			Threshold:     defaultDigestThreshold,
+			WindowSeconds: defaultDigestWindowSeconds,
+		},
+		BoardFeedback: BoardFeedback{
+			Format:        defaultBoardFeedbackFormat,
+			MinIntervalMs: defaultBoardFeedbackMinIntervalMs,
+		},
+		MiniMixer: MiniMixer{
+			AlwaysOnTop: true,
+		},
+		ButtonGestures: ButtonGestures{
+			DoubleClickMs: defaultDoubleClickMs,
+			LongPressMs:   defaultLongPressMs,
+			DebounceMs:    defaultDebounceMs,
+		},
+		Reconnect: Reconnect{
+			InitialDelay: defaultReconnectInitialDelay,
+			MaxDelay:     defaultReconnectMaxDelay,
+		},
+		MQTT: MQTT{
+			StatePrefix:     defaultMQTTStatePrefix,
+			DiscoveryPrefix: defaultMQTTDiscoveryPrefix,
+		},
+		// Set default values
+		ConnectionInfo: ConnectionInfo{
+			SerialPort: "COM4",
+			BaudRate:   9600,
+		},
+	}
+}
+
+// LoadDefaults replaces the configuration with the defaults, with nothing but the implicit slider mapping.
+// This is for running when the config file itself can't be loaded - it is never written back to disk
+func (cm *ConfigManager) LoadDefaults() {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.Config = newDefaultConfig()
+	cm.Config.SliderMappings = map[string]SliderMapping{}
+	cm.changedSliderKeys = []string{}
+	cm.templatedSliderMappings = nil
+	cm.activeProfile = defaultProfileName
+	cm.baseSliderMappings = nil
+	cm.configValues = nil
+
+	cm.applyImplicitMapping(nil)
+	cm.orderedSliderKeys = []string{implicitSliderKey}
+	cm.hardwareSliderKeys = []string{implicitSliderKey}
+
+	cm.logger.Info("Loaded default config")
+}
+
+// Load loads the configuration file into the Config struct
+// Update the Load function to store keys in the order they appear in YAML
+func (cm *ConfigManager) Load() error {
+	cm.logger.Debugw("Loading config", "path", cm.configFilePath)
+
+	// give the sync hook a chance to bring in a newer config first
+	hooks := cm.peekSyncHooks()
+	if hooks.BeforeLoad != "" && !cm.readOnly {
+		if err := cm.runSyncHook("before_load", hooks.BeforeLoad); err != nil {
+			cm.logger.Warnw("Loading config without syncing it", "error", err)
+		}
+	}
+
+	contents, err := ioutil.ReadFile(cm.configFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			cm.logger.Warnw("Config file not found", "path", cm.configFilePath)
+			cm.notifier.Notify("Can't find configuration!", fmt.Sprintf("%s must be in the directory. Please re-launch", cm.configFilePath))
+			return fmt.Errorf("config file doesn't exist: %s", cm.configFilePath)
+		}
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+
+	// older configs (i.e. upstream deej's) wouldn't decode as they are
+	contents, err = cm.migrate(contents)
+	if err != nil {
+		cm.logger.Warnw("Failed to migrate config", "error", err)
+		return fmt.Errorf("failed to migrate config: %w", err)
+	}
+
+	// the files the config includes go underneath it before anything else looks at it
+	contents, includedConfig, includedFiles, err := cm.resolveIncludes(contents)
+	if err != nil {
+		cm.logger.Warnw("Failed to include config files", "error", err)
+		return fmt.Errorf("failed to include config files: %w", err)
+	}
+
+	// and the overrides from the command line and the environment go on top of all of them
+	contents, overriddenValues, err := cm.applyOverrides(contents)
+	if err != nil {
+		cm.logger.Warnw("Failed to apply config overrides", "error", err)
+		return fmt.Errorf("failed to apply config overrides: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	// hold on to the outgoing mappings so we can tell reload consumers what actually changed
+	previousConfigValues := cm.configValues
+	previousProfile := cm.activeProfile
+	var previousSliderMappings map[string]SliderMapping
+	if cm.Config != nil {
+		previousSliderMappings = cm.Config.SliderMappings
+	}
+
+	cm.Config = newDefaultConfig()
+
+	if err := decoder.Decode(cm.Config); err != nil {
+		cm.logger.Warnw("Failed to decode config", "error", err)
+		return fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	cm.includedConfig = includedConfig
+	cm.includedFiles = includedFiles
+	cm.overriddenValues = overriddenValues
+
+	// whatever version the config was in, it's in the current format now, and is saved that way.
+	// a newer config keeps its version, so deej saving it doesn't make it look older than it is
+	if cm.Config.ConfigVersion < currentConfigVersion {
+		cm.Config.ConfigVersion = currentConfigVersion
+	}
+
+	// the step is given in percents, anything outside of (0, 100] makes no sense as a step
+	if cm.Config.QuantizationStep < 0 || cm.Config.QuantizationStep > 100 {
+		cm.logger.Warnw("Invalid quantization step, using default",
+			"quantizationStep", cm.Config.QuantizationStep,
+			"default", defaultQuantizationStep)
+
+		cm.Config.QuantizationStep = defaultQuantizationStep
+	}
+
+	// a digest needs room for at least one regular notification, and a window to collect the rest in
+	if cm.Config.NotificationDigest.Threshold < 1 || cm.Config.NotificationDigest.WindowSeconds < 1 {
+		cm.logger.Warnw("Invalid notification digest settings, using defaults",
+			"notificationDigest", cm.Config.NotificationDigest)
+
+		cm.Config.NotificationDigest = NotificationDigest{
+			Threshold:     defaultDigestThreshold,
+			WindowSeconds: defaultDigestWindowSeconds,
+		}
+	}
+
+	if cm.Config.ShutdownTimeout <= 0 {
+		cm.logger.Warnw("Invalid shutdown timeout, using default",
+			"shutdownTimeout", cm.Config.ShutdownTimeout,
+			"default", defaultShutdownTimeout)
+
+		cm.Config.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	if cm.Config.ConfigBackups < 0 {
+		cm.logger.Warnw("Invalid number of config backups, using default",
+			"configBackups", cm.Config.ConfigBackups,
+			"default", defaultConfigBackups)
+
+		cm.Config.ConfigBackups = defaultConfigBackups
+	}
+
+	if cm.Config.ConnectionInfo.HeartbeatTimeout < 0 {
+		cm.logger.Warnw("Invalid heartbeat timeout, turning heartbeat off",
+			"heartbeatTimeout", cm.Config.ConnectionInfo.HeartbeatTimeout)
+
+		cm.Config.ConnectionInfo.HeartbeatTimeout = 0
+	}
+
+	cm.Config.ConnectionInfo = validFraming(cm.logger, cm.Config.ConnectionInfo)
+	cm.Config.ConnectionInfo.Checksum = validChecksum(cm.logger, cm.Config.ConnectionInfo.Checksum)
+
+	if cm.Config.Reconnect.InitialDelay < 1 || cm.Config.Reconnect.MaxDelay < cm.Config.Reconnect.InitialDelay ||
+		cm.Config.Reconnect.MaxRetries < 0 {
+
+		cm.logger.Warnw("Invalid reconnect settings, using defaults", "reconnect", cm.Config.Reconnect)
+
+		cm.Config.Reconnect = Reconnect{
+			InitialDelay: defaultReconnectInitialDelay,
+			MaxDelay:     defaultReconnectMaxDelay,
+		}
+	}
+
+	if cm.Config.BoardFeedback.MinIntervalMs < 0 {
+		cm.logger.Warnw("Invalid board feedback interval, using default",
+			"minIntervalMs", cm.Config.BoardFeedback.MinIntervalMs,
+			"default", defaultBoardFeedbackMinIntervalMs)
+
+		cm.Config.BoardFeedback.MinIntervalMs = defaultBoardFeedbackMinIntervalMs
+	}
+
+	if gestures := cm.Config.ButtonGestures; gestures.DoubleClickMs <= 0 || gestures.LongPressMs <= 0 || gestures.DebounceMs < 0 {
+		cm.logger.Warnw("Invalid button gesture timing, using defaults", "buttonGestures", gestures)
+
+		cm.Config.ButtonGestures = ButtonGestures{
+			DoubleClickMs: defaultDoubleClickMs,
+			LongPressMs:   defaultLongPressMs,
+			DebounceMs:    defaultDebounceMs,
+			LongPress:     gestures.LongPress,
+		}
+	}
+
+	switch cm.Config.ButtonGestures.LongPress {
+	case "", longPressProfile, longPressLock:
+	default:
+		cm.logger.Warnw("Invalid long press action, using default",
+			"longPress", cm.Config.ButtonGestures.LongPress,
+			"default", longPressProfile)
+
+		cm.Config.ButtonGestures.LongPress = longPressProfile
+	}
+
+	switch cm.Config.NoiseReductionLevel {
+	case "", noiseReductionLow, noiseReductionDefault, noiseReductionHigh:
+	default:
+		cm.logger.Warnw("Invalid noise reduction level, using default",
+			"noiseReductionLevel", cm.Config.NoiseReductionLevel,
+			"default", noiseReductionDefault)
+
+		cm.Config.NoiseReductionLevel = noiseReductionDefault
+	}
+
+	switch cm.Config.StartupVolumes {
+	case startupVolumesNone, startupVolumesApply, startupVolumesAdopt, startupVolumesRestore:
+	default:
+		cm.logger.Warnw("Invalid startup volume policy, using default",
+			"startupVolumes", cm.Config.StartupVolumes,
+			"default", startupVolumesNone)
+
+		cm.Config.StartupVolumes = startupVolumesNone
+	}
+
+	switch cm.Config.LockScreen {
+	case lockScreenKeep, lockScreenMute, lockScreenFreeze:
+	default:
+		cm.logger.Warnw("Invalid lock screen policy, using default",
+			"lockScreen", cm.Config.LockScreen,
+			"default", lockScreenKeep)
+
+		cm.Config.LockScreen = lockScreenKeep
+	}
+
+	switch cm.Config.Protocol {
+	case protocolAnalog, protocolEncoder, protocolMixed:
+	default:
+		cm.logger.Warnw("Invalid protocol, using default",
+			"protocol", cm.Config.Protocol,
+			"default", protocolMixed)
+
+		cm.Config.Protocol = protocolMixed
+	}
+
+	cm.applyActiveProfile()
+	cm.configValues = configSliderValues(cm.Config.SliderMappings)
+	cm.restoreSliderState()
+	cm.templatedSliderMappings = resolveSliderVariables(cm.logger, cm.Config)
+	cm.applyImplicitMapping(previousSliderMappings)
+	cm.notifyImplicitMapping()
+	validateVolumeShapes(cm.logger, cm.Config.SliderMappings)
+	validateSliderGroups(cm.logger, cm.Config.SliderMappings)
+	validateSliderPresets(cm.logger, cm.Config.SliderMappings)
+	validateSliderColors(cm.logger, cm.Config.SliderMappings)
+
+	cm.Config.Rules = validRules(cm.logger, cm.Config.Rules)
+	cm.Config.APITokens = validAPITokens(cm.logger, cm.Config.APITokens)
+	cm.Config.MIDIMappings = validMIDIMappings(cm.logger, cm.Config.MIDIMappings)
+	cm.Config.OnStartup = validStartupActions(cm.logger, cm.Config.OnStartup)
+	cm.Config.Boost = validBoost(cm.logger, cm.Config.Boost)
+
+	for sliderID, level := range cm.Config.Meeting.Levels {
+		if level < 0 || level > 1 {
+			cm.logger.Warnw("Ignoring meeting level outside of 0-1", "slider", sliderID, "level", level)
+			delete(cm.Config.Meeting.Levels, sliderID)
+		}
+	}
+
+	cm.Config.Connections = validConnections(cm.logger, cm.Config)
+	claimedSliderKeys := map[string]bool{}
+	for _, connection := range cm.Config.Connections {
+		for _, key := range connection.Sliders {
+			claimedSliderKeys[key] = true
+		}
+	}
+
+	// the mappings are in a map, the order comes from the file itself (see config_order.go)
+	cm.orderedSliderKeys = orderSliderKeys(cm.Config.SliderMappings, documentSliderKeys(contents, cm.activeProfile))
+	cm.hardwareSliderKeys = make([]string, 0, len(cm.Config.SliderMappings))
+	for _, key := range cm.orderedSliderKeys {
+
+		// only non-virtual sliders get a channel index on the device, and the main board's channels
+		// skip the sliders that other boards drive
+		if !cm.Config.SliderMappings[key].Virtual && !claimedSliderKeys[key] {
+			cm.hardwareSliderKeys = append(cm.hardwareSliderKeys, key)
+		}
+	}
+
+	warnDuplicateTargets(cm.logger, cm.Config.SliderMappings)
+
+	cm.changedSliderKeys = diffSliderMappings(previousSliderMappings, cm.Config.SliderMappings)
+	cm.movedSliderKeys = movedSliders(previousConfigValues, cm.configValues)
+
+	// another profile's sliders start out wherever that profile left them, so every one of them is re-applied (and
+	// motorized faders move to them), even those that happen to look the same as the previous profile's
+	if previousSliderMappings != nil && previousProfile != cm.activeProfile {
+		cm.changedSliderKeys = funk.UniqString(append(cm.changedSliderKeys, cm.orderedSliderKeys...))
+		cm.movedSliderKeys = append([]string{}, cm.orderedSliderKeys...)
+	}
+	cm.rememberSyncedCopy(cm.Config.SyncHooks)
+	cm.rememberLoadedOverrides()
+
+	cm.logger.Infof("Config loaded successfully with ordered keys: %+v", cm.orderedSliderKeys)
+	return nil
+}
+
+func (cm *ConfigManager) SaveConfig() error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	hooks := cm.Config.SyncHooks
+	if cm.syncConflict(hooks) {
+		return cm.saveConflict()
+	}
+
+	// what to write goes by what's in the file, so it has to be worked out before the file's truncated
+	document, indent, err := cm.documentToSave()
+	if err != nil {
+		cm.logger.Warnw("Failed to encode config", "error", err)
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(indent)
+
+	if err := encoder.Encode(document); err != nil {
+		cm.logger.Warnw("Failed to encode config", "error", err)
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if err := encoder.Close(); err != nil {
+		cm.logger.Warnw("Failed to encode config", "error", err)
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	// the file is replaced as a whole, so deej going down halfway through can't leave half a config behind
+	if err := cm.writeConfigFile(buf.Bytes(), cm.Config.ConfigBackups); err != nil {
+		cm.logger.Warnw("Failed to write config file", "error", err)
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	// Reset the modified flag
+	cm.configModified = false
+	cm.logger.Info("Config saved successfully to disk")
+
+	// push the change out in the background, without holding up anyone waiting on the lock
+	if hooks.AfterSave != "" {
+		go func() {
+			if err := cm.runSyncHook("after_save", hooks.AfterSave); err == nil {
+				cm.rememberSyncedCopy(hooks)
+			}
+		}()
+	}
+
+	return nil
+}
+
+// encodeConfig writes the config deej is running with as YAML, including changes that weren't saved yet
+func (cm *ConfigManager) encodeConfig(w io.Writer) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	encoder := yaml.NewEncoder(w)
+	if err := encoder.Encode(cm.unresolvedConfig()); err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+
+	return encoder.Close()
+}
+
+// replaceConfigFile overwrites the config file with the given contents, as long as they decode into a config.
+// it doesn't load them itself, that's left to the config watcher like with any other edit
+func (cm *ConfigManager) replaceConfigFile(contents []byte) error {
+	migrated, _, err := migrateConfig(cm.logger, contents)
+	if err != nil {
+		return fmt.Errorf("migrate config: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(migrated))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(newDefaultConfig()); err != nil {
+		return fmt.Errorf("decode config: %w", err)
+	}
+
+	if err := cm.checkProtectedSettings(migrated); err != nil {
+		return err
+	}
+
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if err := cm.writeConfigFile(contents, cm.Config.ConfigBackups); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+
+	// unsaved settings changes belong to the config that was just replaced, saving them would undo the replacement
+	cm.configModified = false
+
+	return nil
+}
+
+// checkProtectedSettings makes sure a replacement config leaves the sync hooks and API tokens as the config file
+// (and the files it includes) has them. sync hooks run shell commands and the tokens are what keeps the API
+// locked down, so neither may be changed by anyone who can only replace the config from outside
+func (cm *ConfigManager) checkProtectedSettings(replacement []byte) error {
+	current, err := ioutil.ReadFile(cm.configFilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read config: %w", err)
+	}
+
+	current, _, err = migrateConfig(cm.logger, current)
+	if err != nil {
+		return fmt.Errorf("migrate config: %w", err)
+	}
+
+	before, err := cm.protectedSettings(current)
+	if err != nil {
+		return err
+	}
+
+	after, err := cm.protectedSettings(replacement)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(before.SyncHooks, after.SyncHooks) {
+		return errors.New("sync_hooks can't be changed from outside, only in the config file itself")
+	}
+
+	if !reflect.DeepEqual(before.APITokens, after.APITokens) {
+		return errors.New("api_tokens can't be changed from outside, only in the config file itself")
+	}
+
+	return nil
+}
+
+// protectedSettings returns the config the given (migrated) contents make with their includes, for its sync
+// hooks and API tokens
+func (cm *ConfigManager) protectedSettings(contents []byte) (*Config, error) {
+	merged, _, _, err := cm.resolveIncludes(contents)
+	if err != nil {
+		return nil, fmt.Errorf("resolve includes: %w", err)
+	}
+
+	config := newDefaultConfig()
+	if err := yaml.Unmarshal(merged, config); err != nil {
+		return nil, fmt.Errorf("decode config: %w", err)
+	}
+
+	return config, nil
+}
+
+// SaveConfigWhenModified persists deej's own changes for as long as it runs: slider values to the state file,
+// and settings (i.e. inverting the sliders) to the config.
+// It sleeps until something is modified, then waits out the given delay so a burst of changes is saved once
+func (cm *ConfigManager) SaveConfigWhenModified(delay time.Duration) {
+	for {
+		select {
+		case <-cm.modifiedChannel:
+			cm.wakeups.record("config_save")
+
+			select {
+			case <-time.After(delay):
+			case <-cm.stopWatcherChannel:
+				cm.logger.Debug("Stopping config save")
+				return
+			}
+
+			var shouldSave bool
+
+			// Check if config is modified inside the lock
+			// TODO - separate function to get "shouldSave"?
+			// Possibly just call save instead and have that function handle it since it already has it's own lock.
+			// Ran into a deadlock here by locking then calling save while locked, so above would be good change.
+			cm.lock.Lock()
+			if cm.configModified {
+				cm.logger.Info("Config modified, preparing to save...")
+				shouldSave = true
+			}
+
+			if cm.stateModified {
+				if err := cm.saveState(); err != nil {
+					cm.logger.Warnw("Failed to save state to disk", "error", err)
+				}
+			}
+			cm.lock.Unlock() // Release lock before saving to avoid deadlock
+
+			// If modified, save the config
+			if shouldSave {
+				if err := cm.SaveConfig(); err != nil {
+					cm.logger.Warnw("Failed to save config to disk", "error", err)
+				} else {
+					cm.logger.Info("Config saved to disk")
+				}
+			}
+
+		case <-cm.stopWatcherChannel:
+			cm.logger.Debug("Stopping config save")
+			return
+		}
+	}
+}
+
+// flushModified saves deej's own changes to the config right away, rather than after the saver's delay. this is
+// for when deej stops, with the saver already gone
+func (cm *ConfigManager) flushModified() error {
+	cm.lock.Lock()
+	modified := cm.configModified
+
+	if cm.stateModified {
+		if err := cm.saveState(); err != nil {
+			cm.lock.Unlock()
+			return fmt.Errorf("save state: %w", err)
+		}
+	}
+	cm.lock.Unlock()
+
+	if !modified {
+		return nil
+	}
+
+	cm.logger.Info("Saving config changes before stopping")
+
+	return cm.SaveConfig()
+}
+
+// markModified flags the config for saving and wakes up the saver. assumes the lock is held
+func (cm *ConfigManager) markModified() {
+	cm.configModified = true
+	cm.wakeSaver()
+}
+
+// wakeSaver lets the saver know there's something to save, be it the config or the state. assumes the lock is held
+func (cm *ConfigManager) wakeSaver() {
+	select {
+	case cm.modifiedChannel <- true:
+	default:
+	}
+}
+
+// SubscribeToChanges allows external components to subscribe to config reload notifications
+func (cm *ConfigManager) SubscribeToChanges() chan bool {
+	c := make(chan bool)
+
+	cm.reloadConsumerLock.Lock()
+	cm.reloadConsumers = append(cm.reloadConsumers, c)
+	cm.reloadConsumerLock.Unlock()
+
+	return c
+}
+
+// WatchConfigFileChanges starts watching the configuration file for changes and reloads it when modified
+func (cm *ConfigManager) WatchConfigFileChanges() {
+	cm.logger.Debugw("Watching config file for changes", "path", cm.configFilePath)
+
+	const minTimeBetweenReloads = 500 * time.Millisecond
+	const delayAfterChange = 50 * time.Millisecond
+
+	lastReload := time.Now()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		cm.logger.Errorw("Failed to create file watcher", "error", err)
+		return
+	}
+	defer watcher.Close()
+
+	// saving the config replaces the file rather than writing to it, which a watch on the file itself wouldn't survive
+	if err := watcher.Add(filepath.Dir(cm.configFilePath)); err != nil {
+		cm.logger.Errorw("Failed to watch config file", "path", cm.configFilePath, "error", err)
+		return
+	}
+
+	// the files the config includes are watched the same way, wherever they are (i.e. a synced folder)
+	watchIncludedFiles := func() {
+		for _, path := range cm.getIncludedFiles() {
+			if err := watcher.Add(filepath.Dir(path)); err != nil {
+				cm.logger.Warnw("Failed to watch included config file", "path", path, "error", err)
+			}
+		}
+	}
+
+	watchIncludedFiles()
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			if !cm.isConfigFile(event.Name) {
+				continue
+			}
+
+			cm.wakeups.record("config_watcher")
+
+			if event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				now := time.Now()
+
+				if lastReload.Add(minTimeBetweenReloads).Before(now) {
+					cm.logger.Debugw("Config file modified, reloading", "event", event)
+
+					time.Sleep(delayAfterChange)
+
+					if err := cm.Load(); err != nil {
+						cm.logger.Warnw("Failed to reload config", "error", err)
+					} else {
+						cm.logger.Info("Config reloaded successfully")
+						cm.notifier.Notify("Configuration reloaded!", "Your changes have been applied.")
+						cm.notifySubscribers()
+
+						// the config may include other files now
+						watchIncludedFiles()
+					}
+					// the hooks and the load itself may have touched the file, those changes aren't new
+					lastReload = time.Now()
+				}
+			}
+		case <-cm.stopWatcherChannel:
+			cm.logger.Debug("Stopping config file watcher")
+			return
+		}
+	}
+}
+
+// Function to get the key by index using the ordered keys slice
+func (cm *ConfigManager) getSliderMappingByKey(key string) (SliderMapping, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	mapping, exists := cm.Config.SliderMappings[key]
+	if !exists {
+		return SliderMapping{}, fmt.Errorf("slider mapping with key '%s' not found", key)
+	}
+	return mapping, nil
+}
+
+// Function to get the mapping by its hardware channel index (virtual sliders don't have one)
+func (cm *ConfigManager) getSliderMappingByIndex(index int) (SliderMapping, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if index < 0 || index >= len(cm.hardwareSliderKeys) {
+		return SliderMapping{}, fmt.Errorf("invalid index '%d'", index)
+	}
+	key := cm.hardwareSliderKeys[index]
+	return cm.Config.SliderMappings[key], nil
+}
+
+// Function to get the key by index using the ordered keys slice, with error handling
+func (cm *ConfigManager) getSliderMappingKeys() ([]string, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if len(cm.orderedSliderKeys) == 0 {
+		return nil, fmt.Errorf("no slider mapping keys available")
+	}
+
+	// Return a copy of the orderedSliderKeys to avoid external modification
+	keys := make([]string, len(cm.orderedSliderKeys))
+	copy(keys, cm.orderedSliderKeys)
+
+	return keys, nil
+}
+
+// getQuantizationStep returns the configured quantization step as a scalar (i.e. 0.01 for 1%), or 0 if disabled
+func (cm *ConfigManager) getQuantizationStep() float32 {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.QuantizationStep / 100
+}
+
+func (cm *ConfigManager) getInvertSliders() bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.InvertSliders
+}
+
+// SetInvertSliders updates the invert flag and marks the config for saving
+func (cm *ConfigManager) SetInvertSliders(invert bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.Config.InvertSliders = invert
+	cm.markModified()
+	cm.logger.Debugw("Updated invert flag", "invert", invert)
+}
+
+// SetSliderInvert updates a slider's own invert flag and marks the config for saving
+func (cm *ConfigManager) SetSliderInvert(key string, invert bool) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	mapping, ok := cm.Config.SliderMappings[key]
+	if !ok {
+		return fmt.Errorf("slider mapping with key '%s' not found", key)
+	}
+
+	mapping.Invert = &invert
+	cm.Config.SliderMappings[key] = mapping
+	cm.markModified()
+	cm.logger.Debugw("Updated slider invert flag", "key", key, "invert", invert)
+
+	return nil
+}
+
+// getChangedSliderKeys returns the slider keys that were added, removed or modified by the latest load
+func (cm *ConfigManager) getChangedSliderKeys() []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	keys := make([]string, len(cm.changedSliderKeys))
+	copy(keys, cm.changedSliderKeys)
+
+	return keys
+}
+
+// getMovedSliderKeys returns the slider keys whose volume was changed in the config file by the latest load
+func (cm *ConfigManager) getMovedSliderKeys() []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	keys := make([]string, len(cm.movedSliderKeys))
+	copy(keys, cm.movedSliderKeys)
+
+	return keys
+}
+
+func (cm *ConfigManager) getProtocol() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.Protocol
+}
+
+func (cm *ConfigManager) getNoiseReductionLevel() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.NoiseReductionLevel
+}
+
+func (cm *ConfigManager) getTraceLatency() bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.TraceLatency
+}
+
+func (cm *ConfigManager) getAggregateChildren() bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.AggregateChildren
+}
+
+func (cm *ConfigManager) getDeviceSettings(deviceID string) (DeviceSettings, bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	settings, ok := cm.Config.Devices[deviceID]
+	return settings, ok
+}
+
+// SetDeviceInvert updates a specific device's invert flag and marks the config for saving
+func (cm *ConfigManager) SetDeviceInvert(deviceID string, invert bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	if cm.Config.Devices == nil {
+		cm.Config.Devices = map[string]DeviceSettings{}
+	}
+
+	settings := cm.Config.Devices[deviceID]
+	settings.Invert = &invert
+
+	cm.Config.Devices[deviceID] = settings
+	cm.markModified()
+	cm.logger.Debugw("Updated device invert flag", "deviceID", deviceID, "invert", invert)
+}
+
+func (cm *ConfigManager) getAPIAddress() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.APIAddress
+}
+
+func (cm *ConfigManager) getGRPCAddress() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.GRPCAddress
+}
+
+func (cm *ConfigManager) getAPITokens() []APIToken {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.APITokens
+}
+
+func (cm *ConfigManager) getRemoteControl() RemoteControl {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.RemoteControl
+}
+
+func (cm *ConfigManager) getReconnect() Reconnect {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.Reconnect
 }
 
-// NewConfigManager creates a new ConfigManager instance
-func NewConfigManager(logger *zap.SugaredLogger, notifier Notifier, configFilePath string) (*ConfigManager, error) {
-	logger = logger.Named("config")
+func (cm *ConfigManager) getNotificationDigest() NotificationDigest {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	cm := &ConfigManager{
-		logger:             logger,
-		notifier:           notifier,
-		stopWatcherChannel: make(chan bool),
-		reloadConsumers:    []chan bool{},
-		configFilePath:     configFilePath,
-		lock:               &sync.Mutex{},
-	}
+	return cm.Config.NotificationDigest
+}
 
-	logger.Debug("Created config manager instance")
-	return cm, nil
+// getNumberLocale returns the locale to format displayed numbers with, or an empty string for the system's
+func (cm *ConfigManager) getNumberLocale() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.NumberLocale
 }
 
-// Load loads the configuration file into the Config struct
-// Update the Load function to store keys in the order they appear in YAML
-func (cm *ConfigManager) Load() error {
-	cm.logger.Debugw("Loading config", "path", cm.configFilePath)
+func (cm *ConfigManager) getStartupVolumes() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	file, err := os.Open(cm.configFilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			cm.logger.Warnw("Config file not found", "path", cm.configFilePath)
-			cm.notifier.Notify("Can't find configuration!", fmt.Sprintf("%s must be in the directory. Please re-launch", cm.configFilePath))
-			return fmt.Errorf("config file doesn't exist: %s", cm.configFilePath)
-		}
-		return fmt.Errorf("error opening config file: %w", err)
-	}
-	defer file.Close()
+	return cm.Config.StartupVolumes
+}
 
-	decoder := yaml.NewDecoder(file)
-	decoder.KnownFields(true)
+func (cm *ConfigManager) getLockScreen() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	// What's the point of having defaults? It could be different on any system.
-	cm.Config = &Config{
-		ConfigSaveInterval: 60,
-		// Set default values
-		ConnectionInfo: ConnectionInfo{
-			SerialPort: "COM4",
-			BaudRate:   9600,
-		},
-	}
+	return cm.Config.LockScreen
+}
 
-	if err := decoder.Decode(cm.Config); err != nil {
-		cm.logger.Warnw("Failed to decode config", "error", err)
-		return fmt.Errorf("failed to decode config: %w", err)
-	}
+func (cm *ConfigManager) getConnections() []SerialConnection {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	// Populate orderedSliderKeys based on SliderMappings
-	cm.orderedSliderKeys = make([]string, 0, len(cm.Config.SliderMappings))
-	for key := range cm.Config.SliderMappings {
-		cm.orderedSliderKeys = append(cm.orderedSliderKeys, key)
-	}
+	return cm.Config.Connections
+}
 
-	cm.logger.Infof("Config loaded successfully with ordered keys: %+v", cm.orderedSliderKeys)
-	return nil
+func (cm *ConfigManager) getTelemetry() Telemetry {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.Telemetry
 }
 
-func (cm *ConfigManager) SaveConfig() error {
+func (cm *ConfigManager) getPulseServer() PulseServer {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	// Open the file for writing
-	file, err := os.Create(cm.configFilePath)
-	if err != nil {
-		cm.logger.Warnw("Failed to open config file for writing", "error", err)
-		return fmt.Errorf("failed to open config file for writing: %w", err)
-	}
-	defer file.Close()
+	return cm.Config.PulseServer
+}
 
-	encoder := yaml.NewEncoder(file)
-	defer encoder.Close()
+// getShutdownTimeout is safe to call before the config was ever loaded, in which case it returns the default
+func (cm *ConfigManager) getShutdownTimeout() time.Duration {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	// Write the current configuration to the file
-	if err := encoder.Encode(cm.Config); err != nil {
-		cm.logger.Warnw("Failed to encode config to file", "error", err)
-		return fmt.Errorf("failed to encode config to file: %w", err)
+	if cm.Config == nil || cm.Config.ShutdownTimeout <= 0 {
+		return defaultShutdownTimeout * time.Second
 	}
 
-	// Reset the modified flag
-	cm.configModified = false
-	cm.logger.Info("Config saved successfully to disk")
+	return time.Duration(cm.Config.ShutdownTimeout) * time.Second
+}
 
-	return nil
+func (cm *ConfigManager) getBoardFeedback() BoardFeedback {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.BoardFeedback
 }
 
-func (cm *ConfigManager) PeriodicallySaveConfig(interval time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+func (cm *ConfigManager) getButtonGestures() ButtonGestures {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	for {
-		select {
-		case <-ticker.C:
-			var shouldSave bool
+	return cm.Config.ButtonGestures
+}
 
-			// Check if config is modified inside the lock
-			// TODO - separate function to get "shouldSave"?
-			// Possibly just call save instead and have that function handle it since it already has it's own lock.
-			// Ran into a deadlock here by locking then calling save while locked, so above would be good change.
-			cm.lock.Lock()
-			if cm.configModified {
-				cm.logger.Info("Config modified, preparing to save...")
-				shouldSave = true
-			}
-			cm.lock.Unlock() // Release lock before saving to avoid deadlock
+func (cm *ConfigManager) getWebSocket() WebSocket {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-			// If modified, save the config
-			if shouldSave {
-				if err := cm.SaveConfig(); err != nil {
-					cm.logger.Warnw("Failed to save config to disk", "error", err)
-				} else {
-					cm.logger.Info("Config saved to disk")
-				}
-			}
+	return cm.Config.WebSocket
+}
 
-		case <-cm.stopWatcherChannel:
-			cm.logger.Debug("Stopping periodic config save")
-			return
-		}
-	}
+func (cm *ConfigManager) getStartupActions() []StartupAction {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.OnStartup
 }
 
-// SubscribeToChanges allows external components to subscribe to config reload notifications
-func (cm *ConfigManager) SubscribeToChanges() chan bool {
-	c := make(chan bool)
-	cm.reloadConsumers = append(cm.reloadConsumers, c)
-	return c
+func (cm *ConfigManager) getWidget() Widget {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.Widget
 }
 
-// WatchConfigFileChanges starts watching the configuration file for changes and reloads it when modified
-func (cm *ConfigManager) WatchConfigFileChanges() {
-	cm.logger.Debugw("Watching config file for changes", "path", cm.configFilePath)
+func (cm *ConfigManager) getMQTT() MQTT {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	const minTimeBetweenReloads = 500 * time.Millisecond
-	const delayAfterChange = 50 * time.Millisecond
+	return cm.Config.MQTT
+}
 
-	lastReload := time.Now()
+func (cm *ConfigManager) getMeeting() Meeting {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		cm.logger.Errorw("Failed to create file watcher", "error", err)
-		return
-	}
-	defer watcher.Close()
+	return cm.Config.Meeting
+}
 
-	if err := watcher.Add(cm.configFilePath); err != nil {
-		cm.logger.Errorw("Failed to watch config file", "path", cm.configFilePath, "error", err)
-		return
-	}
+func (cm *ConfigManager) getBoost() Boost {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	for {
-		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
-			if event.Op&fsnotify.Write == fsnotify.Write {
-				now := time.Now()
+	return cm.Config.Boost
+}
 
-				if lastReload.Add(minTimeBetweenReloads).Before(now) {
-					cm.logger.Debugw("Config file modified, reloading", "event", event)
+func (cm *ConfigManager) getIPC() IPC {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-					time.Sleep(delayAfterChange)
+	return cm.Config.IPC
+}
 
-					if err := cm.Load(); err != nil {
-						cm.logger.Warnw("Failed to reload config", "error", err)
-					} else {
-						cm.logger.Info("Config reloaded successfully")
-						cm.notifier.Notify("Configuration reloaded!", "Your changes have been applied.")
-						cm.notifySubscribers()
-					}
-					lastReload = now
-				}
-			}
-		case <-cm.stopWatcherChannel:
-			cm.logger.Debug("Stopping config file watcher")
-			return
-		}
-	}
+func (cm *ConfigManager) getMIDIDevice() string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.MIDIDevice
 }
 
-// Function to get the key by index using the ordered keys slice
-func (cm *ConfigManager) getSliderMappingByKey(key string) (SliderMapping, error) {
+func (cm *ConfigManager) getMIDIMappings() []MIDIMapping {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	mapping, exists := cm.Config.SliderMappings[key]
-	if !exists {
-		return SliderMapping{}, fmt.Errorf("slider mapping with key '%s' not found", key)
-	}
-	return mapping, nil
+	return cm.Config.MIDIMappings
 }
 
-// Function to get the key by index using the ordered keys slice
-func (cm *ConfigManager) getSliderMappingByIndex(index int) (SliderMapping, error) {
+func (cm *ConfigManager) getMotorizedFaders() bool {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	if index < 0 || index >= len(cm.orderedSliderKeys) {
-		return SliderMapping{}, fmt.Errorf("invalid index '%d'", index)
-	}
-	key := cm.orderedSliderKeys[index]
-	return cm.Config.SliderMappings[key], nil
+	return cm.Config.MotorizedFaders
 }
 
-// Function to get the key by index using the ordered keys slice, with error handling
-func (cm *ConfigManager) getSliderMappingKeys() ([]string, error) {
+func (cm *ConfigManager) getMiniMixer() MiniMixer {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	if len(cm.orderedSliderKeys) == 0 {
-		return nil, fmt.Errorf("no slider mapping keys available")
-	}
+	return cm.Config.MiniMixer
+}
 
-	// Return a copy of the orderedSliderKeys to avoid external modification
-	keys := make([]string, len(cm.orderedSliderKeys))
-	copy(keys, cm.orderedSliderKeys)
+// getRules returns the config's rules, along with the active profile they're checked against
+func (cm *ConfigManager) getRules() ([]Rule, string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	return keys, nil
+	return cm.Config.Rules, cm.activeProfile
 }
 
+// getSliderMappingCount returns the number of hardware (non-virtual) slider mappings
 func (cm *ConfigManager) getSliderMappingCount() int {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	return len(cm.Config.SliderMappings)
+	return len(cm.hardwareSliderKeys)
 }
 
-// Function to get the key by index using the ordered keys slice, with error handling
+// Function to get the key by hardware channel index, with error handling
 func (cm *ConfigManager) getSliderMappingKeyByIndex(index int) (string, error) {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	if index < 0 || index >= len(cm.orderedSliderKeys) {
+	if index < 0 || index >= len(cm.hardwareSliderKeys) {
 		return "", fmt.Errorf("index %d is out of range", index)
 	}
 
-	return cm.orderedSliderKeys[index], nil
+	return cm.hardwareSliderKeys[index], nil
+}
+
+// getHardwareSliderIndex returns a slider's hardware channel index, unless it's virtual (or doesn't exist)
+func (cm *ConfigManager) getHardwareSliderIndex(key string) (int, bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	for idx, hardwareKey := range cm.hardwareSliderKeys {
+		if hardwareKey == key {
+			return idx, true
+		}
+	}
+
+	return 0, false
+}
+
+// getVirtualSliderKeys returns the keys of all virtual slider mappings
+func (cm *ConfigManager) getVirtualSliderKeys() []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	keys := []string{}
+	for _, key := range cm.orderedSliderKeys {
+		if cm.Config.SliderMappings[key].Virtual {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
 }
 
 func (cm *ConfigManager) UpdateSliderMappingByKey(key string, mapping SliderMapping) {
@@ -294,7 +1466,7 @@ func (cm *ConfigManager) UpdateSliderMappingByKey(key string, mapping SliderMapp
 	defer cm.lock.Unlock()
 
 	cm.Config.SliderMappings[key] = mapping
-	cm.configModified = true
+	cm.recordSliderState(key)
 	cm.logger.Debugw("Updated slider mapping", "key", key)
 }
 
@@ -302,21 +1474,30 @@ func (cm *ConfigManager) UpdateSliderMappingByIndex(index int, mapping SliderMap
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
 
-	var key string = cm.orderedSliderKeys[index]
+	var key string = cm.hardwareSliderKeys[index]
 	cm.Config.SliderMappings[key] = mapping
-	cm.configModified = true
+	cm.recordSliderState(key)
 	cm.logger.Debugw("Updated slider mapping", "key", key)
 }
 
-// StopWatchingConfigFile stops watching the configuration file
+// StopWatchingConfigFile stops watching the configuration file, and saving our own changes to it
 func (cm *ConfigManager) StopWatchingConfigFile() {
-	cm.stopWatcherChannel <- true
+	cm.stopOnce.Do(func() {
+		close(cm.stopWatcherChannel)
+	})
 }
 
 // notifySubscribers notifies all subscribed components of a config reload
 func (cm *ConfigManager) notifySubscribers() {
 	cm.logger.Debug("Notifying subscribers about config reload")
-	for _, subscriber := range cm.reloadConsumers {
+
+	// subscribers can come along from any goroutine, even while others are being notified. appending never
+	// changes the part of the list taken here, so it's safe to go through without the lock
+	cm.reloadConsumerLock.Lock()
+	subscribers := cm.reloadConsumers
+	cm.reloadConsumerLock.Unlock()
+
+	for _, subscriber := range subscribers {
 		subscriber <- true
 	}
 }
@@ -341,6 +1522,97 @@ func (cm *ConfigManager) getSliderMappings() ([]SliderMapping, error) {
 
 	return mappings, nil
 }
-func (cm *ConfigManager) StopPeriodicSave() {
-	cm.stopWatcherChannel <- true
+func (cm *ConfigManager) StopSaving() {
+	cm.StopWatchingConfigFile()
+}
+
+// diffSliderMappings returns the keys of all slider mappings that differ between old and new
+func diffSliderMappings(old map[string]SliderMapping, new map[string]SliderMapping) []string {
+	changed := []string{}
+
+	for key, newMapping := range new {
+		if oldMapping, ok := old[key]; !ok || !oldMapping.equals(newMapping) {
+			changed = append(changed, key)
+		}
+	}
+
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+
+	return changed
+}
+
+// movedSliders returns the keys of sliders whose volume in the file differs from what it had before.
+// the first load doesn't count
+func movedSliders(previous map[string]sliderValues, current map[string]sliderValues) []string {
+	moved := []string{}
+
+	for key, values := range current {
+		if previousValues, ok := previous[key]; ok && previousValues.Volume != values.Volume {
+			moved = append(moved, key)
+		}
+	}
+
+	return moved
+}
+
+func (sm SliderMapping) equals(other SliderMapping) bool {
+	if sm.Volume != other.Volume ||
+		sm.Muted != other.Muted ||
+		sm.Virtual != other.Virtual ||
+		sm.Group != other.Group ||
+		sm.Order != other.Order ||
+		sm.Locked != other.Locked ||
+		sm.Color != other.Color ||
+		!sm.Curve.equals(other.Curve) ||
+		sm.Min != other.Min ||
+		sm.Max != other.Max {
+		return false
+	}
+
+	if len(sm.Targets) != len(other.Targets) {
+		return false
+	}
+
+	for idx, target := range sm.Targets {
+		if target != other.Targets[idx] {
+			return false
+		}
+	}
+
+	if len(sm.Presets) != len(other.Presets) {
+		return false
+	}
+
+	for idx, preset := range sm.Presets {
+		if preset != other.Presets[idx] {
+			return false
+		}
+	}
+
+	if len(sm.Offsets) != len(other.Offsets) {
+		return false
+	}
+
+	for target, offset := range sm.Offsets {
+		if otherOffset, ok := other.Offsets[target]; !ok || offset != otherOffset {
+			return false
+		}
+	}
+
+	return true
+}
+
+// offsetFor returns the given target's offset as a scalar (i.e. -0.1), or 0 if it doesn't have one
+func (sm SliderMapping) offsetFor(target string) float32 {
+	for offsetTarget, offset := range sm.Offsets {
+		if strings.EqualFold(offsetTarget, target) {
+			return offset / 100
+		}
+	}
+
+	return 0
 }
@@ -0,0 +1,380 @@
+package deej
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+
+	// the key yaml uses to merge another mapping into one, i.e. "<<: *base"
+	yamlMergeKey = "<<"
+
+	// how far a config deej wrote from scratch indents, and one it can't tell the indentation of
+	defaultConfigIndent = 4
+)
+
+// documentToSave returns the config as it should be written out, and how far to indent it. deej only rewrites what
+// changed: the config file's comments, key order, quoting and indentation stay as they were, and so do its anchors,
+// aliases and merge keys wherever what they stand for is still the same (so a mapping defined once and reused across
+// profiles stays that way after deej saves a volume change). cm.lock must be held
+func (cm *ConfigManager) documentToSave() (*yaml.Node, int, error) {
+	updated := &yaml.Node{}
+	if err := updated.Encode(cm.unresolvedConfig()); err != nil {
+		return nil, 0, fmt.Errorf("encode config: %w", err)
+	}
+
+	// overridden values are only for running with
+	cm.restoreOverriddenValues(updated)
+
+	contents, err := ioutil.ReadFile(cm.configFilePath)
+	if err != nil {
+		return updated, defaultConfigIndent, nil
+	}
+
+	existing := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, existing); err != nil || len(existing.Content) != 1 {
+		return updated, defaultConfigIndent, nil
+	}
+
+	// options the file leaves out are left out, as long as they're at their default (or what the files it includes
+	// say, which is what they'd be without it)
+	defaults := &yaml.Node{}
+	if err := defaults.Encode(newDefaultConfig()); err != nil {
+		return nil, 0, fmt.Errorf("encode default config: %w", err)
+	}
+
+	if cm.includedConfig != nil {
+		defaults = mergeNodes(defaults, cm.includedConfig)
+	}
+
+	changedAnchors := map[*yaml.Node]bool{}
+	findChangedAnchors(existing.Content[0], updated, changedAnchors)
+
+	// the document node holds the comments at the very top and bottom of the file
+	document := *existing
+	document.Content = []*yaml.Node{reconcileNode(existing.Content[0], updated, defaults, changedAnchors)}
+
+	// whatever the anchors were tangled up in, the file must end up saying exactly what deej meant to save
+	if !loadsAs(&document, updated, cm.includedConfig) {
+		cm.logger.Debug("Couldn't save the config as it was written, saving it from scratch")
+		return updated, defaultConfigIndent, nil
+	}
+
+	untagMergeKeys(&document)
+
+	return &document, configIndent(contents), nil
+}
+
+// findChangedAnchors marks the existing anchored nodes that are about to change. aliases (and merges) of them can't
+// stay, since they'd take on the change too
+func findChangedAnchors(existing *yaml.Node, updated *yaml.Node, changed map[*yaml.Node]bool) {
+	if existing.Anchor != "" && !sameYAMLValue(existing, updated) {
+		changed[existing] = true
+	}
+
+	switch {
+	case existing.Kind == yaml.MappingNode && updated.Kind == yaml.MappingNode:
+		for idx := 0; idx+1 < len(updated.Content); idx += 2 {
+			if value := mappingValue(existing, updated.Content[idx].Value); value != nil {
+				findChangedAnchors(value, updated.Content[idx+1], changed)
+			}
+		}
+
+	case existing.Kind == yaml.SequenceNode && updated.Kind == yaml.SequenceNode:
+		for idx := 0; idx < len(existing.Content) && idx < len(updated.Content); idx++ {
+			findChangedAnchors(existing.Content[idx], updated.Content[idx], changed)
+		}
+	}
+}
+
+// reconcileNode returns the existing node where it already says what the updated one does, and the updated one
+// otherwise. mappings and sequences are reconciled entry by entry, and a changed scalar only takes on the new value,
+// so a change deep inside the config only replaces what it has to and keeps the comments around it. defaults is what the config has where the file doesn't say, if anything
+func reconcileNode(existing *yaml.Node, updated *yaml.Node, defaults *yaml.Node, changedAnchors map[*yaml.Node]bool) *yaml.Node {
+	if !refersToAnchors(existing, changedAnchors) && sameYAMLValue(existing, updated) {
+		return existing
+	}
+
+	switch {
+	case existing.Kind == yaml.MappingNode && updated.Kind == yaml.MappingNode:
+		return reconcileMapping(existing, updated, defaults, changedAnchors)
+
+	case existing.Kind == yaml.SequenceNode && updated.Kind == yaml.SequenceNode:
+		sequence := *existing
+		sequence.Content = make([]*yaml.Node, len(updated.Content))
+
+		for idx := range updated.Content {
+			sequence.Content[idx] = updated.Content[idx]
+			if idx < len(existing.Content) {
+				sequence.Content[idx] = reconcileNode(existing.Content[idx], updated.Content[idx], nil, changedAnchors)
+			}
+		}
+
+		return &sequence
+
+	case existing.Kind == yaml.ScalarNode && updated.Kind == yaml.ScalarNode:
+		scalar := *existing
+		scalar.Value = updated.Value
+
+		// a value of another type (i.e. a number where there was a string) can't keep the old one's quoting
+		if existing.Tag != updated.Tag {
+			scalar.Tag = updated.Tag
+			scalar.Style = updated.Style
+		}
+
+		return &scalar
+	}
+
+	return updated
+}
+
+// reconcileMapping keeps the existing mapping's keys in their order, and appends the keys that are new. its merge
+// keys stay too (unless what they merge in is changing), and values the merges (or defaults) already provide
+// aren't spelled out
+func reconcileMapping(existing *yaml.Node, updated *yaml.Node, defaults *yaml.Node, changedAnchors map[*yaml.Node]bool) *yaml.Node {
+	mapping := *existing
+	mapping.Content = []*yaml.Node{}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode}
+	for idx := 0; idx+1 < len(existing.Content); idx += 2 {
+		key, value := existing.Content[idx], existing.Content[idx+1]
+		if key.Value == yamlMergeKey && !refersToAnchors(value, changedAnchors) {
+			merged.Content = append(merged.Content, mergedEntries(value)...)
+		}
+	}
+
+	for idx := 0; idx+1 < len(existing.Content); idx += 2 {
+		key, value := existing.Content[idx], existing.Content[idx+1]
+
+		switch updatedValue := explicitMappingValue(updated, key.Value); {
+		case key.Value == yamlMergeKey:
+			if !refersToAnchors(value, changedAnchors) {
+				mapping.Content = append(mapping.Content, key, value)
+			}
+
+		case updatedValue != nil:
+			mapping.Content = append(mapping.Content, key,
+				reconcileNode(value, updatedValue, defaultValue(defaults, key.Value), changedAnchors))
+
+		// deej leaves options at their zero value out, but one the user spelled out stays
+		case isZeroYAMLValue(value):
+			mapping.Content = append(mapping.Content, key, value)
+		}
+	}
+
+	for idx := 0; idx+1 < len(updated.Content); idx += 2 {
+		key, value := updated.Content[idx], updated.Content[idx+1]
+
+		if explicitMappingValue(existing, key.Value) != nil {
+			continue
+		}
+
+		// the first merged mapping that has the key is the one it comes from
+		if inherited := mappingValue(merged, key.Value); inherited != nil && sameYAMLValue(inherited, value) {
+			continue
+		}
+
+		fallback := defaultValue(defaults, key.Value)
+		if fallback != nil && sameYAMLValue(fallback, value) {
+			continue
+		}
+
+		// a section the file leaves out only gets what's different from the defaults (or the included files)
+		if fallback != nil && fallback.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			value = reconcileMapping(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, value, fallback, changedAnchors)
+		}
+
+		mapping.Content = append(mapping.Content, key, value)
+	}
+
+	return &mapping
+}
+
+// defaultValue returns the default under the given key, if there are defaults there at all
+func defaultValue(defaults *yaml.Node, key string) *yaml.Node {
+	if defaults == nil || defaults.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	return mappingValue(defaults, key)
+}
+
+// mergedEntries returns the key/value pairs a merge key's value brings in: an aliased mapping, or a list of them
+func mergedEntries(value *yaml.Node) []*yaml.Node {
+	switch value.Kind {
+	case yaml.AliasNode:
+		return mergedEntries(value.Alias)
+	case yaml.MappingNode:
+		return value.Content
+	case yaml.SequenceNode:
+		entries := []*yaml.Node{}
+		for _, item := range value.Content {
+			entries = append(entries, mergedEntries(item)...)
+		}
+
+		return entries
+	}
+
+	return nil
+}
+
+// explicitMappingValue is mappingValue without the merge key, which is never a key of the config's own
+func explicitMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if key == yamlMergeKey {
+		return nil
+	}
+
+	return mappingValue(mapping, key)
+}
+
+// untagMergeKeys clears the tag yaml gives merge keys when it reads them. left alone, they're written back
+// out as "!!merge <<", which means the same but puzzles anyone reading the file
+func untagMergeKeys(node *yaml.Node) {
+	for idx, child := range node.Content {
+		if node.Kind == yaml.MappingNode && idx%2 == 0 && child.Value == yamlMergeKey {
+			child.Tag = ""
+		}
+
+		untagMergeKeys(child)
+	}
+}
+
+// refersToAnchors tells whether a node is, or contains, an alias of any of the given anchored nodes
+func refersToAnchors(node *yaml.Node, anchors map[*yaml.Node]bool) bool {
+	if len(anchors) == 0 {
+		return false
+	}
+
+	if node.Kind == yaml.AliasNode {
+		return anchors[node.Alias]
+	}
+
+	for _, child := range node.Content {
+		if refersToAnchors(child, anchors) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameYAMLValue tells whether two nodes decode to the same value, with aliases and merges resolved. numbers are
+// compared the way the config holds them (as float32), so a volume isn't rewritten just because it's spelled differently,
+// and a key with a zero value is as good as a missing one, like it is to the config
+func sameYAMLValue(a *yaml.Node, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	var aValue, bValue interface{}
+	if a.Decode(&aValue) != nil || b.Decode(&bValue) != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(normalizeYAMLValue(aValue), normalizeYAMLValue(bValue))
+}
+
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case int:
+		return float32(value)
+	case float64:
+		return float32(value)
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for idx, item := range value {
+			normalized[idx] = normalizeYAMLValue(item)
+		}
+
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			if !isZeroDecodedValue(item) {
+				normalized[key] = normalizeYAMLValue(item)
+			}
+		}
+
+		return normalized
+	}
+
+	return value
+}
+
+// isZeroYAMLValue tells whether a node decodes to nothing, false, zero, an empty string or an empty collection
+func isZeroYAMLValue(node *yaml.Node) bool {
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return false
+	}
+
+	return isZeroDecodedValue(value)
+}
+
+func isZeroDecodedValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Slice, reflect.Map:
+		return reflected.Len() == 0
+	}
+
+	return reflected.IsZero()
+}
+
+// configIndent tells how far the config file indents, going by its first indented line. yaml can't say,
+// and writing it back with another indentation would change every line of it
+func configIndent(contents []byte) int {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " ")
+
+		if trimmed == "" || trimmed == line || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// a top-level list's items can be indented by their dash alone
+		if indent := len(line) - len(trimmed); indent >= 2 {
+			return indent
+		}
+	}
+
+	return defaultConfigIndent
+}
+
+// loadsAs tells whether a config file with the given document would load as the updated config, defaults (and the
+// files it includes, if any) and all
+func loadsAs(document *yaml.Node, updated *yaml.Node, included *yaml.Node) bool {
+	if included != nil {
+		document = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{mergeNodes(included, flattenNode(document.Content[0]))}}
+	}
+
+	contents, err := yaml.Marshal(document)
+	if err != nil {
+		return false
+	}
+
+	config := newDefaultConfig()
+	if err := yaml.Unmarshal(contents, config); err != nil {
+		return false
+	}
+
+	loaded := &yaml.Node{}
+	if err := loaded.Encode(config); err != nil {
+		return false
+	}
+
+	return sameYAMLValue(loaded, updated)
+}
@@ -0,0 +1,47 @@
+package deej
+
+// a config without any slider mappings still gets one slider, so a board (or an encoder) plugged in before
+// the config is filled in controls the master volume, instead of failing on every turn. it's never saved
+const implicitSliderKey = "master"
+
+// applyImplicitMapping gives a config without slider mappings the implicit master slider, keeping its volume
+// and mute state from the previous load. it starts out at full volume, but sessions adopt the actual master
+// volume for it rather than having it applied (see session_map.go)
+func (cm *ConfigManager) applyImplicitMapping(previous map[string]SliderMapping) {
+	wasImplicit := cm.implicitMapping
+	cm.implicitMapping = len(cm.Config.SliderMappings) == 0
+
+	if !cm.implicitMapping {
+		return
+	}
+
+	mapping := SliderMapping{Volume: 1, Targets: []string{masterSessionName}}
+	if previousMapping, ok := previous[implicitSliderKey]; ok && wasImplicit {
+		mapping.Volume = previousMapping.Volume
+		mapping.Muted = previousMapping.Muted
+	}
+
+	cm.Config.SliderMappings = map[string]SliderMapping{implicitSliderKey: mapping}
+}
+
+// notifyImplicitMapping lets the user know deej is making do with the implicit slider mapping,
+// once per run rather than on every reload
+func (cm *ConfigManager) notifyImplicitMapping() {
+	if !cm.implicitMapping || cm.implicitMappingNotified {
+		return
+	}
+
+	cm.implicitMappingNotified = true
+
+	cm.logger.Warnw("Config has no slider mappings, controlling the master volume", "slider", implicitSliderKey)
+	cm.notifier.Notify("No slider mappings",
+		"Your config doesn't map any sliders yet, so deej controls the master volume until it does.")
+}
+
+// isImplicitSlider tells whether the given slider is the implicit one, standing in for the config's own
+func (cm *ConfigManager) isImplicitSlider(key string) bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.implicitMapping && key == implicitSliderKey
+}
@@ -0,0 +1,312 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// a config can build on other config files, i.e. a base config that's synced between machines, included by each
+// machine's own config.yaml which then only says what's different there (like its COM port). included files go
+// underneath the file that includes them: mappings are merged key by key, all the way down, and anything else
+// (lists too) is replaced as a whole. a later include wins over an earlier one, and the including file wins over
+// all of them. deej saves its own changes to the config file itself, never to the files it includes
+const includeKey = "include"
+
+// ConfigIncludes lists the config files a config builds on, relative to the directory of the file that includes
+// them. In the config it's a single path (i.e. `include: shared.yaml`) or a list of them
+type ConfigIncludes []string
+
+// UnmarshalYAML reads either a single path or a list of them
+func (ci *ConfigIncludes) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return value.Decode((*[]string)(ci))
+	}
+
+	var path string
+	if err := value.Decode(&path); err != nil {
+		return err
+	}
+
+	*ci = nil
+	if path != "" {
+		*ci = ConfigIncludes{path}
+	}
+
+	return nil
+}
+
+// MarshalYAML writes a single path back as just that, the way it's usually written
+func (ci ConfigIncludes) MarshalYAML() (interface{}, error) {
+	if len(ci) == 1 {
+		return ci[0], nil
+	}
+
+	return []string(ci), nil
+}
+
+// resolveIncludes merges the files the config includes in underneath it. it returns the merged config, what the
+// includes add up to on their own (which is what the config file's own settings go on top of), and the paths of
+// every file that was included. a config without includes is returned as it is
+func (cm *ConfigManager) resolveIncludes(contents []byte) ([]byte, *yaml.Node, []string, error) {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return contents, nil, nil, nil
+	}
+
+	root := document.Content[0]
+	if mappingValue(root, includeKey) == nil {
+		return contents, nil, nil, nil
+	}
+
+	configPath, err := filepath.Abs(cm.configFilePath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("get config path: %w", err)
+	}
+
+	if err := decodeConfigFile(contents); err != nil {
+		return nil, nil, nil, fmt.Errorf("decode config %s: %w", configPath, err)
+	}
+
+	files := []string{}
+	included, err := cm.includeFiles(root, configPath, []string{configPath}, &files)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	document.Content[0] = mergeNodes(included, flattenNode(root))
+
+	merged, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("marshal merged config: %w", err)
+	}
+
+	return merged, included, files, nil
+}
+
+// includeFiles merges the files the given config node includes (in the order it lists them) into one.
+// chain is the files that led here, to catch a file that ends up including itself
+func (cm *ConfigManager) includeFiles(root *yaml.Node, path string, chain []string, files *[]string) (*yaml.Node, error) {
+	included := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	node := mappingValue(root, includeKey)
+	if node == nil {
+		return included, nil
+	}
+
+	var includes ConfigIncludes
+	if err := node.Decode(&includes); err != nil {
+		return nil, fmt.Errorf("decode includes of %s: %w", path, err)
+	}
+
+	for _, includePath := range includes {
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+
+		includePath = filepath.Clean(includePath)
+
+		for _, including := range chain {
+			if including == includePath {
+				return nil, fmt.Errorf("%s includes itself: %s", includePath, strings.Join(append(chain, includePath), " -> "))
+			}
+		}
+
+		file, err := cm.includeFile(includePath, append(chain, includePath), files)
+		if err != nil {
+			return nil, err
+		}
+
+		if file != nil {
+			included = mergeNodes(included, file)
+		}
+	}
+
+	return included, nil
+}
+
+// includeFile reads an included config file, along with whatever it includes in turn. a file that isn't there
+// is left out (i.e. the synced base config hasn't arrived on this machine yet), and deej goes on without it
+func (cm *ConfigManager) includeFile(path string, chain []string, files *[]string) (*yaml.Node, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			cm.logger.Warnw("Included config file not found, going without it", "path", path)
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("read included config %s: %w", path, err)
+	}
+
+	*files = append(*files, path)
+
+	// included files may be shared with machines running older versions of deej, so they're only ever
+	// migrated in memory
+	contents, _, err = migrateConfig(cm.logger, contents)
+	if err != nil {
+		return nil, fmt.Errorf("migrate included config %s: %w", path, err)
+	}
+
+	if err := decodeConfigFile(contents); err != nil {
+		return nil, fmt.Errorf("decode included config %s: %w", path, err)
+	}
+
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil {
+		return nil, fmt.Errorf("decode included config %s: %w", path, err)
+	}
+
+	// an empty file includes nothing, anything else has to be a config
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("included config %s isn't a mapping of settings", path)
+	}
+
+	included, err := cm.includeFiles(root, path, chain, files)
+	if err != nil {
+		return nil, err
+	}
+
+	root = flattenNode(root)
+	removeMappingKey(root, includeKey)
+
+	return mergeNodes(included, root), nil
+}
+
+// decodeConfigFile checks a single file of the config on its own, the way the whole config is decoded. the merged
+// config is written out anew, so a mistake only found there would point at a line of that rather than of the file
+func decodeConfigFile(contents []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	// an empty file is fine, it just doesn't set anything
+	if err := decoder.Decode(newDefaultConfig()); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	return nil
+}
+
+// getIncludedFiles returns the paths of the files the config included as of the latest load
+func (cm *ConfigManager) getIncludedFiles() []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return append([]string{}, cm.includedFiles...)
+}
+
+// isConfigFile tells whether the given path is the config file, or one of the files it included as of the latest load
+func (cm *ConfigManager) isConfigFile(path string) bool {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	if configPath, err := filepath.Abs(cm.configFilePath); err == nil && path == configPath {
+		return true
+	}
+
+	for _, included := range cm.getIncludedFiles() {
+		if path == included {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeNodes returns the overlay node on top of the base one: mappings are merged key by key (the overlay's
+// values winning), and anything else is the overlay's. neither node is changed
+func mergeNodes(base *yaml.Node, overlay *yaml.Node) *yaml.Node {
+	if base == nil || base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		return overlay
+	}
+
+	merged := *base
+	merged.Content = append([]*yaml.Node{}, base.Content...)
+
+	for idx := 0; idx+1 < len(overlay.Content); idx += 2 {
+		key, value := overlay.Content[idx], overlay.Content[idx+1]
+
+		found := false
+		for mergedIdx := 0; mergedIdx+1 < len(merged.Content); mergedIdx += 2 {
+			if merged.Content[mergedIdx].Value == key.Value {
+				merged.Content[mergedIdx+1] = mergeNodes(merged.Content[mergedIdx+1], value)
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			merged.Content = append(merged.Content, key, value)
+		}
+	}
+
+	return &merged
+}
+
+// flattenNode returns a copy of a node with its aliases and merge keys spelled out, and without anchors. once
+// files are merged, an alias could end up before the anchor it refers to (or in another file altogether)
+func flattenNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+
+	flattened := *node
+	flattened.Anchor = ""
+	flattened.Content = nil
+
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			flattened.Content = append(flattened.Content, flattenNode(child))
+		}
+
+		return &flattened
+	}
+
+	// a mapping's own keys win over merged ones, and a merged key goes where the merge key is
+	explicit := map[string]bool{}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		if key := node.Content[idx].Value; key != yamlMergeKey {
+			explicit[key] = true
+		}
+	}
+
+	seen := map[string]bool{}
+	add := func(key *yaml.Node, value *yaml.Node) {
+		if seen[key.Value] {
+			return
+		}
+
+		seen[key.Value] = true
+		flattened.Content = append(flattened.Content, flattenNode(key), flattenNode(value))
+	}
+
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key, value := node.Content[idx], node.Content[idx+1]
+
+		if key.Value != yamlMergeKey {
+			add(key, value)
+			continue
+		}
+
+		merged := flattenNode(&yaml.Node{Kind: yaml.MappingNode, Content: mergedEntries(value)})
+		for mergedIdx := 0; mergedIdx+1 < len(merged.Content); mergedIdx += 2 {
+			if !explicit[merged.Content[mergedIdx].Value] {
+				add(merged.Content[mergedIdx], merged.Content[mergedIdx+1])
+			}
+		}
+	}
+
+	return &flattened
+}
@@ -0,0 +1,293 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// bumped whenever the config's format changes in a way older configs need migrating for. a config without
+// config_version predates versioning, and is either this fork's format already or upstream deej's
+const currentConfigVersion = 1
+
+// configMigration brings a config up to its version. it works on the config's node tree, since the config
+// it gets wouldn't decode (that's why it needs migrating), and reports whether it had anything to do
+type configMigration struct {
+	version     int
+	description string
+	migrate     func(root *yaml.Node) (bool, error)
+}
+
+var configMigrations = []configMigration{
+	{version: 1, description: "upstream deej's slider_mapping and connection keys", migrate: migrateUpstreamConfig},
+}
+
+// migrateConfig runs the migrations the given config needs, and returns it in the current format along with
+// the version it was in. a config that needed nothing is returned as it was, comments and all
+func migrateConfig(logger *zap.SugaredLogger, contents []byte) ([]byte, int, error) {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil {
+		return nil, 0, fmt.Errorf("decode config: %w", err)
+	}
+
+	// anything that isn't a mapping is left for decoding to complain about
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return contents, 0, nil
+	}
+
+	root := document.Content[0]
+
+	version := 0
+	if node := mappingValue(root, "config_version"); node != nil {
+		parsed, err := strconv.Atoi(node.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("config_version %q isn't a number", node.Value)
+		}
+
+		version = parsed
+	}
+
+	if version > currentConfigVersion {
+		logger.Warnw("Config is from a newer version of deej, some of its settings may not work",
+			"configVersion", version, "supportedVersion", currentConfigVersion)
+
+		return contents, version, nil
+	}
+
+	migrated := false
+	for _, migration := range configMigrations {
+		if migration.version <= version {
+			continue
+		}
+
+		changed, err := migration.migrate(root)
+		if err != nil {
+			return nil, version, fmt.Errorf("migrate config to version %d: %w", migration.version, err)
+		}
+
+		if changed {
+			logger.Infow("Migrated config", "version", migration.version, "migration", migration.description)
+			migrated = true
+		}
+	}
+
+	if !migrated {
+		return contents, version, nil
+	}
+
+	// the version goes on top, where it's easy to spot
+	removeMappingKey(root, "config_version")
+	root.Content = append([]*yaml.Node{
+		scalarNode("config_version"),
+		{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(currentConfigVersion)},
+	}, root.Content...)
+
+	migratedContents, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, version, fmt.Errorf("marshal config: %w", err)
+	}
+
+	return migratedContents, version, nil
+}
+
+// migrate brings the config file's contents up to date, and when they needed migrating, writes them back with the
+// previous config kept next to it (i.e. config.yaml.v0.bak). outside of deej (i.e. "deej validate"), the file isn't touched
+func (cm *ConfigManager) migrate(contents []byte) ([]byte, error) {
+	migrated, version, err := migrateConfig(cm.logger, contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if version >= currentConfigVersion || string(migrated) == string(contents) {
+		return contents, nil
+	}
+
+	if cm.readOnly {
+		cm.logger.Warnw("Config is in an older format, deej will migrate it when it runs", "configVersion", version)
+		return migrated, nil
+	}
+
+	// the backup holds everything the config does, API tokens and other secrets included
+	backupPath := fmt.Sprintf("%s.v%d.bak", cm.configFilePath, version)
+	if err := ioutil.WriteFile(backupPath, contents, 0600); err != nil {
+		return nil, fmt.Errorf("back up config: %w", err)
+	}
+
+	if err := writeFileAtomically(cm.configFilePath, migrated); err != nil {
+		return nil, fmt.Errorf("write migrated config: %w", err)
+	}
+
+	cm.logger.Infow("Saved migrated config", "path", cm.configFilePath, "backup", backupPath)
+	cm.notifier.Notify("Config upgraded",
+		fmt.Sprintf("deej converted your config to its current format. The previous one is in %s.", backupPath))
+
+	return migrated, nil
+}
+
+// migrateUpstreamConfig converts upstream deej's config, with its numbered slider_mapping list and top-level
+// connection settings, into named slider mappings and connection_info
+func migrateUpstreamConfig(root *yaml.Node) (bool, error) {
+	changed := false
+
+	if sliders := mappingValue(root, "slider_mapping"); sliders != nil {
+		if mappingValue(root, "slider_mappings") != nil {
+			return false, errors.New("config has both slider_mapping and slider_mappings")
+		}
+
+		mappings, err := upstreamSliderMappings(sliders)
+		if err != nil {
+			return false, err
+		}
+
+		replaceMappingKey(root, "slider_mapping", scalarNode("slider_mappings"), mappings)
+		changed = true
+	}
+
+	connectionKeys := map[string]string{"com_port": "serial_port", "baud_rate": "baud_rate"}
+	for _, upstreamKey := range []string{"com_port", "baud_rate"} {
+		value := mappingValue(root, upstreamKey)
+		if value == nil {
+			continue
+		}
+
+		// connection_info takes the place of the first of them, comments and all
+		connectionInfo := mappingValue(root, "connection_info")
+		if connectionInfo == nil {
+			connectionInfo = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			replaceMappingKey(root, upstreamKey, scalarNode("connection_info"), connectionInfo)
+		} else {
+			removeMappingKey(root, upstreamKey)
+		}
+
+		setMappingValue(connectionInfo, scalarNode(connectionKeys[upstreamKey]), value)
+		changed = true
+	}
+
+	if value := mappingValue(root, "noise_reduction"); value != nil {
+		replaceMappingKey(root, "noise_reduction", scalarNode("noise_reduction_level"), value)
+		changed = true
+	}
+
+	// deej refreshes sessions on its own schedule now (see minTimeBetweenSessionRefreshes)
+	if mappingValue(root, "process_refresh_frequency") != nil {
+		removeMappingKey(root, "process_refresh_frequency")
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// upstreamSliderMappings turns upstream's slider_mapping (slider index to a target, or a list of them) into
+// slider mappings named after their first target, in the order of their indexes
+func upstreamSliderMappings(sliders *yaml.Node) (*yaml.Node, error) {
+	if sliders.Kind != yaml.MappingNode {
+		return nil, errors.New("slider_mapping isn't a mapping")
+	}
+
+	type upstreamSlider struct {
+		index   int
+		targets []string
+	}
+
+	upstream := []upstreamSlider{}
+	for idx := 0; idx+1 < len(sliders.Content); idx += 2 {
+		index, err := strconv.Atoi(sliders.Content[idx].Value)
+		if err != nil {
+			return nil, fmt.Errorf("slider_mapping key %q isn't a slider index", sliders.Content[idx].Value)
+		}
+
+		targets := []string{}
+		if err := sliders.Content[idx+1].Decode(&targets); err != nil {
+			target := ""
+			if err := sliders.Content[idx+1].Decode(&target); err != nil {
+				return nil, fmt.Errorf("slider %d: targets must be a name or a list of names", index)
+			}
+
+			targets = []string{target}
+		}
+
+		upstream = append(upstream, upstreamSlider{index: index, targets: targets})
+	}
+
+	sort.Slice(upstream, func(i, j int) bool { return upstream[i].index < upstream[j].index })
+
+	mappings := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	taken := map[string]bool{}
+
+	for _, slider := range upstream {
+		key := upstreamSliderKey(slider.index, slider.targets, taken)
+		taken[key] = true
+
+		mapping := &yaml.Node{}
+		if err := mapping.Encode(SliderMapping{Volume: 1, Targets: slider.targets}); err != nil {
+			return nil, fmt.Errorf("encode slider %d: %w", slider.index, err)
+		}
+
+		mappings.Content = append(mappings.Content, scalarNode(key), mapping)
+	}
+
+	return mappings, nil
+}
+
+// upstreamSliderKey names a migrated slider after its first target (i.e. "chrome" for chrome.exe, "unmapped"
+// for deej.unmapped), or after its index if that's taken or there's nothing to go by
+func upstreamSliderKey(index int, targets []string, taken map[string]bool) string {
+	key := ""
+	if len(targets) > 0 {
+		key = strings.ToLower(targets[0])
+		key = strings.TrimPrefix(key, specialTargetTransformPrefix)
+		key = strings.TrimSuffix(key, ".exe")
+	}
+
+	if key == "" || taken[key] {
+		key = fmt.Sprintf("slider_%d", index)
+	}
+
+	return key
+}
+
+// mappingValue returns the value under the given key in a yaml mapping, or nil if the key isn't there
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			return mapping.Content[idx+1]
+		}
+	}
+
+	return nil
+}
+
+// replaceMappingKey renames a key in a yaml mapping and gives it a new value, keeping its place (and comments)
+func replaceMappingKey(mapping *yaml.Node, key string, newKey *yaml.Node, value *yaml.Node) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			newKey.HeadComment = mapping.Content[idx].HeadComment
+			newKey.LineComment = mapping.Content[idx].LineComment
+
+			mapping.Content[idx] = newKey
+			mapping.Content[idx+1] = value
+
+			return
+		}
+	}
+}
+
+// removeMappingKey removes a key and its value from a yaml mapping
+func removeMappingKey(mapping *yaml.Node, key string) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key {
+			mapping.Content = append(mapping.Content[:idx], mapping.Content[idx+2:]...)
+			return
+		}
+	}
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
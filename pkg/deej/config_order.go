@@ -0,0 +1,113 @@
+package deej
+
+import (
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sliders are in the order the config file lists them, which is the order the encoder goes through them in
+// and the order classic boards' channels map to. decoding slider_mappings into a map loses that order, so it's
+// read off the file's node tree instead. a slider's order setting overrides it: sliders that have one come
+// first, lowest first, and the rest follow in the file's order
+
+// documentSliderKeys returns the slider keys of the given profile's mappings (or the config's own, for the
+// default profile) in the order the config file has them. mappings merged in with "<<" take the merge key's place
+func documentSliderKeys(contents []byte, profile string) []string {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil || len(document.Content) != 1 {
+		return nil
+	}
+
+	mappings := mappingValue(document.Content[0], "slider_mappings")
+	if profile != defaultProfileName {
+		mappings = nil
+
+		if profiles := mappingValue(document.Content[0], "profiles"); profiles != nil {
+			if profileNode := mappingValue(resolveAlias(profiles), profile); profileNode != nil {
+				mappings = mappingValue(resolveAlias(profileNode), "slider_mappings")
+			}
+		}
+	}
+
+	if mappings == nil {
+		return nil
+	}
+
+	return mappingKeys(resolveAlias(mappings))
+}
+
+// mappingKeys returns a yaml mapping's keys in order, with the keys of merged mappings in the merge key's place
+func mappingKeys(mapping *yaml.Node) []string {
+	keys := []string{}
+	seen := map[string]bool{}
+
+	var collect func(entries []*yaml.Node)
+	collect = func(entries []*yaml.Node) {
+		for idx := 0; idx+1 < len(entries); idx += 2 {
+			key := entries[idx].Value
+
+			if key == yamlMergeKey {
+				collect(mergedEntries(entries[idx+1]))
+				continue
+			}
+
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	collect(mapping.Content)
+
+	return keys
+}
+
+// resolveAlias returns the node an alias stands for, or the node itself if it isn't one
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// orderSliderKeys returns the keys of the given mappings in their order: by their order setting, then the
+// file's order. keys the file doesn't have (i.e. the implicit slider) go last, alphabetically
+func orderSliderKeys(mappings map[string]SliderMapping, documentKeys []string) []string {
+	position := make(map[string]int, len(documentKeys))
+	for idx, key := range documentKeys {
+		position[key] = idx
+	}
+
+	keys := make([]string, 0, len(mappings))
+	for key := range mappings {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := mappings[keys[i]], mappings[keys[j]]
+
+		switch {
+		case a.Order != 0 && b.Order != 0 && a.Order != b.Order:
+			return a.Order < b.Order
+		case (a.Order != 0) != (b.Order != 0):
+			return a.Order != 0
+		}
+
+		aPosition, aListed := position[keys[i]]
+		bPosition, bListed := position[keys[j]]
+
+		switch {
+		case aListed && bListed:
+			return aPosition < bPosition
+		case aListed != bListed:
+			return aListed
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,369 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// config options can be overridden for a run of deej without editing the file (i.e. for packaging, testing, or one
+// deej per board): from the command line with "--set connection_info.baud_rate=115200", or from the environment
+// with "DEEJ_CONNECTION_INFO_BAUD_RATE=115200". the most common ones have shortcuts, like "--serial-port COM5" and
+// "DEEJ_BAUD_RATE". the command line wins over the environment, and both win over the config file (and the files
+// it includes). deej runs with the overridden values but never saves them, and an option deej changes itself (i.e.
+// the active profile, switched from the tray) is the file's again from then on
+const configOverrideEnvPrefix = "DEEJ_"
+
+// ConfigOverrideShortcuts are the options common enough to have a flag and an environment variable of their own
+// (i.e. --serial-port and DEEJ_SERIAL_PORT), by the shortcut's name
+var ConfigOverrideShortcuts = map[string]string{
+	"serial_port": "connection_info.serial_port",
+	"baud_rate":   "connection_info.baud_rate",
+	"profile":     "active_profile",
+}
+
+// ConfigOverrides are config values to run with instead of the config file's, by the option's key as "deej
+// config-schema" lists them (i.e. connection_info.serial_port, or slider_mappings.mic.volume), written the way they
+// would be in the config file
+type ConfigOverrides map[string]string
+
+// configOverride is an override that was found to fit the config. loaded is its value as the latest load left it
+// (validation may have had its say), which tells whether deej changed it since
+type configOverride struct {
+	key    string
+	path   []string
+	value  *yaml.Node
+	loaded *yaml.Node
+}
+
+// ConfigOverridesFromEnvironment returns the overrides the given environment variables (as os.Environ has them)
+// make. variables that don't name an option (including deej's other ones, like DEEJ_NO_TRAY_ICON) are left alone
+func ConfigOverridesFromEnvironment(environment []string) ConfigOverrides {
+	keys := map[string]string{}
+
+	// options inside of lists and maps can only be set from the command line, their names don't make variable names
+	for _, option := range ConfigSchema() {
+		if !strings.Contains(option.Key, "[]") && !strings.Contains(option.Key, "<name>") {
+			keys[strings.ToUpper(strings.ReplaceAll(option.Key, ".", "_"))] = option.Key
+		}
+	}
+
+	for shortcut, key := range ConfigOverrideShortcuts {
+		keys[strings.ToUpper(shortcut)] = key
+	}
+
+	overrides := ConfigOverrides{}
+
+	for _, variable := range environment {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok || !strings.HasPrefix(name, configOverrideEnvPrefix) {
+			continue
+		}
+
+		if key, ok := keys[strings.TrimPrefix(name, configOverrideEnvPrefix)]; ok {
+			overrides[key] = value
+		}
+	}
+
+	return overrides
+}
+
+// setOverrides sets the overrides every load applies from now on. overrides that don't fit the config (an option
+// that doesn't exist, or a value it can't take) are ignored, and complained about
+func (cm *ConfigManager) setOverrides(overrides ConfigOverrides) {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	valid := []configOverride{}
+
+	for _, key := range keys {
+		override, err := newConfigOverride(key, overrides[key])
+		if err != nil {
+			cm.logger.Warnw("Ignoring config override", "key", key, "value", overrides[key], "error", err)
+			continue
+		}
+
+		cm.logger.Infow("Overriding config value", "key", key, "value", overrides[key])
+		valid = append(valid, override)
+	}
+
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.overrides = valid
+}
+
+// newConfigOverride makes an override's value into what the config file would have, and checks that a config
+// with it still decodes
+func newConfigOverride(key string, value string) (configOverride, error) {
+	path := strings.Split(key, ".")
+
+	option, ok := schemaOption(path)
+	if !ok {
+		return configOverride{}, fmt.Errorf("there's no %s option", key)
+	}
+
+	// strings are taken as they are, so values like "#ff8800" aren't read as something else
+	node := scalarNode(value)
+	if option.Type != "string" {
+		document := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(value), document); err != nil || len(document.Content) != 1 {
+			return configOverride{}, errors.New("value isn't valid yaml")
+		}
+
+		node = document.Content[0]
+	}
+
+	check := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setNodeAt(check, path, node)
+
+	contents, err := yaml.Marshal(check)
+	if err != nil {
+		return configOverride{}, fmt.Errorf("marshal override: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(newDefaultConfig()); err != nil {
+		return configOverride{}, fmt.Errorf("decode override: %w", err)
+	}
+
+	return configOverride{key: key, path: path, value: node}, nil
+}
+
+// schemaOption returns the config option at the given path, where a name in it can stand for any slider, profile
+// or other named thing. options inside of lists can't be overridden one by one
+func schemaOption(path []string) (ConfigOption, bool) {
+	for _, option := range ConfigSchema() {
+		optionPath := strings.Split(option.Key, ".")
+		if len(optionPath) != len(path) || strings.Contains(option.Key, "[]") {
+			continue
+		}
+
+		matches := true
+		for idx, segment := range optionPath {
+			if segment != path[idx] && segment != "<name>" {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return option, true
+		}
+	}
+
+	return ConfigOption{}, false
+}
+
+// applyOverrides puts the overrides' values into the config file's contents, and returns them along with what the
+// file had in their place (nil where it had nothing), by the override's key
+func (cm *ConfigManager) applyOverrides(contents []byte) ([]byte, map[string]*yaml.Node, error) {
+	cm.lock.Lock()
+	overrides := cm.overrides
+	cm.lock.Unlock()
+
+	if len(overrides) == 0 {
+		return contents, nil, nil
+	}
+
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(contents, document); err != nil || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		document = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	// an override of an aliased value mustn't change the other places it's used in
+	root := flattenNode(document.Content[0])
+	document.Content[0] = root
+
+	replaced := map[string]*yaml.Node{}
+	for _, override := range overrides {
+		replaced[override.key] = nodeAt(root, override.path)
+		setNodeAt(root, override.path, override.value)
+	}
+
+	overridden, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal overridden config: %w", err)
+	}
+
+	return overridden, replaced, nil
+}
+
+// restoreOverriddenValues puts the config file's own values back in place of the overrides' in a config about to
+// be saved. where deej changed an overridden value itself, its change is saved, and the override is dropped so
+// it doesn't undo the change on the next load. assumes the lock is held
+func (cm *ConfigManager) restoreOverriddenValues(updated *yaml.Node) {
+	if len(cm.overrides) == 0 {
+		return
+	}
+
+	// where the file had nothing, the value goes back to what it'd be without the override: the default, or what
+	// the included files say. it's then left out of the file again like any other value at its default
+	defaults := &yaml.Node{}
+	if err := defaults.Encode(newDefaultConfig()); err != nil {
+		cm.logger.Warnw("Failed to encode default config for its overrides", "error", err)
+	}
+
+	if cm.includedConfig != nil {
+		defaults = mergeNodes(defaults, cm.includedConfig)
+	}
+
+	remaining := []configOverride{}
+
+	for _, override := range cm.overrides {
+		if !sameYAMLValue(nodeAt(updated, override.path), override.loaded) {
+			cm.logger.Infow("Overridden config value changed, saving it and dropping the override", "key", override.key)
+			continue
+		}
+
+		remaining = append(remaining, override)
+
+		if original := cm.overriddenValues[override.key]; original != nil {
+			setNodeAt(updated, override.path, original)
+		} else if fallback := nodeAt(defaults, override.path); fallback != nil {
+			setNodeAt(updated, override.path, fallback)
+		} else if parent := nodeAt(updated, override.path[:len(override.path)-1]); parent != nil {
+			removeMappingKey(parent, override.path[len(override.path)-1])
+		}
+	}
+
+	cm.overrides = remaining
+}
+
+// rememberLoadedOverrides notes the overridden values as the load that just happened left them. assumes the lock
+// is held (or the config is being loaded)
+func (cm *ConfigManager) rememberLoadedOverrides() {
+	if len(cm.overrides) == 0 {
+		return
+	}
+
+	loaded := &yaml.Node{}
+	if err := loaded.Encode(cm.unresolvedConfig()); err != nil {
+		cm.logger.Warnw("Failed to encode config for its overrides", "error", err)
+		return
+	}
+
+	for idx := range cm.overrides {
+		cm.overrides[idx].loaded = nodeAt(loaded, cm.overrides[idx].path)
+	}
+}
+
+// EffectiveConfig returns a copy of the config deej runs with: the config file with the files it includes, the
+// active profile's mappings and any overrides applied. Nothing in it is shared with deej's own, so it can be
+// changed (or kept around) freely
+func (cm *ConfigManager) EffectiveConfig() Config {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return deepCopy(reflect.ValueOf(*cm.Config)).Interface().(Config)
+}
+
+// deepCopy copies a value all the way down: every map, slice and pointer in it is copied too, rather than shared
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.New(value.Elem().Type())
+		copied.Elem().Set(deepCopy(value.Elem()))
+
+		return copied
+
+	case reflect.Interface:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+
+		return copied
+
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for entries := value.MapRange(); entries.Next(); {
+			copied.SetMapIndex(entries.Key(), deepCopy(entries.Value()))
+		}
+
+		return copied
+
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for idx := 0; idx < value.Len(); idx++ {
+			copied.Index(idx).Set(deepCopy(value.Index(idx)))
+		}
+
+		return copied
+
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+
+		// unexported fields can't be set, and are left as they were copied along with the struct
+		for idx := 0; idx < value.NumField(); idx++ {
+			if field := copied.Field(idx); field.CanSet() {
+				field.Set(deepCopy(value.Field(idx)))
+			}
+		}
+
+		return copied
+	}
+
+	return value
+}
+
+// nodeAt returns the value at the given path of keys in nested yaml mappings, or nil if there's nothing there
+func nodeAt(mapping *yaml.Node, path []string) *yaml.Node {
+	for _, key := range path {
+		if mapping == nil || mapping.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		mapping = mappingValue(mapping, key)
+	}
+
+	return mapping
+}
+
+// setNodeAt puts a value at the given path of keys in nested yaml mappings, making the mappings on the way
+// where they're missing (or aren't mappings)
+func setNodeAt(mapping *yaml.Node, path []string, value *yaml.Node) {
+	for idx, key := range path {
+		child := mappingValue(mapping, key)
+
+		if idx == len(path)-1 {
+			child = value
+		} else if child == nil || child.Kind != yaml.MappingNode {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+
+		if existing := mappingValue(mapping, key); existing != nil {
+			replaceMappingKey(mapping, key, scalarNode(key), child)
+		} else {
+			mapping.Content = append(mapping.Content, scalarNode(key), child)
+		}
+
+		mapping = child
+	}
+}
@@ -0,0 +1,126 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// the config's own slider mappings make up a profile too, by this name. it's the active one unless the config
+// says otherwise, and a board cycling through profiles comes back around to it after the last one
+const defaultProfileName = "default"
+
+var errNoProfiles = errors.New("the config has no profiles")
+
+// applyActiveProfile puts the active profile's mappings in place of the config's own, which are kept aside
+// so they can be saved as they were. a profile that doesn't exist leaves the config's own mappings in place
+func (cm *ConfigManager) applyActiveProfile() {
+	cm.activeProfile = defaultProfileName
+	cm.baseSliderMappings = nil
+
+	name := cm.Config.ActiveProfile
+	if name == "" || name == defaultProfileName {
+		return
+	}
+
+	profile, ok := cm.Config.Profiles[name]
+	if !ok {
+		cm.logger.Warnw("Active profile doesn't exist, using the default one", "activeProfile", name)
+		return
+	}
+
+	cm.activeProfile = name
+	cm.baseSliderMappings = cm.Config.SliderMappings
+
+	cm.Config.SliderMappings = make(map[string]SliderMapping, len(profile.SliderMappings))
+	for key, mapping := range profile.SliderMappings {
+		cm.Config.SliderMappings[key] = mapping
+	}
+}
+
+// getProfiles returns the active profile's name, and every profile's: the default one first, then the config's
+// profiles in alphabetical order. a profile named after the default one can't be told apart from it, so it's left out
+func (cm *ConfigManager) getProfiles() (string, []string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	names := make([]string, 0, len(cm.Config.Profiles))
+	for name := range cm.Config.Profiles {
+		if name != defaultProfileName {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return cm.activeProfile, append([]string{defaultProfileName}, names...)
+}
+
+// switchProfile makes the given profile the active one. the changes deej made to the outgoing profile are
+// saved along with the switch, and the new profile's mappings are loaded like any other config change
+func (cm *ConfigManager) switchProfile(name string) error {
+	cm.lock.Lock()
+
+	if _, ok := cm.Config.Profiles[name]; !ok && name != defaultProfileName {
+		cm.lock.Unlock()
+		return fmt.Errorf("profile %s doesn't exist", name)
+	}
+
+	if name == cm.activeProfile {
+		cm.lock.Unlock()
+		return nil
+	}
+
+	cm.Config.ActiveProfile = name
+	if name == defaultProfileName {
+		cm.Config.ActiveProfile = ""
+	}
+
+	cm.lock.Unlock()
+
+	if err := cm.SaveConfig(); err != nil {
+		return fmt.Errorf("save config: %w", err)
+	}
+
+	if err := cm.Load(); err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	cm.notifySubscribers()
+
+	return nil
+}
+
+// cycleProfile switches to the profile step places after the active one (before it, for a negative step),
+// wrapping around at either end, and returns its name
+func (cm *ConfigManager) cycleProfile(step int) (string, error) {
+	active, names := cm.getProfiles()
+	if len(names) < 2 {
+		return "", errNoProfiles
+	}
+
+	current := 0
+	for idx, name := range names {
+		if name == active {
+			current = idx
+		}
+	}
+
+	next := names[((current+step)%len(names)+len(names))%len(names)]
+
+	return next, cm.switchProfile(next)
+}
+
+// cycleProfile switches to the next profile (or the previous one, for a negative step), for boards with a
+// profile button
+func (d *Deej) cycleProfile(logger *zap.SugaredLogger, step int) {
+	name, err := d.configManager.cycleProfile(step)
+	if err != nil {
+		logger.Warnw("Failed to switch profile", "error", err)
+		return
+	}
+
+	logger.Infow("Switched profile", "profile", name)
+}
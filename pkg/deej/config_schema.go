@@ -0,0 +1,140 @@
+package deej
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOption describes one of the config's options, as its struct tags have it: the key from the yaml tag,
+// and the description from the doc tag
+type ConfigOption struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Default     string `json:"default,omitempty"`
+	Description string `json:"description"`
+}
+
+// options that are their own type in the config, but a single value in the file
+var (
+	volumeCurveType = reflect.TypeOf(VolumeCurve{})
+	yamlNodeType    = reflect.TypeOf(yaml.Node{})
+)
+
+// ConfigSchema lists every config option in the order a saved config has them, each section followed by its options.
+// Options of things there can be any number of (sliders, rules...) are listed once, i.e. as slider_mappings.<name>.volume
+// or rules[].when.slider
+func ConfigSchema() []ConfigOption {
+	options := []ConfigOption{}
+	describeOptions(&options, "", reflect.TypeOf(Config{}), reflect.ValueOf(*newDefaultConfig()))
+
+	return options
+}
+
+// describeOptions adds the options of a config struct to the list, taking their defaults from the given value
+// (which is invalid for structs that don't have defaults, i.e. every slider mapping)
+func describeOptions(options *[]ConfigOption, prefix string, structType reflect.Type, defaults reflect.Value) {
+	for idx := 0; idx < structType.NumField(); idx++ {
+		field := structType.Field(idx)
+
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		var value reflect.Value
+		if defaults.IsValid() {
+			value = defaults.Field(idx)
+		}
+
+		key := prefix + name
+
+		*options = append(*options, ConfigOption{
+			Key:         key,
+			Type:        optionType(field.Type),
+			Default:     defaultOptionValue(value),
+			Description: field.Tag.Get("doc"),
+		})
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		switch {
+		case fieldType == volumeCurveType || fieldType == yamlNodeType:
+		case fieldType.Kind() == reflect.Struct:
+			describeOptions(options, key+".", fieldType, value)
+		case fieldType.Kind() == reflect.Slice && fieldType.Elem().Kind() == reflect.Struct:
+			describeOptions(options, key+"[].", fieldType.Elem(), reflect.Value{})
+		case fieldType.Kind() == reflect.Map && fieldType.Elem().Kind() == reflect.Struct:
+			describeOptions(options, key+".<name>.", fieldType.Elem(), reflect.Value{})
+		}
+	}
+}
+
+// optionType names an option's type the way it's written in yaml, rather than in Go
+func optionType(t reflect.Type) string {
+	switch t {
+	case volumeCurveType:
+		return "curve"
+	case yamlNodeType:
+		return "anything"
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return optionType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Struct {
+			return "list"
+		}
+
+		return "list of " + pluralOptionType(t.Elem())
+	case reflect.Map:
+		if t.Elem().Kind() == reflect.Struct {
+			return "map"
+		}
+
+		return "map of " + pluralOptionType(t.Elem())
+	}
+
+	return "section"
+}
+
+func pluralOptionType(t reflect.Type) string {
+	name := optionType(t)
+
+	if strings.HasPrefix(name, "map ") || strings.HasPrefix(name, "list ") {
+		return strings.Replace(name, " ", "s ", 1)
+	}
+
+	return name + "s"
+}
+
+// defaultOptionValue returns a scalar option's default as it would be written in yaml, or nothing if it has none
+func defaultOptionValue(value reflect.Value) string {
+	if !value.IsValid() || value.IsZero() {
+		return ""
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("%q", value.String())
+	case reflect.Struct, reflect.Slice, reflect.Map, reflect.Ptr:
+		return ""
+	}
+
+	return fmt.Sprint(value.Interface())
+}
@@ -0,0 +1,258 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// slider volumes, mute states and locks change all the time, so deej keeps them in a file of its own next to the
+// config rather than in it. the config file only changes when the user (or a settings change they made) changes it
+const stateFilename = "state.yaml"
+
+// sliderValues are the parts of a slider mapping that change as deej runs
+type sliderValues struct {
+	Volume float32 `yaml:"volume"`
+	Muted  bool    `yaml:"muted"`
+	Locked bool    `yaml:"locked,omitempty"`
+}
+
+// sliderState is a slider's values as deej last had them, along with the config file's values at the time.
+// once the file says something else, the user edited it since, and the file wins
+type sliderState struct {
+	sliderValues `yaml:",inline"`
+	Config       sliderValues `yaml:"config"`
+}
+
+// runtimeState is what the state file holds: every profile's slider values, by profile and slider key
+type runtimeState struct {
+	SavedAt  time.Time                         `yaml:"saved_at"`
+	Profiles map[string]map[string]sliderState `yaml:"profiles"`
+
+	// the sliders the lock screen policy muted, until the session unlocks (see user_session.go)
+	LockScreenMuted []string `yaml:"lock_screen_muted,omitempty"`
+
+	// which slider each of the board's encoders was on, by encoder (see serial_selection.go)
+	EncoderSelections map[int]string `yaml:"encoder_selections,omitempty"`
+
+	// the sessions the sliders controlled and their volumes, for the restore startup policy (see session_state.go)
+	Sessions []savedSession `yaml:"sessions,omitempty"`
+}
+
+// stateFilePath returns where the state file for the config file deej runs with goes
+func (cm *ConfigManager) stateFilePath() string {
+	return filepath.Join(filepath.Dir(cm.configFilePath), stateFilename)
+}
+
+// loadState reads the state file, the first time it's needed. a missing or broken one just means deej starts
+// from the config's values. assumes the lock is held
+func (cm *ConfigManager) loadState() {
+	if cm.state != nil {
+		return
+	}
+
+	cm.state = &runtimeState{Profiles: map[string]map[string]sliderState{}}
+
+	contents, err := ioutil.ReadFile(cm.stateFilePath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			cm.logger.Warnw("Failed to read state file, using the config's volumes", "path", cm.stateFilePath(), "error", err)
+		}
+
+		return
+	}
+
+	state := &runtimeState{}
+	if err := yaml.Unmarshal(contents, state); err != nil {
+		cm.logger.Warnw("Failed to parse state file, using the config's volumes", "path", cm.stateFilePath(), "error", err)
+		return
+	}
+
+	if state.Profiles != nil {
+		cm.state = state
+	}
+}
+
+// restoreSliderState puts the state file's values on the active mappings, wherever the config file still says
+// what it did when they were recorded. cm.configValues must already hold the file's values. assumes the lock is held
+func (cm *ConfigManager) restoreSliderState() {
+	cm.loadState()
+
+	states := cm.state.Profiles[cm.activeProfile]
+
+	for key, state := range states {
+		mapping, ok := cm.Config.SliderMappings[key]
+		if !ok {
+			continue
+		}
+
+		if state.Config != cm.configValues[key] {
+			cm.logger.Debugw("Slider changed in the config file, dropping its saved state", "slider", key)
+			delete(states, key)
+			cm.stateModified = true
+
+			continue
+		}
+
+		mapping.Volume = state.Volume
+		mapping.Muted = state.Muted
+		mapping.Locked = state.Locked
+		cm.Config.SliderMappings[key] = mapping
+	}
+}
+
+// recordSliderState remembers a slider's current values for the state file, and wakes up the saver.
+// the implicit slider mapping isn't the user's, so it isn't remembered. assumes the lock is held
+func (cm *ConfigManager) recordSliderState(key string) {
+	if cm.implicitMapping || cm.state == nil {
+		return
+	}
+
+	mapping, ok := cm.Config.SliderMappings[key]
+	if !ok {
+		return
+	}
+
+	if cm.state.Profiles[cm.activeProfile] == nil {
+		cm.state.Profiles[cm.activeProfile] = map[string]sliderState{}
+	}
+
+	state := sliderState{
+		sliderValues: sliderValues{Volume: mapping.Volume, Muted: mapping.Muted, Locked: mapping.Locked},
+		Config:       cm.configValues[key],
+	}
+
+	if previous, ok := cm.state.Profiles[cm.activeProfile][key]; ok && previous == state {
+		return
+	}
+
+	cm.state.Profiles[cm.activeProfile][key] = state
+	cm.stateModified = true
+	cm.wakeSaver()
+}
+
+// setLockScreenMuted remembers which sliders the lock screen policy muted, so they can still be unmuted if deej
+// doesn't get to do it before it stops (i.e. it crashes, or the machine loses power, while locked)
+func (cm *ConfigManager) setLockScreenMuted(keys []string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	if len(keys) == 0 && len(cm.state.LockScreenMuted) == 0 {
+		return
+	}
+
+	cm.state.LockScreenMuted = append([]string{}, keys...)
+	cm.stateModified = true
+	cm.wakeSaver()
+}
+
+// getLockScreenMuted returns the sliders the lock screen policy muted, as of the state file if deej just started
+func (cm *ConfigManager) getLockScreenMuted() []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	return append([]string{}, cm.state.LockScreenMuted...)
+}
+
+// setEncoderSelections remembers which slider each encoder is on, for the next run
+func (cm *ConfigManager) setEncoderSelections(selections map[int]string) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	if reflect.DeepEqual(selections, cm.state.EncoderSelections) {
+		return
+	}
+
+	cm.state.EncoderSelections = make(map[int]string, len(selections))
+	for id, key := range selections {
+		cm.state.EncoderSelections[id] = key
+	}
+
+	cm.stateModified = true
+	cm.wakeSaver()
+}
+
+// getEncoderSelections returns which slider each encoder was on, as of the state file if deej just started
+func (cm *ConfigManager) getEncoderSelections() map[int]string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	selections := make(map[int]string, len(cm.state.EncoderSelections))
+	for id, key := range cm.state.EncoderSelections {
+		selections[id] = key
+	}
+
+	return selections
+}
+
+// setSavedSessions remembers the sessions the sliders control and their volumes, for the next run
+func (cm *ConfigManager) setSavedSessions(sessions []savedSession) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	if reflect.DeepEqual(sessions, cm.state.Sessions) {
+		return
+	}
+
+	cm.state.Sessions = append([]savedSession{}, sessions...)
+	cm.stateModified = true
+	cm.wakeSaver()
+}
+
+// getSavedSessions returns the sessions the sliders controlled, as of the state file if deej just started
+func (cm *ConfigManager) getSavedSessions() []savedSession {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.loadState()
+
+	return append([]savedSession{}, cm.state.Sessions...)
+}
+
+// saveState writes the state file. assumes the lock is held
+func (cm *ConfigManager) saveState() error {
+	if cm.readOnly || cm.state == nil {
+		return nil
+	}
+
+	cm.state.SavedAt = time.Now()
+
+	contents, err := yaml.Marshal(cm.state)
+	if err != nil {
+		return fmt.Errorf("marshal state: %w", err)
+	}
+
+	if err := writeFileAtomically(cm.stateFilePath(), contents); err != nil {
+		return fmt.Errorf("write state: %w", err)
+	}
+
+	cm.stateModified = false
+
+	return nil
+}
+
+// configSliderValues returns the slider values the given mappings have, by slider key
+func configSliderValues(mappings map[string]SliderMapping) map[string]sliderValues {
+	values := make(map[string]sliderValues, len(mappings))
+	for key, mapping := range mappings {
+		values[key] = sliderValues{Volume: mapping.Volume, Muted: mapping.Muted, Locked: mapping.Locked}
+	}
+
+	return values
+}
@@ -0,0 +1,133 @@
+package deej
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+const (
+
+	// a hook that takes longer than this (i.e. waiting on a password prompt) is killed
+	syncHookTimeout = 30 * time.Second
+
+	// where deej's own changes go when saving them would overwrite a newer synced config
+	syncConflictSuffix = ".conflict"
+)
+
+// peekSyncHooks reads just the sync hooks from the config file, since the before_load hook has to run
+// before the config is loaded. a config that doesn't parse has no hooks, as far as this is concerned
+func (cm *ConfigManager) peekSyncHooks() SyncHooks {
+	raw, err := ioutil.ReadFile(cm.configFilePath)
+	if err != nil {
+		return SyncHooks{}
+	}
+
+	var partial struct {
+		SyncHooks SyncHooks `yaml:"sync_hooks"`
+	}
+
+	if err := yaml.Unmarshal(raw, &partial); err != nil {
+		return SyncHooks{}
+	}
+
+	return partial.SyncHooks
+}
+
+// runSyncHook runs a sync hook through the shell, from the config's directory and with the config's path in DEEJ_CONFIG
+func (cm *ConfigManager) runSyncHook(name string, command string) error {
+	logger := cm.logger.Named("sync")
+
+	configPath, err := filepath.Abs(cm.configFilePath)
+	if err != nil {
+		return fmt.Errorf("resolve config path: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncHookTimeout)
+	defer cancel()
+
+	shell := []string{"cmd.exe", "/C", command}
+	if util.Linux() {
+		shell = []string{"/bin/sh", "-c", command}
+	}
+
+	cmd := exec.CommandContext(ctx, shell[0], shell[1:]...)
+	cmd.Dir = filepath.Dir(configPath)
+	cmd.Env = append(os.Environ(), "DEEJ_CONFIG="+configPath)
+
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+
+	if err != nil {
+		logger.Warnw("Sync hook failed", "hook", name, "error", err, "output", string(output))
+		return fmt.Errorf("run %s hook: %w", name, err)
+	}
+
+	logger.Debugw("Ran sync hook", "hook", name, "took", time.Since(started), "output", string(output))
+
+	return nil
+}
+
+// rememberSyncedCopy takes note of the synced copy's current state, which deej has seen (and is fine with) by now
+func (cm *ConfigManager) rememberSyncedCopy(hooks SyncHooks) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cm.syncedCopyModTime = syncedCopyModTime(hooks)
+}
+
+// syncConflict tells whether the synced copy changed since deej last loaded or synced the config,
+// meaning that saving now would overwrite somebody else's changes. assumes the lock is held
+func (cm *ConfigManager) syncConflict(hooks SyncHooks) bool {
+	if hooks.SyncedCopy == "" {
+		return false
+	}
+
+	return !syncedCopyModTime(hooks).Equal(cm.syncedCopyModTime)
+}
+
+// saveConflict writes deej's changes next to the config rather than over it, and lets the user sort it out.
+// it returns an error saying so, since the config itself wasn't saved. assumes the lock is held
+func (cm *ConfigManager) saveConflict() error {
+	conflictPath := cm.configFilePath + syncConflictSuffix
+
+	contents, err := yaml.Marshal(cm.Config)
+	if err != nil {
+		return fmt.Errorf("marshal conflicting config: %w", err)
+	}
+
+	// like the config itself, the copy can hold API tokens and other secrets
+	if err := ioutil.WriteFile(conflictPath, contents, 0600); err != nil {
+		return fmt.Errorf("write conflicting config: %w", err)
+	}
+
+	// don't try again on every change, the synced copy wins until it's loaded
+	cm.configModified = false
+
+	cm.notifier.Notify("Config sync conflict",
+		fmt.Sprintf("Your synced config changed since deej loaded it. deej's changes were saved to %s instead.", conflictPath))
+
+	return fmt.Errorf("synced copy %s changed since it was loaded, saved to %s instead",
+		cm.Config.SyncHooks.SyncedCopy, conflictPath)
+}
+
+func syncedCopyModTime(hooks SyncHooks) time.Time {
+	if hooks.SyncedCopy == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(hooks.SyncedCopy)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
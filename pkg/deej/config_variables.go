@@ -0,0 +1,169 @@
+package deej
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// always defined, so targets (or host_variables) can tell machines apart
+const hostnameVariable = "HOSTNAME"
+
+// slider targets refer to variables as "${NAME}"
+var variableReferencePattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// configVariables returns the variables that apply on this machine: HOSTNAME, the config's variables, and
+// whichever of them the host_variables section overrides for this hostname (case-insensitively)
+func configVariables(logger *zap.SugaredLogger, config *Config) map[string]string {
+	variables := map[string]string{}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Warnw("Failed to get hostname for config variables", "error", err)
+	}
+
+	variables[hostnameVariable] = hostname
+
+	for name, value := range config.Variables {
+		variables[name] = value
+	}
+
+	for host, overrides := range config.HostVariables {
+		if hostname == "" || !strings.EqualFold(host, hostname) {
+			continue
+		}
+
+		for name, value := range overrides {
+			variables[name] = value
+		}
+	}
+
+	return variables
+}
+
+// expandVariables replaces the variable references in a value with their values. references to variables that
+// aren't defined are left as they are, and returned
+func expandVariables(value string, variables map[string]string) (string, []string) {
+	undefined := []string{}
+
+	expanded := variableReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		name := variableReferencePattern.FindStringSubmatch(reference)[1]
+
+		if variableValue, ok := variables[name]; ok {
+			return variableValue
+		}
+
+		undefined = append(undefined, name)
+		return reference
+	})
+
+	return expanded, undefined
+}
+
+// resolveSliderVariables expands variables in every slider's targets and offsets, in place. it returns the
+// mappings that used any of them as they were written, so they can be saved that way
+func resolveSliderVariables(logger *zap.SugaredLogger, config *Config) map[string]SliderMapping {
+	variables := configVariables(logger, config)
+	templated := map[string]SliderMapping{}
+	undefined := map[string]bool{}
+
+	for key, mapping := range config.SliderMappings {
+		usesVariables := false
+
+		targets := make([]string, len(mapping.Targets))
+		for idx, target := range mapping.Targets {
+			expanded, missing := expandVariables(target, variables)
+
+			targets[idx] = expanded
+			usesVariables = usesVariables || expanded != target
+
+			for _, name := range missing {
+				undefined[name] = true
+			}
+		}
+
+		var offsets map[string]float32
+		if mapping.Offsets != nil {
+			offsets = make(map[string]float32, len(mapping.Offsets))
+
+			for target, offset := range mapping.Offsets {
+				expanded, missing := expandVariables(target, variables)
+
+				offsets[expanded] = offset
+				usesVariables = usesVariables || expanded != target
+
+				for _, name := range missing {
+					undefined[name] = true
+				}
+			}
+		}
+
+		if !usesVariables {
+			continue
+		}
+
+		templated[key] = mapping
+
+		mapping.Targets = targets
+		mapping.Offsets = offsets
+		config.SliderMappings[key] = mapping
+	}
+
+	if len(undefined) > 0 {
+		names := []string{}
+		for name := range undefined {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		logger.Warnw("Slider targets refer to undefined variables, leaving them as they are", "variables", names)
+	}
+
+	return templated
+}
+
+// unresolvedConfig returns the config as it should be written out, with slider targets that use variables
+// as they were written rather than resolved, slider values as the file has them (deej's own go to the state file),
+// and the mappings deej runs with back in their profile (if they came from one). cm.lock must be held
+func (cm *ConfigManager) unresolvedConfig() *Config {
+	config := *cm.Config
+	config.SliderMappings = make(map[string]SliderMapping, len(cm.Config.SliderMappings))
+
+	for key, mapping := range cm.Config.SliderMappings {
+		if values, ok := cm.configValues[key]; ok {
+			mapping.Volume = values.Volume
+			mapping.Muted = values.Muted
+			mapping.Locked = values.Locked
+		}
+
+		if templated, ok := cm.templatedSliderMappings[key]; ok {
+			mapping.Targets = templated.Targets
+			mapping.Offsets = templated.Offsets
+		}
+
+		config.SliderMappings[key] = mapping
+	}
+
+	// the implicit slider mapping only stands in for the user's own ones, the config file never gets it
+	if cm.implicitMapping {
+		config.SliderMappings = map[string]SliderMapping{}
+	}
+
+	if cm.baseSliderMappings != nil {
+		config.Profiles = make(map[string]MappingProfile, len(cm.Config.Profiles))
+		for name, profile := range cm.Config.Profiles {
+			config.Profiles[name] = profile
+		}
+
+		profile := config.Profiles[cm.activeProfile]
+		profile.SliderMappings = config.SliderMappings
+		config.Profiles[cm.activeProfile] = profile
+
+		config.SliderMappings = cm.baseSliderMappings
+	}
+
+	return &config
+}
@@ -0,0 +1,102 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// how many previous versions of the config deej keeps around (config.yaml.bak.1 being the latest), unless the
+// config says otherwise
+const defaultConfigBackups = 3
+
+// writeConfigFile replaces the config file with the given contents, keeping the given number of previous versions
+// next to it. assumes the lock is held
+func (cm *ConfigManager) writeConfigFile(contents []byte, backups int) error {
+	if err := rotateBackups(cm.configFilePath, backups); err != nil {
+		cm.logger.Warnw("Failed to back up config, saving it anyway", "error", err)
+	}
+
+	return writeFileAtomically(cm.configFilePath, contents)
+}
+
+// rotateBackups moves path's backups one place down (path.bak.1 to path.bak.2 and so on, dropping the last one),
+// and copies path itself to path.bak.1. there's nothing to back up before path exists
+func rotateBackups(path string, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	backupPath := func(idx int) string {
+		return fmt.Sprintf("%s.bak.%d", path, idx)
+	}
+
+	if err := os.Remove(backupPath(backups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove oldest backup: %w", err)
+	}
+
+	for idx := backups - 1; idx >= 1; idx-- {
+		if err := os.Rename(backupPath(idx), backupPath(idx+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate backup %d: %w", idx, err)
+		}
+	}
+
+	if err := writeFileAtomically(backupPath(1), contents); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+
+	return nil
+}
+
+// writeFileAtomically writes a file such that it has either its old contents or all of its new ones, even if deej
+// (or the machine) goes down halfway: the contents go to a temporary file next to it first, which then takes its place
+func writeFileAtomically(path string, contents []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+
+	// only does anything if something went wrong, the rename takes the file away otherwise
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(contents); err != nil {
+		temp.Close()
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	// the temporary file is only readable by us, the file it replaces may have been readable by others
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return fmt.Errorf("set temporary file's permissions: %w", err)
+	}
+
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+
+	return nil
+}
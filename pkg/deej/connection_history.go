@@ -0,0 +1,90 @@
+package deej
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// how many connection events deej remembers. a board that drops overnight is the point, so this
+// is generous - and repeated failures to connect only take up one entry between them
+const connectionHistorySize = 50
+
+// ConnectionHistoryEntry is a connection event deej remembers, for diagnosing boards that come and go
+type ConnectionHistoryEntry struct {
+	ConnectionEvent
+
+	// how many failures in a row this entry stands for, At being the last of them
+	Attempts int `json:",omitempty"`
+}
+
+// connectionHistory keeps the most recent connection events of every board, oldest first
+type connectionHistory struct {
+	logger       *zap.SugaredLogger
+	subscription *Subscription[ConnectionEvent]
+
+	lock    sync.Mutex
+	entries []ConnectionHistoryEntry
+}
+
+func newConnectionHistory(serial *SerialIO, logger *zap.SugaredLogger) *connectionHistory {
+	h := &connectionHistory{
+		logger:       logger.Named("connections"),
+		subscription: serial.SubscribeToConnectionEvents(PriorityBackground),
+		entries:      make([]ConnectionHistoryEntry, 0, connectionHistorySize),
+	}
+
+	h.logger.Debug("Created connection history instance")
+
+	return h
+}
+
+// follow records connection events as they come, for as long as deej runs
+func (h *connectionHistory) follow() {
+	for event := range h.subscription.C {
+		h.record(event)
+	}
+}
+
+func (h *connectionHistory) record(event ConnectionEvent) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	// a board that can't be reached fails the same way every few seconds, which would push everything else out
+	if last := len(h.entries) - 1; last >= 0 && event.Event == ConnectionFailed {
+		previous := &h.entries[last]
+
+		if previous.Event == ConnectionFailed && previous.Transport == event.Transport && previous.Reason == event.Reason {
+			previous.At = event.At
+			previous.Attempts++
+
+			return
+		}
+	}
+
+	if len(h.entries) == connectionHistorySize {
+		h.entries = h.entries[1:]
+	}
+
+	entry := ConnectionHistoryEntry{ConnectionEvent: event}
+	if event.Event == ConnectionFailed {
+		entry.Attempts = 1
+	}
+
+	h.entries = append(h.entries, entry)
+
+	if event.Event != ConnectionConnected {
+		h.logger.Debugw("Recorded connection event", "transport", event.Transport, "event", event.Event, "reason", event.Reason)
+	}
+}
+
+// snapshot returns a copy of the remembered events, oldest first
+func (h *connectionHistory) snapshot() []ConnectionHistoryEntry {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entries := make([]ConnectionHistoryEntry, len(h.entries))
+	copy(entries, h.entries)
+
+	return entries
+}
@@ -3,6 +3,7 @@
 package deej
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -19,21 +20,74 @@ const (
 	envNoTray = "DEEJ_NO_TRAY_ICON"
 )
 
+// Options control how deej runs, as opposed to the config file which controls what it does
+type Options struct {
+
+	// show verbose logs (useful for debugging serial)
+	Verbose bool
+
+	// use in-memory audio sessions instead of the OS audio backend
+	VirtualAudio bool
+
+	// run with hardware and integrations disabled, and without touching the config file,
+	// so a broken config can be fixed from the tray
+	SafeMode bool
+
+	// version info to show in the tray, the status and to the board
+	Build BuildInfo
+
+	// log how often background loops wake up, to make sure an idle deej stays idle
+	AuditWakeups bool
+
+	// record the raw serial traffic to this file, or play such a recording back instead of connecting
+	// to the board (see serial_capture.go)
+	CaptureFile string
+	ReplayFile  string
+
+	// config values to run with instead of the config file's, on top of those the environment overrides
+	// (see config_overrides.go)
+	ConfigOverrides ConfigOverrides
+}
+
 // Deej is the main entity managing access to all sub-components
 type Deej struct {
 	logger        *zap.SugaredLogger
 	notifier      Notifier
 	configManager *ConfigManager
 	serial        *SerialIO
+	connections   *serialConnections
+	connectionLog *connectionHistory
 	sessions      *sessionMap
+	latency       *latencyRecorder
+	api           *apiServer
+	grpc          *grpcServer
+	remote        *remoteControl
+	telemetry     *telemetry
+	feedback      *boardFeedback
+	miniMixer     *miniMixer
+	websocket     *websocketServer
+	mqtt          *mqttBridge
+	ipc           *ipcServer
+	widget        *widgetBridge
+	midi          *midiInput
+	motors        *motorizedFaders
+	testSignals   *testSignalPlayer
+	meetings      *meetingDetector
+	boosts        *booster
+	userSession   *userSessionMonitor
+	wakeups       *wakeupAudit
+	capture       *serialCapture
 
 	stopChannel chan bool
-	version     string
+	replayPath  string
+	buildInfo   BuildInfo
 	verbose     bool
+	safeMode    bool
+	trayReady   bool
 }
 
 // NewDeej creates a Deej instance
-func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
+func NewDeej(logger *zap.SugaredLogger, options Options) (*Deej, error) {
 	logger = logger.Named("deej")
 
 	notifier, err := NewToastNotifier(logger)
@@ -47,16 +101,41 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 		logger.Errorw("Failed to create Config", "error", err)
 		return nil, fmt.Errorf("create new Config: %w", err)
 	}
-	go configManager.PeriodicallySaveConfig(10 * time.Second)
 
 	d := &Deej{
 		logger:        logger,
 		notifier:      notifier,
 		configManager: configManager,
-		stopChannel:   make(chan bool),
-		verbose:       verbose,
+		latency:       newLatencyRecorder(),
+		stopChannel:   make(chan bool, 1),
+		verbose:       options.Verbose,
+		safeMode:      options.SafeMode,
+		buildInfo:     options.Build,
+		wakeups:       newWakeupAudit(logger, options.AuditWakeups),
+		replayPath:    options.ReplayFile,
+	}
+
+	if options.CaptureFile != "" {
+		capture, err := newSerialCapture(options.CaptureFile)
+		if err != nil {
+			logger.Errorw("Failed to create serial capture", "error", err)
+			return nil, fmt.Errorf("create serial capture: %w", err)
+		}
+
+		logger.Infow("Capturing serial traffic", "path", options.CaptureFile)
+		d.capture = capture
+	}
+
+	configManager.wakeups = d.wakeups
+
+	// the command line wins over the environment
+	overrides := ConfigOverridesFromEnvironment(os.Environ())
+	for key, value := range options.ConfigOverrides {
+		overrides[key] = value
 	}
 
+	configManager.setOverrides(overrides)
+
 	serial, err := NewSerialIO(d, logger)
 	if err != nil {
 		logger.Errorw("Failed to create SerialIO", "error", err)
@@ -64,11 +143,21 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 	}
 
 	d.serial = serial
+	d.connections = newSerialConnections(d, logger)
+	d.connectionLog = newConnectionHistory(serial, logger)
 
-	sessionFinder, err := newSessionFinder(logger)
-	if err != nil {
-		logger.Errorw("Failed to create SessionFinder", "error", err)
-		return nil, fmt.Errorf("create new SessionFinder: %w", err)
+	var sessionFinder SessionFinder
+
+	// safe mode stays away from the real audio backend too, in case that's what's crashing
+	if options.VirtualAudio || options.SafeMode {
+		logger.Info("Using virtual audio backend")
+		sessionFinder = newVirtualSessionFinder(logger)
+	} else {
+		sessionFinder, err = newSessionFinder(logger, d.configManager)
+		if err != nil {
+			logger.Errorw("Failed to create SessionFinder", "error", err)
+			return nil, fmt.Errorf("create new SessionFinder: %w", err)
+		}
 	}
 
 	sessions, err := newSessionMap(d, logger, sessionFinder)
@@ -78,6 +167,22 @@ func NewDeej(logger *zap.SugaredLogger, verbose bool) (*Deej, error) {
 	}
 
 	d.sessions = sessions
+	d.api = newAPIServer(d, logger)
+	d.grpc = newGRPCServer(d, logger)
+	d.remote = newRemoteControl(d, logger)
+	d.telemetry = newTelemetry(d, logger)
+	d.feedback = newBoardFeedback(d, logger)
+	d.miniMixer = newMiniMixer(d, logger)
+	d.websocket = newWebsocketServer(d, logger)
+	d.mqtt = newMQTTBridge(d, logger)
+	d.ipc = newIPCServer(d, logger)
+	d.widget = newWidgetBridge(d, logger)
+	d.midi = newMIDIInput(d, logger)
+	d.motors = newMotorizedFaders(d, logger)
+	d.testSignals = newTestSignalPlayer(d, logger)
+	d.meetings = newMeetingDetector(d, logger)
+	d.boosts = newBooster(d, logger)
+	d.userSession = newUserSessionMonitor(d, logger)
 
 	logger.Debug("Created deej instance")
 
@@ -90,8 +195,18 @@ func (d *Deej) Initialize() error {
 
 	// load the config for the first time
 	if err := d.configManager.Load(); err != nil {
-		d.logger.Errorw("Failed to load config during initialization", "error", err)
-		return fmt.Errorf("load config during init: %w", err)
+		if !d.safeMode {
+			d.logger.Errorw("Failed to load config during initialization", "error", err)
+
+			// deej exits next, don't let that swallow the notification explaining why
+			d.notifier.Flush(notificationFlushTimeout)
+
+			return fmt.Errorf("load config during init: %w", err)
+		}
+
+		// in safe mode, we stay up regardless - fixing the config is the whole point
+		d.logger.Warnw("Failed to load config in safe mode, continuing with defaults", "error", err)
+		d.configManager.LoadDefaults()
 	}
 
 	// initialize the session map
@@ -117,9 +232,9 @@ func (d *Deej) Initialize() error {
 	return nil
 }
 
-// SetVersion causes deej to add a version string to its tray menu if called before Initialize
-func (d *Deej) SetVersion(version string) {
-	d.version = version
+// formatPercent formats a scalar (i.e. a volume) as a percentage for display, following the configured number locale
+func (d *Deej) formatPercent(v float32) string {
+	return util.LookupNumberLocale(d.configManager.getNumberLocale()).FormatPercent(v, 0)
 }
 
 // Verbose returns a boolean indicating whether deej is running in verbose mode
@@ -127,6 +242,11 @@ func (d *Deej) Verbose() bool {
 	return d.verbose
 }
 
+// SafeMode returns a boolean indicating whether deej is running in safe mode
+func (d *Deej) SafeMode() bool {
+	return d.safeMode
+}
+
 func (d *Deej) setupInterruptHandler() {
 	interruptChannel := util.SetupCloseHandler()
 
@@ -143,21 +263,125 @@ func (d *Deej) run() {
 	// watch the config file for changes
 	go d.configManager.WatchConfigFileChanges()
 
+	// count background wakeups, if asked to
+	go d.wakeups.run()
+
+	// serve the API, if the config asks for it
+	if address := d.configManager.getAPIAddress(); address != "" {
+		if err := d.api.start(address); err != nil {
+			d.logger.Warnw("Failed to start API server", "error", err)
+		}
+	}
+
+	// and the gRPC service, if the config asks for that too
+	if address := d.configManager.getGRPCAddress(); address != "" {
+		if err := d.grpc.start(address); err != nil {
+			d.logger.Warnw("Failed to start gRPC server", "error", err)
+		}
+	}
+
+	// in safe mode, that's all there is - no board, no integrations, and no writing to the config
+	if d.safeMode {
+		d.logger.Info("Running in safe mode, hardware and integrations are disabled")
+		d.notifier.Notify("deej is running in safe mode",
+			"Your board and integrations are disabled. Fix your configuration from the tray menu, then restart deej.")
+
+		d.waitForStop()
+		return
+	}
+
+	// persist our own changes to the config
+	go d.configManager.SaveConfigWhenModified(10 * time.Second)
+
+	// keep an eye on the board connection's health, and what it's been saying lately
+	go d.monitorLinkQuality()
+	go d.persistSerialHistory()
+	go d.connectionLog.follow()
+
+	// log how long slider events take to apply, if the config asks for tracing them
+	go d.reportLatency()
+
+	// push state back to boards with displays or LEDs, if the config asks for it
+	go d.feedback.run()
+
+	// keep motorized faders where their volumes are, if the config says there are any
+	go d.motors.run()
+
+	// adjust volumes during calls, if the config asks for it
+	go d.meetings.run()
+
+	// step aside while another user's session is in front of the screen
+	go d.userSession.run()
+
+	// report anonymous usage statistics, if the user opted in (and preview them regardless)
+	go d.telemetry.run()
+
+	// show the mini mixer right away, if the config asks for it
+	if d.configManager.getMiniMixer().OpenOnStartup {
+		if err := d.miniMixer.open(); err != nil {
+			d.logger.Warnw("Failed to open mini mixer", "error", err)
+		}
+	}
+
+	// share the board with another machine, if the config asks for it
+	if err := d.remote.start(d.configManager.getRemoteControl()); err != nil {
+		d.logger.Warnw("Failed to start remote control", "error", err)
+	}
+
+	// accept boards over the network, if the config asks for it
+	if err := d.websocket.start(d.configManager.getWebSocket()); err != nil {
+		d.logger.Warnw("Failed to start websocket server", "error", err)
+	}
+
+	// publish state to (and take commands and board lines from) an MQTT broker, if the config asks for it
+	if err := d.mqtt.start(d.configManager.getMQTT()); err != nil {
+		d.logger.Warnw("Failed to start MQTT bridge", "error", err)
+	}
+
+	// take board lines from local scripts and tools, if the config asks for it
+	if err := d.ipc.start(d.configManager.getIPC()); err != nil {
+		d.logger.Warnw("Failed to start local control interface", "error", err)
+	}
+
+	// show (and take changes from) desktop widgets, if the config asks for it
+	if err := d.widget.start(d.configManager.getWidget()); err != nil {
+		d.logger.Warnw("Failed to start widget bridge", "error", err)
+	}
+
+	// a MIDI controller's faders can move sliders too, whenever the config maps them
+	d.midi.start()
+
+	// connect to the boards on ports of their own, if the config lists any
+	go d.connections.run()
+
 	// connect to the arduino for the first time
 	go func() {
-		if err := d.serial.Start(); err != nil {
+		err := d.serial.Start()
+
+		// if the port is busy (something else is connected) or we aren't allowed to use it,
+		// let the user know and wait for that to change
+		if err != nil {
+			err = d.serial.recoverStart(err)
+		}
+
+		if err != nil {
 			d.logger.Warnw("Failed to start first-time serial connection", "error", err)
 
-			// If the port is busy, that's because something else is connected - notify and quit
+			// we aren't allowed to open the port, and can't tell why - notify and quit
 			if errors.Is(err, os.ErrPermission) {
-				d.logger.Warnw("Serial port seems busy, notifying user and closing",
+				d.logger.Warnw("No permission to open serial port, notifying user and closing",
 					"comPort", d.configManager.Config.ConnectionInfo.SerialPort)
 
 				d.notifier.Notify(fmt.Sprintf("Can't connect to %s!", d.configManager.Config.ConnectionInfo.SerialPort),
-					"This serial port is busy, make sure to close any serial monitor or other deej instance.")
+					"deej isn't allowed to open this serial port.")
 
 				d.signalStop()
 
+				// boards can connect on their own instead, in which case a missing serial port is fine
+			} else if errors.Is(err, os.ErrNotExist) && d.acceptsConnectingBoards() {
+				d.logger.Infow("No board on the serial port, waiting for boards to connect",
+					"comPort", d.configManager.Config.ConnectionInfo.SerialPort)
+
 				// also notify if the COM port they gave isn't found, maybe their config is wrong
 			} else if errors.Is(err, os.ErrNotExist) {
 				d.logger.Warnw("Provided COM port seems wrong, notifying user and closing",
@@ -167,10 +391,33 @@ func (d *Deej) run() {
 					"This serial port doesn't exist, check your configuration and make sure it's set correctly.")
 
 				d.signalStop()
+
+				// anything else might go away by itself (i.e. a board that's still starting up), keep trying
+			} else {
+				d.serial.reconnect()
 			}
 		}
 	}()
 
+	// with everything up, bring the desk to where the config wants it every time
+	d.runStartupActions()
+
+	d.waitForStop()
+}
+
+// acceptsConnectingBoards tells whether boards can connect without a serial port, over websocket, MQTT or IPC,
+// or whether a MIDI controller (or the boards from the connections list) takes the board's place
+func (d *Deej) acceptsConnectingBoards() bool {
+	return d.configManager.getWebSocket().Listen != "" ||
+		d.configManager.getMQTT().Topic != "" ||
+		d.configManager.getIPC().Enabled ||
+		len(d.configManager.getMIDIMappings()) > 0 ||
+		len(d.configManager.getConnections()) > 0
+}
+
+// waitForStop blocks until deej is told to stop, then stops it and exits
+func (d *Deej) waitForStop() {
+
 	// wait until stopped (gracefully)
 	<-d.stopChannel
 	d.logger.Debug("Stop channel signaled, terminating")
@@ -184,27 +431,74 @@ func (d *Deej) run() {
 	}
 }
 
+// signalStop asks deej to stop. it's safe to call from anywhere, any number of times
 func (d *Deej) signalStop() {
 	d.logger.Debug("Signalling stop channel")
-	d.stopChannel <- true
+
+	select {
+	case d.stopChannel <- true:
+	default:
+		d.logger.Debug("Already stopping")
+	}
 }
 
 func (d *Deej) stop() error {
-	d.logger.Info("Stopping")
+	ctx, cancel := context.WithTimeout(context.Background(), d.configManager.getShutdownTimeout())
+	defer cancel()
 
-	d.configManager.StopWatchingConfigFile()
-	d.serial.Stop()
+	return d.Shutdown(ctx)
+}
 
-	// release the session map
-	if err := d.sessions.release(); err != nil {
-		d.logger.Errorw("Failed to release session map", "error", err)
-		return fmt.Errorf("release session map: %w", err)
-	}
+// Shutdown stops every part of deej in turn: it lets go of the boards, saves what's left to save (pending config
+// changes included) and releases the audio sessions. Whatever hasn't stopped by the time the context is done is
+// left behind, and the returned error says what
+func (d *Deej) Shutdown(ctx context.Context) error {
+	d.logger.Info("Stopping")
 
-	d.stopTray()
+	err := d.shutdown(ctx, []shutdownStep{
+		{"config watcher", func() error { d.configManager.StopWatchingConfigFile(); return nil }},
+		{"api", func() error { d.api.stop(); return nil }},
+		{"grpc", func() error { d.grpc.stop(); return nil }},
+		{"mini mixer", func() error { d.miniMixer.stop(); return nil }},
+		{"remote control", func() error { d.remote.stop(); return nil }},
+		{"websocket boards", func() error { d.websocket.stop(); return nil }},
+		{"mqtt", func() error { d.mqtt.stop(); return nil }},
+		{"ipc", func() error { d.ipc.stop(); return nil }},
+		{"widget", func() error { d.widget.stop(); return nil }},
+		{"midi", func() error { d.midi.stop(); return nil }},
+		{"test signal", func() error { d.testSignals.stop(); return nil }},
+		{"boosts", func() error { d.boosts.stop(); return nil }},
+		{"serial", func() error { d.serial.Stop(); return nil }},
+		{"serial connections", func() error { d.connections.stop(); return nil }},
+		{"serial capture", d.closeCapture},
+		{"serial history", d.serial.history.persist},
+		{"encoder selections", d.serial.persistSelections},
+		{"session state", d.sessions.persistState},
+		{"config", d.flushConfig},
+		{"session map", d.sessions.release},
+		{"tray", func() error { d.stopTray(); return nil }},
+	})
 
 	// attempt to sync on exit - this won't necessarily work but can't harm
 	d.logger.Sync()
 
-	return nil
+	return err
+}
+
+// closeCapture finishes the --capture file, if deej was asked to record one
+func (d *Deej) closeCapture() error {
+	if d.capture == nil {
+		return nil
+	}
+
+	return d.capture.close()
+}
+
+// flushConfig saves the config changes the saver didn't get to yet. safe mode never writes to the config
+func (d *Deej) flushConfig() error {
+	if d.safeMode {
+		return nil
+	}
+
+	return d.configManager.flushModified()
 }
@@ -0,0 +1,215 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: deej.proto
+
+package deejpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Deej_GetConfig_FullMethodName         = "/deej.v1.Deej/GetConfig"
+	Deej_ListSessions_FullMethodName      = "/deej.v1.Deej/ListSessions"
+	Deej_WatchSliderEvents_FullMethodName = "/deej.v1.Deej/WatchSliderEvents"
+)
+
+// DeejClient is the client API for Deej service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Deej is deej's gRPC service, for GUIs and companion apps that would rather not poll the HTTP API.
+// It takes the same api_tokens, sent as "authorization: Bearer <token>" metadata, with the same scopes
+type DeejClient interface {
+	// GetConfig returns the config deej is running with. The config holds tokens and other secrets,
+	// so this takes the config_write scope
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error)
+	// ListSessions returns every audio session deej currently knows about, mapped to a slider or not
+	ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error)
+	// WatchSliderEvents streams slider moves as they happen, until the client hangs up or deej stops
+	WatchSliderEvents(ctx context.Context, in *WatchSliderEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SliderEvent], error)
+}
+
+type deejClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeejClient(cc grpc.ClientConnInterface) DeejClient {
+	return &deejClient{cc}
+}
+
+func (c *deejClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetConfigResponse)
+	err := c.cc.Invoke(ctx, Deej_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deejClient) ListSessions(ctx context.Context, in *ListSessionsRequest, opts ...grpc.CallOption) (*ListSessionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSessionsResponse)
+	err := c.cc.Invoke(ctx, Deej_ListSessions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deejClient) WatchSliderEvents(ctx context.Context, in *WatchSliderEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SliderEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Deej_ServiceDesc.Streams[0], Deej_WatchSliderEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchSliderEventsRequest, SliderEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Deej_WatchSliderEventsClient = grpc.ServerStreamingClient[SliderEvent]
+
+// DeejServer is the server API for Deej service.
+// All implementations must embed UnimplementedDeejServer
+// for forward compatibility.
+//
+// Deej is deej's gRPC service, for GUIs and companion apps that would rather not poll the HTTP API.
+// It takes the same api_tokens, sent as "authorization: Bearer <token>" metadata, with the same scopes
+type DeejServer interface {
+	// GetConfig returns the config deej is running with. The config holds tokens and other secrets,
+	// so this takes the config_write scope
+	GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error)
+	// ListSessions returns every audio session deej currently knows about, mapped to a slider or not
+	ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error)
+	// WatchSliderEvents streams slider moves as they happen, until the client hangs up or deej stops
+	WatchSliderEvents(*WatchSliderEventsRequest, grpc.ServerStreamingServer[SliderEvent]) error
+	mustEmbedUnimplementedDeejServer()
+}
+
+// UnimplementedDeejServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDeejServer struct{}
+
+func (UnimplementedDeejServer) GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedDeejServer) ListSessions(context.Context, *ListSessionsRequest) (*ListSessionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSessions not implemented")
+}
+func (UnimplementedDeejServer) WatchSliderEvents(*WatchSliderEventsRequest, grpc.ServerStreamingServer[SliderEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchSliderEvents not implemented")
+}
+func (UnimplementedDeejServer) mustEmbedUnimplementedDeejServer() {}
+func (UnimplementedDeejServer) testEmbeddedByValue()              {}
+
+// UnsafeDeejServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeejServer will
+// result in compilation errors.
+type UnsafeDeejServer interface {
+	mustEmbedUnimplementedDeejServer()
+}
+
+func RegisterDeejServer(s grpc.ServiceRegistrar, srv DeejServer) {
+	// If the following call pancis, it indicates UnimplementedDeejServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Deej_ServiceDesc, srv)
+}
+
+func _Deej_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeejServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Deej_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeejServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Deej_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeejServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Deej_ListSessions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeejServer).ListSessions(ctx, req.(*ListSessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Deej_WatchSliderEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchSliderEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DeejServer).WatchSliderEvents(m, &grpc.GenericServerStream[WatchSliderEventsRequest, SliderEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Deej_WatchSliderEventsServer = grpc.ServerStreamingServer[SliderEvent]
+
+// Deej_ServiceDesc is the grpc.ServiceDesc for Deej service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Deej_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "deej.v1.Deej",
+	HandlerType: (*DeejServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetConfig",
+			Handler:    _Deej_GetConfig_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _Deej_ListSessions_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchSliderEvents",
+			Handler:       _Deej_WatchSliderEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "deej.proto",
+}
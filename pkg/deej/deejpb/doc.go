@@ -0,0 +1,5 @@
+// Package deejpb holds deej's gRPC service definition (deej.proto) and the Go bindings generated from it,
+// for companion apps written in Go. Apps in other languages can generate their own from deej.proto
+package deejpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative deej.proto
@@ -0,0 +1,163 @@
+package deej
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConsumerPriority decides how an event consumer is treated when it can't keep up
+type ConsumerPriority int
+
+const (
+
+	// PriorityAudio consumers apply volumes to audio sessions. They're always delivered to first,
+	// and delivery waits for them - a volume change must never be dropped
+	PriorityAudio ConsumerPriority = iota
+
+	// PriorityFeedback consumers reflect state back to hardware (displays, LEDs). They're delivered to
+	// after audio consumers, through a buffer; if it's full, the oldest event waiting in it is dropped
+	PriorityFeedback
+
+	// PriorityBackground consumers are cosmetic or best-effort (webhooks, logging).
+	// Same as feedback consumers, but only after everyone else
+	PriorityBackground
+)
+
+// how many events a consumer can fall behind by. audio consumers get a buffer too, so a burst of
+// events doesn't hold up the serial loop, but once it's full delivery waits for them
+var consumerBufferSizes = map[ConsumerPriority]int{
+	PriorityAudio:      16,
+	PriorityFeedback:   16,
+	PriorityBackground: 64,
+}
+
+// what happened to a board's connection, in a ConnectionEvent
+const (
+	ConnectionConnected    = "connected"
+	ConnectionDisconnected = "disconnected"
+	ConnectionFailed       = "failed"
+)
+
+// ConnectionEvent represents a board connecting to deej, going away, or failing to connect
+type ConnectionEvent struct {
+	Transport string
+	Event     string
+	At        time.Time
+
+	// why the board went away, or couldn't connect
+	Reason string `json:",omitempty"`
+
+	// how long a board that went away was connected for
+	Duration time.Duration `json:",omitempty"`
+}
+
+// Subscription receives events from one of the event bus' topics on C, until it's unsubscribed
+type Subscription[T any] struct {
+	C <-chan T
+
+	ch       chan T
+	priority ConsumerPriority
+	done     chan struct{}
+	once     sync.Once
+	topic    *eventTopic[T]
+}
+
+// Unsubscribe stops delivery to the subscription. C isn't closed, since an event may be on its way
+// to it still; stop reading from it instead
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() {
+		s.topic.unsubscribe(s)
+		close(s.done)
+	})
+}
+
+// eventTopic delivers one kind of event to its subscriptions in order of priority, so a slow
+// cosmetic consumer can never hold up a volume change
+type eventTopic[T any] struct {
+	lock          sync.Mutex
+	subscriptions []*Subscription[T]
+	dropped       atomic.Uint64
+}
+
+func (t *eventTopic[T]) subscribe(priority ConsumerPriority) *Subscription[T] {
+	ch := make(chan T, consumerBufferSizes[priority])
+	sub := &Subscription[T]{C: ch, ch: ch, priority: priority, done: make(chan struct{}), topic: t}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// publish goes through the subscriptions without the lock, so they're never changed in place: a new list
+	// takes their place instead
+	subscriptions := make([]*Subscription[T], 0, len(t.subscriptions)+1)
+	subscriptions = append(subscriptions, t.subscriptions...)
+	subscriptions = append(subscriptions, sub)
+
+	sort.SliceStable(subscriptions, func(i, j int) bool {
+		return subscriptions[i].priority < subscriptions[j].priority
+	})
+
+	t.subscriptions = subscriptions
+
+	return sub
+}
+
+func (t *eventTopic[T]) unsubscribe(sub *Subscription[T]) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for idx, existing := range t.subscriptions {
+		if existing == sub {
+			t.subscriptions = append(t.subscriptions[:idx:idx], t.subscriptions[idx+1:]...)
+			return
+		}
+	}
+}
+
+// publish delivers the event to every subscription. it doesn't hold the lock while delivering,
+// so consumers are free to (un)subscribe while handling an event. the list it takes under the lock is
+// never changed afterwards, (un)subscribing replaces it
+func (t *eventTopic[T]) publish(event T) {
+	t.lock.Lock()
+	subscriptions := t.subscriptions
+	t.lock.Unlock()
+
+	for _, sub := range subscriptions {
+		if sub.priority == PriorityAudio {
+			select {
+			case sub.ch <- event:
+			case <-sub.done:
+			}
+
+			continue
+		}
+
+		// make room by dropping the oldest event, since the newest one is what the consumer wants to see
+		for delivered := false; !delivered; {
+			select {
+			case sub.ch <- event:
+				delivered = true
+			default:
+				select {
+				case <-sub.ch:
+					t.dropped.Add(1)
+				default:
+				}
+			}
+		}
+	}
+}
+
+// eventBus holds every topic a board's events are published on
+type eventBus struct {
+	sliderMoves eventTopic[SliderMoveEvent]
+	muteToggles eventTopic[MuteToggleEvent]
+	buttons     eventTopic[ButtonEvent]
+	connections eventTopic[ConnectionEvent]
+}
+
+// droppedSliderMoveCount returns how many slider move events were dropped for lagging consumers so far
+func (bus *eventBus) droppedSliderMoveCount() uint64 {
+	return bus.sliderMoves.dropped.Load()
+}
@@ -0,0 +1,87 @@
+package deej
+
+import (
+	"sync"
+	"time"
+)
+
+// how many recent events are kept around for clients that poll
+const eventLogSize = 256
+
+// LoggedEvent is a slider move as seen by API clients, numbered so they can ask for whatever came after it
+type LoggedEvent struct {
+	Seq    uint64    `json:"seq"`
+	Slider string    `json:"slider"`
+	Value  float32   `json:"value"`
+	Time   time.Time `json:"time"`
+}
+
+// eventLog keeps the most recent slider events in order, and lets readers wait for new ones
+type eventLog struct {
+	lock    sync.Mutex
+	events  []LoggedEvent
+	lastSeq uint64
+
+	// closed (and replaced) whenever an event is appended, waking up everyone waiting on it
+	appended chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		events:   make([]LoggedEvent, 0, eventLogSize),
+		appended: make(chan struct{}),
+	}
+}
+
+func (l *eventLog) append(event SliderMoveEvent) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.lastSeq++
+
+	if len(l.events) == eventLogSize {
+		l.events = l.events[1:]
+	}
+
+	l.events = append(l.events, LoggedEvent{
+		Seq:    l.lastSeq,
+		Slider: event.SliderID,
+		Value:  event.PercentValue,
+		Time:   time.Now(),
+	})
+
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// since returns all kept events after the given sequence number, the latest sequence number, and a
+// channel that's closed once there's anything newer than that
+func (l *eventLog) since(seq uint64) ([]LoggedEvent, uint64, <-chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	events := []LoggedEvent{}
+	for _, event := range l.events {
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+
+	return events, l.lastSeq, l.appended
+}
+
+// wait returns events after the given sequence number, waiting up to timeout for some to arrive
+func (l *eventLog) wait(seq uint64, timeout time.Duration) ([]LoggedEvent, uint64) {
+	events, lastSeq, appended := l.since(seq)
+	if len(events) > 0 {
+		return events, lastSeq
+	}
+
+	select {
+	case <-appended:
+		events, lastSeq, _ = l.since(seq)
+	case <-time.After(timeout):
+	}
+
+	return events, lastSeq
+}
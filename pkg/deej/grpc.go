@@ -0,0 +1,245 @@
+package deej
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/omriharel/deej/pkg/deej/deejpb"
+)
+
+// how long open streams get to wind down on shutdown, before they're cut off
+const grpcShutdownTimeout = 2 * time.Second
+
+// the scope each RPC takes, matching its HTTP API counterpart
+var grpcMethodScopes = map[string]string{
+	deejpb.Deej_GetConfig_FullMethodName:         apiScopeConfigWrite,
+	deejpb.Deej_ListSessions_FullMethodName:      apiScopeRead,
+	deejpb.Deej_WatchSliderEvents_FullMethodName: apiScopeRead,
+}
+
+// grpcServer serves deej's gRPC service (see deejpb/deej.proto), for GUIs and companion apps that want
+// slider events streamed to them rather than polling the HTTP API for them
+type grpcServer struct {
+	deejpb.UnimplementedDeejServer
+
+	deej   *Deej
+	logger *zap.SugaredLogger
+	events *eventLog
+	server *grpc.Server
+
+	// closed on stop, ending every stream
+	stopChannel chan bool
+}
+
+func newGRPCServer(deej *Deej, logger *zap.SugaredLogger) *grpcServer {
+	logger = logger.Named("grpc")
+
+	gs := &grpcServer{
+		deej:        deej,
+		logger:      logger,
+		events:      newEventLog(),
+		stopChannel: make(chan bool),
+	}
+
+	logger.Debug("Created gRPC server instance")
+
+	return gs
+}
+
+// start records slider events and serves the gRPC service on the given address, until stop is called
+func (gs *grpcServer) start(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", address, err)
+	}
+
+	gs.server = grpc.NewServer(
+		grpc.UnaryInterceptor(gs.authorizeUnary),
+		grpc.StreamInterceptor(gs.authorizeStream))
+
+	deejpb.RegisterDeejServer(gs.server, gs)
+
+	sliderEvents := gs.deej.serial.SubscribeToSliderMoveEvents(PriorityBackground)
+
+	go func() {
+		for event := range sliderEvents.C {
+			gs.events.append(event)
+		}
+	}()
+
+	go func() {
+		if err := gs.server.Serve(listener); err != nil {
+			gs.logger.Warnw("gRPC server stopped unexpectedly", "error", err)
+		}
+	}()
+
+	gs.logger.Infow("Serving gRPC",
+		"address", listener.Addr().String(),
+		"tokens", len(gs.deej.configManager.getAPITokens()))
+
+	return nil
+}
+
+func (gs *grpcServer) stop() {
+	if gs.server == nil {
+		return
+	}
+
+	close(gs.stopChannel)
+
+	stopped := make(chan bool)
+
+	go func() {
+		gs.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(grpcShutdownTimeout):
+		gs.logger.Warn("Timed out waiting for gRPC clients, disconnecting them")
+		gs.server.Stop()
+	}
+}
+
+// GetConfig returns the config deej is running with, as YAML
+func (gs *grpcServer) GetConfig(ctx context.Context, request *deejpb.GetConfigRequest) (*deejpb.GetConfigResponse, error) {
+	buf := &bytes.Buffer{}
+	if err := gs.deej.configManager.encodeConfig(buf); err != nil {
+		gs.logger.Warnw("Failed to encode config for gRPC", "error", err)
+		return nil, status.Error(codes.Internal, "failed to encode config")
+	}
+
+	return &deejpb.GetConfigResponse{Yaml: buf.String()}, nil
+}
+
+// ListSessions returns every audio session deej knows about, ordered by key
+func (gs *grpcServer) ListSessions(ctx context.Context, request *deejpb.ListSessionsRequest) (*deejpb.ListSessionsResponse, error) {
+	sessions := []*deejpb.Session{}
+
+	for _, session := range gs.deej.sessions.all() {
+		sessions = append(sessions, &deejpb.Session{
+			Key:    session.Key(),
+			Volume: session.GetVolume(),
+			Muted:  session.GetMute(),
+		})
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool { return sessions[i].Key < sessions[j].Key })
+
+	return &deejpb.ListSessionsResponse{Sessions: sessions}, nil
+}
+
+// WatchSliderEvents replays kept events after the requested sequence number, then streams new ones as they come
+func (gs *grpcServer) WatchSliderEvents(request *deejpb.WatchSliderEventsRequest, stream deejpb.Deej_WatchSliderEventsServer) error {
+	after := request.After
+
+	// without a starting point, only what happens from now on
+	if after == 0 {
+		_, after, _ = gs.events.since(0)
+	}
+
+	for {
+		events, _, appended := gs.events.since(after)
+
+		for _, event := range events {
+			if err := stream.Send(&deejpb.SliderEvent{
+				Seq:    event.Seq,
+				Slider: event.Slider,
+				Value:  event.Value,
+				Time:   timestamppb.New(event.Time),
+			}); err != nil {
+				return err
+			}
+
+			after = event.Seq
+		}
+
+		select {
+		case <-appended:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-gs.stopChannel:
+			return status.Error(codes.Unavailable, "deej is stopping")
+		}
+	}
+}
+
+func (gs *grpcServer) authorizeUnary(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	if err := gs.authorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, request)
+}
+
+func (gs *grpcServer) authorizeStream(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler) error {
+
+	if err := gs.authorize(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(server, stream)
+}
+
+// authorize checks the call's token against the API tokens, the same way the HTTP API does. without any
+// tokens in the config, only calls that read deej's state are let through
+func (gs *grpcServer) authorize(ctx context.Context, method string) error {
+	scope, ok := grpcMethodScopes[method]
+	if !ok {
+		return status.Error(codes.Unimplemented, "unknown method")
+	}
+
+	tokens := gs.deej.configManager.getAPITokens()
+	if len(tokens) == 0 {
+		if !openWithoutTokens(scope) {
+			return status.Error(codes.PermissionDenied, "this needs a token with the "+scope+" scope, and api_tokens has none")
+		}
+
+		return nil
+	}
+
+	presented := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("authorization") {
+			if strings.HasPrefix(value, "Bearer ") {
+				presented = strings.TrimSpace(strings.TrimPrefix(value, "Bearer "))
+			}
+		}
+	}
+
+	if presented == "" {
+		return status.Error(codes.Unauthenticated, "missing token")
+	}
+
+	token, ok := matchAPIToken(gs.logger, tokens, presented)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if !token.allows(scope) {
+		gs.logger.Debugw("Refused gRPC call outside of token's scopes",
+			"token", token.Name,
+			"method", method,
+			"required", scope)
+
+		return status.Error(codes.PermissionDenied, "token doesn't have the "+scope+" scope")
+	}
+
+	return nil
+}
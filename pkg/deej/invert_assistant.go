@@ -0,0 +1,50 @@
+package deej
+
+import (
+	"fmt"
+	"time"
+)
+
+// how long the user has to move the slider once the assistant has asked them to
+const invertAssistantTimeout = 15 * time.Second
+
+// runInvertAssistant asks the user to move a slider up (or turn its knob up), checks which slider moved and which
+// direction the board reported, and saves the matching invert setting on that slider, so nobody has to guess it
+// in the config
+func (d *Deej) runInvertAssistant() {
+	logger := d.logger.Named("invert_assistant")
+
+	if !d.serial.connected {
+		logger.Info("Not connected, can't detect slider direction")
+		d.notifier.Notify("Can't detect slider direction!", "deej isn't connected to your board right now.")
+		return
+	}
+
+	logger.Info("Waiting for the user to move a slider up")
+	d.notifier.Notify("Detecting slider direction",
+		fmt.Sprintf("Move a slider up (or turn a knob up by one step) within %d seconds.", int(invertAssistantTimeout.Seconds())))
+
+	direction, err := d.serial.CaptureNextDirection(invertAssistantTimeout)
+	if err != nil {
+		logger.Warnw("Failed to capture slider direction", "error", err)
+		d.notifier.Notify("Slider direction not detected", "No slider movement was detected, please try again.")
+		return
+	}
+
+	// the slider was moved up - if the board says it went down, it's mounted the other way around
+	invert := !direction.Up
+
+	logger.Infow("Detected slider direction", "slider", direction.SliderID, "up", direction.Up, "invert", invert)
+
+	if err := d.configManager.SetSliderInvert(direction.SliderID, invert); err != nil {
+		logger.Warnw("Failed to save slider invert setting", "slider", direction.SliderID, "error", err)
+		d.notifier.Notify("Slider direction not saved", "The slider you moved isn't in your config.")
+		return
+	}
+
+	if invert {
+		d.notifier.Notify("Slider direction saved", fmt.Sprintf("%s was reversed, deej will now invert it.", direction.SliderID))
+	} else {
+		d.notifier.Notify("Slider direction saved", fmt.Sprintf("%s is set up correctly, no inversion needed.", direction.SliderID))
+	}
+}
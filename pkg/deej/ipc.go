@@ -0,0 +1,202 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// ipcTransport talks to a single local client (a script, a macro tool, a stream deck plugin) over the Unix socket
+// or named pipe. Clients send the same lines a board would, and may disconnect as soon as they're done
+type ipcTransport struct {
+	conn net.Conn
+	name string
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newIPCTransport(conn net.Conn, number int) *ipcTransport {
+	return &ipcTransport{
+		conn:   conn,
+		name:   fmt.Sprintf("ipc:%d", number),
+		closed: make(chan struct{}),
+	}
+}
+
+// Connect does nothing, the client is connected by the time there's a transport for it
+func (it *ipcTransport) Connect() error {
+	return nil
+}
+
+func (it *ipcTransport) ReadLines(lines chan<- TransportLine) error {
+	reader := bufio.NewReader(it.conn)
+
+	for {
+		line, err := reader.ReadString('\n')
+
+		// a script that writes its last line without an LF and disconnects still gets it handled
+		if line != "" {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			lines <- TransportLine{Text: line, ReadAt: time.Now()}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (it *ipcTransport) Write(data []byte) (int, error) {
+	return it.conn.Write(data)
+}
+
+// Close closes the connection. it's safe to call more than once, which happens when deej closes
+// the connection and the client's read loop notices
+func (it *ipcTransport) Close() error {
+	var err error
+
+	it.closeOnce.Do(func() {
+		err = it.conn.Close()
+		close(it.closed)
+	})
+
+	return err
+}
+
+func (it *ipcTransport) Name() string {
+	return it.name
+}
+
+func (it *ipcTransport) DeviceID() (string, error) {
+	return "", errors.New("local clients have no device id")
+}
+
+// ipcServer is deej's local control interface: a Unix socket on Linux and a named pipe on Windows, only reachable
+// from this machine. Every client gets its own SerialIO, just like a board connecting over websocket
+type ipcServer struct {
+	deej     *Deej
+	logger   *zap.SugaredLogger
+	listener net.Listener
+
+	boardsLock sync.Mutex
+	boards     map[*SerialIO]bool
+	clients    int
+	stopping   bool
+}
+
+func newIPCServer(deej *Deej, logger *zap.SugaredLogger) *ipcServer {
+	logger = logger.Named("ipc")
+
+	is := &ipcServer{
+		deej:   deej,
+		logger: logger,
+		boards: map[*SerialIO]bool{},
+	}
+
+	logger.Debug("Created IPC server instance")
+
+	return is
+}
+
+// start accepts local clients until stop is called. it does nothing unless enabled
+func (is *ipcServer) start(settings IPC) error {
+	if !settings.Enabled {
+		return nil
+	}
+
+	path := settings.Path
+	if path == "" {
+		path = util.DefaultLocalSocketPath()
+	}
+
+	listener, err := util.ListenLocal(path)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", path, err)
+	}
+
+	is.listener = listener
+
+	go is.accept()
+
+	is.logger.Infow("Accepting local connections", "path", path)
+
+	return nil
+}
+
+// stop disconnects all clients and stops accepting new ones
+func (is *ipcServer) stop() {
+	if is.listener == nil {
+		return
+	}
+
+	is.boardsLock.Lock()
+	defer is.boardsLock.Unlock()
+
+	is.stopping = true
+
+	if err := is.listener.Close(); err != nil {
+		is.logger.Warnw("Failed to close local listener", "error", err)
+	}
+
+	for board := range is.boards {
+		board.Stop()
+	}
+}
+
+func (is *ipcServer) accept() {
+	for {
+		conn, err := is.listener.Accept()
+		if err != nil {
+			is.boardsLock.Lock()
+			stopping := is.stopping
+			is.boardsLock.Unlock()
+
+			if !stopping {
+				is.logger.Warnw("Stopped accepting local connections", "error", err)
+			}
+
+			return
+		}
+
+		go is.handleClient(conn)
+	}
+}
+
+// handleClient handles a client's lines for as long as it stays connected
+func (is *ipcServer) handleClient(conn net.Conn) {
+	is.boardsLock.Lock()
+	is.clients++
+	transport := newIPCTransport(conn, is.clients)
+	is.boardsLock.Unlock()
+
+	board := newBoardIO(is.deej, is.logger, is.deej.serial)
+	board.quiet = true
+
+	if err := board.StartTransport(transport); err != nil {
+		is.logger.Warnw("Failed to start local client", "client", transport.Name(), "error", err)
+		transport.Close()
+		return
+	}
+
+	is.boardsLock.Lock()
+	is.boards[board] = true
+	is.boardsLock.Unlock()
+
+	<-transport.closed
+
+	is.boardsLock.Lock()
+	delete(is.boards, board)
+	is.boardsLock.Unlock()
+}
@@ -0,0 +1,180 @@
+package deej
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// how many of the most recent samples each stage keeps for its percentiles
+const latencySampleCount = 512
+
+// how often the percentiles are logged while latency tracing is enabled (and slider events were traced since)
+const latencyReportInterval = time.Minute
+
+// latencyTrace follows a single slider event from the moment its line was read until its volume got applied
+type latencyTrace struct {
+	readAt       time.Time
+	parsedAt     time.Time
+	dispatchedAt time.Time
+	appliedAt    time.Time
+}
+
+// LatencyPercentiles summarizes the recent samples of a single pipeline stage
+type LatencyPercentiles struct {
+	Samples int
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// LatencyStats breaks input-to-volume latency down by pipeline stage, so lag can be
+// attributed to serial, to deej's own processing or to the OS audio API
+type LatencyStats struct {
+	Parse    LatencyPercentiles // line read -> slider event parsed
+	Dispatch LatencyPercentiles // slider event parsed -> picked up by the session map
+	Apply    LatencyPercentiles // picked up -> OS volume call returned
+	Total    LatencyPercentiles // line read -> OS volume call returned
+}
+
+type latencyRecorder struct {
+	lock sync.Mutex
+
+	parse    *durationRing
+	dispatch *durationRing
+	apply    *durationRing
+	total    *durationRing
+
+	// how many events were recorded so far, to tell whether there's anything new to report
+	recorded uint64
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{
+		parse:    newDurationRing(latencySampleCount),
+		dispatch: newDurationRing(latencySampleCount),
+		apply:    newDurationRing(latencySampleCount),
+		total:    newDurationRing(latencySampleCount),
+	}
+}
+
+func (r *latencyRecorder) record(trace *latencyTrace) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.parse.add(trace.parsedAt.Sub(trace.readAt))
+	r.dispatch.add(trace.dispatchedAt.Sub(trace.parsedAt))
+	r.apply.add(trace.appliedAt.Sub(trace.dispatchedAt))
+	r.total.add(trace.appliedAt.Sub(trace.readAt))
+	r.recorded++
+}
+
+func (r *latencyRecorder) recordedCount() uint64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.recorded
+}
+
+func (r *latencyRecorder) stats() LatencyStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return LatencyStats{
+		Parse:    r.parse.percentiles(),
+		Dispatch: r.dispatch.percentiles(),
+		Apply:    r.apply.percentiles(),
+		Total:    r.total.percentiles(),
+	}
+}
+
+// reportLatency logs the latency percentiles every once in a while, as long as latency tracing is enabled and
+// there were slider events since the last time. with tracing off, it only wakes up for config reloads
+func (d *Deej) reportLatency() {
+	logger := d.logger.Named("latency")
+	configReloaded := d.configManager.SubscribeToChanges()
+
+	var lastRecorded uint64
+
+	for {
+		if !d.configManager.getTraceLatency() {
+			<-configReloaded
+			continue
+		}
+
+		select {
+		case <-time.After(latencyReportInterval):
+		case <-configReloaded:
+			continue
+		}
+
+		d.wakeups.record("latency_report")
+
+		recorded := d.latency.recordedCount()
+		if recorded == lastRecorded {
+			continue
+		}
+
+		lastRecorded = recorded
+		stats := d.latency.stats()
+
+		logger.Infow("Slider latency over recent events",
+			"events", recorded,
+			"samples", stats.Total.Samples,
+			"p50", stats.Total.P50,
+			"p95", stats.Total.P95,
+			"max", stats.Total.Max,
+			"parseP95", stats.Parse.P95,
+			"dispatchP95", stats.Dispatch.P95,
+			"applyP95", stats.Apply.P95)
+	}
+}
+
+// durationRing keeps the last N durations added to it
+type durationRing struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newDurationRing(size int) *durationRing {
+	return &durationRing{samples: make([]time.Duration, size)}
+}
+
+func (dr *durationRing) add(d time.Duration) {
+	dr.samples[dr.next] = d
+	dr.next = (dr.next + 1) % len(dr.samples)
+
+	if dr.next == 0 {
+		dr.full = true
+	}
+}
+
+func (dr *durationRing) percentiles() LatencyPercentiles {
+	count := dr.next
+	if dr.full {
+		count = len(dr.samples)
+	}
+
+	if count == 0 {
+		return LatencyPercentiles{}
+	}
+
+	sorted := make([]time.Duration, count)
+	copy(sorted, dr.samples[:count])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	percentile := func(p float64) time.Duration {
+		return sorted[int(math.Ceil(p*float64(count)))-1]
+	}
+
+	return LatencyPercentiles{
+		Samples: count,
+		P50:     percentile(0.50),
+		P95:     percentile(0.95),
+		P99:     percentile(0.99),
+		Max:     sorted[count-1],
+	}
+}
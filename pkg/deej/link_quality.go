@@ -0,0 +1,242 @@
+package deej
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+
+	// how far back the link quality score looks
+	linkQualityWindow = 2 * time.Minute
+
+	// score thresholds for each rating
+	linkQualityGoodScore = 80
+	linkQualityFairScore = 50
+
+	linkQualityRatingGood = "good"
+	linkQualityRatingFair = "fair"
+	linkQualityRatingPoor = "poor"
+
+	// how often (at most) deej re-evaluates the score to update the tray and decide whether to notify
+	linkQualityCheckInterval = 15 * time.Second
+)
+
+type linkEventKind int
+
+const (
+	linkEventLine linkEventKind = iota
+	linkEventBadLine
+	linkEventCorruptLine
+	linkEventConnect
+	linkEventDisconnect
+)
+
+type linkEvent struct {
+	kind linkEventKind
+	at   time.Time
+}
+
+// LinkQuality is a snapshot of the board connection's health over the recent window
+type LinkQuality struct {
+	Score        int
+	Rating       string
+	Lines        int
+	BadLines     int
+	CorruptLines int
+	Reconnects   int
+	Downtime     time.Duration
+}
+
+func (lq LinkQuality) String() string {
+	return fmt.Sprintf("%s (%d%%)", lq.Rating, lq.Score)
+}
+
+// linkQualityTracker keeps a rolling window of link events and scores them. parse errors hint at
+// electrical noise, reconnects and downtime hint at a failing cable or port
+type linkQualityTracker struct {
+	lock      sync.Mutex
+	events    []linkEvent
+	connected bool
+
+	// signaled whenever an event is recorded
+	changed chan bool
+}
+
+func newLinkQualityTracker() *linkQualityTracker {
+	return &linkQualityTracker{
+		changed: make(chan bool, 1),
+	}
+}
+
+func (t *linkQualityTracker) record(kind linkEventKind) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// only track actual transitions, closing an already closed connection isn't another disconnect
+	switch kind {
+	case linkEventConnect:
+		if t.connected {
+			return
+		}
+		t.connected = true
+	case linkEventDisconnect:
+		if !t.connected {
+			return
+		}
+		t.connected = false
+	}
+
+	now := time.Now()
+	t.prune(now)
+	t.events = append(t.events, linkEvent{kind: kind, at: now})
+
+	select {
+	case t.changed <- true:
+	default:
+	}
+}
+
+// settled tells whether the window is empty, meaning the score can't change until something new is recorded
+func (t *linkQualityTracker) settled() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.prune(time.Now())
+
+	return len(t.events) == 0
+}
+
+func (t *linkQualityTracker) snapshot() LinkQuality {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	lq := LinkQuality{}
+
+	var disconnectedAt time.Time
+	for _, event := range t.events {
+		switch event.kind {
+		case linkEventLine:
+			lq.Lines++
+		case linkEventBadLine:
+			lq.BadLines++
+		case linkEventCorruptLine:
+			lq.CorruptLines++
+		case linkEventDisconnect:
+			disconnectedAt = event.at
+		case linkEventConnect:
+			if !disconnectedAt.IsZero() {
+				lq.Reconnects++
+				lq.Downtime += event.at.Sub(disconnectedAt)
+				disconnectedAt = time.Time{}
+			}
+		}
+	}
+
+	// still down
+	if !disconnectedAt.IsZero() {
+		lq.Downtime += now.Sub(disconnectedAt)
+	}
+
+	score := 100.0
+
+	// garbage (and corrupted) lines cost up to 60 points, depending on how much of the traffic they make up
+	if total := lq.Lines + lq.BadLines + lq.CorruptLines; total > 0 {
+		score -= 60 * float64(lq.BadLines+lq.CorruptLines) / float64(total)
+	}
+
+	// each reconnect costs 15 points, and downtime costs up to 30 depending on how much of the window it took
+	score -= 15 * float64(lq.Reconnects)
+	score -= 30 * float64(lq.Downtime) / float64(linkQualityWindow)
+
+	if score < 0 {
+		score = 0
+	}
+
+	lq.Score = int(score)
+
+	switch {
+	case lq.Score >= linkQualityGoodScore:
+		lq.Rating = linkQualityRatingGood
+	case lq.Score >= linkQualityFairScore:
+		lq.Rating = linkQualityRatingFair
+	default:
+		lq.Rating = linkQualityRatingPoor
+	}
+
+	return lq
+}
+
+// prune drops events that fell out of the window. assumes the lock is held
+func (t *linkQualityTracker) prune(now time.Time) {
+	cutoff := now.Add(-linkQualityWindow)
+
+	firstKept := 0
+	for firstKept < len(t.events) && t.events[firstKept].at.Before(cutoff) {
+		firstKept++
+	}
+
+	t.events = t.events[firstKept:]
+}
+
+// monitorLinkQuality reflects the link quality in the tray and lets the user know when it goes bad,
+// which usually points at a cable or port rather than at deej itself. it only wakes up while
+// there's something to evaluate: new events, or old ones that still have to age out of the window
+func (d *Deej) monitorLinkQuality() {
+	logger := d.logger.Named("link_quality")
+	notifiedPoor := false
+
+	var lastCheck time.Time
+	var recheck <-chan time.Time
+
+	for {
+		select {
+		case <-d.serial.quality.changed:
+		case <-recheck:
+		}
+
+		d.wakeups.record("link_quality")
+
+		// every line is an event, so don't re-evaluate more often than the interval
+		if wait := linkQualityCheckInterval - time.Since(lastCheck); wait > 0 {
+			<-time.After(wait)
+		}
+
+		select {
+		case <-d.serial.quality.changed:
+		default:
+		}
+
+		lastCheck = time.Now()
+		lq := d.serial.LinkQuality()
+
+		d.setTrayTooltip(fmt.Sprintf("deej - connection: %s (%s)", lq.Rating, d.formatPercent(float32(lq.Score)/100)))
+
+		switch lq.Rating {
+		case linkQualityRatingPoor:
+			if !notifiedPoor {
+				logger.Warnw("Connection quality degraded", "linkQuality", lq)
+
+				d.notifier.Notify("Connection quality is poor",
+					fmt.Sprintf("%d garbled lines and %d reconnects recently. Check your board's USB cable and port.",
+						lq.BadLines+lq.CorruptLines, lq.Reconnects))
+
+				notifiedPoor = true
+			}
+		case linkQualityRatingGood:
+			if notifiedPoor {
+				logger.Infow("Connection quality recovered", "linkQuality", lq)
+				notifiedPoor = false
+			}
+		}
+
+		recheck = nil
+		if !d.serial.quality.settled() {
+			recheck = time.After(linkQualityCheckInterval)
+		}
+	}
+}
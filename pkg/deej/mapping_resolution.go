@@ -0,0 +1,153 @@
+package deej
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+)
+
+// how a slider's target picks up a process. sliders targeting a process by name all control it, and if there
+// are none, sliders targeting unmapped sessions do. the dynamic targets (the active window, whatever's making
+// noise, steam's game, window titles) pick it up on top of that, but only while they apply
+const (
+	targetMatchProcess  = "process"
+	targetMatchUnmapped = "unmapped"
+	targetMatchDynamic  = "dynamic"
+)
+
+// TargetMatch is a slider's target that picks up a process
+type TargetMatch struct {
+	Slider string `json:"slider"`
+	Target string `json:"target"`
+	Match  string `json:"match"`
+}
+
+// MappingResolution is what the config makes of a process: the sliders that control its volume (more than one
+// means they fight over it, and whichever moved last wins) and every target that may pick it up
+type MappingResolution struct {
+	Process  string        `json:"process"`
+	Sliders  []string      `json:"sliders"`
+	Conflict bool          `json:"conflict"`
+	Matches  []TargetMatch `json:"matches"`
+}
+
+// ResolveMapping loads the given config file without running deej, and tells which sliders control the given
+// process with it. The config's variables and active profile are taken into account, just like deej would
+func ResolveMapping(path string, process string) (*MappingResolution, error) {
+	if process == "" {
+		return nil, errors.New("no process name given")
+	}
+
+	cm, err := loadConfigQuietly(zap.NewNop().Sugar(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	resolution := cm.resolveProcess(process)
+
+	return &resolution, nil
+}
+
+// plainTarget returns a target as the process name it stands for, unless it's one of the dynamic ones
+func plainTarget(target string) (string, bool) {
+	target = strings.ToLower(target)
+
+	if strings.HasPrefix(target, specialTargetTransformPrefix) ||
+		strings.HasPrefix(target, specialTargetSteamPrefix) ||
+		strings.HasPrefix(target, specialTargetTitlePrefix) {
+		return "", false
+	}
+
+	return target, true
+}
+
+// resolveProcess tells which sliders control the given process. sliders are gone through by name,
+// so the answer is the same every time
+func (cm *ConfigManager) resolveProcess(process string) MappingResolution {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	process = strings.ToLower(process)
+
+	keys := make([]string, 0, len(cm.Config.SliderMappings))
+	for key := range cm.Config.SliderMappings {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	resolution := MappingResolution{Process: process, Sliders: []string{}, Matches: []TargetMatch{}}
+	unmapped := []TargetMatch{}
+
+	for _, key := range keys {
+		for _, target := range cm.Config.SliderMappings[key].Targets {
+			match := TargetMatch{Slider: key, Target: target, Match: targetMatchDynamic}
+
+			if name, ok := plainTarget(target); ok {
+				if name != process {
+					continue
+				}
+
+				match.Match = targetMatchProcess
+				resolution.Sliders = append(resolution.Sliders, key)
+			} else if strings.ToLower(target) == specialTargetTransformPrefix+specialTargetAllUnmapped {
+				match.Match = targetMatchUnmapped
+				unmapped = append(unmapped, match)
+
+				continue
+			}
+
+			resolution.Matches = append(resolution.Matches, match)
+		}
+	}
+
+	// unmapped sessions are the ones no slider targets by name. master, system and mic (and devices) never are,
+	// see sessionMapped
+	special := process == masterSessionName || process == systemSessionName || process == inputSessionName ||
+		deviceSessionKeyPattern.MatchString(process)
+
+	if len(resolution.Sliders) == 0 && !special {
+		for _, match := range unmapped {
+			resolution.Sliders = append(resolution.Sliders, match.Slider)
+			resolution.Matches = append(resolution.Matches, match)
+		}
+	}
+
+	resolution.Sliders = funk.UniqString(resolution.Sliders)
+	resolution.Conflict = len(resolution.Sliders) > 1
+
+	return resolution
+}
+
+// warnDuplicateTargets complains about processes that more than one slider targets by name,
+// since those sliders fight over the process' volume
+func warnDuplicateTargets(logger *zap.SugaredLogger, mappings map[string]SliderMapping) {
+	sliders := map[string][]string{}
+
+	for key, mapping := range mappings {
+		for _, target := range mapping.Targets {
+			if name, ok := plainTarget(target); ok {
+				sliders[name] = funk.UniqString(append(sliders[name], key))
+			}
+		}
+	}
+
+	targets := make([]string, 0, len(sliders))
+	for target, keys := range sliders {
+		if len(keys) > 1 {
+			targets = append(targets, target)
+		}
+	}
+
+	sort.Strings(targets)
+
+	for _, target := range targets {
+		keys := sliders[target]
+		sort.Strings(keys)
+
+		logger.Warnw("Target is mapped by more than one slider, whichever moves last wins", "target", target, "sliders", keys)
+	}
+}
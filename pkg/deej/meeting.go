@@ -0,0 +1,177 @@
+package deej
+
+import (
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// how often deej checks whether a conferencing app is recording
+const meetingPollInterval = 2 * time.Second
+
+// the conferencing apps deej knows about, as process names on Windows and Linux
+var defaultMeetingApps = []string{
+	"zoom.exe", "zoom",
+	"teams.exe", "ms-teams.exe", "teams",
+	"discord.exe", "discord",
+}
+
+// meetingDetector watches for conferencing apps capturing the microphone, which is a good sign they're in a call,
+// and applies the configured meeting levels for as long as one is. When the call ends, the sliders it touched
+// go back to where they were
+type meetingDetector struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	// the app whose call is going on, if any
+	app string
+
+	// what the meeting changed: sliders' volumes from before it, and the sliders it muted
+	volumes map[string]float32
+	muted   []string
+}
+
+func newMeetingDetector(deej *Deej, logger *zap.SugaredLogger) *meetingDetector {
+	logger = logger.Named("meeting")
+
+	md := &meetingDetector{
+		deej:   deej,
+		logger: logger,
+	}
+
+	logger.Debug("Created meeting detector instance")
+
+	return md
+}
+
+// run checks for meetings until deej stops. with meeting detection off, it only wakes up for config reloads,
+// so meeting settings can be added or changed at any time
+func (md *meetingDetector) run() {
+	finder, ok := md.deej.sessions.sessionFinder.(captureSessionFinder)
+	if !ok {
+		md.logger.Debug("Audio backend can't tell which apps are recording, meeting detection is disabled")
+		return
+	}
+
+	configReloaded := md.deej.configManager.SubscribeToChanges()
+
+	for {
+		// nothing to do in a meeting means nothing to detect, but a meeting in progress still needs ending
+		if !md.deej.configManager.getMeeting().enabled() && md.app == "" {
+			<-configReloaded
+			continue
+		}
+
+		select {
+		case <-time.After(meetingPollInterval):
+		case <-configReloaded:
+			continue
+		}
+
+		settings := md.deej.configManager.getMeeting()
+
+		// meetings in another user's session aren't ours to handle
+		if md.deej.userSession.isPaused() {
+			continue
+		}
+
+		md.deej.wakeups.record("meeting")
+
+		processes, err := finder.GetCapturingProcesses()
+		if err != nil {
+			md.logger.Debugw("Failed to get capturing processes", "error", err)
+			continue
+		}
+
+		app := meetingApp(settings, processes)
+
+		switch {
+		case app != "" && md.app == "" && settings.enabled():
+			md.start(app, settings)
+		case app == "" && md.app != "":
+			md.end()
+		}
+	}
+}
+
+// start applies the meeting levels and mutes, remembering what it changed
+func (md *meetingDetector) start(app string, settings Meeting) {
+	md.logger.Infow("Meeting started", "app", app)
+
+	md.app = app
+	md.volumes = map[string]float32{}
+	md.muted = nil
+
+	for sliderID, level := range settings.Levels {
+		mapping, err := md.deej.configManager.getSliderMappingByKey(sliderID)
+		if err != nil {
+			md.logger.Warnw("Meeting level set for unknown slider", "slider", sliderID)
+			continue
+		}
+
+		md.volumes[sliderID] = mapping.Volume
+
+		if err := md.deej.SetSliderValue(sliderID, level); err != nil {
+			md.logger.Warnw("Failed to apply meeting level", "slider", sliderID, "error", err)
+		}
+	}
+
+	for _, sliderID := range settings.Mute {
+		mapping, err := md.deej.configManager.getSliderMappingByKey(sliderID)
+		if err != nil {
+			md.logger.Warnw("Meeting mute set for unknown slider", "slider", sliderID)
+			continue
+		}
+
+		// sliders that were muted already stay muted after the meeting, too
+		if !mapping.Muted {
+			md.deej.serial.toggleMute(md.logger, sliderID)
+			md.muted = append(md.muted, sliderID)
+		}
+	}
+}
+
+// end puts back what the meeting changed. sliders unmuted by hand during the meeting are left alone
+func (md *meetingDetector) end() {
+	md.logger.Infow("Meeting ended", "app", md.app)
+
+	for sliderID, volume := range md.volumes {
+		if err := md.deej.SetSliderValue(sliderID, volume); err != nil {
+			md.logger.Warnw("Failed to restore slider volume after meeting", "slider", sliderID, "error", err)
+		}
+	}
+
+	for _, sliderID := range md.muted {
+		if mapping, err := md.deej.configManager.getSliderMappingByKey(sliderID); err == nil && mapping.Muted {
+			md.deej.serial.toggleMute(md.logger, sliderID)
+		}
+	}
+
+	md.app = ""
+	md.volumes = nil
+	md.muted = nil
+}
+
+// enabled tells whether there's anything to do during a meeting
+func (m Meeting) enabled() bool {
+	return len(m.Levels) > 0 || len(m.Mute) > 0
+}
+
+// meetingApp returns the first of the capturing processes that's a conferencing app, or an empty string
+func meetingApp(settings Meeting, processes []string) string {
+	apps := settings.Apps
+	if len(apps) == 0 {
+		apps = defaultMeetingApps
+	}
+
+	for _, process := range processes {
+		for _, app := range apps {
+			if strings.EqualFold(process, app) {
+				return process
+			}
+		}
+	}
+
+	return ""
+}
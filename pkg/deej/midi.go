@@ -0,0 +1,286 @@
+package deej
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// a controller that isn't plugged in (or was unplugged) is looked for again this often
+	midiReconnectInterval = 5 * time.Second
+
+	midiStatusControlChange = 0xB0
+	midiMaxValue            = 127
+	midiChannels            = 16
+)
+
+// MIDIMapping assigns one of a MIDI controller's control change (CC) numbers to a slider, i.e. a fader on a nanoKONTROL.
+// Channel is 1-16, or 0 (the default) to take the CC from any channel
+type MIDIMapping struct {
+	CC      int    `yaml:"cc" doc:"The control change number"`
+	Channel int    `yaml:"channel,omitempty" doc:"The MIDI channel (1-16), any if unset"`
+	Slider  string `yaml:"slider" doc:"The slider the control moves"`
+}
+
+// midiMessage is a single MIDI channel message. data2 is unused by messages with only one data byte
+type midiMessage struct {
+	status byte
+	data1  byte
+	data2  byte
+}
+
+// midiPort is an open MIDI input, as opened by openMIDIPort on each platform
+type midiPort interface {
+
+	// ReadMessages hands every channel message to the handler, until the port is closed or the device goes away
+	ReadMessages(handler func(message midiMessage)) error
+	Close() error
+	Name() string
+}
+
+// midiInput lets a MIDI controller (the kind with a row of faders) stand in for a board. Every control change
+// mapped in midi_mappings moves its slider, going through the same pipeline as a board's slider moves
+type midiInput struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	stopChannel chan bool
+
+	// the open port, nil while there isn't one, and what stops reading from the controller while there are mappings
+	lock           sync.Mutex
+	port           midiPort
+	runStopChannel chan bool
+	stopped        bool
+}
+
+func newMIDIInput(deej *Deej, logger *zap.SugaredLogger) *midiInput {
+	logger = logger.Named("midi")
+
+	mi := &midiInput{
+		deej:        deej,
+		logger:      logger,
+		stopChannel: make(chan bool),
+	}
+
+	logger.Debug("Created midi input instance")
+
+	return mi
+}
+
+// start follows the config in the background until stop is called: the controller is looked for and read from
+// while there are mappings, and let go of once they're gone
+func (mi *midiInput) start() {
+	configReloadedChannel := mi.deej.configManager.SubscribeToChanges()
+
+	go func() {
+		mi.update()
+
+		for {
+			select {
+			case <-mi.stopChannel:
+				return
+			case <-configReloadedChannel:
+				mi.update()
+			}
+		}
+	}()
+}
+
+// update starts reading from the controller if the config has mappings for it, or stops if it no longer does
+func (mi *midiInput) update() {
+	mapped := len(mi.deej.configManager.getMIDIMappings()) > 0
+
+	mi.lock.Lock()
+	defer mi.lock.Unlock()
+
+	if mi.stopped || mapped == (mi.runStopChannel != nil) {
+		return
+	}
+
+	if mapped {
+		mi.runStopChannel = make(chan bool)
+		go mi.run(mi.runStopChannel)
+
+		return
+	}
+
+	mi.logger.Info("No more MIDI mappings, letting go of the controller")
+
+	close(mi.runStopChannel)
+	mi.runStopChannel = nil
+	mi.closePort()
+}
+
+func (mi *midiInput) stop() {
+	mi.lock.Lock()
+	defer mi.lock.Unlock()
+
+	if mi.stopped {
+		return
+	}
+
+	mi.stopped = true
+	close(mi.stopChannel)
+
+	if mi.runStopChannel != nil {
+		close(mi.runStopChannel)
+		mi.runStopChannel = nil
+	}
+
+	mi.closePort()
+}
+
+// closePort closes the open port, if there is one, which ends its session. mi.lock must be held
+func (mi *midiInput) closePort() {
+	if mi.port == nil {
+		return
+	}
+
+	if err := mi.port.Close(); err != nil {
+		mi.logger.Debugw("Failed to close MIDI port", "error", err)
+	}
+}
+
+// run keeps reading from the controller, and looking for it again whenever it goes away, until stopChannel is closed
+func (mi *midiInput) run(stopChannel chan bool) {
+	warned := false
+
+	for {
+		connected, err := mi.session(stopChannel)
+
+		select {
+		case <-stopChannel:
+			return
+		default:
+		}
+
+		// a controller that's simply not plugged in is only worth a warning once, until it shows up
+		if connected {
+			mi.logger.Warnw("Lost MIDI controller, trying again soon", "error", err, "retryIn", midiReconnectInterval)
+			warned = true
+		} else if !warned {
+			mi.logger.Warnw("No MIDI controller, trying again soon",
+				"device", mi.deej.configManager.getMIDIDevice(),
+				"error", err,
+				"retryIn", midiReconnectInterval)
+
+			warned = true
+		} else {
+			mi.logger.Debugw("Still no MIDI controller", "error", err)
+		}
+
+		select {
+		case <-stopChannel:
+			return
+		case <-time.After(midiReconnectInterval):
+		}
+	}
+}
+
+// session opens the controller and handles its messages until it goes away. the config's device is
+// read every time, so a different controller can be picked without restarting deej
+func (mi *midiInput) session(stopChannel chan bool) (bool, error) {
+	port, err := openMIDIPort(mi.deej.configManager.getMIDIDevice())
+	if err != nil {
+		return false, err
+	}
+
+	mi.lock.Lock()
+	select {
+	case <-stopChannel:
+		mi.lock.Unlock()
+		port.Close()
+		return false, errors.New("stopped while connecting")
+	default:
+	}
+
+	mi.port = port
+	mi.lock.Unlock()
+
+	defer func() {
+		mi.lock.Lock()
+
+		// a session that was stopped may only get here once the next one has its own port
+		if mi.port == port {
+			mi.port = nil
+		}
+
+		mi.lock.Unlock()
+
+		port.Close()
+	}()
+
+	mi.logger.Infow("Connected to MIDI controller", "port", port.Name())
+
+	return true, port.ReadMessages(mi.handleMessage)
+}
+
+// handleMessage moves the sliders mapped to a control change. the mappings are read every time, so they follow config reloads
+func (mi *midiInput) handleMessage(message midiMessage) {
+	if message.status&0xF0 != midiStatusControlChange {
+		return
+	}
+
+	channel := int(message.status&0x0F) + 1
+	mapped := false
+
+	for _, mapping := range mi.deej.configManager.getMIDIMappings() {
+		if mapping.CC != int(message.data1) || (mapping.Channel != 0 && mapping.Channel != channel) {
+			continue
+		}
+
+		mapped = true
+
+		// a MIDI fader is as much a piece of hardware as the board's, and locked sliders ignore it just the same
+		if mi.deej.configManager.isSliderLocked(mapping.Slider) {
+			continue
+		}
+
+		value := float32(message.data2) / midiMaxValue
+
+		if err := mi.deej.injectSliderMove(SliderMoveEvent{SliderID: mapping.Slider, PercentValue: value}); err != nil {
+			mi.logger.Warnw("Failed to move slider from MIDI", "slider", mapping.Slider, "error", err)
+		}
+	}
+
+	// this is how users find out which CC a fader sends, so they can map it
+	if !mapped {
+		mi.logger.Debugw("Unmapped control change", "channel", channel, "cc", message.data1, "value", message.data2)
+	}
+}
+
+// midiDeviceMatches tells whether a port is the configured device, which is either part of its name
+// (case doesn't matter) or its exact path. no device at all means the first port will do
+func midiDeviceMatches(device string, name string, path string) bool {
+	return device == "" || device == path || strings.Contains(strings.ToLower(name), strings.ToLower(device))
+}
+
+func validMIDIMappings(logger *zap.SugaredLogger, mappings []MIDIMapping) []MIDIMapping {
+	valid := make([]MIDIMapping, 0, len(mappings))
+
+	for idx, mapping := range mappings {
+		if mapping.CC < 0 || mapping.CC > midiMaxValue {
+			logger.Warnw("Ignoring MIDI mapping with CC outside of 0-127", "mapping", idx, "cc", mapping.CC)
+			continue
+		}
+
+		if mapping.Channel < 0 || mapping.Channel > midiChannels {
+			logger.Warnw("Ignoring MIDI mapping with channel outside of 1-16", "mapping", idx, "channel", mapping.Channel)
+			continue
+		}
+
+		if mapping.Slider == "" {
+			logger.Warnw("Ignoring MIDI mapping without a slider", "mapping", idx)
+			continue
+		}
+
+		valid = append(valid, mapping)
+	}
+
+	return valid
+}
@@ -0,0 +1,148 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MIDI controllers show up as ALSA raw MIDI devices, which are plain streams of MIDI bytes
+const alsaRawMIDIGlob = "/dev/snd/midiC*D*"
+
+type rawMIDIPort struct {
+	file *os.File
+	name string
+}
+
+// midiParser turns a stream of MIDI bytes back into messages, keeping track of running status
+// (senders may leave out a status byte that's the same as the last one)
+type midiParser struct {
+	status byte
+	data   []byte
+	sysex  bool
+}
+
+func openMIDIPort(device string) (midiPort, error) {
+
+	// a path is used as is, which also covers devices that don't live in /dev/snd
+	if strings.HasPrefix(device, "/") {
+		return openRawMIDIPort(device, device)
+	}
+
+	paths, err := filepath.Glob(alsaRawMIDIGlob)
+	if err != nil {
+		return nil, fmt.Errorf("list raw MIDI devices: %w", err)
+	}
+
+	for _, path := range paths {
+		if name := rawMIDIPortName(path); midiDeviceMatches(device, name, path) {
+			return openRawMIDIPort(path, name)
+		}
+	}
+
+	return nil, errors.New("no matching MIDI device found")
+}
+
+func openRawMIDIPort(path string, name string) (midiPort, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	return &rawMIDIPort{file: file, name: name}, nil
+}
+
+// rawMIDIPortName returns the device's sound card id (i.e. "nanoKONTROL2"), the closest thing ALSA has to a name for it
+func rawMIDIPortName(path string) string {
+	id, err := ioutil.ReadFile(filepath.Join("/sys/class/sound", filepath.Base(path), "device", "id"))
+	if err != nil {
+		return path
+	}
+
+	return strings.TrimSpace(string(id))
+}
+
+func (rp *rawMIDIPort) ReadMessages(handler func(message midiMessage)) error {
+	parser := &midiParser{}
+	buf := make([]byte, 256)
+
+	for {
+		n, err := rp.file.Read(buf)
+
+		for _, b := range buf[:n] {
+			if message, ok := parser.feed(b); ok {
+				handler(message)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (rp *rawMIDIPort) Close() error {
+	return rp.file.Close()
+}
+
+func (rp *rawMIDIPort) Name() string {
+	return rp.name
+}
+
+// feed takes the next byte off the wire, and returns a message once one is complete
+func (mp *midiParser) feed(b byte) (midiMessage, bool) {
+	switch {
+
+	// real-time messages (clock, start, stop) are a single byte and may show up anywhere, even mid-message
+	case b >= 0xF8:
+		return midiMessage{}, false
+
+	case b == 0xF0:
+		mp.sysex = true
+		mp.status = 0
+		return midiMessage{}, false
+
+	// the end of a system exclusive message, or a system common message - both cancel running status
+	case b >= 0xF1:
+		mp.sysex = false
+		mp.status = 0
+		return midiMessage{}, false
+
+	case b >= 0x80:
+		mp.sysex = false
+		mp.status = b
+		mp.data = mp.data[:0]
+		return midiMessage{}, false
+	}
+
+	if mp.sysex || mp.status == 0 {
+		return midiMessage{}, false
+	}
+
+	mp.data = append(mp.data, b)
+	if len(mp.data) < midiDataLength(mp.status) {
+		return midiMessage{}, false
+	}
+
+	message := midiMessage{status: mp.status, data1: mp.data[0]}
+	if len(mp.data) > 1 {
+		message.data2 = mp.data[1]
+	}
+
+	mp.data = mp.data[:0]
+
+	return message, true
+}
+
+// midiDataLength returns how many data bytes follow a channel message's status byte
+func midiDataLength(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 1
+	default:
+		return 2
+	}
+}
@@ -0,0 +1,194 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	midiCallbackFunction = 0x30000 // CALLBACK_FUNCTION
+	midiInData           = 0x3C3   // MIM_DATA
+
+	// winmm doesn't say when a device is unplugged, so the open device is checked for this often
+	midiPresenceInterval = 2 * time.Second
+
+	// messages are queued up for the reader rather than handled on winmm's own thread
+	midiMessageBuffer = 256
+)
+
+var (
+	procMidiInGetNumDevs  = syscall.NewLazyDLL("winmm.dll").NewProc("midiInGetNumDevs")
+	procMidiInGetDevCapsW = syscall.NewLazyDLL("winmm.dll").NewProc("midiInGetDevCapsW")
+	procMidiInOpen        = syscall.NewLazyDLL("winmm.dll").NewProc("midiInOpen")
+	procMidiInStart       = syscall.NewLazyDLL("winmm.dll").NewProc("midiInStart")
+	procMidiInStop        = syscall.NewLazyDLL("winmm.dll").NewProc("midiInStop")
+	procMidiInReset       = syscall.NewLazyDLL("winmm.dll").NewProc("midiInReset")
+	procMidiInClose       = syscall.NewLazyDLL("winmm.dll").NewProc("midiInClose")
+
+	// callbacks can't be freed, so all ports share a single one and are told apart by their id
+	winMIDICallback     uintptr
+	winMIDICallbackOnce sync.Once
+
+	winMIDIPortsLock sync.Mutex
+	winMIDIPorts     = map[uintptr]*winMIDIPort{}
+	winMIDINextID    uintptr
+)
+
+// midiInCaps is MIDIINCAPSW
+type midiInCaps struct {
+	mid           uint16
+	pid           uint16
+	driverVersion uint32
+	name          [32]uint16
+	support       uint32
+}
+
+type winMIDIPort struct {
+	id     uintptr
+	index  uintptr
+	name   string
+	handle uintptr
+
+	messages  chan midiMessage
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func openMIDIPort(device string) (midiPort, error) {
+	count, _, _ := procMidiInGetNumDevs.Call()
+
+	for index := uintptr(0); index < count; index++ {
+		name, err := midiInName(index)
+		if err != nil || !midiDeviceMatches(device, name, "") {
+			continue
+		}
+
+		return openWinMIDIPort(index, name)
+	}
+
+	return nil, errors.New("no matching MIDI device found")
+}
+
+func openWinMIDIPort(index uintptr, name string) (midiPort, error) {
+	winMIDICallbackOnce.Do(func() {
+		winMIDICallback = syscall.NewCallback(winMIDICallbackProc)
+	})
+
+	port := &winMIDIPort{
+		index:    index,
+		name:     name,
+		messages: make(chan midiMessage, midiMessageBuffer),
+		closed:   make(chan struct{}),
+	}
+
+	winMIDIPortsLock.Lock()
+	winMIDINextID++
+	port.id = winMIDINextID
+	winMIDIPorts[port.id] = port
+	winMIDIPortsLock.Unlock()
+
+	result, _, _ := procMidiInOpen.Call(
+		uintptr(unsafe.Pointer(&port.handle)),
+		index,
+		winMIDICallback,
+		port.id,
+		midiCallbackFunction)
+
+	if result != 0 {
+		port.unregister()
+		return nil, fmt.Errorf("open %s: winmm error %d", name, result)
+	}
+
+	if result, _, _ := procMidiInStart.Call(port.handle); result != 0 {
+		port.Close()
+		return nil, fmt.Errorf("start %s: winmm error %d", name, result)
+	}
+
+	return port, nil
+}
+
+func midiInName(index uintptr) (string, error) {
+	var caps midiInCaps
+
+	if result, _, _ := procMidiInGetDevCapsW.Call(index, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps)); result != 0 {
+		return "", fmt.Errorf("get device caps: winmm error %d", result)
+	}
+
+	return syscall.UTF16ToString(caps.name[:]), nil
+}
+
+// winMIDICallbackProc runs on winmm's thread, so it only queues the message up. a full queue drops it,
+// which beats holding up the driver
+func winMIDICallbackProc(handle, message, instance, param1, param2 uintptr) uintptr {
+	if message != midiInData {
+		return 0
+	}
+
+	winMIDIPortsLock.Lock()
+	port := winMIDIPorts[instance]
+	winMIDIPortsLock.Unlock()
+
+	if port == nil {
+		return 0
+	}
+
+	select {
+	case port.messages <- midiMessage{status: byte(param1), data1: byte(param1 >> 8), data2: byte(param1 >> 16)}:
+	default:
+	}
+
+	return 0
+}
+
+func (wp *winMIDIPort) ReadMessages(handler func(message midiMessage)) error {
+	ticker := time.NewTicker(midiPresenceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-wp.messages:
+			handler(message)
+		case <-wp.closed:
+			return errors.New("port closed")
+		case <-ticker.C:
+
+			// an unplugged device disappears from the list, or something else takes its place
+			if name, err := midiInName(wp.index); err != nil || name != wp.name {
+				return errors.New("device went away")
+			}
+		}
+	}
+}
+
+func (wp *winMIDIPort) Close() error {
+	var result uintptr
+
+	wp.closeOnce.Do(func() {
+		procMidiInStop.Call(wp.handle)
+		procMidiInReset.Call(wp.handle)
+		result, _, _ = procMidiInClose.Call(wp.handle)
+
+		wp.unregister()
+		close(wp.closed)
+	})
+
+	if result != 0 {
+		return fmt.Errorf("close %s: winmm error %d", wp.name, result)
+	}
+
+	return nil
+}
+
+func (wp *winMIDIPort) Name() string {
+	return wp.name
+}
+
+func (wp *winMIDIPort) unregister() {
+	winMIDIPortsLock.Lock()
+	delete(winMIDIPorts, wp.id)
+	winMIDIPortsLock.Unlock()
+}
@@ -0,0 +1,430 @@
+package deej
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+const (
+
+	// the mixer page's title, which is also how its window is found to keep it on top
+	miniMixerTitle = "deej mixer"
+
+	// roughly four faders' worth, the window can be resized from there
+	miniMixerWidth  = 320
+	miniMixerHeight = 360
+
+	// browsers take their time to start up, so keep looking for the window for a while
+	miniMixerPinTimeout  = 15 * time.Second
+	miniMixerPinInterval = 500 * time.Millisecond
+
+	// the page polls for events back to back, so a mixer that hasn't polled for this long has had its window closed
+	miniMixerIdleTimeout = 2 * defaultPollTimeout
+)
+
+// miniMixer is a compact window of vertical faders mirroring the board's sliders, meant to sit on a second monitor.
+// It's a small page served on loopback only and shown in a browser app window: moving a fader goes through the same
+// pipeline as moving a slider, and slider moves from anywhere (the board, the API, rules) show up on its faders
+type miniMixer struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+	events *eventLog
+
+	// set once the mixer's server is up, which happens the first time it's opened
+	lock   sync.Mutex
+	url    string
+	server *http.Server
+
+	// slider moves are only followed while a window is open, which is known from it polling for them
+	followLock   sync.Mutex
+	sliderEvents *Subscription[SliderMoveEvent]
+	lastPolled   time.Time
+}
+
+// miniMixerFader is a slider as the mixer page shows it
+type miniMixerFader struct {
+	Name    string  `json:"name"`
+	Volume  float32 `json:"volume"`
+	Virtual bool    `json:"virtual"`
+	Color   string  `json:"color,omitempty"`
+}
+
+type miniMixerMove struct {
+	Slider string  `json:"slider"`
+	Volume float32 `json:"volume"`
+}
+
+func newMiniMixer(deej *Deej, logger *zap.SugaredLogger) *miniMixer {
+	logger = logger.Named("mini_mixer")
+
+	mm := &miniMixer{
+		deej:   deej,
+		logger: logger,
+		events: newEventLog(),
+	}
+
+	logger.Debug("Created mini mixer instance")
+
+	return mm
+}
+
+// open shows the mini mixer window, starting its server first if this is the first time
+func (mm *miniMixer) open() error {
+	url, err := mm.start()
+	if err != nil {
+		return err
+	}
+
+	if err := util.OpenAppWindow(mm.logger, url, miniMixerWidth, miniMixerHeight); err != nil {
+		return fmt.Errorf("open window: %w", err)
+	}
+
+	if mm.deej.configManager.getMiniMixer().AlwaysOnTop {
+		go mm.pinOnTop()
+	}
+
+	mm.logger.Infow("Opened mini mixer", "url", url)
+
+	return nil
+}
+
+// start serves the mixer page on a random loopback port, and returns its url. the url has a random token in it,
+// since anyone on the machine can reach loopback ports
+func (mm *miniMixer) start() (string, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	if mm.server != nil {
+		return mm.url, nil
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, tokenBytes); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+
+	token := hex.EncodeToString(tokenBytes)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("listen: %w", err)
+	}
+
+	prefix := "/" + token
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/", mm.handlePage)
+	mux.HandleFunc(prefix+"/faders", mm.handleFaders)
+	mux.HandleFunc(prefix+"/events", mm.handleEvents)
+	mux.HandleFunc(prefix+"/move", mm.handleMove)
+	mux.HandleFunc(prefix+"/close", mm.handleClose)
+
+	mm.server = &http.Server{Handler: mux}
+	mm.url = fmt.Sprintf("http://%s%s/", listener.Addr().String(), prefix)
+
+	go func() {
+		if err := mm.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			mm.logger.Warnw("Mini mixer server stopped unexpectedly", "error", err)
+		}
+	}()
+
+	mm.logger.Debugw("Serving mini mixer", "address", listener.Addr().String())
+
+	return mm.url, nil
+}
+
+func (mm *miniMixer) stop() {
+	mm.unfollow()
+
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	if mm.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+
+	if err := mm.server.Shutdown(ctx); err != nil {
+		mm.logger.Warnw("Failed to shut down mini mixer server", "error", err)
+	}
+}
+
+// follow subscribes to slider moves for the page if it isn't already, and notes that it's still around
+func (mm *miniMixer) follow() {
+	mm.followLock.Lock()
+	defer mm.followLock.Unlock()
+
+	mm.lastPolled = time.Now()
+
+	if mm.sliderEvents != nil {
+		return
+	}
+
+	sliderEvents := mm.deej.serial.SubscribeToSliderMoveEvents(PriorityBackground)
+	mm.sliderEvents = sliderEvents
+
+	go func() {
+		for {
+			select {
+			case event := <-sliderEvents.C:
+				mm.events.append(event)
+			case <-sliderEvents.done:
+				return
+			}
+		}
+	}()
+
+	time.AfterFunc(miniMixerIdleTimeout, mm.checkIdle)
+
+	mm.logger.Debug("Following slider moves for mini mixer window")
+}
+
+// checkIdle lets go of slider moves once the page stops polling for them, and checks again later otherwise
+func (mm *miniMixer) checkIdle() {
+	mm.followLock.Lock()
+
+	if mm.sliderEvents == nil {
+		mm.followLock.Unlock()
+		return
+	}
+
+	if idle := time.Since(mm.lastPolled); idle < miniMixerIdleTimeout {
+		mm.followLock.Unlock()
+		time.AfterFunc(miniMixerIdleTimeout-idle, mm.checkIdle)
+		return
+	}
+
+	mm.followLock.Unlock()
+
+	mm.logger.Debug("Mini mixer window went away")
+	mm.unfollow()
+}
+
+func (mm *miniMixer) unfollow() {
+	mm.followLock.Lock()
+	defer mm.followLock.Unlock()
+
+	if mm.sliderEvents == nil {
+		return
+	}
+
+	mm.sliderEvents.Unsubscribe()
+	mm.sliderEvents = nil
+
+	mm.logger.Debug("Stopped following slider moves for mini mixer")
+}
+
+// pinOnTop waits for the mixer's window to show up, and keeps it above all others
+func (mm *miniMixer) pinOnTop() {
+	deadline := time.Now().Add(miniMixerPinTimeout)
+
+	for {
+		err := util.SetWindowAlwaysOnTop(miniMixerTitle)
+		if err == nil {
+			mm.logger.Debug("Pinned mini mixer on top")
+			return
+		}
+
+		if time.Now().After(deadline) {
+			mm.logger.Infow("Couldn't keep mini mixer on top", "error", err)
+			return
+		}
+
+		time.Sleep(miniMixerPinInterval)
+	}
+}
+
+func (mm *miniMixer) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, miniMixerPage)
+}
+
+// handleFaders returns every slider in config order, virtual ones included
+func (mm *miniMixer) handleFaders(w http.ResponseWriter, r *http.Request) {
+	mm.follow()
+
+	faders := []miniMixerFader{}
+
+	keys, _ := mm.deej.configManager.getSliderMappingKeys()
+	for _, key := range keys {
+		mapping, err := mm.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		faders = append(faders, miniMixerFader{Name: key, Volume: mapping.Volume, Virtual: mapping.Virtual, Color: mapping.Color})
+	}
+
+	mm.writeJSON(w, faders)
+}
+
+// handleEvents long-polls for slider moves, just like the API's event stream
+func (mm *miniMixer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	mm.follow()
+
+	after, _ := strconv.ParseUint(r.URL.Query().Get("after"), 10, 64)
+
+	events, next := mm.events.wait(after, defaultPollTimeout)
+
+	w.Header().Set("Cache-Control", "no-store")
+	mm.writeJSON(w, pollResponse{Events: events, Next: next})
+}
+
+func (mm *miniMixer) handleMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var move miniMixerMove
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIRequestSize)).Decode(&move); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if move.Volume < 0 || move.Volume > 1 {
+		http.Error(w, "volume must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+
+	if err := mm.deej.SetSliderValue(move.Slider, move.Volume); err != nil {
+		http.Error(w, "unknown slider", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleClose is beaconed by the page as its window closes, so slider moves aren't followed for nobody
+func (mm *miniMixer) handleClose(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mm.unfollow()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (mm *miniMixer) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		mm.logger.Warnw("Failed to write mini mixer response", "error", err)
+	}
+}
+
+// the whole mixer page. it fetches the faders, then long-polls for moves and applies them, refetching
+// the faders whenever a poll comes back empty (which also picks up config reloads). it says so when its window closes
+const miniMixerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>` + miniMixerTitle + `</title>
+<style>
+	body { margin: 0; padding: 12px; background: #1e1e1e; color: #ddd; font: 12px sans-serif; user-select: none; }
+	#faders { display: flex; gap: 12px; justify-content: center; height: calc(100vh - 24px); }
+	.fader { display: flex; flex-direction: column; align-items: center; width: 56px; }
+	.fader input { flex: 1; writing-mode: vertical-lr; direction: rtl; width: 24px; accent-color: #4fa3ff; }
+	.fader.virtual input { accent-color: #b07cff; }
+	.fader .value { margin: 4px 0; font-variant-numeric: tabular-nums; }
+	.fader .name { max-width: 56px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+</style>
+</head>
+<body>
+<div id="faders"></div>
+<script>
+	const container = document.getElementById("faders");
+	const faders = {};
+	let after = 0;
+
+	function render(list) {
+		container.innerHTML = "";
+
+		for (const name in faders) {
+			delete faders[name];
+		}
+
+		for (const fader of list) {
+			const element = document.createElement("div");
+			element.className = "fader" + (fader.virtual ? " virtual" : "");
+			element.innerHTML = '<span class="value"></span><input type="range" min="0" max="100"><span class="name"></span>';
+
+			const input = element.querySelector("input");
+			const value = element.querySelector(".value");
+			element.querySelector(".name").textContent = fader.name;
+			element.title = fader.name;
+
+			if (fader.color) {
+				input.style.accentColor = fader.color;
+			}
+
+			input.addEventListener("input", () => {
+				value.textContent = input.value + "%";
+				fetch("move", { method: "POST", body: JSON.stringify({ slider: fader.name, volume: input.value / 100 }) });
+			});
+
+			faders[fader.name] = { input, value };
+			set(fader.name, fader.volume);
+			container.appendChild(element);
+		}
+	}
+
+	function set(name, volume) {
+		const fader = faders[name];
+
+		// don't fight the user while they're dragging
+		if (!fader || document.activeElement === fader.input) {
+			return;
+		}
+
+		fader.input.value = Math.round(volume * 100);
+		fader.value.textContent = fader.input.value + "%";
+	}
+
+	async function refresh() {
+		render(await (await fetch("faders")).json());
+	}
+
+	async function poll() {
+		for (;;) {
+			try {
+				const response = await (await fetch("events?after=" + after)).json();
+				after = response.next;
+
+				if (response.events.length === 0) {
+					await refresh();
+				}
+
+				for (const event of response.events) {
+					set(event.slider, event.value);
+				}
+			} catch (e) {
+				await new Promise(resolve => setTimeout(resolve, 2000));
+			}
+		}
+	}
+
+	// the window is going away, let deej know it can stop following slider moves for it
+	window.addEventListener("pagehide", () => navigator.sendBeacon("close"));
+
+	refresh().then(poll);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,180 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// how often the OS mixer is checked for volumes changed behind deej's back
+	motorizedFadersPollInterval = time.Second
+
+	// sliders deej moved itself this recently are left out of that check, since their sessions may not have caught up yet
+	motorizedFadersSettleTime = 2 * time.Second
+
+	// how long a fader's readings are ignored while its motor moves it, unless it gets there sooner
+	motorizedFaderMoveTimeout = 1500 * time.Millisecond
+
+	// volumes this close to a slider's are left alone, so the motors don't chase rounding errors
+	motorizedFaderTolerance = 0.01
+)
+
+// motorizedFaders keeps the physical faders of boards with motors where their sliders' volumes are. Whenever
+// a volume changes anywhere but on the fader itself (the API, rules, the OS mixer, a config reload), the board
+// is told to move the fader there with a "fader:<index>:<value>" line, value being 0-1023 like the board reports it
+type motorizedFaders struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	// signaled when a board connects, since its faders may be anywhere
+	connected chan bool
+
+	// when each slider was last moved through deej, by its key
+	lastMoved map[string]time.Time
+}
+
+func newMotorizedFaders(deej *Deej, logger *zap.SugaredLogger) *motorizedFaders {
+	logger = logger.Named("motorized_faders")
+
+	mf := &motorizedFaders{
+		deej:      deej,
+		logger:    logger,
+		connected: make(chan bool, 1),
+		lastMoved: map[string]time.Time{},
+	}
+
+	logger.Debug("Created motorized faders instance")
+
+	return mf
+}
+
+// run moves faders for as long as deej runs. the config is consulted every time, so motorized faders can
+// be turned on and off without restarting. the OS mixer is only polled while they're on
+func (mf *motorizedFaders) run() {
+	sliderEvents := mf.deej.serial.SubscribeToSliderMoveEvents(PriorityFeedback)
+	configReloaded := mf.deej.configManager.SubscribeToChanges()
+
+	var ticker *time.Ticker
+	var poll <-chan time.Time
+
+	updatePolling := func() {
+		switch enabled := mf.enabled(); {
+		case enabled && ticker == nil:
+			ticker = time.NewTicker(motorizedFadersPollInterval)
+			poll = ticker.C
+		case !enabled && ticker != nil:
+			ticker.Stop()
+			ticker, poll = nil, nil
+		}
+	}
+
+	updatePolling()
+
+	for {
+		select {
+		case event := <-sliderEvents.C:
+			mf.lastMoved[event.SliderID] = time.Now()
+
+			// the fader is where the event says already, if that's where it came from
+			if !event.analog && mf.enabled() {
+				mf.move(event.SliderID, event.PercentValue)
+			}
+
+		case <-configReloaded:
+			updatePolling()
+
+			if mf.enabled() {
+				for _, key := range mf.deej.configManager.getMovedSliderKeys() {
+					mf.moveToStoredVolume(key)
+				}
+			}
+
+		case <-mf.connected:
+			if mf.enabled() {
+				mf.syncAll()
+			}
+
+		case <-poll:
+			mf.deej.wakeups.record("motorized_faders")
+			mf.followSessions()
+		}
+	}
+}
+
+// boardConnected moves a freshly connected board's faders to their sliders' volumes
+func (mf *motorizedFaders) boardConnected() {
+	select {
+	case mf.connected <- true:
+	default:
+	}
+}
+
+func (mf *motorizedFaders) enabled() bool {
+	return mf.deej.configManager.getMotorizedFaders()
+}
+
+func (mf *motorizedFaders) syncAll() {
+	for idx := 0; idx < mf.deej.configManager.getSliderMappingCount(); idx++ {
+		if key, err := mf.deej.configManager.getSliderMappingKeyByIndex(idx); err == nil {
+			mf.moveToStoredVolume(key)
+		}
+	}
+}
+
+func (mf *motorizedFaders) moveToStoredVolume(sliderID string) {
+	if mapping, err := mf.deej.configManager.getSliderMappingByKey(sliderID); err == nil {
+		mf.move(sliderID, mapping.Volume)
+	}
+}
+
+// followSessions catches volumes changed outside of deej (i.e. in the OS mixer), takes them as the slider's
+// volume and moves the fader there
+func (mf *motorizedFaders) followSessions() {
+	for idx := 0; idx < mf.deej.configManager.getSliderMappingCount(); idx++ {
+		key, err := mf.deej.configManager.getSliderMappingKeyByIndex(idx)
+		if err != nil || time.Since(mf.lastMoved[key]) < motorizedFadersSettleTime {
+			continue
+		}
+
+		mapping, err := mf.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		volume, ok := mf.deej.sessions.sliderSessionVolume(key)
+		if !ok || math.Abs(float64(volume-mapping.Volume)) <= motorizedFaderTolerance {
+			continue
+		}
+
+		mf.logger.Debugw("Volume changed outside of deej, moving fader", "slider", key, "from", mapping.Volume, "to", volume)
+
+		mapping.Volume = volume
+		mf.deej.configManager.UpdateSliderMappingByKey(key, mapping)
+
+		mf.move(key, volume)
+	}
+}
+
+// move tells the board to move a slider's fader, and ignores the fader until it gets there
+func (mf *motorizedFaders) move(sliderID string, volume float32) {
+	idx, ok := mf.deej.configManager.getHardwareSliderIndex(sliderID)
+	if !ok {
+		return
+	}
+
+	position := volume
+	if mf.deej.serial.invertSlider(sliderID) {
+		position = 1 - position
+	}
+
+	mf.deej.serial.holdUntilReached(sliderID, volume, motorizedFaderMoveTimeout)
+
+	line := fmt.Sprintf("fader:%d:%d\n", idx, int(math.Round(float64(position)*analogMaxValue)))
+	if err := mf.deej.serial.Write([]byte(line)); err != nil {
+		mf.logger.Debugw("Failed to move fader", "slider", sliderID, "error", err)
+	}
+}
@@ -0,0 +1,593 @@
+package deej
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultMQTTStatePrefix     = "deej"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+
+	// a broker that went away is tried again this often
+	mqttReconnectInterval = 10 * time.Second
+
+	mqttPayloadOn      = "ON"
+	mqttPayloadOff     = "OFF"
+	mqttPayloadOnline  = "online"
+	mqttPayloadOffline = "offline"
+)
+
+// what MQTT topics (and Home Assistant object IDs) may safely contain, anything else in a slider's key is replaced
+var mqttUnsafeCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// mqttBridge connects deej to an MQTT broker. It publishes every slider's volume and mute state as retained
+// topics under the state prefix, and takes commands to change them. If the config names an input topic,
+// messages on it are handled exactly like lines from a board, so boards can talk to deej over MQTT too.
+// With discovery enabled, Home Assistant picks up every slider as a number (its volume) and a switch (its mute)
+type mqttBridge struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	settings    MQTT
+	stopChannel chan bool
+
+	// the current connection, nil while there isn't one
+	lock   sync.Mutex
+	client *mqttClient
+
+	// object IDs that have discovery configs out there, so removed sliders can be taken out of Home Assistant.
+	// the lock also keeps connecting and reloading the config from publishing everything at the same time
+	discoveredLock sync.Mutex
+	discovered     map[string]bool
+}
+
+// mqttDiscoveryDevice groups all of deej's entities under one device in Home Assistant
+type mqttDiscoveryDevice struct {
+	Identifiers []string `json:"identifiers"`
+	Name        string   `json:"name"`
+	Model       string   `json:"model"`
+	SWVersion   string   `json:"sw_version,omitempty"`
+}
+
+type mqttDiscoveryConfig struct {
+	Name              string              `json:"name"`
+	UniqueID          string              `json:"unique_id"`
+	StateTopic        string              `json:"state_topic"`
+	CommandTopic      string              `json:"command_topic"`
+	AvailabilityTopic string              `json:"availability_topic"`
+	Icon              string              `json:"icon,omitempty"`
+	Min               *int                `json:"min,omitempty"`
+	Max               *int                `json:"max,omitempty"`
+	Unit              string              `json:"unit_of_measurement,omitempty"`
+	Device            mqttDiscoveryDevice `json:"device"`
+}
+
+func newMQTTBridge(deej *Deej, logger *zap.SugaredLogger) *mqttBridge {
+	logger = logger.Named("mqtt")
+
+	mb := &mqttBridge{
+		deej:        deej,
+		logger:      logger,
+		stopChannel: make(chan bool),
+		discovered:  map[string]bool{},
+	}
+
+	logger.Debug("Created mqtt bridge instance")
+
+	return mb
+}
+
+// start connects to the configured broker in the background, and keeps reconnecting until stop is called.
+// it does nothing without a broker
+func (mb *mqttBridge) start(settings MQTT) error {
+	if settings.Broker == "" {
+		return nil
+	}
+
+	if _, _, err := parseMQTTBroker(settings.Broker); err != nil {
+		return fmt.Errorf("parse broker: %w", err)
+	}
+
+	mb.settings = settings
+
+	sliderEvents := mb.deej.serial.SubscribeToSliderMoveEvents(PriorityBackground)
+	muteEvents := mb.deej.serial.SubscribeToMuteToggleEvents(PriorityBackground)
+	configReloadedChannel := mb.deej.configManager.SubscribeToChanges()
+
+	go func() {
+		defer sliderEvents.Unsubscribe()
+		defer muteEvents.Unsubscribe()
+
+		for {
+			select {
+			case event := <-sliderEvents.C:
+				mb.publishVolume(event.SliderID, event.PercentValue)
+			case event := <-muteEvents.C:
+				mb.publishMute(event.SliderID, event.Muted)
+			case <-configReloadedChannel:
+				mb.publishAll()
+			case <-mb.stopChannel:
+				return
+			}
+		}
+	}()
+
+	go mb.run()
+
+	return nil
+}
+
+// stop marks deej as offline and disconnects from the broker
+func (mb *mqttBridge) stop() {
+	if mb.settings.Broker == "" {
+		return
+	}
+
+	close(mb.stopChannel)
+
+	mb.lock.Lock()
+	defer mb.lock.Unlock()
+
+	if mb.client == nil {
+		return
+	}
+
+	// disconnecting politely means the broker won't publish the last will, so say it ourselves
+	mb.client.publish(mb.availabilityTopic(), []byte(mqttPayloadOffline), true)
+
+	if err := mb.client.close(); err != nil {
+		mb.logger.Debugw("Failed to close broker connection", "error", err)
+	}
+}
+
+func (mb *mqttBridge) run() {
+	for {
+		err := mb.session()
+
+		select {
+		case <-mb.stopChannel:
+			return
+		default:
+		}
+
+		mb.logger.Warnw("No connection to MQTT broker, trying again soon",
+			"broker", mb.settings.Broker,
+			"error", err,
+			"retryIn", mqttReconnectInterval)
+
+		select {
+		case <-mb.stopChannel:
+			return
+		case <-time.After(mqttReconnectInterval):
+		}
+	}
+}
+
+// session connects to the broker and handles its messages until the connection goes away
+func (mb *mqttBridge) session() error {
+	password, err := resolveSecret(mb.settings.Password)
+	if err != nil {
+		return fmt.Errorf("resolve password: %w", err)
+	}
+
+	client, err := dialMQTT(mb.settings.Broker, mqttConnectOptions{
+		clientID:    mb.clientID(),
+		username:    mb.settings.Username,
+		password:    password,
+		willTopic:   mb.availabilityTopic(),
+		willPayload: mqttPayloadOffline,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	mb.lock.Lock()
+	select {
+	case <-mb.stopChannel:
+		mb.lock.Unlock()
+		client.close()
+		return errors.New("stopped while connecting")
+	default:
+		mb.client = client
+	}
+	mb.lock.Unlock()
+
+	defer func() {
+		mb.lock.Lock()
+		mb.client = nil
+		mb.lock.Unlock()
+
+		client.close()
+	}()
+
+	mb.logger.Infow("Connected to MQTT broker", "broker", mb.settings.Broker, "statePrefix", mb.statePrefix())
+
+	for idx, filter := range []string{mb.commandTopic("+", "volume"), mb.commandTopic("+", "mute")} {
+		if err := client.subscribe(uint16(idx+1), filter); err != nil {
+			return fmt.Errorf("subscribe to %s: %w", filter, err)
+		}
+	}
+
+	// boards on the input topic are handled just like any other board, through the main SerialIO's consumers
+	var transport *mqttTransport
+
+	if mb.settings.Topic != "" {
+		if err := client.subscribe(3, mb.settings.Topic); err != nil {
+			return fmt.Errorf("subscribe to %s: %w", mb.settings.Topic, err)
+		}
+
+		transport = newMQTTTransport(client, mb.settings.Topic, mb.topic("board"))
+		board := newBoardIO(mb.deej, mb.logger, mb.deej.serial)
+
+		if err := board.StartTransport(transport); err != nil {
+			return fmt.Errorf("start board: %w", err)
+		}
+
+		defer board.Stop()
+	}
+
+	client.publish(mb.availabilityTopic(), []byte(mqttPayloadOnline), true)
+	mb.publishAll()
+
+	// the broker drops clients it doesn't hear from, so keep pinging while nothing else is going on
+	pingerDone := make(chan bool)
+	defer close(pingerDone)
+
+	go func() {
+		ticker := time.NewTicker(mqttKeepAlive / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-pingerDone:
+				return
+			case <-ticker.C:
+				mb.deej.wakeups.record("mqtt_ping")
+
+				if err := client.ping(); err != nil {
+					mb.logger.Debugw("Failed to ping broker", "error", err)
+				}
+			}
+		}
+	}()
+
+	return client.readMessages(func(topic string, payload []byte) {
+		if mb.handleCommand(topic, payload) {
+			return
+		}
+
+		if transport != nil {
+			transport.deliver(payload)
+		}
+	})
+}
+
+// handleCommand carries out a volume or mute command, and tells whether the topic was a command topic at all
+func (mb *mqttBridge) handleCommand(topic string, payload []byte) bool {
+	prefix := mb.statePrefix() + "/"
+	if !strings.HasPrefix(topic, prefix) || !strings.HasSuffix(topic, "/set") {
+		return false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(topic, prefix), "/set"), "/")
+	if len(parts) != 2 {
+		return false
+	}
+
+	sliderID, ok := mb.sliderByObjectID(parts[0])
+	if !ok {
+		mb.logger.Debugw("Got command for unknown slider", "topic", topic)
+		return true
+	}
+
+	value := strings.TrimSpace(string(payload))
+
+	switch parts[1] {
+	case "volume":
+		percent, err := strconv.ParseFloat(value, 32)
+		if err != nil || percent < 0 || percent > 100 {
+			mb.logger.Warnw("Got invalid volume command", "slider", sliderID, "payload", value)
+			return true
+		}
+
+		if err := mb.deej.SetSliderValue(sliderID, float32(percent/100)); err != nil {
+			mb.logger.Warnw("Failed to set slider volume", "slider", sliderID, "error", err)
+		}
+
+	case "mute":
+		muted := strings.EqualFold(value, mqttPayloadOn)
+		if !muted && !strings.EqualFold(value, mqttPayloadOff) {
+			mb.logger.Warnw("Got invalid mute command", "slider", sliderID, "payload", value)
+			return true
+		}
+
+		mapping, err := mb.deej.configManager.getSliderMappingByKey(sliderID)
+		if err != nil {
+			return true
+		}
+
+		if mapping.Muted != muted {
+			mb.deej.serial.toggleMute(mb.logger, sliderID)
+		}
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// publishAll publishes every slider's state (and, if enabled, its discovery configs) from scratch.
+// this happens on every connection and config reload, since sliders may have come or gone
+func (mb *mqttBridge) publishAll() {
+	mb.discoveredLock.Lock()
+	defer mb.discoveredLock.Unlock()
+
+	keys, err := mb.deej.configManager.getSliderMappingKeys()
+	if err != nil {
+		return
+	}
+
+	current := map[string]bool{}
+
+	for _, key := range keys {
+		mapping, err := mb.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		if mb.settings.Discovery {
+			mb.publishDiscovery(key)
+		}
+
+		mb.publishVolume(key, mapping.Volume)
+		mb.publishMute(key, mapping.Muted)
+
+		current[mqttObjectID(key)] = true
+	}
+
+	if !mb.settings.Discovery {
+		return
+	}
+
+	// an empty retained config removes the entity from home assistant
+	for objectID := range mb.discovered {
+		if !current[objectID] {
+			mb.publish(mb.discoveryTopic("number", objectID), nil)
+			mb.publish(mb.discoveryTopic("switch", objectID), nil)
+			delete(mb.discovered, objectID)
+		}
+	}
+}
+
+func (mb *mqttBridge) publishVolume(sliderID string, volume float32) {
+	percent := strconv.Itoa(int(volume*100 + 0.5))
+	mb.publish(mb.stateTopic(mqttObjectID(sliderID), "volume"), []byte(percent))
+}
+
+func (mb *mqttBridge) publishMute(sliderID string, muted bool) {
+	payload := mqttPayloadOff
+	if muted {
+		payload = mqttPayloadOn
+	}
+
+	mb.publish(mb.stateTopic(mqttObjectID(sliderID), "mute"), []byte(payload))
+}
+
+func (mb *mqttBridge) publishDiscovery(sliderID string) {
+	objectID := mqttObjectID(sliderID)
+	nodeID := mqttObjectID(mb.statePrefix())
+	minVolume, maxVolume := 0, 100
+
+	device := mqttDiscoveryDevice{
+		Identifiers: []string{nodeID},
+		Name:        "deej",
+		Model:       "deej",
+		SWVersion:   mb.deej.buildInfo.Version(),
+	}
+
+	configs := map[string]mqttDiscoveryConfig{
+		"number": {
+			Name:         sliderID + " volume",
+			UniqueID:     nodeID + "_" + objectID + "_volume",
+			StateTopic:   mb.stateTopic(objectID, "volume"),
+			CommandTopic: mb.commandTopic(objectID, "volume"),
+			Icon:         "mdi:volume-high",
+			Min:          &minVolume,
+			Max:          &maxVolume,
+			Unit:         "%",
+		},
+		"switch": {
+			Name:         sliderID + " mute",
+			UniqueID:     nodeID + "_" + objectID + "_mute",
+			StateTopic:   mb.stateTopic(objectID, "mute"),
+			CommandTopic: mb.commandTopic(objectID, "mute"),
+			Icon:         "mdi:volume-off",
+		},
+	}
+
+	for component, config := range configs {
+		config.AvailabilityTopic = mb.availabilityTopic()
+		config.Device = device
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			mb.logger.Warnw("Failed to marshal discovery config", "slider", sliderID, "error", err)
+			continue
+		}
+
+		mb.publish(mb.discoveryTopic(component, objectID), payload)
+	}
+
+	mb.discovered[objectID] = true
+}
+
+// publish sends a retained message, if there's a connection to send it over. everything deej publishes
+// is state, so anything missed while disconnected is caught up on by publishAll after reconnecting
+func (mb *mqttBridge) publish(topic string, payload []byte) {
+	mb.lock.Lock()
+	client := mb.client
+	mb.lock.Unlock()
+
+	if client == nil {
+		return
+	}
+
+	if err := client.publish(topic, payload, true); err != nil {
+		mb.logger.Debugw("Failed to publish", "topic", topic, "error", err)
+	}
+}
+
+// sliderByObjectID finds the slider whose key makes the given topic-safe ID
+func (mb *mqttBridge) sliderByObjectID(objectID string) (string, bool) {
+	keys, err := mb.deej.configManager.getSliderMappingKeys()
+	if err != nil {
+		return "", false
+	}
+
+	for _, key := range keys {
+		if mqttObjectID(key) == objectID {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+func (mb *mqttBridge) statePrefix() string {
+	return strings.TrimSuffix(mb.settings.StatePrefix, "/")
+}
+
+// i.e. deej/master/volume
+func (mb *mqttBridge) stateTopic(objectID string, kind string) string {
+	return mb.topic(objectID, kind)
+}
+
+// i.e. deej/master/volume/set
+func (mb *mqttBridge) commandTopic(objectID string, kind string) string {
+	return mb.topic(objectID, kind, "set")
+}
+
+func (mb *mqttBridge) availabilityTopic() string {
+	return mb.topic("status")
+}
+
+func (mb *mqttBridge) topic(levels ...string) string {
+	return strings.Join(append([]string{mb.statePrefix()}, levels...), "/")
+}
+
+// i.e. homeassistant/number/deej/master/config
+func (mb *mqttBridge) discoveryTopic(component string, objectID string) string {
+	return strings.Join([]string{mb.settings.DiscoveryPrefix, component, mqttObjectID(mb.statePrefix()), objectID, "config"}, "/")
+}
+
+// clientID tells instances apart on a shared broker, which disconnects the older of two clients with the same ID
+func (mb *mqttBridge) clientID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return "deej-" + mqttObjectID(hostname)
+}
+
+func mqttObjectID(key string) string {
+	return mqttUnsafeCharacters.ReplaceAllString(key, "_")
+}
+
+// mqttTransport hands messages on the input topic to a SerialIO as board lines, and publishes
+// whatever deej sends the board (its hello, feedback) to the board topic
+type mqttTransport struct {
+	client      *mqttClient
+	inputTopic  string
+	outputTopic string
+
+	messages chan []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newMQTTTransport(client *mqttClient, inputTopic string, outputTopic string) *mqttTransport {
+	return &mqttTransport{
+		client:      client,
+		inputTopic:  inputTopic,
+		outputTopic: outputTopic,
+		messages:    make(chan []byte),
+		closed:      make(chan struct{}),
+	}
+}
+
+// deliver passes a message on to whoever's reading lines, unless the transport is closed
+func (mt *mqttTransport) deliver(message []byte) {
+	select {
+	case mt.messages <- message:
+	case <-mt.closed:
+	}
+}
+
+// Connect does nothing, the broker is connected by the time there's a transport for it
+func (mt *mqttTransport) Connect() error {
+	return nil
+}
+
+func (mt *mqttTransport) ReadLines(lines chan<- TransportLine) error {
+	for {
+		select {
+		case <-mt.closed:
+			return errors.New("transport closed")
+		case message := <-mt.messages:
+			readAt := time.Now()
+
+			// every line is handed over with its LF, like a serial board would send it
+			for _, line := range strings.SplitAfter(string(message), "\n") {
+				if line == "" {
+					continue
+				}
+
+				if !strings.HasSuffix(line, "\n") {
+					line += "\n"
+				}
+
+				lines <- TransportLine{Text: line, ReadAt: readAt}
+			}
+		}
+	}
+}
+
+// Write publishes data for the board, not retained since it's only meaningful to a board that's listening right now
+func (mt *mqttTransport) Write(data []byte) (int, error) {
+	if err := mt.client.publish(mt.outputTopic, data, false); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Close stops delivering lines. the broker connection itself belongs to the bridge
+func (mt *mqttTransport) Close() error {
+	mt.closeOnce.Do(func() {
+		close(mt.closed)
+	})
+
+	return nil
+}
+
+func (mt *mqttTransport) Name() string {
+	return "mqtt:" + mt.inputTopic
+}
+
+func (mt *mqttTransport) DeviceID() (string, error) {
+	return "", errors.New("mqtt boards introduce themselves, if at all")
+}
@@ -0,0 +1,311 @@
+package deej
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// just enough of MQTT 3.1.1 for deej: QoS 0 publishing (retained or not), subscribing, and a last will.
+// deej never needs delivery guarantees - every state it publishes is superseded by the next one anyway
+
+const (
+	mqttPacketConnect     = 1
+	mqttPacketConnack     = 2
+	mqttPacketPublish     = 3
+	mqttPacketSubscribe   = 8
+	mqttPacketSuback      = 9
+	mqttPacketPingreq     = 12
+	mqttPacketPingresp    = 13
+	mqttPacketDisconnect  = 14
+	mqttProtocolLevel     = 4
+	mqttSubscribeFailure  = 0x80
+	mqttMaxRemainingBytes = 4
+
+	mqttDefaultPort    = "1883"
+	mqttDefaultTLSPort = "8883"
+
+	mqttDialTimeout  = 10 * time.Second
+	mqttWriteTimeout = 5 * time.Second
+	mqttKeepAlive    = 30 * time.Second
+)
+
+// mqttConnectOptions describes who deej is to the broker, and what the broker should publish if deej vanishes
+type mqttConnectOptions struct {
+	clientID string
+	username string
+	password string
+
+	willTopic   string
+	willPayload string
+}
+
+// mqttClient is a single connection to an MQTT broker. Publishing is safe from any goroutine,
+// while incoming messages are read by whoever calls readMessages
+type mqttClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeLock sync.Mutex
+}
+
+// dialMQTT connects to a broker, given as "host[:port]" or a URL with a tcp://, mqtt://, ssl://, tls:// or mqtts:// scheme
+func dialMQTT(broker string, options mqttConnectOptions) (*mqttClient, error) {
+	address, useTLS, err := parseMQTTBroker(broker)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: mqttDialTimeout}
+
+	var conn net.Conn
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", address, err)
+	}
+
+	client := &mqttClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	if err := client.connect(options); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func parseMQTTBroker(broker string) (string, bool, error) {
+	useTLS := false
+
+	if idx := strings.Index(broker, "://"); idx != -1 {
+		switch scheme := broker[:idx]; scheme {
+		case "tcp", "mqtt":
+		case "ssl", "tls", "mqtts":
+			useTLS = true
+		default:
+			return "", false, fmt.Errorf("unsupported broker scheme %q", scheme)
+		}
+
+		broker = strings.TrimSuffix(broker[idx+3:], "/")
+	}
+
+	if broker == "" {
+		return "", false, errors.New("no broker address")
+	}
+
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		port := mqttDefaultPort
+		if useTLS {
+			port = mqttDefaultTLSPort
+		}
+
+		broker = net.JoinHostPort(broker, port)
+	}
+
+	return broker, useTLS, nil
+}
+
+func (c *mqttClient) connect(options mqttConnectOptions) error {
+
+	// always a clean session, deej subscribes again on every connection anyway
+	flags := byte(0x02)
+
+	payload := mqttString(options.clientID)
+
+	if options.willTopic != "" {
+		flags |= 0x04 | 0x20
+		payload = append(payload, mqttString(options.willTopic)...)
+		payload = append(payload, mqttString(options.willPayload)...)
+	}
+
+	if options.username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(options.username)...)
+
+		if options.password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(options.password)...)
+		}
+	}
+
+	body := mqttString("MQTT")
+	body = append(body, mqttProtocolLevel, flags)
+	body = append(body, byte(mqttKeepAlive/time.Second>>8), byte(mqttKeepAlive/time.Second))
+	body = append(body, payload...)
+
+	if err := c.writePacket(mqttPacketConnect<<4, body); err != nil {
+		return fmt.Errorf("send connect: %w", err)
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(mqttDialTimeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	packetType, body, err := c.readPacket()
+	if err != nil {
+		return fmt.Errorf("read connack: %w", err)
+	}
+
+	if packetType != mqttPacketConnack || len(body) != 2 {
+		return fmt.Errorf("expected connack, got packet type %d", packetType)
+	}
+
+	switch body[1] {
+	case 0:
+		return nil
+	case 4, 5:
+		return errors.New("broker refused credentials")
+	default:
+		return fmt.Errorf("broker refused connection (code %d)", body[1])
+	}
+}
+
+// subscribe asks for messages on the given topic filter, at QoS 0. the broker's answer arrives through readMessages
+func (c *mqttClient) subscribe(packetID uint16, filter string) error {
+	body := []byte{byte(packetID >> 8), byte(packetID)}
+	body = append(body, mqttString(filter)...)
+	body = append(body, 0)
+
+	return c.writePacket(mqttPacketSubscribe<<4|0x02, body)
+}
+
+func (c *mqttClient) publish(topic string, payload []byte, retain bool) error {
+	header := byte(mqttPacketPublish << 4)
+	if retain {
+		header |= 0x01
+	}
+
+	return c.writePacket(header, append(mqttString(topic), payload...))
+}
+
+func (c *mqttClient) ping() error {
+	return c.writePacket(mqttPacketPingreq<<4, nil)
+}
+
+// readMessages hands every message the broker publishes to deej to the handler, until the connection fails or is closed.
+// the broker must be pinged regularly for this not to time out
+func (c *mqttClient) readMessages(handler func(topic string, payload []byte)) error {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+
+		packetType, body, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+
+		switch packetType {
+		case mqttPacketPublish:
+			if len(body) < 2 {
+				return errors.New("malformed publish packet")
+			}
+
+			topicLength := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+topicLength {
+				return errors.New("malformed publish packet")
+			}
+
+			handler(string(body[2:2+topicLength]), body[2+topicLength:])
+
+		case mqttPacketSuback:
+			if len(body) < 3 {
+				return errors.New("malformed suback packet")
+			}
+
+			for _, code := range body[2:] {
+				if code == mqttSubscribeFailure {
+					return errors.New("broker refused subscription")
+				}
+			}
+
+		case mqttPacketPingresp:
+		default:
+			return fmt.Errorf("unexpected packet type %d", packetType)
+		}
+	}
+}
+
+// close disconnects politely, so the broker doesn't publish the last will
+func (c *mqttClient) close() error {
+	c.writePacket(mqttPacketDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+func (c *mqttClient) writePacket(header byte, body []byte) error {
+	packet := []byte{header}
+
+	// the remaining length is encoded 7 bits at a time, least significant first
+	length := len(body)
+	for {
+		encoded := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			encoded |= 0x80
+		}
+
+		packet = append(packet, encoded)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	packet = append(packet, body...)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout))
+	_, err := c.conn.Write(packet)
+
+	return err
+}
+
+func (c *mqttClient) readPacket() (byte, []byte, error) {
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length, multiplier := 0, 1
+	for idx := 0; ; idx++ {
+		if idx == mqttMaxRemainingBytes {
+			return 0, nil, errors.New("malformed packet length")
+		}
+
+		encoded, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(encoded&0x7f) * multiplier
+		multiplier *= 128
+
+		if encoded&0x80 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header >> 4, body, nil
+}
+
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s))}, s...)
+}
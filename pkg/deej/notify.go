@@ -1,8 +1,12 @@
 package deej
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/gen2brain/beeep"
 	"go.uber.org/zap"
@@ -14,25 +18,94 @@ import (
 // Notifier provides generic notification sending
 type Notifier interface {
 	Notify(title string, message string)
+
+	// Flush waits (up to the given timeout) for notifications that were queued but not sent yet,
+	// i.e. before deej exits
+	Flush(timeout time.Duration)
 }
 
+const (
+
+	// notifications waiting to be sent. past this many, new ones are dropped rather than wait
+	notificationQueueSize = 16
+
+	// how long a notification backend (the portal, or the OS's own) gets to send one before it's given up on
+	notificationBackendTimeout = 5 * time.Second
+
+	// how long deej waits for its last notifications on its way out, i.e. about a crash
+	notificationFlushTimeout = 3 * time.Second
+)
+
+// a backend that takes too long may still get its notification out eventually, unlike one that failed
+var errNotificationTimeout = errors.New("timed out")
+
 // ToastNotifier provides toast notifications for Windows
 type ToastNotifier struct {
 	logger *zap.SugaredLogger
+
+	// Notify only queues notifications, they're sent one at a time from here. this way a slow (or hung)
+	// notification backend never holds up the caller, i.e. a config reload or the serial loop
+	queue   chan notification
+	pending sync.WaitGroup
+}
+
+type notification struct {
+	title   string
+	message string
 }
 
 // NewToastNotifier creates a new ToastNotifier
 func NewToastNotifier(logger *zap.SugaredLogger) (*ToastNotifier, error) {
 	logger = logger.Named("notifier")
-	tn := &ToastNotifier{logger: logger}
+	tn := &ToastNotifier{
+		logger: logger,
+		queue:  make(chan notification, notificationQueueSize),
+	}
+
+	go tn.run()
 
 	logger.Debug("Created toast notifier instance")
 
 	return tn, nil
 }
 
-// Notify sends a toast notification (or falls back to other types of notification for older Windows versions)
+// Notify queues a toast notification, and returns right away
 func (tn *ToastNotifier) Notify(title string, message string) {
+	tn.pending.Add(1)
+
+	select {
+	case tn.queue <- notification{title: title, message: message}:
+	default:
+		tn.pending.Done()
+		tn.logger.Warnw("Too many notifications waiting to be sent, dropping this one", "title", title, "message", message)
+	}
+}
+
+func (tn *ToastNotifier) run() {
+	for n := range tn.queue {
+		tn.send(n.title, n.message)
+		tn.pending.Done()
+	}
+}
+
+// Flush waits for queued notifications to be sent, or for the timeout
+func (tn *ToastNotifier) Flush(timeout time.Duration) {
+	flushed := make(chan bool)
+
+	go func() {
+		tn.pending.Wait()
+		close(flushed)
+	}()
+
+	select {
+	case <-flushed:
+	case <-time.After(timeout):
+		tn.logger.Warnw("Gave up waiting for notifications to be sent", "timeout", timeout)
+	}
+}
+
+// send sends a toast notification (or falls back to other types of notification for older Windows versions)
+func (tn *ToastNotifier) send(title string, message string) {
 
 	// we need to unpack deej.ico somewhere to remain portable. we already have it as bytes so it should be fine
 	appIconPath := filepath.Join(os.TempDir(), "deej.ico")
@@ -56,8 +129,46 @@ func (tn *ToastNotifier) Notify(title string, message string) {
 
 	tn.logger.Infow("Sending toast notification", "title", title, "message", message)
 
+	// flatpak only lets us talk to the notification portal
+	if util.Sandbox() == util.SandboxFlatpak {
+		err := tn.withTimeout("portal", func() error {
+			return util.SendPortalNotification(title, message)
+		})
+
+		if err == nil {
+			return
+		}
+
+		// falling back now could show the same notification twice
+		if errors.Is(err, errNotificationTimeout) {
+			tn.logger.Warnw("Portal notification is taking too long, not falling back", "error", err)
+			return
+		}
+
+		tn.logger.Warnw("Failed to send portal notification, falling back", "error", err)
+	}
+
 	// send the actual notification
-	if err := beeep.Notify(title, message, appIconPath); err != nil {
+	if err := tn.withTimeout("toast", func() error {
+		return beeep.Notify(title, message, appIconPath)
+	}); err != nil {
 		tn.logger.Errorw("Failed to send toast notification", "error", err)
 	}
 }
+
+// withTimeout sends a notification through one backend, giving up on it after a while. a backend that hangs
+// for good is left behind, so the notifications after it still get their chance
+func (tn *ToastNotifier) withTimeout(backend string, send func() error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- send()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(notificationBackendTimeout):
+		return fmt.Errorf("%s notification after %s: %w", backend, notificationBackendTimeout, errNotificationTimeout)
+	}
+}
@@ -0,0 +1,91 @@
+package deej
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultDigestThreshold     = 2
+	defaultDigestWindowSeconds = 120
+)
+
+// notificationDigest collapses bursts of similar notifications (i.e. a flaky cable's reconnect storm) into one.
+// The first few within the window go out as usual, the rest are held back and summarized once the window ends
+type notificationDigest struct {
+	notifier Notifier
+
+	// read on every notification, so config changes apply right away
+	settings func() NotificationDigest
+
+	// builds the digest notification from how many notifications the burst had, and how long it lasted
+	summarize func(count int, duration time.Duration) (string, string)
+
+	lock       sync.Mutex
+	recent     []time.Time
+	pending    bool
+	burstStart time.Time
+	burstCount int
+}
+
+func newNotificationDigest(
+	notifier Notifier,
+	settings func() NotificationDigest,
+	summarize func(count int, duration time.Duration) (string, string),
+) *notificationDigest {
+	return &notificationDigest{
+		notifier:  notifier,
+		settings:  settings,
+		summarize: summarize,
+	}
+}
+
+func (nd *notificationDigest) notify(title string, message string) {
+	settings := nd.settings()
+	window := time.Duration(settings.WindowSeconds) * time.Second
+	now := time.Now()
+
+	nd.lock.Lock()
+
+	// forget whatever fell out of the window
+	firstKept := 0
+	for firstKept < len(nd.recent) && nd.recent[firstKept].Before(now.Add(-window)) {
+		firstKept++
+	}
+
+	nd.recent = append(nd.recent[firstKept:], now)
+
+	// still a trickle, not a burst
+	if len(nd.recent) <= settings.Threshold {
+		nd.lock.Unlock()
+		nd.notifier.Notify(title, message)
+
+		return
+	}
+
+	// a burst is starting - count everything in the window towards it, and summarize it when the window ends
+	if !nd.pending {
+		nd.pending = true
+		nd.burstStart = nd.recent[0]
+		nd.burstCount = len(nd.recent) - 1
+
+		time.AfterFunc(window, nd.flush)
+	}
+
+	nd.burstCount++
+	nd.lock.Unlock()
+}
+
+func (nd *notificationDigest) flush() {
+	nd.lock.Lock()
+
+	count := nd.burstCount
+	duration := time.Since(nd.burstStart)
+
+	nd.pending = false
+	nd.burstCount = 0
+
+	nd.lock.Unlock()
+
+	nd.notifier.Notify(nd.summarize(count, duration))
+}
@@ -61,6 +61,8 @@ func (d *Deej) recoverFromPanic() {
 	d.notifier.Notify("Unexpected crash occurred...",
 		fmt.Sprintf("More details in %s", crashlogPath))
 
+	d.notifier.Flush(notificationFlushTimeout)
+
 	// bye :(
 	d.signalStop()
 	d.logger.Errorw("Quitting", "exitCode", 1)
@@ -0,0 +1,255 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+
+	// bumped whenever a profile's contents change in a way older versions of deej can't make sense of
+	profileFormatVersion = 1
+
+	defaultProfileFilename = "deej-profile.yaml"
+
+	// the config as it was before the last import, in case the profile wasn't what the user hoped for
+	profileBackupSuffix = ".bak"
+)
+
+// Profile is a self-contained, shareable deej setup: everything that describes how a board behaves
+// (mappings, rules, labels, feedback), and nothing that's specific to the machine it was exported on
+// (ports, addresses, certificates, credentials)
+type Profile struct {
+	FormatVersion int    `yaml:"deej_profile"`
+	Description   string `yaml:"description,omitempty"`
+	ExportedBy    string `yaml:"exported_by,omitempty"`
+
+	SliderMappings      map[string]SliderMapping  `yaml:"slider_mappings"`
+	InvertSliders       bool                      `yaml:"invert_sliders"`
+	NoiseReductionLevel string                    `yaml:"noise_reduction_level,omitempty"`
+	QuantizationStep    float32                   `yaml:"quantization_step,omitempty"`
+	Protocol            string                    `yaml:"protocol,omitempty"`
+	Rules               []Rule                    `yaml:"rules,omitempty"`
+	BoardFeedback       BoardFeedback             `yaml:"board_feedback,omitempty"`
+	Devices             map[string]DeviceSettings `yaml:"devices,omitempty"`
+}
+
+// ExportProfile writes the profile-worthy parts of the config file to a profile file, and returns its path
+func ExportProfile(configPath string, outputPath string, buildInfo BuildInfo) (string, error) {
+	if outputPath == "" {
+		outputPath = defaultProfileFilename
+	}
+
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", fmt.Errorf("read config: %w", err)
+	}
+
+	config := newDefaultConfig()
+	if err := yaml.Unmarshal(raw, config); err != nil {
+		return "", fmt.Errorf("decode config: %w", err)
+	}
+
+	// profiles don't carry the config's variables, so targets go out as they're used on this machine
+	resolveSliderVariables(zap.NewNop().Sugar(), config)
+
+	profile := Profile{
+		FormatVersion:       profileFormatVersion,
+		ExportedBy:          buildInfo.Version(),
+		SliderMappings:      config.SliderMappings,
+		InvertSliders:       config.InvertSliders,
+		NoiseReductionLevel: config.NoiseReductionLevel,
+		QuantizationStep:    config.QuantizationStep,
+		Protocol:            config.Protocol,
+		Rules:               config.Rules,
+		BoardFeedback:       config.BoardFeedback,
+		Devices:             config.Devices,
+	}
+
+	if err := profile.validate(); err != nil {
+		return "", fmt.Errorf("config doesn't make a valid profile: %w", err)
+	}
+
+	contents, err := yaml.Marshal(&profile)
+	if err != nil {
+		return "", fmt.Errorf("marshal profile: %w", err)
+	}
+
+	if err := ioutil.WriteFile(outputPath, contents, 0644); err != nil {
+		return "", fmt.Errorf("write profile: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+// ImportProfile validates a profile file and merges it into the config file, replacing the settings the profile
+// covers and leaving all others (and their comments) alone. The previous config is kept next to it, with a .bak suffix
+func ImportProfile(profilePath string, configPath string) (*Profile, error) {
+	raw, err := ioutil.ReadFile(profilePath)
+	if err != nil {
+		return nil, fmt.Errorf("read profile: %w", err)
+	}
+
+	profile := &Profile{}
+	if err := yaml.Unmarshal(raw, profile); err != nil {
+		return nil, fmt.Errorf("decode profile: %w", err)
+	}
+
+	if err := profile.validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile: %w", err)
+	}
+
+	// merge into the config's node tree rather than into Config, so nothing the profile doesn't cover is lost
+	document := &yaml.Node{}
+
+	existing, err := ioutil.ReadFile(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	if len(existing) > 0 {
+		if err := yaml.Unmarshal(existing, document); err != nil {
+			return nil, fmt.Errorf("decode config: %w", err)
+		}
+
+		// the backup holds everything the config does, API tokens and other secrets included
+		if err := ioutil.WriteFile(configPath+profileBackupSuffix, existing, 0600); err != nil {
+			return nil, fmt.Errorf("back up config: %w", err)
+		}
+	}
+
+	if err := profile.mergeInto(document); err != nil {
+		return nil, err
+	}
+
+	merged, err := yaml.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("marshal config: %w", err)
+	}
+
+	if err := writeFileAtomically(configPath, merged); err != nil {
+		return nil, fmt.Errorf("write config: %w", err)
+	}
+
+	return profile, nil
+}
+
+// validate returns an error describing everything that's wrong with the profile, if anything is
+func (p *Profile) validate() error {
+	problems := []string{}
+
+	switch {
+	case p.FormatVersion == 0:
+		return errors.New("not a deej profile (deej_profile is missing)")
+	case p.FormatVersion > profileFormatVersion:
+		return fmt.Errorf("profile format %d needs a newer version of deej", p.FormatVersion)
+	}
+
+	if len(p.SliderMappings) == 0 {
+		problems = append(problems, "no slider mappings")
+	}
+
+	for key, mapping := range p.SliderMappings {
+		if mapping.Volume < 0 || mapping.Volume > 1 {
+			problems = append(problems, fmt.Sprintf("slider %q: volume %.2f is outside of 0-1", key, mapping.Volume))
+		}
+
+		for target, offset := range mapping.Offsets {
+			if offset < -100 || offset > 100 {
+				problems = append(problems, fmt.Sprintf("slider %q: offset %.0f for %s is outside of -100-100", key, offset, target))
+			}
+		}
+	}
+
+	switch p.NoiseReductionLevel {
+	case "", "low", "default", "high":
+	default:
+		problems = append(problems, fmt.Sprintf("unknown noise reduction level %q", p.NoiseReductionLevel))
+	}
+
+	if p.QuantizationStep < 0 || p.QuantizationStep > 100 {
+		problems = append(problems, fmt.Sprintf("quantization step %.2f is outside of 0-100", p.QuantizationStep))
+	}
+
+	switch p.Protocol {
+	case "", protocolAnalog, protocolEncoder, protocolMixed:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown protocol %q", p.Protocol))
+	}
+
+	for idx, rule := range p.Rules {
+		if rule.Then.empty() {
+			problems = append(problems, fmt.Sprintf("rule %d has no action", idx))
+		} else if rule.Then.Min != nil && rule.Then.Max != nil && *rule.Then.Min > *rule.Then.Max {
+			problems = append(problems, fmt.Sprintf("rule %d has its min above its max", idx))
+		}
+	}
+
+	if p.BoardFeedback.MinIntervalMs < 0 {
+		problems = append(problems, "board feedback interval is negative")
+	}
+
+	if p.BoardFeedback.Format != "" {
+		if _, err := template.New("board_feedback").Parse(p.BoardFeedback.Format); err != nil {
+			problems = append(problems, fmt.Sprintf("board feedback format: %v", err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// mergeInto sets the profile's settings in a config document, replacing the ones already there
+func (p *Profile) mergeInto(document *yaml.Node) error {
+	if document.Kind == 0 {
+		document.Kind = yaml.DocumentNode
+		document.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return errors.New("config isn't a yaml mapping")
+	}
+
+	// encode the profile the same way the config would have it, minus the profile's own metadata
+	encoded := &yaml.Node{}
+	if err := encoded.Encode(p); err != nil {
+		return fmt.Errorf("encode profile: %w", err)
+	}
+
+	root := document.Content[0]
+
+	for idx := 0; idx+1 < len(encoded.Content); idx += 2 {
+		key, value := encoded.Content[idx], encoded.Content[idx+1]
+
+		switch key.Value {
+		case "deej_profile", "description", "exported_by":
+			continue
+		}
+
+		setMappingValue(root, key, value)
+	}
+
+	return nil
+}
+
+// setMappingValue replaces the value under the given key in a yaml mapping, or appends the pair if the key isn't there
+func setMappingValue(mapping *yaml.Node, key *yaml.Node, value *yaml.Node) {
+	for idx := 0; idx+1 < len(mapping.Content); idx += 2 {
+		if mapping.Content[idx].Value == key.Value {
+			mapping.Content[idx+1] = value
+			return
+		}
+	}
+
+	mapping.Content = append(mapping.Content, key, value)
+}
@@ -0,0 +1,398 @@
+package deej
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	remoteSliderPath = "/remote/slider"
+
+	// forwarded events are only worth anything while they're fresh
+	remoteForwardTimeout = 2 * time.Second
+
+	remoteShutdownTimeout = 2 * time.Second
+
+	// how many forwarded events may queue up behind a slow network before they're dropped
+	remoteQueueSize = 16
+
+	// the forward target that means "this machine", which is always available
+	localForwardTarget = "local"
+)
+
+// remoteSliderMove is what goes over the wire between two deej instances
+type remoteSliderMove struct {
+	Slider string  `json:"slider"`
+	Value  float32 `json:"value"`
+}
+
+type outgoingSliderMove struct {
+	address string
+	event   SliderMoveEvent
+}
+
+// remoteControl forwards slider events to other deej instances and/or accepts them from one,
+// over mutually authenticated TLS.
+//
+// Events can be mirrored to a single instance (forward_to), in which case they're also applied locally,
+// and/or sent to one of several named targets, switched between KVM-style. While a named target is active,
+// events are applied there and only there. Slider values are kept per target, so switching back and forth
+// picks up each machine's sliders where they were left
+type remoteControl struct {
+	deej     *Deej
+	logger   *zap.SugaredLogger
+	server   *http.Server
+	client   *http.Client
+	outgoing chan outgoingSliderMove
+
+	targetLock   sync.Mutex
+	targets      map[string]string
+	targetNames  []string
+	activeTarget string
+	targetValues map[string]map[string]float32
+}
+
+func newRemoteControl(deej *Deej, logger *zap.SugaredLogger) *remoteControl {
+	logger = logger.Named("remote")
+
+	rc := &remoteControl{
+		deej:         deej,
+		logger:       logger,
+		outgoing:     make(chan outgoingSliderMove, remoteQueueSize),
+		targets:      map[string]string{},
+		targetNames:  []string{localForwardTarget},
+		activeTarget: localForwardTarget,
+		targetValues: map[string]map[string]float32{},
+	}
+
+	logger.Debug("Created remote control instance")
+
+	return rc
+}
+
+// start sets up whichever sides of remote control the config asks for
+func (rc *remoteControl) start(settings RemoteControl) error {
+	if settings.Listen == "" && settings.ForwardTo == "" && len(settings.Targets) == 0 {
+		return nil
+	}
+
+	tlsConfig, err := loadMutualTLSConfig(settings)
+	if err != nil {
+		return fmt.Errorf("load mutual TLS config: %w", err)
+	}
+
+	if settings.Listen != "" {
+		if err := rc.listen(settings.Listen, tlsConfig); err != nil {
+			return fmt.Errorf("listen for remote events: %w", err)
+		}
+	}
+
+	if settings.ForwardTo != "" || len(settings.Targets) > 0 {
+		rc.startSending(tlsConfig)
+	}
+
+	if settings.ForwardTo != "" {
+		rc.mirror(settings.ForwardTo)
+	}
+
+	rc.setTargets(settings.Targets)
+
+	return nil
+}
+
+func (rc *remoteControl) stop() {
+	if rc.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteShutdownTimeout)
+	defer cancel()
+
+	if err := rc.server.Shutdown(ctx); err != nil {
+		rc.logger.Warnw("Failed to shut down remote control server", "error", err)
+	}
+}
+
+// listen accepts slider events from other deej instances that present a certificate signed by our CA
+func (rc *remoteControl) listen(address string, tlsConfig *tls.Config) error {
+	serverTLSConfig := tlsConfig.Clone()
+	serverTLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	serverTLSConfig.ClientCAs = tlsConfig.RootCAs
+
+	listener, err := tls.Listen("tcp", address, serverTLSConfig)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", address, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(remoteSliderPath, rc.handleSliderMove)
+
+	rc.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := rc.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			rc.logger.Warnw("Remote control server stopped unexpectedly", "error", err)
+		}
+	}()
+
+	rc.logger.Infow("Accepting remote slider events", "address", listener.Addr().String())
+
+	return nil
+}
+
+func (rc *remoteControl) handleSliderMove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var move remoteSliderMove
+	if err := json.NewDecoder(r.Body).Decode(&move); err != nil {
+		http.Error(w, "invalid slider move", http.StatusBadRequest)
+		return
+	}
+
+	if rc.deej.Verbose() {
+		rc.logger.Debugw("Received remote slider move",
+			"from", r.TLS.PeerCertificates[0].Subject.CommonName,
+			"slider", move.Slider,
+			"value", move.Value)
+	}
+
+	// the other machine's board is a board all the same, and locked sliders ignore it too
+	if rc.deej.configManager.isSliderLocked(move.Slider) {
+		if rc.deej.Verbose() {
+			rc.logger.Debugw("Slider is locked, ignoring remote slider move", "slider", move.Slider)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event := SliderMoveEvent{SliderID: move.Slider, PercentValue: move.Value, remote: true}
+
+	if err := rc.deej.injectSliderMove(event); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// startSending sets up the connection used for all outgoing events, and starts sending whatever gets queued
+func (rc *remoteControl) startSending(tlsConfig *tls.Config) {
+	rc.client = &http.Client{
+		Timeout:   remoteForwardTimeout,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+
+	go func() {
+		for move := range rc.outgoing {
+			if err := rc.send(move.address, move.event); err != nil {
+				rc.logger.Warnw("Failed to forward slider move", "address", move.address, "error", err)
+			}
+		}
+	}()
+}
+
+// mirror sends every local slider event on to the given deej instance, on top of applying it locally
+func (rc *remoteControl) mirror(address string) {
+
+	// forwarding happens over the network, so it mustn't ever hold up local volume changes
+	sliderEvents := rc.deej.serial.SubscribeToSliderMoveEvents(PriorityFeedback)
+
+	go func() {
+		for event := range sliderEvents.C {
+
+			// don't bounce events back and forth between two instances forwarding to each other
+			if event.remote {
+				continue
+			}
+
+			rc.enqueue(address, event)
+		}
+	}()
+
+	rc.logger.Infow("Mirroring slider events", "address", address)
+}
+
+func (rc *remoteControl) enqueue(address string, event SliderMoveEvent) {
+	select {
+	case rc.outgoing <- outgoingSliderMove{address: address, event: event}:
+	default:
+		rc.logger.Debugw("Remote queue full, dropping slider move", "address", address, "slider", event.SliderID)
+	}
+}
+
+func (rc *remoteControl) send(address string, event SliderMoveEvent) error {
+	body, err := json.Marshal(remoteSliderMove{Slider: event.SliderID, Value: event.PercentValue})
+	if err != nil {
+		return fmt.Errorf("marshal slider move: %w", err)
+	}
+
+	url := fmt.Sprintf("https://%s%s", address, remoteSliderPath)
+
+	response, err := rc.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post slider move: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusNoContent {
+		message, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("remote responded with %s: %s", response.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+func (rc *remoteControl) setTargets(targets map[string]string) {
+	rc.targetLock.Lock()
+	defer rc.targetLock.Unlock()
+
+	names := []string{}
+	for name := range targets {
+		if name == localForwardTarget {
+			rc.logger.Warnw("Ignoring forward target with a reserved name", "name", name)
+			continue
+		}
+
+		rc.targets[name] = targets[name]
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	rc.targetNames = append([]string{localForwardTarget}, names...)
+
+	if len(names) > 0 {
+		rc.logger.Infow("Forward targets available", "targets", rc.targetNames)
+	}
+}
+
+// forwardToActiveTarget sends the event to the active target, returning false if that's this machine
+func (rc *remoteControl) forwardToActiveTarget(event SliderMoveEvent) bool {
+	rc.targetLock.Lock()
+	defer rc.targetLock.Unlock()
+
+	if rc.activeTarget == localForwardTarget {
+		return false
+	}
+
+	rc.enqueue(rc.targets[rc.activeTarget], event)
+	return true
+}
+
+// switchTarget makes the named target receive all subsequent slider events
+func (rc *remoteControl) switchTarget(name string) error {
+	rc.targetLock.Lock()
+
+	if _, ok := rc.targets[name]; !ok && name != localForwardTarget {
+		rc.targetLock.Unlock()
+		return fmt.Errorf("unknown forward target: %s", name)
+	}
+
+	if name == rc.activeTarget {
+		rc.targetLock.Unlock()
+		return nil
+	}
+
+	rc.swapSliderValues(rc.activeTarget, name)
+
+	rc.logger.Infow("Switched forward target", "from", rc.activeTarget, "to", name)
+	rc.activeTarget = name
+
+	rc.targetLock.Unlock()
+
+	// there's no telling whether the user can see which machine is being controlled, so let them know
+	rc.deej.notifier.Notify("Switched target", fmt.Sprintf("Sliders now control %s", name))
+
+	return nil
+}
+
+// nextTarget switches to the target after the active one, wrapping around
+func (rc *remoteControl) nextTarget() error {
+	rc.targetLock.Lock()
+
+	next := rc.targetNames[0]
+	for idx, name := range rc.targetNames {
+		if name == rc.activeTarget {
+			next = rc.targetNames[(idx+1)%len(rc.targetNames)]
+			break
+		}
+	}
+
+	rc.targetLock.Unlock()
+
+	return rc.switchTarget(next)
+}
+
+// swapSliderValues remembers the slider values for the outgoing target, and brings back the
+// incoming target's values from when it was last active (if it ever was)
+func (rc *remoteControl) swapSliderValues(from string, to string) {
+	keys, err := rc.deej.configManager.getSliderMappingKeys()
+	if err != nil {
+		return
+	}
+
+	fromValues := map[string]float32{}
+	for _, key := range keys {
+		if mapping, err := rc.deej.configManager.getSliderMappingByKey(key); err == nil {
+			fromValues[key] = mapping.Volume
+		}
+	}
+
+	rc.targetValues[from] = fromValues
+
+	for key, value := range rc.targetValues[to] {
+		if mapping, err := rc.deej.configManager.getSliderMappingByKey(key); err == nil {
+			mapping.Volume = value
+			rc.deej.configManager.UpdateSliderMappingByKey(key, mapping)
+		}
+	}
+}
+
+// SetForwardTarget makes the named remote control target (or "local") receive all subsequent slider events
+func (d *Deej) SetForwardTarget(name string) error {
+	return d.remote.switchTarget(name)
+}
+
+// loadMutualTLSConfig loads our own certificate (presented to the other side, whichever side we are)
+// and the CA that the other side's certificate must be signed by
+func loadMutualTLSConfig(settings RemoteControl) (*tls.Config, error) {
+	if settings.CertFile == "" || settings.KeyFile == "" || settings.CAFile == "" {
+		return nil, errors.New("cert_file, key_file and ca_file are all required")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %w", err)
+	}
+
+	caPEM, err := ioutil.ReadFile(settings.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", settings.CAFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      caPool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,247 @@
+package deej
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	reportFilenameFormat = "deej-report-%s.zip"
+
+	// crash logs older than this are unlikely to be about the issue being reported
+	reportMaxLogAge = 7 * 24 * time.Hour
+
+	redactedValue = "<redacted>"
+)
+
+// config keys whose values are stripped from reports, wherever they appear
+var secretConfigKeyPattern = regexp.MustCompile(`(?i)password|secret|token|passphrase|api_key`)
+
+// WriteReport bundles everything useful for a bug report into a zip file: recent logs, the recent raw
+// serial lines, the config and the files it includes (with secrets stripped) and version/platform info. It works from the files
+// deej leaves in its directory, so it doesn't need (or touch) a running instance. Returns the zip's path
+func WriteReport(outputPath string, buildInfo BuildInfo) (string, error) {
+	if outputPath == "" {
+		outputPath = fmt.Sprintf(reportFilenameFormat, time.Now().Format(crashlogTimestampFormat))
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return "", fmt.Errorf("create report file: %w", err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	includedFiles := reportIncludedFiles("config.yaml", map[string]bool{})
+
+	if err := writeReportEntry(archive, "info.txt", strings.NewReader(reportInfo(buildInfo, includedFiles))); err != nil {
+		return "", err
+	}
+
+	config, err := sanitizedConfig("config.yaml")
+	if err != nil {
+		config = fmt.Sprintf("# couldn't include config: %v\n", err)
+	}
+
+	if err := writeReportEntry(archive, "config.yaml", strings.NewReader(config)); err != nil {
+		return "", err
+	}
+
+	// the files the config includes make it up as much as config.yaml does, and can hold secrets just the same
+	for idx, path := range includedFiles {
+		included, err := sanitizedConfig(path)
+		if err != nil {
+			included = fmt.Sprintf("# couldn't include %s: %v\n", path, err)
+		}
+
+		if err := writeReportEntry(archive, reportIncludedName(idx, path), strings.NewReader(included)); err != nil {
+			return "", err
+		}
+	}
+
+	logFiles, err := recentLogFiles()
+	if err != nil {
+		return "", fmt.Errorf("list log files: %w", err)
+	}
+
+	for _, logFile := range logFiles {
+		if err := writeReportFile(archive, logFile); err != nil {
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", fmt.Errorf("finish report archive: %w", err)
+	}
+
+	return outputPath, nil
+}
+
+func reportInfo(buildInfo BuildInfo, includedFiles []string) string {
+	lines := []string{
+		fmt.Sprintf("Version tag: %s", buildInfo.VersionTag),
+		fmt.Sprintf("Git commit: %s", buildInfo.GitCommit),
+		fmt.Sprintf("Build type: %s", buildInfo.BuildType),
+		fmt.Sprintf("Build date: %s", buildInfo.BuildDate),
+		fmt.Sprintf("Platform: %s/%s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("Go version: %s", runtime.Version()),
+		fmt.Sprintf("Report created: %s", time.Now().Format(time.RFC3339)),
+	}
+
+	for idx, path := range includedFiles {
+		lines = append(lines, fmt.Sprintf("Included config: %s (as %s)", path, reportIncludedName(idx, path)))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// sanitizedConfig returns the config file with the values of secret-looking keys replaced.
+// it's decoded as a node tree rather than into Config, so a broken config still ends up in the report
+func sanitizedConfig(path string) (string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read config: %w", err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(raw, &document); err != nil {
+
+		// can't tell keys from values here, so don't risk including any of it
+		return fmt.Sprintf("# config doesn't parse, contents left out: %v\n", err), nil
+	}
+
+	redactSecrets(&document)
+
+	sanitized, err := yaml.Marshal(&document)
+	if err != nil {
+		return "", fmt.Errorf("marshal sanitized config: %w", err)
+	}
+
+	return string(sanitized), nil
+}
+
+// reportIncludedFiles returns the files the config includes, all the way down. unlike loading the config, it
+// doesn't mind files that are missing or don't parse, since those may well be what the report is about
+func reportIncludedFiles(path string, seen map[string]bool) []string {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal(raw, document); err != nil || len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	node := mappingValue(document.Content[0], includeKey)
+	if node == nil {
+		return nil
+	}
+
+	var includes ConfigIncludes
+	if err := node.Decode(&includes); err != nil {
+		return nil
+	}
+
+	files := []string{}
+	for _, includePath := range includes {
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(filepath.Dir(path), includePath)
+		}
+
+		includePath = filepath.Clean(includePath)
+		if seen[includePath] {
+			continue
+		}
+
+		seen[includePath] = true
+		files = append(files, includePath)
+		files = append(files, reportIncludedFiles(includePath, seen)...)
+	}
+
+	return files
+}
+
+// reportIncludedName is where an included config file goes in the report. they're numbered, since files in
+// different directories can have the same name
+func reportIncludedName(idx int, path string) string {
+	return fmt.Sprintf("includes/%d-%s", idx+1, filepath.Base(path))
+}
+
+func redactSecrets(node *yaml.Node) {
+	if node.Kind == yaml.MappingNode {
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key, value := node.Content[idx], node.Content[idx+1]
+
+			// secret references are only names, and telling them apart from plaintext secrets helps with support
+			if secretConfigKeyPattern.MatchString(key.Value) && !isSecretReference(value.Value) {
+				*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: redactedValue}
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		redactSecrets(child)
+	}
+}
+
+// recentLogFiles returns the log directory's files that are recent enough to be relevant
+func recentLogFiles() ([]string, error) {
+	entries, err := ioutil.ReadDir(logDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	logFiles := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() || time.Since(entry.ModTime()) > reportMaxLogAge {
+			continue
+		}
+
+		logFiles = append(logFiles, filepath.Join(logDirectory, entry.Name()))
+	}
+
+	return logFiles, nil
+}
+
+func writeReportFile(archive *zip.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	return writeReportEntry(archive, filepath.ToSlash(path), file)
+}
+
+func writeReportEntry(archive *zip.Writer, name string, contents io.Reader) error {
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("create report entry %s: %w", name, err)
+	}
+
+	if _, err := io.Copy(entry, contents); err != nil {
+		return fmt.Errorf("write report entry %s: %w", name, err)
+	}
+
+	return nil
+}
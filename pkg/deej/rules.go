@@ -0,0 +1,98 @@
+package deej
+
+import (
+	"go.uber.org/zap"
+)
+
+// Rule lets the config declaratively adjust or drop slider events before they're applied, i.e.
+// "when the mic slider goes above 0.8, keep it at 0.8". Rules are evaluated in order, each one
+// seeing the value as left by the ones before it
+type Rule struct {
+	When RuleCondition `yaml:"when" doc:"Which slider moves the rule applies to"`
+	Then RuleAction    `yaml:"then" doc:"What happens to them"`
+}
+
+// RuleCondition describes which slider events a rule applies to. Empty fields match everything
+type RuleCondition struct {
+	Slider  string   `yaml:"slider,omitempty" doc:"Only this slider's moves"`
+	Profile string   `yaml:"profile,omitempty" doc:"Only while this profile is active"`
+	Above   *float32 `yaml:"above,omitempty" doc:"Only moves above this volume (0-1)"`
+	Below   *float32 `yaml:"below,omitempty" doc:"Only moves below this volume (0-1)"`
+}
+
+// RuleAction describes what happens to a matching slider event
+type RuleAction struct {
+	Min    *float32 `yaml:"min,omitempty" doc:"Raise the volume to at least this (0-1)"`
+	Max    *float32 `yaml:"max,omitempty" doc:"Lower the volume to at most this (0-1)"`
+	Ignore bool     `yaml:"ignore,omitempty" doc:"Drop the move"`
+}
+
+func (c RuleCondition) matches(event SliderMoveEvent, activeProfile string) bool {
+	if c.Slider != "" && c.Slider != event.SliderID {
+		return false
+	}
+
+	if c.Profile != "" && c.Profile != activeProfile {
+		return false
+	}
+
+	if c.Above != nil && event.PercentValue <= *c.Above {
+		return false
+	}
+
+	if c.Below != nil && event.PercentValue >= *c.Below {
+		return false
+	}
+
+	return true
+}
+
+func (a RuleAction) empty() bool {
+	return a.Min == nil && a.Max == nil && !a.Ignore
+}
+
+// validRules drops (and complains about) rules that can't do anything
+func validRules(logger *zap.SugaredLogger, rules []Rule) []Rule {
+	valid := make([]Rule, 0, len(rules))
+
+	for idx, rule := range rules {
+		if rule.Then.empty() {
+			logger.Warnw("Ignoring rule without an action", "rule", idx)
+			continue
+		}
+
+		if rule.Then.Min != nil && rule.Then.Max != nil && *rule.Then.Min > *rule.Then.Max {
+			logger.Warnw("Ignoring rule with min above max", "rule", idx, "min", *rule.Then.Min, "max", *rule.Then.Max)
+			continue
+		}
+
+		valid = append(valid, rule)
+	}
+
+	return valid
+}
+
+// applyRules runs a slider event through the configured rules, returning the (possibly adjusted)
+// event and whether it should be applied at all
+func applyRules(logger *zap.SugaredLogger, rules []Rule, activeProfile string, event SliderMoveEvent) (SliderMoveEvent, bool) {
+	for idx, rule := range rules {
+		if !rule.When.matches(event, activeProfile) {
+			continue
+		}
+
+		if rule.Then.Ignore {
+			logger.Debugw("Rule dropped slider event", "rule", idx, "slider", event.SliderID)
+			return event, false
+		}
+
+		if rule.Then.Min != nil && event.PercentValue < *rule.Then.Min {
+			event.PercentValue = *rule.Then.Min
+		}
+
+		if rule.Then.Max != nil && event.PercentValue > *rule.Then.Max {
+			event.PercentValue = *rule.Then.Max
+		}
+	}
+
+	return event, true
+}
@@ -5,13 +5,15 @@ echo 'Building deej (development)...'
 # shove git commit, version tag into env
 GIT_COMMIT=$(git rev-list -1 --abbrev-commit HEAD)
 VERSION_TAG=$(git describe --tags --always)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
 BUILD_TYPE=dev
 echo 'Embedding build-time parameters:'
 echo "- gitCommit $GIT_COMMIT"
 echo "- versionTag $VERSION_TAG"
 echo "- buildType $BUILD_TYPE"
+echo "- buildDate $BUILD_DATE"
 
-go build -o deej-dev -ldflags "-X main.gitCommit=$GIT_COMMIT -X main.versionTag=$VERSION_TAG -X main.buildType=$BUILD_TYPE" ./pkg/deej/cmd
+go build -o deej-dev -ldflags "-X main.gitCommit=$GIT_COMMIT -X main.versionTag=$VERSION_TAG -X main.buildType=$BUILD_TYPE -X main.buildDate=$BUILD_DATE" ./pkg/deej/cmd
 if [ $? -eq 0 ]; then
     echo 'Done.'
 else
@@ -0,0 +1,75 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// config values starting with this refer to a secret in the OS keychain by name (i.e. "password: secret:obs"),
+// rather than holding it in plaintext
+const secretReferencePrefix = "secret:"
+
+// secret names end up in keychain attributes and CLI arguments, so they're kept simple
+var secretNamePattern = regexp.MustCompile(`^[\w.-]+$`)
+
+// resolveSecret returns a config value as it should be used: a secret reference is looked up in the OS keychain,
+// and anything else is returned as it is. integrations call this where they use the value, so the secret itself
+// never ends up in Config (and from there, in a saved config file or a report)
+func resolveSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, secretReferencePrefix) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, secretReferencePrefix)
+
+	secret, err := util.GetSecret(name)
+	if errors.Is(err, util.ErrSecretNotFound) {
+		return "", fmt.Errorf("secret %q isn't stored, add it with \"deej secret set %s\"", name, name)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("get secret %q: %w", name, err)
+	}
+
+	return secret, nil
+}
+
+// isSecretReference tells whether a config value refers to a stored secret rather than holding one
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretReferencePrefix) &&
+		secretNamePattern.MatchString(strings.TrimPrefix(value, secretReferencePrefix))
+}
+
+// StoreSecret stores a secret in the OS keychain, for the config to refer to as "secret:<name>"
+func StoreSecret(name string, value string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q (use letters, digits, dots, dashes and underscores)", name)
+	}
+
+	if value == "" {
+		return errors.New("secret is empty")
+	}
+
+	if err := util.SetSecret(name, value); err != nil {
+		return fmt.Errorf("store secret: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteSecret removes a secret stored with StoreSecret from the OS keychain
+func DeleteSecret(name string) error {
+	if !secretNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+
+	if err := util.DeleteSecret(name); err != nil {
+		return fmt.Errorf("delete secret: %w", err)
+	}
+
+	return nil
+}
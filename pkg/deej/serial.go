@@ -1,15 +1,14 @@
 package deej
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
-	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/jacobsa/go-serial/serial"
 	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/util"
@@ -23,46 +22,165 @@ type SerialIO struct {
 	deej   *Deej
 	logger *zap.SugaredLogger
 
-	stopChannel chan bool
-	connected   bool
-	connOptions serial.OpenOptions
-	conn        io.ReadWriteCloser
+	stopChannel    chan bool
+	connected      bool
+	connectionInfo ConnectionInfo
+	transport      Transport
+
+	// closed once the connection's goroutine is done with it, after it was stopped or lost
+	connectionDone chan bool
+
+	// writes can come from anywhere (board feedback, commands), but mustn't interleave on the wire
+	writeLock sync.Mutex
+
+	// closed to end a running reconnect (see serial_reconnect.go)
+	reconnectLock   sync.Mutex
+	reconnectCancel chan bool
+
+	// closed to end a running recoverStart, also under reconnectLock (see retryStart)
+	recoverCancel chan bool
+
+	// where the board was last found, when its port is discovered automatically
+	discoveredPort string
+
+	// guards the analog sliders' values below, which are also caught up from a timer once the board goes quiet
+	analogLock sync.Mutex
 
-	lastKnownNumSliders        int
 	currentSliderPercentValues []float32
 
-	sliderMoveConsumers []chan SliderMoveEvent
+	// analog sliders' values after smoothing, before they're checked for noise, and the raw values they're
+	// smoothed towards (see serial_analog.go)
+	smoothedSliderValues []float32
+	rawSliderValues      []float32
+	smoothingFlush       *time.Timer
+
+	// the last position each slider was set to with a "v:" line, by the slider's index
+	positionPercentValues map[int]float32
+
+	// what the board's rotary encoders are doing
+	encoders *EncoderState
+
+	// which slider each encoder was on when deej last ran, by the encoder's ID (see serial_selection.go)
+	restoredSelections map[int]string
+
+	// boards that connect on their own (i.e. over the network) hand their events to the main SerialIO,
+	// so consumers see a single stream no matter how many boards there are
+	hub *SerialIO
+
+	// what each connected board is connected over (see transportKind), kept by the hub for all of its boards
+	boardsLock      sync.Mutex
+	boardTransports map[*SerialIO]string
+
+	// set for boards from the config's connections list (see serial_connections.go)
+	connection *SerialConnection
+
+	events eventBus
+
+	quality *linkQualityTracker
+	history *serialHistory
+
+	connectionNotices *notificationDigest
+	lostConnection    bool
+
+	// when the current connection was made, and why its reader stopped (see readLines)
+	connectedAt time.Time
+	readError   error
+
+	// analog sliders waiting to reach a volume that changed from under them, by slider key (see serial_takeover.go)
+	takeoverLock sync.Mutex
+	takeovers    map[string]*softTakeover
+
+	// set for clients that come and go all the time (i.e. local scripts), whose connections aren't worth a notification
+	quiet bool
+
+	// identifies the connected board - its USB serial number, unless the firmware introduces itself
+	deviceIDLock sync.Mutex
+	deviceID     string
+
+	// set while someone (i.e. the invert assistant) waits for the next raw encoder turn or slider move. the
+	// baseline is every analog slider's raw value when the wait began, by index
+	directionCaptureLock     sync.Mutex
+	directionCaptureChannel  chan CapturedDirection
+	directionCaptureBaseline map[int]int
+}
+
+// CapturedDirection is which way a slider was moved (or its encoder turned) on the board, before any inverting
+type CapturedDirection struct {
+	SliderID string
+	Up       bool
 }
 
 // SliderMoveEvent represents a single slider move captured by deej
 type SliderMoveEvent struct {
 	SliderID     string
 	PercentValue float32
+
+	// only set while latency tracing is enabled
+	trace *latencyTrace
+
+	// set for events received from another deej instance, so they're never forwarded back
+	remote bool
+
+	// set for events from an analog slider, which is already where the event says (motorized faders needn't move)
+	analog bool
 }
 
-var expectedLinePattern = regexp.MustCompile(`^[lrud]\n$`)
+// MuteToggleEvent represents a slider being muted or unmuted from the board
+type MuteToggleEvent struct {
+	SliderID string
+	Muted    bool
+}
 
-var currentSliderIndex int = 0
-var currentSliderName string
-var wantedValue float32 = 0.0
-var isButtonHeld bool = false
-var needToUpdate bool = false
+// a mute button toggles the selected slider ("m") or a specific one by its index ("m:2")
+var muteLinePattern = regexp.MustCompile(`^m(?::(\d{1,4}))?\r?\n$`)
+
+// a boost button turns up the selected slider for a little while ("boost") or a specific one by its index ("boost:2")
+var boostLinePattern = regexp.MustCompile(`^boost(?::(\d{1,4}))?\r?\n$`)
+
+// a preset button flips the selected slider between its two presets ("preset") or a specific one's by its index ("preset:2")
+var presetLinePattern = regexp.MustCompile(`^preset(?::(\d{1,4}))?\r?\n$`)
+
+// a lock button locks or unlocks the selected slider ("lock") or a specific one by its index ("lock:2")
+var lockLinePattern = regexp.MustCompile(`^lock(?::(\d{1,4}))?\r?\n$`)
+
+// firmware can optionally introduce itself with a stable ID, e.g. "id:desk-mixer"
+var handshakeLinePattern = regexp.MustCompile(`^id:([\w.-]+)\r?\n$`)
+
+// firmware can also pick which machine its sliders control, e.g. "target:gaming-pc" (or "t" for the next one)
+var targetLinePattern = regexp.MustCompile(`^target:([\w.-]+)\r?\n$`)
+
+// a profile button cycles through the config's profiles, forwards ("profile_next") or backwards ("profile_prev")
+var profileLinePattern = regexp.MustCompile(`^profile_(next|prev)\r?\n$`)
+
+// or switch to a specific one by its name ("profile:gaming")
+var profileNameLinePattern = regexp.MustCompile(`^profile:([\w.-]+)\r?\n$`)
+
+// how often a busy port (or one we weren't allowed to open) is tried again
+const (
+	portBusyRetryInterval   = 2 * time.Second
+	portAccessRetryInterval = 5 * time.Second
+)
+
+// how long closing a port gets before it's left to close in the background
+const portCloseTimeout = 2 * time.Second
+
+// how much a single encoder tick moves the current slider's volume
+const encoderStep = 0.01
+
+const (
+	encoderDirectionLeft  = "l"
+	encoderDirectionRight = "r"
+)
 
 // NewSerialIO creates a SerialIO instance that uses the provided deej
 // instance's connection info to establish communications with the arduino chip
 func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
-	logger = logger.Named("serial")
+	sio := newBoardIO(deej, logger.Named("serial"), nil)
 
-	sio := &SerialIO{
-		deej:                deej,
-		logger:              logger,
-		stopChannel:         make(chan bool),
-		connected:           false,
-		conn:                nil,
-		sliderMoveConsumers: []chan SliderMoveEvent{},
-	}
+	// pick up where the encoders were left last time
+	sio.restoredSelections = deej.configManager.getEncoderSelections()
 
-	logger.Debug("Created serial i/o instance")
+	sio.logger.Debug("Created serial i/o instance")
 
 	// respond to config changes
 	sio.setupOnConfigReload()
@@ -70,6 +188,33 @@ func NewSerialIO(deej *Deej, logger *zap.SugaredLogger) (*SerialIO, error) {
 	return sio, nil
 }
 
+// newBoardIO creates a SerialIO for a single board, delivering its events through the given hub (if any).
+// boards that connect on their own are started with StartTransport, and don't follow config changes
+func newBoardIO(deej *Deej, logger *zap.SugaredLogger, hub *SerialIO) *SerialIO {
+	sio := &SerialIO{
+		deej:        deej,
+		logger:      logger,
+		stopChannel: make(chan bool),
+		connected:   false,
+		transport:   nil,
+		quality:     newLinkQualityTracker(),
+		history:     newSerialHistory(),
+		encoders:    newEncoderState(),
+		hub:         hub,
+	}
+
+	// a flaky cable can connect and disconnect many times a minute, don't spam the user about each one
+	sio.connectionNotices = newNotificationDigest(deej.notifier,
+		deej.configManager.getNotificationDigest,
+		func(count int, duration time.Duration) (string, string) {
+			return "Unstable connection",
+				fmt.Sprintf("Your board connected and disconnected %d times in %s. Check its USB cable and port.",
+					count, duration.Round(time.Second))
+		})
+
+	return sio
+}
+
 // Start attempts to connect to our arduino chip
 func (sio *SerialIO) Start() error {
 
@@ -79,54 +224,155 @@ func (sio *SerialIO) Start() error {
 		return errors.New("serial: connection already active")
 	}
 
-	// set minimum read size according to platform (0 for windows, 1 for linux)
-	// this prevents a rare bug on windows where serial reads get congested,
-	// resulting in significant lag
-	minimumReadSize := 0
-	if util.Linux() {
-		minimumReadSize = 1
-	}
+	sio.connectionInfo = sio.configuredConnectionInfo()
+
+	var transport Transport
 
-	// TODO - handle all of this in the config
-	// TODO - have the data/stop bits all have defaults/optional
-	sio.connOptions = serial.OpenOptions{
-		PortName:        sio.deej.configManager.Config.ConnectionInfo.SerialPort,
-		BaudRate:        sio.deej.configManager.Config.ConnectionInfo.BaudRate,
-		DataBits:        8,
-		StopBits:        1,
-		MinimumReadSize: uint(minimumReadSize),
+	if sio.deej.replayPath != "" {
+		transport = newReplayTransport(sio.logger, sio.deej.replayPath, sio.connectionInfo)
+	} else if sio.connectionInfo.SerialPort == autoSerialPort {
+		discovered, err := sio.discoverPort(sio.connectionInfo)
+		if err != nil {
+			return err
+		}
+
+		transport = discovered
+	} else {
+		serialTransport := newSerialTransport(sio.logger, sio.connectionInfo)
+		serialTransport.capture = sio.deej.capture
+
+		transport = serialTransport
 	}
 
-	sio.logger.Debugw("Attempting serial connection",
-		"comPort", sio.connOptions.PortName,
-		"baudRate", sio.connOptions.BaudRate,
-		"minReadSize", minimumReadSize)
+	return sio.StartTransport(transport)
+}
 
-	var err error
-	sio.conn, err = serial.Open(sio.connOptions)
-	if err != nil {
+// StartTransport connects to a board over the given transport, and handles its lines until it's gone or stopped
+func (sio *SerialIO) StartTransport(transport Transport) error {
+	sio.writeLock.Lock()
+	sio.transport = transport
+	sio.writeLock.Unlock()
+
+	if err := sio.transport.Connect(); err != nil {
+		sio.eventHub().events.connections.publish(ConnectionEvent{
+			Transport: transport.Name(),
+			Event:     ConnectionFailed,
+			At:        time.Now(),
+			Reason:    err.Error(),
+		})
 
-		// might need a user notification here, TBD
-		sio.logger.Warnw("Failed to open serial connection", "error", err)
-		return fmt.Errorf("open serial connection: %w", err)
+		return err
 	}
 
-	namedLogger := sio.logger.Named(strings.ToLower(sio.connOptions.PortName))
+	namedLogger := sio.logger.Named(strings.ToLower(sio.transport.Name()))
 
-	namedLogger.Infow("Connected", "conn", sio.conn)
+	namedLogger.Infow("Connected", "transport", sio.transport.Name())
 	sio.connected = true
+	sio.connectedAt = time.Now()
+	sio.readError = nil
+	sio.quality.record(linkEventConnect)
+	sio.eventHub().trackBoard(sio, transportKind(transport))
+	sio.eventHub().events.connections.publish(ConnectionEvent{
+		Transport: sio.transport.Name(),
+		Event:     ConnectionConnected,
+		At:        sio.connectedAt,
+	})
+
+	if sio.lostConnection {
+		sio.lostConnection = false
+		sio.connectionNotices.notify("Reconnected", fmt.Sprintf("deej is connected to %s again.", sio.transport.Name()))
+	}
+
+	// until the firmware says otherwise, the transport's idea of the device (i.e. its usb serial number) is the best we have
+	deviceID, err := sio.transport.DeviceID()
+	if err != nil {
+		namedLogger.Debugw("Couldn't get device ID from port", "error", err)
+	}
+
+	sio.identifyDevice(namedLogger, deviceID)
+
+	// a (re)connected analog board sends all of its sliders' values again, even if they didn't move
+	sio.resetAnalogValues()
+	sio.positionPercentValues = nil
+
+	// introduce ourselves, so firmware that cares knows what it's talking to. boards that don't just ignore it
+	sio.writeHello(namedLogger, sio.transport)
+	sio.resumeSelection(namedLogger, sio.transport)
+
+	// whatever the board showed before, it needs the current state now
+	sio.deej.feedback.boardConnected()
+	sio.deej.motors.boardConnected()
 
 	// read lines or await a stop
+	connectionDone := make(chan bool)
+	sio.connectionDone = connectionDone
+
 	go func() {
-		connReader := bufio.NewReader(sio.conn)
-		lineChannel := sio.readLine(namedLogger, connReader)
+		defer close(connectionDone)
+
+		lineChannel := sio.readLines(namedLogger, transport)
+
+		heartbeat := sio.startHeartbeat()
+		defer heartbeat.stop()
 
 		for {
 			select {
 			case <-sio.stopChannel:
-				sio.close(namedLogger)
-			case line := <-lineChannel:
-				sio.handleLine(namedLogger, line)
+				sio.closeWithTimeout(namedLogger, lineChannel, "stopped")
+				return
+
+			// a board that went quiet gets pinged, and one that stays quiet is dropped and reconnected
+			case <-heartbeat.C():
+				if heartbeat.stale() {
+					namedLogger.Warnw("Board stopped responding, reconnecting", "silentFor", heartbeat.silence().Round(time.Second))
+
+					sio.dropStale(namedLogger, lineChannel)
+					sio.reconnect()
+
+					return
+				}
+
+				if err := sio.Write([]byte("ping\n")); err != nil {
+					namedLogger.Debugw("Failed to ping board", "error", err)
+				}
+
+			case line, ok := <-lineChannel:
+
+				// the connection's gone (i.e. the board was unplugged), keep trying to get it back
+				if !ok {
+					reason := "connection lost"
+					if sio.readError != nil {
+						reason = sio.readError.Error()
+					}
+
+					sio.close(namedLogger, reason)
+					sio.reconnect()
+
+					return
+				}
+
+				if sio.deej.Verbose() {
+					namedLogger.Debugw("Read new line", "line", line.Text)
+				}
+
+				sio.deej.wakeups.record("serial_line")
+				heartbeat.seen(line.ReadAt)
+				sio.history.record(line.Text, line.ReadAt)
+
+				// a line that got corrupted on the way could still look like a valid one, so it's dropped
+				text, ok := line.Text, true
+				if !line.Verified {
+					text, ok = verifyChecksum(sio.connectionInfo.Checksum, line.Text)
+				}
+
+				if !ok {
+					namedLogger.Debugw("Dropped line with a bad checksum", "line", line.Text)
+					sio.quality.record(linkEventCorruptLine)
+
+					continue
+				}
+
+				sio.handleLine(namedLogger, text, line.ReadAt)
 			}
 		}
 	}()
@@ -134,23 +380,269 @@ func (sio *SerialIO) Start() error {
 	return nil
 }
 
-// Stop signals us to shut down our serial connection, if one is active
+// StartWhenPortFrees is for when Start fails because the port is busy. It tells the user what's likely holding
+// the port, then quietly keeps trying to connect until the port frees up or fails in some other way
+func (sio *SerialIO) StartWhenPortFrees() error {
+	port := sio.connectionInfo.SerialPort
+	holder := util.GetSerialPortHolder(port)
+
+	sio.logger.Warnw("Serial port is busy, retrying until it frees up", "comPort", port, "holder", holder)
+
+	description := "Something else is using it, like the Arduino IDE's serial monitor or another deej instance."
+	if holder != "" {
+		description = fmt.Sprintf("It's being used by %s.", holder)
+	}
+
+	sio.deej.notifier.Notify(fmt.Sprintf("%s is busy", port),
+		fmt.Sprintf("%s deej will connect as soon as it's closed.", description))
+
+	return sio.retryStart(util.IsSerialPortBusy, portBusyRetryInterval, "The port is free now.")
+}
+
+// StartWhenPermitted is for when Start fails because the user isn't allowed to open the port (on Linux, usually
+// because they aren't in the dialout group). It explains the fix, then quietly keeps trying to connect
+// in case access is granted without restarting deej (i.e. through a udev rule)
+func (sio *SerialIO) StartWhenPermitted(accessErr *util.SerialPortAccessError) error {
+	sio.logger.Warnw("No permission to open serial port, retrying until granted",
+		"comPort", accessErr.Port,
+		"group", accessErr.Group,
+		"needsRelogin", accessErr.NeedsRelogin,
+		"fix", accessErr.Fix())
+
+	sio.deej.notifier.Notify(fmt.Sprintf("No permission to use %s", accessErr.Port), accessErr.Fix())
+
+	return sio.retryStart(isSerialPortAccessError, portAccessRetryInterval, "deej has permission to use the port now.")
+}
+
+// StartWhenBoardFound is for when Start can't find a board on any port (with serial_port set to auto).
+// It lets the user know, then quietly keeps looking until the board is plugged in
+func (sio *SerialIO) StartWhenBoardFound() error {
+	sio.logger.Warn("No board found, looking until one is plugged in")
+
+	sio.deej.notifier.Notify("Can't find your board",
+		"Make sure it's plugged in. deej will keep looking, and connect as soon as it finds it.")
+
+	return sio.retryStart(isNoBoardFoundError, discoveryRetryInterval, "Found your board.")
+}
+
+// recoverStart waits out the errors Start can recover from (a busy port, missing permissions, no board yet)
+// and keeps retrying. Other errors are returned as they are. Only one runs at a time: a newer one (i.e. after the
+// connection parameters changed again) or a Stop ends the one before it, which then returns nil
+func (sio *SerialIO) recoverStart(err error) error {
+	var accessErr *util.SerialPortAccessError
+
+	if util.IsSerialPortBusy(err) {
+		return sio.StartWhenPortFrees()
+	} else if errors.As(err, &accessErr) {
+		return sio.StartWhenPermitted(accessErr)
+	} else if errors.Is(err, errNoBoardFound) {
+		return sio.StartWhenBoardFound()
+	}
+
+	return err
+}
+
+// retryStart tries to connect every interval for as long as Start keeps failing in a way that's retryable,
+// or until it's cancelled
+func (sio *SerialIO) retryStart(retryable func(error) bool, interval time.Duration, connectedMessage string) error {
+	cancel := sio.claimRecovery()
+	defer sio.releaseRecovery(cancel)
+
+	for {
+		select {
+		case <-time.After(interval):
+		case <-cancel:
+			sio.logger.Debug("Waiting to connect cancelled")
+			return nil
+		}
+
+		sio.deej.wakeups.record("serial_retry")
+
+		// a config change might have connected us (to another port) in the meantime
+		if sio.connected {
+			return nil
+		}
+
+		err := sio.Start()
+		if err == nil {
+			sio.deej.notifier.Notify(fmt.Sprintf("Connected to %s", sio.transport.Name()), connectedMessage)
+			return nil
+		}
+
+		if !retryable(err) {
+			return err
+		}
+	}
+}
+
+// claimRecovery makes the calling retryStart the only one running, cancelling the one before it (if any)
+func (sio *SerialIO) claimRecovery() chan bool {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	if sio.recoverCancel != nil {
+		close(sio.recoverCancel)
+	}
+
+	cancel := make(chan bool)
+	sio.recoverCancel = cancel
+
+	return cancel
+}
+
+func (sio *SerialIO) releaseRecovery(cancel chan bool) {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	if sio.recoverCancel == cancel {
+		sio.recoverCancel = nil
+	}
+}
+
+// recovering tells whether a recoverStart is waiting to connect
+func (sio *SerialIO) recovering() bool {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	return sio.recoverCancel != nil
+}
+
+// cancelRecovery ends a running recoverStart, if there is one
+func (sio *SerialIO) cancelRecovery() {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	if sio.recoverCancel != nil {
+		close(sio.recoverCancel)
+		sio.recoverCancel = nil
+	}
+}
+
+func isSerialPortAccessError(err error) bool {
+	var accessErr *util.SerialPortAccessError
+	return errors.As(err, &accessErr)
+}
+
+func isNoBoardFoundError(err error) bool {
+	return errors.Is(err, errNoBoardFound)
+}
+
+func isRecoverableStartError(err error) bool {
+	return util.IsSerialPortBusy(err) || isSerialPortAccessError(err) || isNoBoardFoundError(err)
+}
+
+// portName returns the port we're connected to, or the configured one if we aren't
+func (sio *SerialIO) portName() string {
+	if sio.connected && sio.transport != nil {
+		return sio.transport.Name()
+	}
+
+	return sio.connectionInfo.SerialPort
+}
+
+// Stop shuts down our serial connection, if one is active, and stops trying to reconnect. Once it returns, the
+// connection is let go of: nothing it still reads is handled, and writing to it fails
 func (sio *SerialIO) Stop() {
+	sio.cancelReconnect()
+	sio.cancelRecovery()
+
 	if sio.connected {
 		sio.logger.Debug("Shutting down serial connection")
-		sio.stopChannel <- true
+		connectionDone := sio.connectionDone
+
+		// the connection may also go away on its own in the meantime, and then there's no one to tell
+		select {
+		case sio.stopChannel <- true:
+		case <-connectionDone:
+		}
+
+		<-connectionDone
 	} else {
 		sio.logger.Debug("Not currently connected, nothing to stop")
 	}
 }
 
-// SubscribeToSliderMoveEvents returns an unbuffered channel that receives
-// a sliderMoveEvent struct every time a slider moves
-func (sio *SerialIO) SubscribeToSliderMoveEvents() chan SliderMoveEvent {
-	ch := make(chan SliderMoveEvent)
-	sio.sliderMoveConsumers = append(sio.sliderMoveConsumers, ch)
+// Write sends raw data to the board. It's safe to call from any goroutine, and fails if no board is connected
+func (sio *SerialIO) Write(data []byte) error {
+	sio.writeLock.Lock()
+	defer sio.writeLock.Unlock()
 
-	return ch
+	if !sio.connected || sio.transport == nil {
+		return errors.New("serial: not connected")
+	}
+
+	if _, err := sio.transport.Write(data); err != nil {
+		return fmt.Errorf("write to %s: %w", sio.transport.Name(), err)
+	}
+
+	return nil
+}
+
+// SendCommand sends a single command line to the board, in the same colon-separated form deej reads from it,
+// e.g. SendCommand("vol", "master", "50") sends "vol:master:50"
+func (sio *SerialIO) SendCommand(name string, args ...string) error {
+	return sio.Write([]byte(strings.Join(append([]string{name}, args...), ":") + "\n"))
+}
+
+// LinkQuality returns a snapshot of the connection's recent health
+func (sio *SerialIO) LinkQuality() LinkQuality {
+	return sio.quality.snapshot()
+}
+
+// DeviceID returns the identity of the currently connected board, or an empty string if it's unknown
+func (sio *SerialIO) DeviceID() string {
+	sio.deviceIDLock.Lock()
+	defer sio.deviceIDLock.Unlock()
+
+	return sio.deviceID
+}
+
+// CaptureNextDirection waits for the next encoder turn, or for an analog slider to be moved a good part of
+// its way, and returns which slider it was and its raw direction, ignoring any configured inversion. Board input
+// is swallowed while it waits, and won't move any slider
+func (sio *SerialIO) CaptureNextDirection(timeout time.Duration) (CapturedDirection, error) {
+	ch := make(chan CapturedDirection, 1)
+
+	sio.directionCaptureLock.Lock()
+	if sio.directionCaptureChannel != nil {
+		sio.directionCaptureLock.Unlock()
+		return CapturedDirection{}, errors.New("serial: direction capture already in progress")
+	}
+	sio.directionCaptureChannel = ch
+	sio.directionCaptureBaseline = map[int]int{}
+	sio.directionCaptureLock.Unlock()
+
+	defer func() {
+		sio.directionCaptureLock.Lock()
+		sio.directionCaptureChannel = nil
+		sio.directionCaptureBaseline = nil
+		sio.directionCaptureLock.Unlock()
+	}()
+
+	select {
+	case direction := <-ch:
+		return direction, nil
+	case <-time.After(timeout):
+		return CapturedDirection{}, errors.New("serial: timed out waiting for a slider to move")
+	}
+}
+
+// SubscribeToSliderMoveEvents returns a subscription that receives a SliderMoveEvent struct every time
+// a slider moves. Consumers below audio priority miss the oldest events if they fall too far behind
+func (sio *SerialIO) SubscribeToSliderMoveEvents(priority ConsumerPriority) *Subscription[SliderMoveEvent] {
+	return sio.events.sliderMoves.subscribe(priority)
+}
+
+// SubscribeToMuteToggleEvents returns a subscription that receives
+// a MuteToggleEvent struct every time a slider is muted or unmuted
+func (sio *SerialIO) SubscribeToMuteToggleEvents(priority ConsumerPriority) *Subscription[MuteToggleEvent] {
+	return sio.events.muteToggles.subscribe(priority)
+}
+
+// SubscribeToConnectionEvents returns a subscription that receives
+// a ConnectionEvent struct every time a board connects or goes away
+func (sio *SerialIO) SubscribeToConnectionEvents(priority ConsumerPriority) *Subscription[ConnectionEvent] {
+	return sio.events.connections.subscribe(priority)
 }
 
 func (sio *SerialIO) setupOnConfigReload() {
@@ -163,19 +655,19 @@ func (sio *SerialIO) setupOnConfigReload() {
 			select {
 			case <-configReloadedChannel:
 
-				// make any config reload unset our slider number to ensure process volumes are being re-set
-				// (the next read line will emit SliderMoveEvent instances for all sliders)\
-				// this needs to happen after a small delay, because the session map will also re-acquire sessions
-				// whenever the config file is reloaded, and we don't want it to receive these move events while the map
-				// is still cleared. this is kind of ugly, but shouldn't cause any issues
-				go func() {
-					<-time.After(stopDelay)
-					sio.lastKnownNumSliders = 0
-				}()
+				// there's no need to re-emit slider values here - the session map
+				// re-applies volumes for whichever mappings actually changed. sliders whose volume
+				// changed shouldn't undo that as soon as they're touched, though
+				sio.armTakeovers(sio.logger)
+
+				// safe mode never connects, not even when the config changes. neither does a paused deej,
+				// which connects with whatever the config says by the time it resumes
+				if sio.deej.SafeMode() || sio.deej.userSession.isPaused() {
+					continue
+				}
 
 				// if connection params have changed, attempt to stop and start the connection
-				if sio.deej.configManager.Config.ConnectionInfo.SerialPort != sio.connOptions.PortName ||
-					uint(sio.deej.configManager.Config.ConnectionInfo.BaudRate) != sio.connOptions.BaudRate {
+				if sio.deej.configManager.Config.ConnectionInfo != sio.connectionInfo {
 
 					sio.logger.Info("Detected change in connection parameters, attempting to renew connection")
 					sio.Stop()
@@ -183,8 +675,16 @@ func (sio *SerialIO) setupOnConfigReload() {
 					// let the connection close
 					<-time.After(stopDelay)
 
-					if err := sio.Start(); err != nil {
+					err := sio.Start()
+					if isRecoverableStartError(err) {
+						go func() {
+							if err := sio.recoverStart(err); err != nil {
+								sio.logger.Warnw("Failed to renew connection after waiting for port", "error", err)
+							}
+						}()
+					} else if err != nil {
 						sio.logger.Warnw("Failed to renew connection after parameter change", "error", err)
+						sio.reconnect()
 					} else {
 						sio.logger.Debug("Renewed connection successfully")
 					}
@@ -194,163 +694,505 @@ func (sio *SerialIO) setupOnConfigReload() {
 	}()
 }
 
-func (sio *SerialIO) close(logger *zap.SugaredLogger) {
-	if err := sio.conn.Close(); err != nil {
-		logger.Warnw("Failed to close serial connection", "error", err)
+// writeHello tells the board which version of deej it's connected to, e.g. "deej:release-v0.9.10".
+// firmware that supports port discovery answers with its ID
+func (sio *SerialIO) writeHello(logger *zap.SugaredLogger, transport Transport) {
+	version := sio.deej.buildInfo.Version()
+	if version == "" {
+		version = "unknown"
+	}
+
+	if _, err := transport.Write([]byte(fmt.Sprintf("deej:%s\n", version))); err != nil {
+		logger.Debugw("Failed to send hello to board", "error", err)
+	}
+}
+
+func (sio *SerialIO) close(logger *zap.SugaredLogger, reason string) {
+	if err := sio.transport.Close(); err != nil {
+		logger.Warnw("Failed to close connection", "error", err)
 	} else {
-		logger.Debug("Serial connection closed")
+		logger.Debug("Connection closed")
 	}
 
-	sio.conn = nil
-	sio.connected = false
+	sio.disconnected(logger, reason)
 }
 
-func (sio *SerialIO) readLine(logger *zap.SugaredLogger, reader *bufio.Reader) chan string {
-	ch := make(chan string)
+// closeWithTimeout forgets about the connection right away, and closes it. closing a serial port can block until
+// the board sends something (i.e. a hung driver, or a board that stopped responding), so the close only gets so
+// long before it's left to finish in the background. until then, the port stays taken, and opening it again fails
+// (with "Access is denied" on Windows). whatever the connection still reads is thrown away, so its reader isn't
+// left waiting on a channel no one reads, and ends as soon as the port gives up
+func (sio *SerialIO) closeWithTimeout(logger *zap.SugaredLogger, lineChannel chan TransportLine, reason string) {
+	transport := sio.transport
+	sio.disconnected(logger, reason)
 
 	go func() {
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
+		for range lineChannel {
+		}
+	}()
 
-				if sio.deej.Verbose() {
-					logger.Warnw("Failed to read line from serial", "error", err, "line", line)
-				}
+	closed := make(chan error, 1)
+	go func() {
+		closed <- transport.Close()
+	}()
 
-				// just ignore the line, the read loop will stop after this
-				return
-			}
+	select {
+	case err := <-closed:
+		if err != nil {
+			logger.Debugw("Failed to close connection", "error", err)
+		} else {
+			logger.Debug("Connection closed")
+		}
 
-			if sio.deej.Verbose() {
-				logger.Debugw("Read new line", "line", line)
-			}
+	case <-time.After(portCloseTimeout):
+		logger.Warnw("Connection is taking too long to close, leaving it to close in the background",
+			"timeout", portCloseTimeout)
+	}
+}
+
+// disconnected forgets about the closed (or abandoned) connection, and lets consumers know why it went away
+func (sio *SerialIO) disconnected(logger *zap.SugaredLogger, reason string) {
+	sio.writeLock.Lock()
+	transport := sio.transport
+	sio.transport = nil
+	sio.connected = false
+	sio.writeLock.Unlock()
 
-			// deliver the line to the channel
-			ch <- line
+	sio.quality.record(linkEventDisconnect)
+	sio.identifyDevice(logger, "")
+
+	if transport != nil {
+		now := time.Now()
+
+		sio.eventHub().untrackBoard(sio)
+
+		sio.eventHub().events.connections.publish(ConnectionEvent{
+			Transport: transport.Name(),
+			Event:     ConnectionDisconnected,
+			At:        now,
+			Reason:    reason,
+			Duration:  now.Sub(sio.connectedAt),
+		})
+	}
+}
+
+// readLines reads lines from the given transport in the background, and closes the returned channel
+// once the connection goes away
+func (sio *SerialIO) readLines(logger *zap.SugaredLogger, transport Transport) chan TransportLine {
+	ch := make(chan TransportLine)
+
+	go func() {
+		err := transport.ReadLines(ch)
+
+		// the connection's loop only looks at this once the channel's closed, below. a connection deej
+		// already gave up on mustn't speak for the one that replaced it
+		if sio.currentTransport() == transport {
+			sio.readError = err
+		}
+
+		if sio.deej.Verbose() {
+			logger.Warnw("Stopped reading lines", "error", err)
 		}
+
+		// the board is gone (unplugged, most likely). closing the connection counts it against the link.
+		// a connection deej already gave up on (see dropStale) was announced back then
+		if !sio.quiet && sio.currentTransport() == transport {
+			sio.lostConnection = true
+			sio.connectionNotices.notify("Board disconnected",
+				fmt.Sprintf("deej lost its connection to %s.", transport.Name()))
+		}
+
+		close(ch)
 	}()
 
 	return ch
 }
 
-func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string) {
+// addressedSlider returns the key of the slider a button's command is for: the one at the index the line gives,
+// or whichever slider the first encoder is on if it gives none
+func (sio *SerialIO) addressedSlider(logger *zap.SugaredLogger, command string, index string) (string, bool) {
+	idx := sio.selectedIndex(0)
+	if index != "" {
+		idx, _ = strconv.Atoi(index)
+	}
 
-	// this function receives an unsanitized line which is guaranteed to end with LF,
-	// but most lines will end with CRLF. it may also have garbage instead of
-	// deej-formatted values, so we must check for that! just ignore bad ones
-	if !expectedLinePattern.MatchString(line) {
+	sliderID, err := sio.sliderKeyByIndex(idx)
+	if err != nil {
+		logger.Warnw("Got command for unknown slider", "command", command, "index", idx)
+		return "", false
+	}
+
+	return sliderID, true
+}
+
+// currentTransport returns the transport of the current connection, or nil once it's gone (see disconnected)
+func (sio *SerialIO) currentTransport() Transport {
+	sio.writeLock.Lock()
+	defer sio.writeLock.Unlock()
+
+	return sio.transport
+}
+
+func (sio *SerialIO) handleLine(logger *zap.SugaredLogger, line string, readAt time.Time) {
+
+	// a handshake tells us which board this is, so its device-specific settings can kick in
+	if match := handshakeLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+		sio.identifyDevice(logger, match[1])
 		return
 	}
 
-	// trim the suffix
-	line = strings.TrimSuffix(line, "\n")
-	// logger.Debugf("Got input '%s'", line)
+	// the transport switched to the binary protocol by itself, there's nothing left to do
+	if binaryOfferLinePattern.MatchString(line) {
+		sio.quality.record(linkEventLine)
+		logger.Info("Board switched to the binary protocol")
+		return
+	}
 
-	// Initial fetch to avoid 0 value by default.
-	// if needToFetchCurrentLevel {
-	// 	currentValue = sio.currentSliderPercentValues[currentSlider]
-	// 	needToFetchCurrentLevel = false
-	// }
-	switch line {
-	case "l":
-		if isButtonHeld {
-			logger.Debug("Channel previous")
-			currentSliderIndex--
-			if currentSliderIndex < 0 {
-				currentSliderIndex = 0
-			}
-			sliderMapping, _ := sio.deej.configManager.getSliderMappingByIndex(currentSliderIndex)
-			wantedValue = sliderMapping.Volume
+	if match := heartbeatLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+		sio.handleHeartbeatLine(logger, match[1])
+		return
+	}
 
-			currentSliderName, _ = sio.deej.configManager.getSliderMappingKeyByIndex(currentSliderIndex)
-			logger.Debugf("Channel: %d %s", currentSliderIndex, currentSliderName)
-		} else {
-			sliderMapping, _ := sio.deej.configManager.getSliderMappingByKey(currentSliderName)
-			wantedValue = sliderMapping.Volume - 0.01
-			if wantedValue < 0.0 {
-				wantedValue = 0.0
-			}
-			needToUpdate = true
-			logger.Debugf("Lowering slider %d %s volume %d", currentSliderIndex, currentSliderName, wantedValue)
+	if match := targetLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+
+		if err := sio.deej.remote.switchTarget(match[1]); err != nil {
+			logger.Warnw("Failed to switch forward target", "error", err)
 		}
-	case "r":
-		if isButtonHeld {
-			logger.Debug("Channel next")
-			currentSliderIndex++
-			// why was 1024 specifically hardcoded originally in deej?
-			if currentSliderIndex > 1024 {
-				currentSliderIndex = 1024
-			}
-			sliderMappingCount := sio.deej.configManager.getSliderMappingCount()
-			if currentSliderIndex > sliderMappingCount {
-				currentSliderIndex = sliderMappingCount
-			}
 
-			sliderMapping, _ := sio.deej.configManager.getSliderMappingByIndex(currentSliderIndex)
-			wantedValue = sliderMapping.Volume
+		return
+	}
 
-			currentSliderName, _ = sio.deej.configManager.getSliderMappingKeyByIndex(currentSliderIndex)
-			logger.Debugf("Channel: %d %s", currentSliderIndex, currentSliderName)
-		} else {
-			sliderMapping, _ := sio.deej.configManager.getSliderMappingByKey(currentSliderName)
-			wantedValue = sliderMapping.Volume + 0.01
-			if wantedValue > 1.0 {
-				wantedValue = 1.0
-			}
+	if match := profileLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
 
-			needToUpdate = true
-			logger.Debugf("Raising slider %d %s volume %d", currentSliderIndex, currentSliderName, wantedValue)
+		if sio.deej.userSession.ignoresInput() {
+			return
 		}
-	case "d":
-		logger.Debug("Selecting channel")
-		isButtonHeld = true
-		// logger.Debugf("Num sliders %d", len(sio.deej.config.SliderMapping))
-		keys, _ := sio.deej.configManager.getSliderMappingKeys()
-		logger.Debugf("Sliders %+s", keys)
-
-		needToUpdate = false
-	case "u":
-		logger.Debug("Selecting volume")
-		isButtonHeld = false
-		// TODO - get current value and assign to both so it doesn't reset
-		// TODO - get average of values?
-		needToUpdate = false
-		currentSliderName, _ = sio.deej.configManager.getSliderMappingKeyByIndex(currentSliderIndex)
-		// currentValue = sio.deej.serial.currentSliderPercentValues[currentSlider]
 
-	default:
-		logger.Warnf("Unhandled input \"%s\"", line)
+		step := 1
+		if match[1] == "prev" {
+			step = -1
+		}
+
+		sio.deej.cycleProfile(logger, step)
+		return
 	}
 
-	// for each slider:
-	moveEvents := []SliderMoveEvent{}
+	if match := profileNameLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
 
-	sliderMapping, _ := sio.deej.configManager.getSliderMappingByIndex(currentSliderIndex)
-	if needToUpdate && (wantedValue != sliderMapping.Volume) {
-		moveEvents = append(moveEvents, SliderMoveEvent{
-			SliderID:     currentSliderName,
-			PercentValue: wantedValue,
-		})
-		// sio.deej.config.Config.SliderMappings[currentSlider].Volume = wantedValue
+		if sio.deej.userSession.ignoresInput() {
+			return
+		}
+
+		if err := sio.deej.configManager.switchProfile(match[1]); err != nil {
+			logger.Warnw("Failed to switch profile", "profile", match[1], "error", err)
+			return
+		}
+
+		logger.Infow("Switched profile", "profile", match[1])
+		return
 	}
 
-	if sio.deej.Verbose() {
-		for _, event := range moveEvents {
-			logger.Debugw("Slider moved", "event", event)
+	if match := muteLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+
+		sliderID, ok := sio.addressedSlider(logger, "mute", match[1])
+		if !ok {
+			return
+		}
+
+		if sio.lockedOut(logger, sliderID) {
+			return
 		}
+
+		sio.toggleMute(logger, sliderID)
+		return
 	}
 
-	// deliver move events if there are any, towards all potential consumers
-	if len(moveEvents) > 0 {
-		for _, consumer := range sio.sliderMoveConsumers {
-			for _, moveEvent := range moveEvents {
-				consumer <- moveEvent
-				// currentSliderValues[moveEvent.SliderID] = moveEvent.PercentValue
-				// TODO use a local function in config manager to lock/update the values
-				sm, _ := sio.deej.configManager.getSliderMappingByKey(moveEvent.SliderID)
-				sm.Volume = moveEvent.PercentValue
-				sio.deej.configManager.UpdateSliderMappingByKey(moveEvent.SliderID, sm)
-			}
+	if match := boostLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+
+		if sio.deej.userSession.ignoresInput() {
+			return
+		}
+
+		sliderID, ok := sio.addressedSlider(logger, "boost", match[1])
+		if !ok {
+			return
+		}
+
+		if sio.lockedOut(logger, sliderID) {
+			return
+		}
+
+		if err := sio.deej.boosts.boost(sliderID); err != nil {
+			logger.Warnw("Failed to boost slider", "slider", sliderID, "error", err)
+		}
+
+		return
+	}
+
+	if match := presetLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+
+		if sio.deej.userSession.ignoresInput() {
+			return
 		}
+
+		sliderID, ok := sio.addressedSlider(logger, "preset", match[1])
+		if !ok {
+			return
+		}
+
+		if sio.lockedOut(logger, sliderID) {
+			return
+		}
+
+		volume, err := sio.deej.togglePreset(sliderID)
+		if err != nil {
+			logger.Warnw("Failed to toggle slider preset", "slider", sliderID, "error", err)
+			return
+		}
+
+		logger.Debugw("Toggled slider preset", "slider", sliderID, "volume", volume)
+		return
+	}
+
+	if match := lockLinePattern.FindStringSubmatch(line); match != nil {
+		sio.quality.record(linkEventLine)
+
+		if sio.deej.userSession.ignoresInput() {
+			return
+		}
+
+		sliderID, ok := sio.addressedSlider(logger, "lock", match[1])
+		if !ok {
+			return
+		}
+
+		if _, err := sio.deej.toggleSliderLock(sliderID); err != nil {
+			logger.Warnw("Failed to toggle slider lock", "slider", sliderID, "error", err)
+		}
+
+		return
+	}
+
+	// classic deej boards send all of their sliders' raw values at once
+	if analogLinePattern.MatchString(line) && sio.acceptsLine(protocolAnalog) {
+		sio.quality.record(linkEventLine)
+		sio.handleAnalogLine(logger, line, readAt)
+		return
 	}
+
+	// encoder firmware with a potentiometer or touch strip sets a slider's position in one go
+	if match := positionLinePattern.FindStringSubmatch(line); match != nil && sio.acceptsLine(protocolEncoder) {
+		sio.quality.record(linkEventLine)
+		sio.handlePositionLine(logger, match, readAt)
+		return
+	}
+
+	// this function receives an unsanitized line which is guaranteed to end with LF,
+	// but most lines will end with CRLF. it may also have garbage instead of
+	// deej-formatted values, so we must check for that! just ignore bad ones
+	if !expectedLinePattern.MatchString(line) || !sio.acceptsLine(protocolEncoder) {
+		sio.quality.record(linkEventBadLine)
+		return
+	}
+
+	sio.quality.record(linkEventLine)
+	sio.handleEncoderLine(logger, line, readAt)
+}
+
+// dispatchSliderMove stores a slider's new value in the config and delivers the event to all consumers.
+// this is also how software-controlled (virtual) sliders get moved, since they never show up on the wire
+func (sio *SerialIO) dispatchSliderMove(moveEvent SliderMoveEvent) {
+
+	// another user's session is in front of the screen (their audio isn't ours to touch), or the lock screen is
+	if sio.deej.userSession.ignoresInput() {
+		return
+	}
+
+	// let the config's rules have their say first, they may adjust the value or drop the event entirely
+	rules, activeProfile := sio.deej.configManager.getRules()
+
+	moveEvent, apply := applyRules(sio.logger, rules, activeProfile, moveEvent)
+	if !apply {
+		return
+	}
+
+	// TODO use a local function in config manager to lock/update the values
+	sm, _ := sio.deej.configManager.getSliderMappingByKey(moveEvent.SliderID)
+	sm.Volume = moveEvent.PercentValue
+	sio.deej.configManager.UpdateSliderMappingByKey(moveEvent.SliderID, sm)
+
+	// while another machine is being controlled, the event is meant for it alone
+	if !moveEvent.remote && sio.deej.remote.forwardToActiveTarget(moveEvent) {
+		return
+	}
+
+	sio.eventHub().events.sliderMoves.publish(moveEvent)
+}
+
+// toggleMute flips a slider's mute state in the config and lets all consumers know
+func (sio *SerialIO) toggleMute(logger *zap.SugaredLogger, sliderID string) {
+	if sio.deej.userSession.ignoresInput() {
+		return
+	}
+
+	sm, err := sio.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		logger.Warnw("Failed to toggle mute", "error", err)
+		return
+	}
+
+	sm.Muted = !sm.Muted
+	sio.deej.configManager.UpdateSliderMappingByKey(sliderID, sm)
+
+	logger.Debugw("Toggled slider mute", "slider", sliderID, "muted", sm.Muted)
+
+	sio.eventHub().events.muteToggles.publish(MuteToggleEvent{SliderID: sliderID, Muted: sm.Muted})
+
+	sio.deej.feedback.stateChanged()
+}
+
+// eventHub returns the SerialIO whose event bus gets this board's events
+func (sio *SerialIO) eventHub() *SerialIO {
+	if sio.hub != nil {
+		return sio.hub
+	}
+
+	return sio
+}
+
+// tickSize returns how much a single encoder tick should move the volume. this is never smaller than
+// the quantization step, otherwise small ticks would be snapped right back to where they started
+func (sio *SerialIO) tickSize() float32 {
+	if step := sio.deej.configManager.getQuantizationStep(); step > encoderStep {
+		return step
+	}
+
+	return encoderStep
+}
+
+// quantize snaps a volume value to the configured quantization step before it's emitted
+func (sio *SerialIO) quantize(v float32) float32 {
+	return util.QuantizeScalar(v, sio.deej.configManager.getQuantizationStep())
+}
+
+// captureDirection delivers an encoder's turn to a pending CaptureNextDirection call, if there is one, as a move
+// of the encoder's selected slider
+func (sio *SerialIO) captureDirection(id int, line string) bool {
+	if line != encoderDirectionLeft && line != encoderDirectionRight {
+		return false
+	}
+
+	sio.directionCaptureLock.Lock()
+	defer sio.directionCaptureLock.Unlock()
+
+	if sio.directionCaptureChannel == nil {
+		return false
+	}
+
+	sliderID, err := sio.sliderKeyByIndex(sio.selectedIndex(id))
+	if err != nil {
+		return true
+	}
+
+	select {
+	case sio.directionCaptureChannel <- CapturedDirection{SliderID: sliderID, Up: line == encoderDirectionRight}:
+	default:
+	}
+
+	return true
+}
+
+// captureAnalogDirection swallows an analog slider's raw value while a CaptureNextDirection call is pending, and
+// delivers its direction to it once the slider has moved far enough from where it was when the wait began
+func (sio *SerialIO) captureAnalogDirection(idx int, number int) bool {
+	sio.directionCaptureLock.Lock()
+	defer sio.directionCaptureLock.Unlock()
+
+	if sio.directionCaptureChannel == nil {
+		return false
+	}
+
+	baseline, ok := sio.directionCaptureBaseline[idx]
+	if !ok {
+		sio.directionCaptureBaseline[idx] = number
+		return true
+	}
+
+	if number-baseline < analogCaptureDistance && baseline-number < analogCaptureDistance {
+		return true
+	}
+
+	sliderID, err := sio.sliderKeyByIndex(idx)
+	if err != nil {
+		return true
+	}
+
+	select {
+	case sio.directionCaptureChannel <- CapturedDirection{SliderID: sliderID, Up: number > baseline}:
+	default:
+	}
+
+	return true
+}
+
+func invertEncoderDirection(line string) string {
+	switch line {
+	case encoderDirectionLeft:
+		return encoderDirectionRight
+	case encoderDirectionRight:
+		return encoderDirectionLeft
+	}
+
+	return line
+}
+
+func (sio *SerialIO) identifyDevice(logger *zap.SugaredLogger, deviceID string) {
+	sio.deviceIDLock.Lock()
+	defer sio.deviceIDLock.Unlock()
+
+	if deviceID == sio.deviceID {
+		return
+	}
+
+	sio.deviceID = deviceID
+
+	if deviceID == "" {
+		return
+	}
+
+	settings, ok := sio.deej.configManager.getDeviceSettings(deviceID)
+	if !ok {
+		logger.Infow("Identified device (no device-specific settings)", "deviceID", deviceID)
+		return
+	}
+
+	logger.Infow("Identified device", "deviceID", deviceID, "label", settings.Label)
+}
+
+// invertDirection returns whether encoder turns should be flipped, preferring the connected
+// device's own setting over the global one
+func (sio *SerialIO) invertDirection() bool {
+	if settings, ok := sio.deej.configManager.getDeviceSettings(sio.DeviceID()); ok && settings.Invert != nil {
+		return *settings.Invert
+	}
+
+	return sio.deej.configManager.getInvertSliders()
+}
+
+// invertSlider returns whether a slider's values should be flipped, preferring the slider's own setting
+// over the board's
+func (sio *SerialIO) invertSlider(sliderID string) bool {
+	if mapping, err := sio.deej.configManager.getSliderMappingByKey(sliderID); err == nil && mapping.Invert != nil {
+		return *mapping.Invert
+	}
+
+	return sio.invertDirection()
 }
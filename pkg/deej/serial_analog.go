@@ -0,0 +1,298 @@
+package deej
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// which kind of lines deej accepts from the board
+const (
+
+	// classic deej firmware: every slider's raw analog value in one line, e.g. "1023|512|0"
+	protocolAnalog = "analog"
+
+	// rotary encoder firmware: one command per line, e.g. "l", "r", "d", "u"
+	protocolEncoder = "encoder"
+
+	// either of the above, told apart line by line
+	protocolMixed = "mixed"
+)
+
+// the largest raw value a slider can report (arduino analog reads are 10 bit)
+const analogMaxValue = 1023
+
+// how hard deej works to keep noisy sliders from moving by themselves. analog sliders are first smoothed
+// (a low-pass filter over the values the board streams), then anything that's still too small a change to be
+// more than noise is dropped. "default" is the same as leaving it empty
+const (
+	noiseReductionLow     = "low"
+	noiseReductionDefault = "default"
+	noiseReductionHigh    = "high"
+)
+
+// how much of every new reading makes it into a slider's smoothed value, by noise reduction level. the rest
+// is the slider's smoothed value so far, so lower is smoother (and a little slower to follow the slider)
+var analogSmoothingFactors = map[string]float32{
+	noiseReductionLow:     0.75,
+	noiseReductionDefault: 0.5,
+	noiseReductionHigh:    0.3,
+}
+
+// half of the smallest step a volume takes
+const analogSmoothingSnap = 0.005
+
+// smoothed values only move on with new readings, so once a board stops sending (i.e. it only sends when a slider
+// moves) for this long, its sliders are taken straight to their latest readings
+const analogSmoothingIdle = 150 * time.Millisecond
+
+// how far (in raw values) a slider has to move for the invert assistant to take it as a move, rather than noise
+const analogCaptureDistance = analogMaxValue / 4
+
+var analogLinePattern = regexp.MustCompile(`^\d{1,4}(\|\d{1,4})*\r?\n$`)
+
+// a single slider's raw value, for encoder firmware with a potentiometer or touch strip (e.g. "v:2:512")
+var positionLinePattern = regexp.MustCompile(`^v:(\d{1,4}):(\d{1,4})\r?\n$`)
+
+// handleAnalogLine turns a line of raw slider values into move events for the sliders that actually moved.
+// sliders are matched to the config's hardware slider mappings by position
+func (sio *SerialIO) handleAnalogLine(logger *zap.SugaredLogger, line string, readAt time.Time) {
+	sio.dispatchAnalogMoves(logger, sio.analogLineMoves(logger, line, readAt))
+}
+
+// analogLineMoves works out which of an analog line's sliders moved, and where to
+func (sio *SerialIO) analogLineMoves(logger *zap.SugaredLogger, line string, readAt time.Time) []SliderMoveEvent {
+	values := strings.Split(strings.TrimRight(line, "\r\n"), "|")
+
+	sio.analogLock.Lock()
+	defer sio.analogLock.Unlock()
+
+	// the board's slider count is known from its first line. send every slider's value once after that,
+	// so volumes match the sliders' positions right away
+	if len(values) != len(sio.currentSliderPercentValues) {
+		logger.Infow("Detected analog sliders", "amount", len(values))
+
+		sio.currentSliderPercentValues = make([]float32, len(values))
+		sio.smoothedSliderValues = make([]float32, len(values))
+		sio.rawSliderValues = make([]float32, len(values))
+		for idx := range sio.currentSliderPercentValues {
+			sio.currentSliderPercentValues[idx] = -1.0
+			sio.smoothedSliderValues[idx] = -1.0
+			sio.rawSliderValues[idx] = -1.0
+		}
+	}
+
+	noiseReductionLevel := sio.deej.configManager.getNoiseReductionLevel()
+	moveEvents := []SliderMoveEvent{}
+	settling := false
+
+	for idx, value := range values {
+		number, _ := strconv.Atoi(value)
+
+		// the first line after connecting sometimes comes out dirty (i.e. "4558|925|41|643|220")
+		if number > analogMaxValue {
+			logger.Debugw("Got malformed analog line, ignoring", "line", line)
+			return nil
+		}
+
+		// if anyone's waiting on a raw slider move, hand it over instead of acting on it
+		if sio.captureAnalogDirection(idx, number) {
+			continue
+		}
+
+		sio.rawSliderValues[idx] = sio.analogPercent(idx, number)
+		percent := sio.smooth(&sio.smoothedSliderValues[idx], sio.rawSliderValues[idx], noiseReductionLevel)
+		settling = settling || sio.smoothedSliderValues[idx] != sio.rawSliderValues[idx]
+
+		previous := &sio.currentSliderPercentValues[idx]
+		if moveEvent, ok := sio.analogMove(logger, idx, percent, previous, noiseReductionLevel, readAt); ok {
+			moveEvents = append(moveEvents, moveEvent)
+		}
+	}
+
+	// sliders that haven't caught up with their readings yet get there once the board goes quiet, unless
+	// another line comes first
+	if settling {
+		if sio.smoothingFlush == nil {
+			sio.smoothingFlush = time.AfterFunc(analogSmoothingIdle, func() { sio.flushSmoothing(logger) })
+		} else {
+			sio.smoothingFlush.Reset(analogSmoothingIdle)
+		}
+	}
+
+	return moveEvents
+}
+
+// flushSmoothing takes analog sliders straight to their latest readings, after the board went quiet
+func (sio *SerialIO) flushSmoothing(logger *zap.SugaredLogger) {
+	sio.analogLock.Lock()
+
+	noiseReductionLevel := sio.deej.configManager.getNoiseReductionLevel()
+	moveEvents := []SliderMoveEvent{}
+
+	for idx, raw := range sio.rawSliderValues {
+		if raw < 0 || sio.smoothedSliderValues[idx] == raw {
+			continue
+		}
+
+		sio.smoothedSliderValues[idx] = raw
+
+		previous := &sio.currentSliderPercentValues[idx]
+		if moveEvent, ok := sio.analogMove(logger, idx, raw, previous, noiseReductionLevel, time.Now()); ok {
+			moveEvents = append(moveEvents, moveEvent)
+		}
+	}
+
+	sio.analogLock.Unlock()
+
+	sio.dispatchAnalogMoves(logger, moveEvents)
+}
+
+// resetAnalogValues forgets the analog sliders' values, so a (re)connected board's first line sets them all
+func (sio *SerialIO) resetAnalogValues() {
+	sio.analogLock.Lock()
+	defer sio.analogLock.Unlock()
+
+	if sio.smoothingFlush != nil {
+		sio.smoothingFlush.Stop()
+		sio.smoothingFlush = nil
+	}
+
+	sio.currentSliderPercentValues = nil
+	sio.smoothedSliderValues = nil
+	sio.rawSliderValues = nil
+}
+
+func (sio *SerialIO) dispatchAnalogMoves(logger *zap.SugaredLogger, moveEvents []SliderMoveEvent) {
+	if sio.deej.Verbose() {
+		for _, event := range moveEvents {
+			logger.Debugw("Slider moved", "event", event)
+		}
+	}
+
+	for _, moveEvent := range moveEvents {
+		sio.dispatchBoardSliderMove(logger, moveEvent)
+	}
+}
+
+// handlePositionLine sets a single slider to an absolute position, calibrated for noise just like an analog
+// line's values. it saves encoder firmware from sending a tick for every step of the way. positions aren't
+// smoothed, since firmware sends them when they change rather than all the time, and a smoothed slider would
+// stop short of where it was left
+func (sio *SerialIO) handlePositionLine(logger *zap.SugaredLogger, match []string, readAt time.Time) {
+	idx, _ := strconv.Atoi(match[1])
+	number, _ := strconv.Atoi(match[2])
+
+	if number > analogMaxValue {
+		logger.Debugw("Got position outside of the analog range, ignoring", "index", idx, "value", number)
+		return
+	}
+
+	if sio.captureAnalogDirection(idx, number) {
+		return
+	}
+
+	if sio.positionPercentValues == nil {
+		sio.positionPercentValues = map[int]float32{}
+	}
+
+	previous, ok := sio.positionPercentValues[idx]
+	if !ok {
+		previous = -1.0
+	}
+
+	moveEvent, moved := sio.analogMove(logger, idx, sio.analogPercent(idx, number), &previous, sio.deej.configManager.getNoiseReductionLevel(), readAt)
+	sio.positionPercentValues[idx] = previous
+
+	if !moved {
+		return
+	}
+
+	if sio.deej.Verbose() {
+		logger.Debugw("Slider moved", "event", moveEvent)
+	}
+
+	sio.dispatchBoardSliderMove(logger, moveEvent)
+}
+
+// analogPercent turns a slider's raw value into a volume, the right way around
+func (sio *SerialIO) analogPercent(idx int, number int) float32 {
+	sliderID, _ := sio.sliderKeyByIndex(idx)
+
+	percent := util.NormalizeScalar(float32(number) / analogMaxValue)
+	if sio.invertSlider(sliderID) {
+		percent = 1 - percent
+	}
+
+	return percent
+}
+
+// smooth adds a reading to a slider's smoothed value, and returns the new smoothed value. a slider reaching
+// either end gets there right away, since that's where it's usually pushed to on purpose
+func (sio *SerialIO) smooth(smoothed *float32, percent float32, noiseReductionLevel string) float32 {
+	factor, ok := analogSmoothingFactors[noiseReductionLevel]
+	if !ok {
+		factor = analogSmoothingFactors[noiseReductionDefault]
+	}
+
+	// a slider's first reading has nothing to be smoothed with
+	if *smoothed < 0 {
+		*smoothed = percent
+	} else {
+		*smoothed += factor * (percent - *smoothed)
+	}
+
+	// the smoothed value only ever gets closer to a slider that stopped, so it's snapped once it's close enough
+	if percent == 0 || percent == 1 || math.Abs(float64(percent-*smoothed)) < analogSmoothingSnap {
+		*smoothed = percent
+	}
+
+	return util.NormalizeScalar(*smoothed)
+}
+
+// analogMove turns a slider's value into a move event, unless it's too close to its previous value to be
+// anything but noise, or the slider is waiting for its hardware to catch up. previous is updated as the slider moves
+func (sio *SerialIO) analogMove(logger *zap.SugaredLogger, idx int, percent float32, previous *float32,
+	noiseReductionLevel string, readAt time.Time) (SliderMoveEvent, bool) {
+
+	// jumpy raw values shouldn't move anything
+	if !util.SignificantlyDifferent(*previous, percent, noiseReductionLevel) {
+		return SliderMoveEvent{}, false
+	}
+
+	*previous = percent
+
+	sliderID, err := sio.sliderKeyByIndex(idx)
+	if err != nil {
+		return SliderMoveEvent{}, false
+	}
+
+	if sio.holdForTakeover(logger, sliderID, sio.quantize(percent)) {
+		return SliderMoveEvent{}, false
+	}
+
+	moveEvent := SliderMoveEvent{
+		SliderID:     sliderID,
+		PercentValue: sio.quantize(percent),
+		analog:       true,
+	}
+
+	if sio.deej.configManager.getTraceLatency() {
+		moveEvent.trace = &latencyTrace{readAt: readAt, parsedAt: time.Now()}
+	}
+
+	return moveEvent, true
+}
+
+// acceptsLine tells whether the configured protocol allows a line that matched the given protocol
+func (sio *SerialIO) acceptsLine(protocol string) bool {
+	configured := sio.deej.configManager.getProtocol()
+
+	return configured == protocolMixed || configured == protocol
+}
@@ -0,0 +1,218 @@
+package deej
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// boards that speak the compact binary protocol offer it with this line, which deej echoes back to accept.
+// both sides switch to binary frames from there on, while older deej versions never answer and keep to lines
+var binaryOfferLinePattern = regexp.MustCompile(`^proto:binary\r?\n$`)
+
+const binaryOfferLine = "proto:binary\n"
+
+// binary frames are COBS-encoded (so they never contain a zero byte) and end with one. their first byte says
+// what they hold:
+//
+//	1iiiiivv vvvvvvvv  analog slider i (0-31) at value v (0-1023)
+//	01eeeccc           encoder e (0-7) command c: l, r, u, d or t (0-4)
+//	00100000           mute the selected slider
+//	00100001 iiiiiiii  mute slider i (0-255)
+//	00010000           ping
+//	00010001           pong
+//	00000001 ...       a regular line, without its LF (everything else, in both directions)
+//
+// with a checksum mode, every frame ends with its CRC8 (see serial_checksum.go) as one more byte
+const (
+	binaryAnalogFlag    = 0x80
+	binaryEncoderFlag   = 0x40
+	binaryMuteSelected  = 0x20
+	binaryMuteIndex     = 0x21
+	binaryPing          = 0x10
+	binaryPong          = 0x11
+	binaryText          = 0x01
+	binaryEncoderFields = "lrudt"
+)
+
+var errBadFrame = errors.New("bad frame")
+
+// binaryProtocol turns binary frames into the lines they stand for, so SerialIO handles them like any other,
+// and lines into frames. it keeps the board's analog values, since a frame only carries one slider's
+type binaryProtocol struct {
+	checksum bool
+
+	sliderValues []int
+	sliderSeen   []bool
+}
+
+func newBinaryProtocol(checksum string) *binaryProtocol {
+	return &binaryProtocol{checksum: checksum == checksumCRC8}
+}
+
+// decode returns the line a frame (without its trailing zero) stands for, and whether its checksum was
+// verified. empty lines are for frames that don't stand for anything yet (i.e. analog values before every
+// slider was heard from), and frames that can't be decoded come back as lines no pattern matches
+func (bp *binaryProtocol) decode(frame []byte) (string, bool) {
+	if len(frame) == 0 {
+		return "", false
+	}
+
+	payload, err := cobsDecode(frame)
+	if err == nil && bp.checksum {
+		payload, err = bp.verify(payload)
+	}
+
+	if err != nil || len(payload) == 0 {
+		return fmt.Sprintf("binary:%x\n", frame), false
+	}
+
+	line, err := bp.line(payload)
+	if err != nil {
+		return fmt.Sprintf("binary:%x\n", frame), false
+	}
+
+	return line, bp.checksum
+}
+
+func (bp *binaryProtocol) verify(payload []byte) ([]byte, error) {
+	if len(payload) < 2 {
+		return nil, errBadFrame
+	}
+
+	data, sent := payload[:len(payload)-1], payload[len(payload)-1]
+	if crc8(data) != sent {
+		return nil, errBadFrame
+	}
+
+	return data, nil
+}
+
+func (bp *binaryProtocol) line(payload []byte) (string, error) {
+	first := payload[0]
+
+	switch {
+	case first&binaryAnalogFlag != 0:
+		if len(payload) != 2 {
+			return "", errBadFrame
+		}
+
+		return bp.analogLine(int(first>>2&0x1F), int(first&0x03)<<8|int(payload[1])), nil
+
+	case first&binaryEncoderFlag != 0:
+		id, command := int(first>>3&0x07), int(first&0x07)
+		if len(payload) != 1 || command >= len(binaryEncoderFields) {
+			return "", errBadFrame
+		}
+
+		if id == 0 {
+			return string(binaryEncoderFields[command]) + "\n", nil
+		}
+
+		return fmt.Sprintf("%d:%c\n", id, binaryEncoderFields[command]), nil
+
+	case first == binaryMuteSelected && len(payload) == 1:
+		return "m\n", nil
+
+	case first == binaryMuteIndex && len(payload) == 2:
+		return fmt.Sprintf("m:%d\n", payload[1]), nil
+
+	case first == binaryPing && len(payload) == 1:
+		return "ping\n", nil
+
+	case first == binaryPong && len(payload) == 1:
+		return "pong\n", nil
+
+	case first == binaryText:
+		return string(payload[1:]) + "\n", nil
+	}
+
+	return "", errBadFrame
+}
+
+// analogLine records a slider's value and returns every slider's, the way analog lines have them. it stays
+// empty until every slider up to the highest one yet has been heard from, so none of them starts out at 0
+func (bp *binaryProtocol) analogLine(index int, value int) string {
+	for len(bp.sliderValues) <= index {
+		bp.sliderValues = append(bp.sliderValues, 0)
+		bp.sliderSeen = append(bp.sliderSeen, false)
+	}
+
+	bp.sliderValues[index] = value
+	bp.sliderSeen[index] = true
+
+	values := make([]string, len(bp.sliderValues))
+	for idx, sliderValue := range bp.sliderValues {
+		if !bp.sliderSeen[idx] {
+			return ""
+		}
+
+		values[idx] = strconv.Itoa(sliderValue)
+	}
+
+	return strings.Join(values, "|") + "\n"
+}
+
+// encode turns whatever deej writes to the board (any number of lines) into text frames, one per line
+func (bp *binaryProtocol) encode(data []byte) []byte {
+	encoded := &bytes.Buffer{}
+
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		payload := append([]byte{binaryText}, line...)
+		if bp.checksum {
+			payload = append(payload, crc8(payload))
+		}
+
+		encoded.Write(cobsEncode(payload))
+		encoded.WriteByte(0)
+	}
+
+	return encoded.Bytes()
+}
+
+// cobsEncode replaces every zero byte, so the result can be delimited by one
+// (Consistent Overhead Byte Stuffing, which costs a byte per 254)
+func cobsEncode(data []byte) []byte {
+	encoded := make([]byte, 1, len(data)+len(data)/254+2)
+	codeIndex, code := 0, byte(1)
+
+	for _, b := range data {
+		if b != 0 {
+			encoded = append(encoded, b)
+			code++
+		}
+
+		if b == 0 || code == 0xFF {
+			encoded[codeIndex] = code
+			codeIndex, code = len(encoded), 1
+			encoded = append(encoded, 0)
+		}
+	}
+
+	encoded[codeIndex] = code
+
+	return encoded
+}
+
+func cobsDecode(encoded []byte) ([]byte, error) {
+	decoded := make([]byte, 0, len(encoded))
+
+	for idx := 0; idx < len(encoded); {
+		code := int(encoded[idx])
+		if code == 0 || idx+code > len(encoded) {
+			return nil, errBadFrame
+		}
+
+		decoded = append(decoded, encoded[idx+1:idx+code]...)
+		idx += code
+
+		if code < 0xFF && idx < len(encoded) {
+			decoded = append(decoded, 0)
+		}
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,159 @@
+package deej
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCOBSRoundTrip(t *testing.T) {
+	run := func(length int, fill byte) []byte {
+		return bytes.Repeat([]byte{fill}, length)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"single zero", []byte{0}},
+		{"zeros only", []byte{0, 0, 0}},
+		{"no zeros", []byte("0|512|1023")},
+		{"zeros in between", []byte{0x01, 0x00, 0x02, 0x00, 0x00, 0x03}},
+		{"leading and trailing zeros", []byte{0x00, 0x11, 0x22, 0x00}},
+		{"253 non-zero bytes", run(253, 0x42)},
+		{"254 non-zero bytes", run(254, 0x42)},
+		{"255 non-zero bytes", run(255, 0x42)},
+		{"600 non-zero bytes", run(600, 0x42)},
+		{"254 non-zero bytes then a zero", append(run(254, 0x42), 0)},
+		{"zero then 254 non-zero bytes", append([]byte{0}, run(254, 0x42)...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded := cobsEncode(test.data)
+
+			if bytes.IndexByte(encoded, 0) != -1 {
+				t.Fatalf("encoded %x has a zero byte in it", encoded)
+			}
+
+			decoded, err := cobsDecode(encoded)
+			if err != nil {
+				t.Fatalf("decode %x: %v", encoded, err)
+			}
+
+			if !bytes.Equal(decoded, test.data) {
+				t.Errorf("round trip of %x came back as %x", test.data, decoded)
+			}
+		})
+	}
+}
+
+func TestCOBSDecodeRejectsMalformedFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded []byte
+	}{
+		{"zero code", []byte{0x00, 0x01}},
+		{"zero code after a block", []byte{0x02, 0x11, 0x00}},
+		{"code past the end", []byte{0x05, 0x11, 0x22}},
+		{"long block cut short", append([]byte{0xFF}, bytes.Repeat([]byte{0x42}, 100)...)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if decoded, err := cobsDecode(test.encoded); err == nil {
+				t.Errorf("cobsDecode(%x) = %x, expected an error", test.encoded, decoded)
+			}
+		})
+	}
+}
+
+func TestBinaryProtocolLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		line    string
+	}{
+		{"analog slider 0 at 1023", []byte{0x83, 0xFF}, "1023\n"},
+		{"encoder 0 left", []byte{0x40}, "l\n"},
+		{"encoder 2 button", []byte{0x40 | 2<<3 | 2}, "2:u\n"},
+		{"encoder 1 turned", []byte{0x40 | 1<<3 | 4}, "1:t\n"},
+		{"mute selected", []byte{binaryMuteSelected}, "m\n"},
+		{"mute index", []byte{binaryMuteIndex, 3}, "m:3\n"},
+		{"ping", []byte{binaryPing}, "ping\n"},
+		{"pong", []byte{binaryPong}, "pong\n"},
+		{"text", append([]byte{binaryText}, "lock:1"...), "lock:1\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, err := newBinaryProtocol(checksumNone).line(test.payload)
+			if err != nil {
+				t.Fatalf("line(%x): %v", test.payload, err)
+			}
+
+			if line != test.line {
+				t.Errorf("line(%x) = %q, want %q", test.payload, line, test.line)
+			}
+		})
+	}
+}
+
+func TestBinaryProtocolLineRejectsMalformedPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"analog without its value", []byte{0x80}},
+		{"analog with an extra byte", []byte{0x80, 0x01, 0x02}},
+		{"encoder with an unknown command", []byte{0x40 | 5}},
+		{"encoder with an extra byte", []byte{0x40, 0x00}},
+		{"mute index without an index", []byte{binaryMuteIndex}},
+		{"ping with an extra byte", []byte{binaryPing, 0x00}},
+		{"unknown type", []byte{0x02}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if line, err := newBinaryProtocol(checksumNone).line(test.payload); err == nil {
+				t.Errorf("line(%x) = %q, expected an error", test.payload, line)
+			}
+		})
+	}
+}
+
+func TestBinaryProtocolAnalogWaitsForEverySlider(t *testing.T) {
+	bp := newBinaryProtocol(checksumNone)
+
+	// slider 1 at 512, slider 0 not heard from yet
+	if line, _ := bp.line([]byte{0x80 | 1<<2 | 0x02, 0x00}); line != "" {
+		t.Fatalf("expected no line before slider 0 is heard from, got %q", line)
+	}
+
+	if line, _ := bp.line([]byte{0x80, 0x07}); line != "7|512\n" {
+		t.Errorf("expected %q, got %q", "7|512\n", line)
+	}
+}
+
+func TestBinaryProtocolChecksummedFrames(t *testing.T) {
+	bp := newBinaryProtocol(checksumCRC8)
+
+	frame := bp.encode([]byte("sel:master\n"))
+	if frame[len(frame)-1] != 0 {
+		t.Fatalf("encoded frame %x doesn't end with a zero", frame)
+	}
+
+	line, verified := bp.decode(frame[:len(frame)-1])
+	if line != "sel:master\n" || !verified {
+		t.Errorf("decode = %q, %v, want %q, true", line, verified, "sel:master\n")
+	}
+
+	// flip a bit of the text, the checksum no longer matches
+	payload, _ := cobsDecode(frame[:len(frame)-1])
+	payload[1] ^= 0x01
+
+	if line, verified := bp.decode(cobsEncode(payload)); verified || !strings.HasPrefix(line, "binary:") {
+		t.Errorf("decode of a corrupted frame = %q, %v, expected it refused", line, verified)
+	}
+}
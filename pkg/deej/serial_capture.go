@@ -0,0 +1,250 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// capture files have a line per chunk of bytes, with the seconds since the capture started, the direction
+// ("<" read from the board, ">" written to it) and the bytes as a quoted string, and a line per opened port:
+//
+//	0.000000 open COM4
+//	0.012702 < "0|512|1023\n"
+//	0.015113 > "deej:hello\n"
+//
+// lines starting with "#" are comments
+const (
+	captureRead  = "<"
+	captureWrite = ">"
+	captureOpen  = "open"
+)
+
+// serialCapture records the raw bytes going over serial connections to a file, so a board's behavior can be
+// replayed without it (see replayConn). every chunk is written as soon as it's captured, so nothing's lost
+// when deej crashes
+type serialCapture struct {
+	lock    sync.Mutex
+	file    *os.File
+	started time.Time
+}
+
+func newSerialCapture(path string) (*serialCapture, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create capture file: %w", err)
+	}
+
+	started := time.Now()
+	fmt.Fprintf(file, "# deej serial capture, started %s\n", started.Format(time.RFC3339))
+
+	return &serialCapture{file: file, started: started}, nil
+}
+
+func (sc *serialCapture) record(event string, data string) {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	// a connection that's still winding down after the capture was closed has nothing left to add
+	if sc.file == nil {
+		return
+	}
+
+	fmt.Fprintf(sc.file, "%.6f %s %s\n", time.Since(sc.started).Seconds(), event, data)
+}
+
+// close gets the capture onto the disk and closes its file, once deej is done with the board
+func (sc *serialCapture) close() error {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	if sc.file == nil {
+		return nil
+	}
+
+	file := sc.file
+	sc.file = nil
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync capture file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close capture file: %w", err)
+	}
+
+	return nil
+}
+
+// wrap returns a connection that captures everything read from and written to the given one
+func (sc *serialCapture) wrap(conn io.ReadWriteCloser, port string) io.ReadWriteCloser {
+	sc.record(captureOpen, port)
+
+	return &capturingConn{ReadWriteCloser: conn, capture: sc}
+}
+
+type capturingConn struct {
+	io.ReadWriteCloser
+	capture *serialCapture
+}
+
+func (cc *capturingConn) Read(p []byte) (int, error) {
+	n, err := cc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		cc.capture.record(captureRead, strconv.Quote(string(p[:n])))
+	}
+
+	return n, err
+}
+
+func (cc *capturingConn) Write(p []byte) (int, error) {
+	n, err := cc.ReadWriteCloser.Write(p)
+	if n > 0 {
+		cc.capture.record(captureWrite, strconv.Quote(string(p[:n])))
+	}
+
+	return n, err
+}
+
+type captureChunk struct {
+	at   time.Duration
+	data []byte
+}
+
+// replayConn stands in for a board's serial port, playing back the bytes a capture read from it with the same
+// timing (and chunking). what deej writes is thrown away. once everything was played back, reads wait for the
+// connection to close, so deej doesn't take the end of the capture for a lost board and start over
+type replayConn struct {
+	logger *zap.SugaredLogger
+	chunks []captureChunk
+
+	started time.Time
+	next    int
+	closed  chan bool
+	once    sync.Once
+}
+
+func openReplay(logger *zap.SugaredLogger, path string) (*replayConn, error) {
+	chunks, err := readCapture(path)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Infow("Replaying capture", "path", path, "chunks", len(chunks))
+
+	return &replayConn{
+		logger:  logger,
+		chunks:  chunks,
+		started: time.Now(),
+		closed:  make(chan bool),
+	}, nil
+}
+
+// readCapture returns the chunks a capture read from its boards, timed from the first of them
+func readCapture(path string) ([]captureChunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open capture file: %w", err)
+	}
+	defer file.Close()
+
+	chunks := []captureChunk{}
+	first := -1.0
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("parse capture line %d: expected time, event and data", lineNumber)
+		}
+
+		if fields[1] != captureRead {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse capture line %d time: %w", lineNumber, err)
+		}
+
+		data, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parse capture line %d data: %w", lineNumber, err)
+		}
+
+		if first < 0 {
+			first = seconds
+		}
+
+		chunks = append(chunks, captureChunk{
+			at:   time.Duration((seconds - first) * float64(time.Second)),
+			data: []byte(data),
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read capture file: %w", err)
+	}
+
+	return chunks, nil
+}
+
+func (rc *replayConn) Read(p []byte) (int, error) {
+	if rc.next >= len(rc.chunks) {
+		if rc.next == len(rc.chunks) {
+			rc.logger.Info("Finished replaying capture")
+			rc.next++
+		}
+
+		<-rc.closed
+		return 0, io.EOF
+	}
+
+	chunk := &rc.chunks[rc.next]
+
+	select {
+	case <-time.After(time.Until(rc.started.Add(chunk.at))):
+	case <-rc.closed:
+		return 0, io.EOF
+	}
+
+	// the reader may ask for less than a whole chunk, the rest comes with the next read
+	n := copy(p, chunk.data)
+	chunk.data = chunk.data[n:]
+
+	if len(chunk.data) == 0 {
+		rc.next++
+	}
+
+	return n, nil
+}
+
+func (rc *replayConn) Write(p []byte) (int, error) {
+	select {
+	case <-rc.closed:
+		return 0, errors.New("replay closed")
+	default:
+		return len(p), nil
+	}
+}
+
+func (rc *replayConn) Close() error {
+	rc.once.Do(func() { close(rc.closed) })
+	return nil
+}
@@ -0,0 +1,70 @@
+package deej
+
+import (
+	"regexp"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// line checksum modes
+const (
+	checksumNone = "none"
+	checksumCRC8 = "crc8"
+)
+
+// with checksums, the firmware ends every line with "*" and the checksum of everything before it,
+// as two hex digits (i.e. "0|512|1023*41")
+var checksumLinePattern = regexp.MustCompile(`^(.*)\*([0-9A-Fa-f]{2})(\r?\n)$`)
+
+// verifyChecksum checks a line's checksum, and returns the line without it. lines whose checksum is missing or
+// doesn't match were corrupted on the way (i.e. by a long cable or a cheap USB-UART bridge). without a checksum
+// mode, every line passes as it is
+func verifyChecksum(mode string, line string) (string, bool) {
+	if mode != checksumCRC8 {
+		return line, true
+	}
+
+	match := checksumLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		return line, false
+	}
+
+	sent, err := strconv.ParseUint(match[2], 16, 8)
+	if err != nil || byte(sent) != crc8([]byte(match[1])) {
+		return line, false
+	}
+
+	return match[1] + match[3], true
+}
+
+// crc8 is CRC-8/SMBUS (polynomial 0x07, no reflection, starting from 0), which takes a few lines on an Arduino
+func crc8(data []byte) byte {
+	crc := byte(0)
+
+	for _, b := range data {
+		crc ^= b
+
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// validChecksum puts a checksum mode deej doesn't know back to none, and complains about it
+func validChecksum(logger *zap.SugaredLogger, mode string) string {
+	switch mode {
+	case "", checksumNone, checksumCRC8:
+		return mode
+	}
+
+	logger.Warnw("Invalid checksum mode, not checking lines", "checksum", mode, "valid", []string{checksumNone, checksumCRC8})
+
+	return ""
+}
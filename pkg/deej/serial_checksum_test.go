@@ -0,0 +1,56 @@
+package deej
+
+import "testing"
+
+func TestCRC8(t *testing.T) {
+	tests := []struct {
+		data string
+		crc  byte
+	}{
+		{"", 0x00},
+
+		// CRC-8/SMBUS' check value
+		{"123456789", 0xF4},
+
+		// the README's example line
+		{"0|512|1023", 0x41},
+	}
+
+	for _, test := range tests {
+		t.Run(test.data, func(t *testing.T) {
+			if crc := crc8([]byte(test.data)); crc != test.crc {
+				t.Errorf("crc8(%q) = %#02x, want %#02x", test.data, crc, test.crc)
+			}
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		line string
+		want string
+		ok   bool
+	}{
+		{"stripped", checksumCRC8, "0|512|1023*41\n", "0|512|1023\n", true},
+		{"lowercase digits", checksumCRC8, "123456789*f4\n", "123456789\n", true},
+		{"carriage return kept", checksumCRC8, "0|512|1023*41\r\n", "0|512|1023\r\n", true},
+		{"wrong checksum", checksumCRC8, "0|512|1023*42\n", "0|512|1023*42\n", false},
+		{"garbled line", checksumCRC8, "0|512|1033*41\n", "0|512|1033*41\n", false},
+		{"missing checksum", checksumCRC8, "0|512|1023\n", "0|512|1023\n", false},
+		{"one digit", checksumCRC8, "0|512|1023*4\n", "0|512|1023*4\n", false},
+		{"not hex", checksumCRC8, "0|512|1023*4G\n", "0|512|1023*4G\n", false},
+		{"no checksum mode", checksumNone, "0|512|1023\n", "0|512|1023\n", true},
+		{"no checksum mode leaves a suffix alone", checksumNone, "0|512|1023*41\n", "0|512|1023*41\n", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			line, ok := verifyChecksum(test.mode, test.line)
+			if line != test.want || ok != test.ok {
+				t.Errorf("verifyChecksum(%q, %q) = %q, %v, want %q, %v", test.mode, test.line, line, ok, test.want, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package deej
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// serialConnections runs the boards from the config's connections list, each with a SerialIO of its own that
+// delivers its events through the main one (like boards that connect on their own do), so consumers see them
+// all as a single stream. Unlike those, these reconnect on their own when they're lost
+type serialConnections struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	lock sync.Mutex
+
+	// the running boards, by connection name
+	boards map[string]*SerialIO
+}
+
+func newSerialConnections(deej *Deej, logger *zap.SugaredLogger) *serialConnections {
+	logger = logger.Named("connections")
+
+	sc := &serialConnections{
+		deej:   deej,
+		logger: logger,
+		boards: map[string]*SerialIO{},
+	}
+
+	logger.Debug("Created serial connections instance")
+
+	return sc
+}
+
+// run connects to the configured boards, and follows the config from there
+func (sc *serialConnections) run() {
+	configReloadedChannel := sc.deej.configManager.SubscribeToChanges()
+
+	sc.apply()
+
+	for range configReloadedChannel {
+
+		// a paused deej connects with whatever the config says by the time it resumes
+		if sc.deej.userSession.isPaused() {
+			continue
+		}
+
+		sc.apply()
+	}
+}
+
+// apply connects to the configured boards that aren't connected yet, and reconnects to those whose
+// connection changed. boards that are no longer configured are let go
+func (sc *serialConnections) apply() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	configured := map[string]SerialConnection{}
+	for _, connection := range sc.deej.configManager.getConnections() {
+		configured[connection.Name] = connection
+	}
+
+	for name, board := range sc.boards {
+		if connection, ok := configured[name]; ok && reflect.DeepEqual(*board.connection, connection) {
+			continue
+		}
+
+		sc.logger.Infow("Letting go of board", "connection", name)
+
+		board.Stop()
+		delete(sc.boards, name)
+	}
+
+	for name, connection := range configured {
+		if _, ok := sc.boards[name]; ok {
+			continue
+		}
+
+		connection := connection
+		board := newBoardIO(sc.deej, sc.logger.Named(name), sc.deej.serial)
+		board.connection = &connection
+		sc.boards[name] = board
+
+		if err := board.Start(); err != nil {
+			sc.logger.Warnw("Failed to connect to board, reconnecting", "connection", name, "error", err)
+			board.reconnect()
+		}
+	}
+}
+
+// stop lets go of every board, i.e. while deej is paused or stopping. apply connects to them again
+func (sc *serialConnections) stop() {
+	sc.lock.Lock()
+	defer sc.lock.Unlock()
+
+	for name, board := range sc.boards {
+		board.Stop()
+		delete(sc.boards, name)
+	}
+}
+
+// validConnections returns the connections deej can use. the sliders they list keep their place (as that's
+// the channel they're on) even when they don't exist, but connections without a name or port are left out
+func validConnections(logger *zap.SugaredLogger, config *Config) []SerialConnection {
+	valid := []SerialConnection{}
+	names := map[string]bool{}
+
+	for idx, connection := range config.Connections {
+		if connection.Name == "" || connection.ConnectionInfo.SerialPort == "" {
+			logger.Warnw("Ignoring connection without a name or serial port", "index", idx)
+			continue
+		}
+
+		if names[connection.Name] {
+			logger.Warnw("Ignoring connection with a name that's taken", "connection", connection.Name)
+			continue
+		}
+
+		names[connection.Name] = true
+
+		for _, key := range connection.Sliders {
+			if mapping, ok := config.SliderMappings[key]; !ok || mapping.Virtual {
+				logger.Warnw("Connection lists a slider that doesn't exist (or is virtual)",
+					"connection", connection.Name,
+					"slider", key)
+			}
+		}
+
+		connection.ConnectionInfo = validFraming(logger, connection.ConnectionInfo)
+		connection.ConnectionInfo.Checksum = validChecksum(logger, connection.ConnectionInfo.Checksum)
+
+		valid = append(valid, connection)
+	}
+
+	return valid
+}
+
+// configuredConnectionInfo returns how the config says to connect to this board
+func (sio *SerialIO) configuredConnectionInfo() ConnectionInfo {
+	if sio.connection != nil {
+		return sio.connection.ConnectionInfo
+	}
+
+	return sio.deej.configManager.Config.ConnectionInfo
+}
+
+// sliderKeyByIndex returns the slider a board's channel drives: one of the connection's own, for boards from
+// the connections list, and one of the hardware sliders no connection drives otherwise
+func (sio *SerialIO) sliderKeyByIndex(index int) (string, error) {
+	if sio.connection == nil {
+		return sio.deej.configManager.getSliderMappingKeyByIndex(index)
+	}
+
+	if index < 0 || index >= len(sio.connection.Sliders) {
+		return "", fmt.Errorf("index %d is out of range", index)
+	}
+
+	return sio.connection.Sliders[index], nil
+}
+
+func (sio *SerialIO) sliderMappingByIndex(index int) (SliderMapping, error) {
+	key, err := sio.sliderKeyByIndex(index)
+	if err != nil {
+		return SliderMapping{}, err
+	}
+
+	return sio.deej.configManager.getSliderMappingByKey(key)
+}
+
+// sliderCount returns how many channels the board has sliders for
+func (sio *SerialIO) sliderCount() int {
+	if sio.connection == nil {
+		return sio.deej.configManager.getSliderMappingCount()
+	}
+
+	return len(sio.connection.Sliders)
+}
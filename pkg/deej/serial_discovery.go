@@ -0,0 +1,169 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// with serial_port set to this, deej finds the board by itself (and finds it again when it's replugged)
+const autoSerialPort = "auto"
+
+const (
+
+	// how long a port has to answer our hello (or say anything deej-like) before we move on to the next one.
+	// boards that reset when the port opens need a good part of this to boot
+	discoveryProbeTimeout = 3 * time.Second
+
+	// the hello is repeated in case the first one arrived while the board was still booting
+	discoveryHelloInterval = 500 * time.Millisecond
+
+	// how often to look for a board when none was found (or it was unplugged)
+	discoveryRetryInterval = 2 * time.Second
+)
+
+var errNoBoardFound = errors.New("serial: no board running deej firmware found")
+
+// discoverPort probes every serial port for a board running deej firmware, and returns a transport that's
+// already connected to the first one that answers. the port that was found last time goes first,
+// since that's where the board most likely still is
+func (sio *SerialIO) discoverPort(connectionInfo ConnectionInfo) (Transport, error) {
+	ports, err := util.ListSerialPorts()
+	if err != nil {
+		return nil, fmt.Errorf("list serial ports: %w", err)
+	}
+
+	for idx, port := range ports {
+		if port == sio.discoveredPort {
+			ports[0], ports[idx] = ports[idx], ports[0]
+		}
+	}
+
+	for _, port := range ports {
+		if transport := sio.probePort(port, connectionInfo); transport != nil {
+			sio.logger.Infow("Found board", "port", port)
+			sio.discoveredPort = port
+
+			return transport, nil
+		}
+	}
+
+	sio.logger.Debugw("No board found", "probedPorts", len(ports))
+
+	return nil, errNoBoardFound
+}
+
+// probePort checks whether the board on the given port runs deej firmware. firmware that supports discovery
+// answers our hello with its ID, but any line that looks like deej's (i.e. a knob being turned) counts too.
+// if it does, the port is kept open and handed back as a connected transport - reopening it could reset the board.
+// every port is opened the way the config describes the board's (baud rate, framing)
+func (sio *SerialIO) probePort(port string, connectionInfo ConnectionInfo) Transport {
+	logger := sio.logger.Named("discovery")
+
+	connectionInfo.SerialPort = port
+	transport := newSerialTransport(logger, connectionInfo)
+	transport.capture = sio.deej.capture
+
+	if err := transport.Connect(); err != nil {
+		logger.Debugw("Skipping port that can't be opened", "port", port, "error", err)
+		return nil
+	}
+
+	probed := &probedTransport{
+		Transport: transport,
+		lines:     make(chan TransportLine),
+	}
+
+	go func() {
+		probed.readErr = transport.ReadLines(probed.lines)
+		close(probed.lines)
+	}()
+
+	timeout := time.NewTimer(discoveryProbeTimeout)
+	defer timeout.Stop()
+
+	hello := time.NewTicker(discoveryHelloInterval)
+	defer hello.Stop()
+
+	sio.writeHello(logger, transport)
+
+	for {
+		select {
+		case line, ok := <-probed.lines:
+			if !ok {
+				logger.Debugw("Port closed while probing", "port", port)
+				return nil
+			}
+
+			if text, ok := verifyChecksum(connectionInfo.Checksum, line.Text); (ok || line.Verified) && isDeejLine(text) {
+				logger.Debugw("Port answered like a deej board", "port", port, "line", line.Text)
+				probed.first = &line
+
+				return probed
+			}
+
+		case <-hello.C:
+			sio.writeHello(logger, transport)
+
+		case <-timeout.C:
+			logger.Debugw("Port didn't answer", "port", port)
+
+			// closing the port doesn't necessarily interrupt a pending read (and waits for it), so neither
+			// the close nor the reader can be waited on. don't leave the reader hanging on a line nobody takes
+			go func() {
+				if err := transport.Close(); err != nil {
+					logger.Debugw("Failed to close probed port", "port", port, "error", err)
+				}
+			}()
+
+			go func() {
+				for range probed.lines {
+				}
+			}()
+
+			return nil
+		}
+	}
+}
+
+// probedTransport is a transport that discovery already connected to and started reading from
+type probedTransport struct {
+	Transport
+
+	// the line the board answered with, which is delivered before all others
+	first   *TransportLine
+	lines   chan TransportLine
+	readErr error
+}
+
+// Connect does nothing, since the transport is connected already
+func (pt *probedTransport) Connect() error {
+	return nil
+}
+
+// ReadLines takes over the lines read since probing
+func (pt *probedTransport) ReadLines(lines chan<- TransportLine) error {
+	if pt.first != nil {
+		lines <- *pt.first
+	}
+
+	for line := range pt.lines {
+		lines <- line
+	}
+
+	return pt.readErr
+}
+
+func isDeejLine(line string) bool {
+	return expectedLinePattern.MatchString(line) ||
+		analogLinePattern.MatchString(line) ||
+		positionLinePattern.MatchString(line) ||
+		muteLinePattern.MatchString(line) ||
+		handshakeLinePattern.MatchString(line) ||
+		heartbeatLinePattern.MatchString(line) ||
+		binaryOfferLinePattern.MatchString(line) ||
+		targetLinePattern.MatchString(line) ||
+		profileLinePattern.MatchString(line)
+}
@@ -0,0 +1,328 @@
+package deej
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotary encoder lines: a single command, optionally addressed to one of several encoders by its ID
+// (e.g. "r", "2:l"). unaddressed lines are meant for encoder 0, so single encoder firmware works as it always has
+var expectedLinePattern = regexp.MustCompile(`^(?:(\d{1,2}):)?([lrudt])\r?\n$`)
+
+// EncoderState tracks the board's rotary encoders: which slider each one is on, and whether its button is held.
+// It's owned by a SerialIO, and safe to read from anywhere through its accessors
+type EncoderState struct {
+	lock     sync.Mutex
+	encoders map[int]*encoder
+}
+
+// Encoder is a snapshot of a single encoder's state
+type Encoder struct {
+	ID          int
+	SliderIndex int
+	SliderName  string
+	Held        bool
+}
+
+// encoder is a single encoder's live state, only touched with the EncoderState's lock held
+type encoder struct {
+	sliderIndex  int
+	sliderName   string
+	held         bool
+	wantedValue  float32
+	needToUpdate bool
+
+	// telling the button's gestures apart (see serial_gestures.go)
+	buttonChangedAt time.Time
+	turnedWhileHeld bool
+	longPressed     bool
+	longPressTimer  *time.Timer
+	clickTimer      *time.Timer
+}
+
+func newEncoderState() *EncoderState {
+	return &EncoderState{encoders: map[int]*encoder{}}
+}
+
+// Get returns the state of the encoder with the given ID, and whether the board has used it yet
+func (es *EncoderState) Get(id int) (Encoder, bool) {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	enc, ok := es.encoders[id]
+	if !ok {
+		return Encoder{}, false
+	}
+
+	return enc.snapshot(id), true
+}
+
+// All returns the state of every encoder the board has used so far, ordered by ID
+func (es *EncoderState) All() []Encoder {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	all := make([]Encoder, 0, len(es.encoders))
+	for id, enc := range es.encoders {
+		all = append(all, enc.snapshot(id))
+	}
+
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+
+	return all
+}
+
+// Selections returns the name of the slider each encoder is on, by the encoder's ID
+func (es *EncoderState) Selections() map[int]string {
+	es.lock.Lock()
+	defer es.lock.Unlock()
+
+	selected := make(map[int]string, len(es.encoders))
+	for id, enc := range es.encoders {
+		selected[id] = enc.sliderName
+	}
+
+	return selected
+}
+
+func (enc *encoder) snapshot(id int) Encoder {
+	return Encoder{
+		ID:          id,
+		SliderIndex: enc.sliderIndex,
+		SliderName:  enc.sliderName,
+		Held:        enc.held,
+	}
+}
+
+// Encoders returns the state of the board's rotary encoders
+func (sio *SerialIO) Encoders() *EncoderState {
+	return sio.encoders
+}
+
+// encoderByID returns an encoder's state, starting it off on the slider with the same index as its ID
+// (clamped to the slider count) so that several encoders control several sliders right away.
+// assumes the encoder state's lock is held
+func (sio *SerialIO) encoderByID(id int) *encoder {
+	if enc, ok := sio.encoders.encoders[id]; ok {
+		return enc
+	}
+
+	index := id
+	if count := sio.sliderCount(); index >= count && count > 0 {
+		index = count - 1
+	}
+
+	if restored, ok := sio.restoredIndex(id); ok {
+		index = restored
+	}
+
+	enc := &encoder{sliderIndex: index}
+	enc.sliderName, _ = sio.sliderKeyByIndex(index)
+
+	sio.encoders.encoders[id] = enc
+
+	return enc
+}
+
+// selectSlider puts the given encoder on the slider with the given key, as if it had been turned there
+func (sio *SerialIO) selectSlider(id int, key string) error {
+	sio.encoders.lock.Lock()
+	enc := sio.encoderByID(id)
+
+	found := false
+	for idx := 0; idx < sio.sliderCount(); idx++ {
+		if name, _ := sio.sliderKeyByIndex(idx); name == key {
+			enc.sliderIndex = idx
+			enc.sliderName = name
+			found = true
+
+			break
+		}
+	}
+
+	sio.encoders.lock.Unlock()
+
+	if !found {
+		return fmt.Errorf("slider %s has no channel on the board", key)
+	}
+
+	sio.deej.feedback.stateChanged()
+
+	return nil
+}
+
+// selectedIndex returns the index of the slider the given encoder is on
+func (sio *SerialIO) selectedIndex(id int) int {
+	sio.encoders.lock.Lock()
+	defer sio.encoders.lock.Unlock()
+
+	return sio.encoderByID(id).sliderIndex
+}
+
+// handleEncoderLine acts on a rotary encoder line: turning moves the encoder's slider, and turning while
+// the button is held selects another slider for it. pressing the button without turning makes a gesture instead
+func (sio *SerialIO) handleEncoderLine(logger *zap.SugaredLogger, line string, readAt time.Time) {
+	match := expectedLinePattern.FindStringSubmatch(line)
+
+	id := 0
+	if match[1] != "" {
+		id, _ = strconv.Atoi(match[1])
+	}
+
+	command := match[2]
+
+	if id != 0 {
+		logger = logger.With("encoder", id)
+	}
+
+	// if anyone's waiting on a raw encoder turn, hand it over instead of acting on it
+	if sio.captureDirection(id, command) {
+		return
+	}
+
+	// cheap buttons bounce, and a single press can come through as several
+	if (command == "d" || command == "u") && sio.buttonBounced(id, command == "d", readAt) {
+		logger.Debugw("Ignoring button bounce", "command", command)
+		return
+	}
+
+	// flip the encoder's direction if it's mounted (or wired) the other way around
+	if sliderID, _ := sio.sliderKeyByIndex(sio.selectedIndex(id)); sio.invertSlider(sliderID) {
+		command = invertEncoderDirection(command)
+	}
+	// logger.Debugf("Got input '%s'", command)
+
+	sio.encoders.lock.Lock()
+	encoder := sio.encoderByID(id)
+
+	// the board may want to show which slider is selected, so tell it when that changes
+	previousSliderName := encoder.sliderName
+
+	// set when releasing the button completes a gesture
+	gesture := ""
+
+	// Initial fetch to avoid 0 value by default.
+	// if needToFetchCurrentLevel {
+	// 	currentValue = sio.currentSliderPercentValues[currentSlider]
+	// 	needToFetchCurrentLevel = false
+	// }
+	switch command {
+	case encoderDirectionLeft:
+		if encoder.held {
+			logger.Debug("Channel previous")
+			encoder.buttonTurned()
+			encoder.sliderIndex--
+			if encoder.sliderIndex < 0 {
+				encoder.sliderIndex = 0
+			}
+			sliderMapping, _ := sio.sliderMappingByIndex(encoder.sliderIndex)
+			encoder.wantedValue = sliderMapping.Volume
+
+			encoder.sliderName, _ = sio.sliderKeyByIndex(encoder.sliderIndex)
+			logger.Debugf("Channel: %d %s", encoder.sliderIndex, encoder.sliderName)
+		} else {
+			sliderMapping, _ := sio.deej.configManager.getSliderMappingByKey(encoder.sliderName)
+			encoder.wantedValue = sio.quantize(sliderMapping.Volume - sio.tickSize())
+			encoder.needToUpdate = true
+			logger.Debugf("Lowering slider %d %s volume %.2f", encoder.sliderIndex, encoder.sliderName, encoder.wantedValue)
+		}
+	case encoderDirectionRight:
+		if encoder.held {
+			logger.Debug("Channel next")
+			encoder.buttonTurned()
+			encoder.sliderIndex++
+			// why was 1024 specifically hardcoded originally in deej?
+			if encoder.sliderIndex > 1024 {
+				encoder.sliderIndex = 1024
+			}
+			sliderMappingCount := sio.sliderCount()
+			if encoder.sliderIndex >= sliderMappingCount {
+				encoder.sliderIndex = max(sliderMappingCount-1, 0)
+			}
+
+			sliderMapping, _ := sio.sliderMappingByIndex(encoder.sliderIndex)
+			encoder.wantedValue = sliderMapping.Volume
+
+			encoder.sliderName, _ = sio.sliderKeyByIndex(encoder.sliderIndex)
+			logger.Debugf("Channel: %d %s", encoder.sliderIndex, encoder.sliderName)
+		} else {
+			sliderMapping, _ := sio.deej.configManager.getSliderMappingByKey(encoder.sliderName)
+			encoder.wantedValue = sio.quantize(sliderMapping.Volume + sio.tickSize())
+			encoder.needToUpdate = true
+			logger.Debugf("Raising slider %d %s volume %.2f", encoder.sliderIndex, encoder.sliderName, encoder.wantedValue)
+		}
+	case "d":
+		logger.Debug("Selecting channel")
+		encoder.held = true
+		// logger.Debugf("Num sliders %d", len(sio.deej.config.SliderMapping))
+		keys, _ := sio.deej.configManager.getSliderMappingKeys()
+		logger.Debugf("Sliders %+s", keys)
+
+		encoder.needToUpdate = false
+		sio.buttonPressed(logger, id, encoder)
+	case "t":
+		logger.Debug("Switching to next forward target")
+		if err := sio.deej.remote.nextTarget(); err != nil {
+			logger.Warnw("Failed to switch forward target", "error", err)
+		}
+	case "u":
+		logger.Debug("Selecting volume")
+		encoder.held = false
+		// TODO - get current value and assign to both so it doesn't reset
+		// TODO - get average of values?
+		encoder.needToUpdate = false
+		encoder.sliderName, _ = sio.sliderKeyByIndex(encoder.sliderIndex)
+		gesture = sio.buttonReleased(logger, id, encoder)
+		// currentValue = sio.deej.serial.currentSliderPercentValues[currentSlider]
+
+	default:
+		logger.Warnf("Unhandled input \"%s\"", strings.TrimSpace(line))
+	}
+
+	// for each slider:
+	moveEvents := []SliderMoveEvent{}
+
+	sliderMapping, _ := sio.sliderMappingByIndex(encoder.sliderIndex)
+	if encoder.needToUpdate && encoder.sliderName != "" && (encoder.wantedValue != sliderMapping.Volume) {
+		moveEvent := SliderMoveEvent{
+			SliderID:     encoder.sliderName,
+			PercentValue: encoder.wantedValue,
+		}
+
+		if sio.deej.configManager.getTraceLatency() {
+			moveEvent.trace = &latencyTrace{readAt: readAt, parsedAt: time.Now()}
+		}
+
+		moveEvents = append(moveEvents, moveEvent)
+		// sio.deej.config.Config.SliderMappings[currentSlider].Volume = wantedValue
+	}
+
+	selectionChanged := encoder.sliderName != previousSliderName
+	sio.encoders.lock.Unlock()
+
+	if selectionChanged {
+		sio.deej.feedback.stateChanged()
+	}
+
+	if gesture != "" {
+		sio.handleGesture(logger, id, gesture)
+	}
+
+	if sio.deej.Verbose() {
+		for _, event := range moveEvents {
+			logger.Debugw("Slider moved", "event", event)
+		}
+	}
+
+	// deliver move events if there are any, towards all potential consumers
+	for _, moveEvent := range moveEvents {
+		sio.dispatchBoardSliderMove(logger, moveEvent)
+	}
+}
@@ -0,0 +1,191 @@
+package deej
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// encoder buttons do more than select channels: pressing and releasing one without turning it is a click,
+// which moves the encoder on to the next slider, two of those in a row toggle the slider's mute, and holding
+// the button down is a long press, which switches to the next profile (or, if the config says so, locks the
+// slider). the encoder firmware only sends "d" and "u", so it's all down to timing
+const (
+	ButtonGestureClick       = "click"
+	ButtonGestureDoubleClick = "double_click"
+	ButtonGestureLongPress   = "long_press"
+
+	// what a long press can do
+	longPressProfile = "profile"
+	longPressLock    = "lock"
+
+	defaultDoubleClickMs = 300
+	defaultLongPressMs   = 800
+
+	// cheap buttons bounce for a few milliseconds, which nobody can press and release in
+	defaultDebounceMs = 25
+)
+
+// ButtonEvent represents a gesture made with an encoder's button, on the slider the encoder was on
+type ButtonEvent struct {
+	Encoder  int
+	SliderID string
+	Gesture  string
+}
+
+// SubscribeToButtonEvents returns a subscription that receives
+// a ButtonEvent struct every time an encoder's button is clicked, double clicked or long pressed
+func (sio *SerialIO) SubscribeToButtonEvents(priority ConsumerPriority) *Subscription[ButtonEvent] {
+	return sio.events.buttons.subscribe(priority)
+}
+
+// buttonBounced tells whether the given encoder's button going down (or up) is bounce rather than a press
+// (or release): either the button already is that way, or it changed too recently for anyone to have done it
+func (sio *SerialIO) buttonBounced(id int, pressed bool, at time.Time) bool {
+	debounce := time.Duration(sio.deej.configManager.getButtonGestures().DebounceMs) * time.Millisecond
+
+	sio.encoders.lock.Lock()
+	defer sio.encoders.lock.Unlock()
+
+	enc := sio.encoderByID(id)
+	if enc.held == pressed || at.Sub(enc.buttonChangedAt) < debounce {
+		return true
+	}
+
+	enc.buttonChangedAt = at
+
+	return false
+}
+
+// buttonPressed starts timing a press of the given encoder's button, which turns into a long press unless
+// it's released (or turned) first. assumes the encoder state's lock is held
+func (sio *SerialIO) buttonPressed(logger *zap.SugaredLogger, id int, enc *encoder) {
+	enc.stopLongPress()
+	enc.turnedWhileHeld = false
+	enc.longPressed = false
+
+	longPress := time.Duration(sio.deej.configManager.getButtonGestures().LongPressMs) * time.Millisecond
+
+	var timer *time.Timer
+	timer = time.AfterFunc(longPress, func() {
+		sio.encoders.lock.Lock()
+
+		// released, turned or pressed again in the meantime
+		if enc.longPressTimer != timer {
+			sio.encoders.lock.Unlock()
+			return
+		}
+
+		enc.longPressTimer = nil
+		enc.longPressed = true
+		sio.encoders.lock.Unlock()
+
+		sio.handleGesture(logger, id, ButtonGestureLongPress)
+	})
+
+	enc.longPressTimer = timer
+}
+
+// buttonReleased tells what the press that just ended was, and returns the gesture if it's already known.
+// a first click isn't known until the double click window passes without another one, so it's handled
+// from a timer instead. assumes the encoder state's lock is held
+func (sio *SerialIO) buttonReleased(logger *zap.SugaredLogger, id int, enc *encoder) string {
+	enc.stopLongPress()
+
+	// turning while held selects a channel, and a long press was handled while the button was still down
+	if enc.turnedWhileHeld || enc.longPressed {
+		return ""
+	}
+
+	if enc.clickTimer != nil {
+		enc.clickTimer.Stop()
+		enc.clickTimer = nil
+
+		return ButtonGestureDoubleClick
+	}
+
+	doubleClick := time.Duration(sio.deej.configManager.getButtonGestures().DoubleClickMs) * time.Millisecond
+
+	var timer *time.Timer
+	timer = time.AfterFunc(doubleClick, func() {
+		sio.encoders.lock.Lock()
+
+		// the second click of a double click got here first
+		if enc.clickTimer != timer {
+			sio.encoders.lock.Unlock()
+			return
+		}
+
+		enc.clickTimer = nil
+		sio.encoders.lock.Unlock()
+
+		sio.handleGesture(logger, id, ButtonGestureClick)
+	})
+
+	enc.clickTimer = timer
+
+	return ""
+}
+
+// buttonTurned marks the held button as selecting a channel, rather than making a gesture.
+// assumes the encoder state's lock is held
+func (enc *encoder) buttonTurned() {
+	enc.turnedWhileHeld = true
+	enc.stopLongPress()
+}
+
+func (enc *encoder) stopLongPress() {
+	if enc.longPressTimer != nil {
+		enc.longPressTimer.Stop()
+		enc.longPressTimer = nil
+	}
+}
+
+// handleGesture acts on a button gesture and lets all consumers know about it
+func (sio *SerialIO) handleGesture(logger *zap.SugaredLogger, id int, gesture string) {
+	sio.encoders.lock.Lock()
+	enc := sio.encoderByID(id)
+	sliderID := enc.sliderName
+
+	// a click moves the encoder on to the next slider, and from the last one back around to the first
+	selectionChanged := false
+	if count := sio.sliderCount(); gesture == ButtonGestureClick && count > 0 {
+		enc.sliderIndex = (enc.sliderIndex + 1) % count
+		enc.sliderName, _ = sio.sliderKeyByIndex(enc.sliderIndex)
+		selectionChanged = enc.sliderName != sliderID
+	}
+
+	sio.encoders.lock.Unlock()
+
+	logger.Debugw("Button gesture", "gesture", gesture, "slider", sliderID)
+
+	switch gesture {
+	case ButtonGestureClick:
+		if selectionChanged {
+			sio.deej.feedback.stateChanged()
+		}
+	case ButtonGestureDoubleClick:
+		if !sio.lockedOut(logger, sliderID) {
+			sio.toggleMute(logger, sliderID)
+		}
+	case ButtonGestureLongPress:
+		if sio.deej.userSession.ignoresInput() {
+			break
+		}
+
+		if sio.deej.configManager.getButtonGestures().LongPress == longPressLock {
+			if _, err := sio.deej.toggleSliderLock(sliderID); err != nil {
+				logger.Warnw("Failed to toggle slider lock", "slider", sliderID, "error", err)
+			}
+
+			break
+		}
+
+		// without profiles, there's nothing to switch between, and a long press is only for consumers
+		if _, profiles := sio.deej.configManager.getProfiles(); len(profiles) > 1 {
+			sio.deej.cycleProfile(logger, 1)
+		}
+	}
+
+	sio.eventHub().events.buttons.publish(ButtonEvent{Encoder: id, SliderID: sliderID, Gesture: gesture})
+}
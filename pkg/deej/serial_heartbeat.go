@@ -0,0 +1,89 @@
+package deej
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// how many pings go out per heartbeat timeout, so a single lost one doesn't make a board look wedged
+const heartbeatPingsPerTimeout = 3
+
+// boards may ping deej too ("ping", answered with "pong"), and answer deej's own pings with "pong"
+var heartbeatLinePattern = regexp.MustCompile(`^(ping|pong)\r?\n$`)
+
+// heartbeat notices a board that's still connected but stopped sending anything, i.e. because its firmware is
+// stuck. any line counts as a sign of life, and deej pings boards that might otherwise be quiet for a while
+type heartbeat struct {
+	timeout  time.Duration
+	ticker   *time.Ticker
+	lastLine time.Time
+}
+
+// startHeartbeat returns the connection's heartbeat, which does nothing unless the config sets a timeout.
+// it only covers the serial connection, since boards that connect on their own also reconnect on their own
+func (sio *SerialIO) startHeartbeat() *heartbeat {
+	hb := &heartbeat{
+		timeout:  time.Duration(sio.connectionInfo.HeartbeatTimeout) * time.Second,
+		lastLine: time.Now(),
+	}
+
+	if hb.timeout > 0 && sio.hub == nil {
+		hb.ticker = time.NewTicker(hb.timeout / heartbeatPingsPerTimeout)
+	}
+
+	return hb
+}
+
+// C ticks whenever it's time to check on the board, and never without a timeout
+func (hb *heartbeat) C() <-chan time.Time {
+	if hb.ticker == nil {
+		return nil
+	}
+
+	return hb.ticker.C
+}
+
+func (hb *heartbeat) seen(at time.Time) {
+	hb.lastLine = at
+}
+
+func (hb *heartbeat) silence() time.Duration {
+	return time.Since(hb.lastLine)
+}
+
+func (hb *heartbeat) stale() bool {
+	return hb.ticker != nil && hb.silence() > hb.timeout
+}
+
+func (hb *heartbeat) stop() {
+	if hb.ticker != nil {
+		hb.ticker.Stop()
+	}
+}
+
+// handleHeartbeatLine answers a board's ping. pongs need no answer, they only show the board is alive
+func (sio *SerialIO) handleHeartbeatLine(logger *zap.SugaredLogger, kind string) {
+	if kind != "ping" {
+		return
+	}
+
+	if err := sio.Write([]byte("pong\n")); err != nil {
+		logger.Debugw("Failed to answer board's ping", "error", err)
+	}
+}
+
+// dropStale gives up on a board that stopped responding. closing its port can block until the board sends
+// something (which it won't), so it may be left to close in the background
+func (sio *SerialIO) dropStale(logger *zap.SugaredLogger, lineChannel chan TransportLine) {
+	name := sio.transport.Name()
+	sio.closeWithTimeout(logger, lineChannel, "board stopped responding")
+
+	if !sio.quiet {
+		sio.lostConnection = true
+		sio.connectionNotices.notify("Board stopped responding",
+			fmt.Sprintf("deej lost contact with %s, and is reconnecting.", name))
+	}
+}
@@ -0,0 +1,104 @@
+package deej
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+const (
+
+	// how many raw lines are kept around for bug reports
+	serialHistorySize = 200
+
+	serialHistoryFilename = "deej-serial-lines.log"
+
+	// how long new lines wait to be written, so a burst of them is written once
+	serialHistoryPersistDelay = 30 * time.Second
+)
+
+type serialHistoryLine struct {
+	text   string
+	readAt time.Time
+}
+
+// serialHistory keeps the most recent raw lines read from the board, exactly as they came in
+type serialHistory struct {
+	lock    sync.Mutex
+	lines   []serialHistoryLine
+	changed bool
+
+	// signaled whenever a line is recorded
+	recorded chan bool
+}
+
+func newSerialHistory() *serialHistory {
+	return &serialHistory{
+		lines:    make([]serialHistoryLine, 0, serialHistorySize),
+		recorded: make(chan bool, 1),
+	}
+}
+
+func (h *serialHistory) record(text string, readAt time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if len(h.lines) == serialHistorySize {
+		h.lines = h.lines[1:]
+	}
+
+	h.lines = append(h.lines, serialHistoryLine{text: text, readAt: readAt})
+	h.changed = true
+
+	select {
+	case h.recorded <- true:
+	default:
+	}
+}
+
+// persist writes the kept lines to the log directory, if anything changed since it last did
+func (h *serialHistory) persist() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.changed {
+		return nil
+	}
+
+	if err := util.EnsureDirExists(logDirectory); err != nil {
+		return fmt.Errorf("ensure log directory exists: %w", err)
+	}
+
+	// quote lines so garbage and line endings stay visible
+	buf := &bytes.Buffer{}
+	for _, line := range h.lines {
+		fmt.Fprintf(buf, "%s %s\n", line.readAt.Format("2006-01-02 15:04:05.000"), strconv.Quote(line.text))
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(logDirectory, serialHistoryFilename), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("write serial history: %w", err)
+	}
+
+	h.changed = false
+
+	return nil
+}
+
+// persistSerialHistory writes the recent raw serial lines to disk shortly after they come in, so they can go into a report
+func (d *Deej) persistSerialHistory() {
+	for range d.serial.history.recorded {
+		d.wakeups.record("serial_history")
+
+		<-time.After(serialHistoryPersistDelay)
+
+		if err := d.serial.history.persist(); err != nil {
+			d.logger.Warnw("Failed to persist serial history", "error", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package deej
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultReconnectInitialDelay = 1
+	defaultReconnectMaxDelay     = 30
+
+	// every delay is randomly stretched or shortened by up to this much, so several boards (or deej instances)
+	// that went away together don't all come knocking at the same moment
+	reconnectJitter = 0.2
+)
+
+// reconnect gets the board back after losing it, whether it was unplugged, stopped responding or its port failed
+// to open. it tries again and again in the background with growing delays (see Reconnect), until it gets through,
+// the connection is taken care of some other way (i.e. a config change or shutdown), or it runs out of retries.
+// only one runs at a time, and it's set up by the time reconnect returns, so a Stop right after cancels it
+func (sio *SerialIO) reconnect() {
+
+	// boards that connect on their own also reconnect on their own (unlike those from the connections list),
+	// and nothing reconnects while another user's session is in front of the screen
+	if (sio.hub != nil && sio.connection == nil) || sio.deej.userSession.isPaused() {
+		return
+	}
+
+	sio.reconnectLock.Lock()
+	if sio.reconnectCancel != nil {
+		sio.reconnectLock.Unlock()
+		return
+	}
+
+	cancel := make(chan bool)
+	sio.reconnectCancel = cancel
+	sio.reconnectLock.Unlock()
+
+	go sio.retryConnecting(cancel)
+}
+
+// retryConnecting is reconnect's retry loop, which runs until it's done or the given channel is closed
+func (sio *SerialIO) retryConnecting(cancel chan bool) {
+	defer func() {
+		sio.reconnectLock.Lock()
+		if sio.reconnectCancel == cancel {
+			sio.reconnectCancel = nil
+		}
+		sio.reconnectLock.Unlock()
+	}()
+
+	policy := sio.deej.configManager.getReconnect()
+	connectionInfo := sio.configuredConnectionInfo()
+
+	sio.logger.Infow("Board went away, reconnecting",
+		"comPort", connectionInfo.SerialPort,
+		"maxRetries", policy.MaxRetries)
+
+	for attempt := 1; ; attempt++ {
+		delay := reconnectDelay(policy, attempt)
+
+		select {
+		case <-time.After(delay):
+		case <-cancel:
+			sio.logger.Debug("Reconnecting cancelled")
+			return
+		}
+
+		sio.deej.wakeups.record("serial_reconnect")
+
+		// we might have been reconnected (or pointed somewhere else) in the meantime
+		if sio.connected || sio.configuredConnectionInfo() != connectionInfo {
+			return
+		}
+
+		err := sio.Start()
+		if err == nil {
+			sio.logger.Infow("Reconnected to board", "attempts", attempt)
+			return
+		}
+
+		sio.logger.Debugw("Failed to reconnect to board", "attempt", attempt, "waited", delay.Round(time.Millisecond), "error", err)
+
+		if policy.MaxRetries > 0 && attempt >= policy.MaxRetries {
+			sio.logger.Warnw("Giving up on reconnecting to board", "attempts", attempt, "error", err)
+
+			sio.deej.notifier.Notify("Gave up reconnecting",
+				fmt.Sprintf("deej couldn't reconnect to %s after %d attempts. Plug your board back in and restart deej (or change its port in your config) to try again.",
+					connectionInfo.SerialPort, attempt))
+
+			return
+		}
+	}
+}
+
+// reconnecting tells whether a reconnect is running
+func (sio *SerialIO) reconnecting() bool {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	return sio.reconnectCancel != nil
+}
+
+// cancelReconnect stops a running reconnect, i.e. because the connection's being renewed or deej is stopping
+func (sio *SerialIO) cancelReconnect() {
+	sio.reconnectLock.Lock()
+	defer sio.reconnectLock.Unlock()
+
+	if sio.reconnectCancel != nil {
+		close(sio.reconnectCancel)
+		sio.reconnectCancel = nil
+	}
+}
+
+// reconnectDelay is how long to wait before the given attempt: the initial delay, doubled for every attempt
+// before it, up to the max delay, give or take the jitter
+func reconnectDelay(policy Reconnect, attempt int) time.Duration {
+	delay := time.Duration(policy.InitialDelay) * time.Second
+	maxDelay := time.Duration(policy.MaxDelay) * time.Second
+
+	for idx := 1; idx < attempt && delay < maxDelay; idx++ {
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	jitter := 1 + reconnectJitter*(rand.Float64()*2-1)
+
+	return time.Duration(float64(delay) * jitter)
+}
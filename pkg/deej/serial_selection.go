@@ -0,0 +1,79 @@
+package deej
+
+import (
+	"fmt"
+	"math"
+
+	"go.uber.org/zap"
+)
+
+// deej remembers which slider each of the board's encoders was on, so they're back on it after a restart
+// rather than starting over from the first slider. they're kept in the state file by the slider's name, not its
+// index, so reordering or adding sliders in the meantime doesn't put an encoder on the wrong one
+
+// persistSelections saves which slider each encoder is on, for the next run. a board that never used its
+// encoders leaves the previous run's selections alone
+func (sio *SerialIO) persistSelections() error {
+	selected := sio.encoders.Selections()
+	if len(selected) == 0 {
+		return nil
+	}
+
+	sio.deej.configManager.setEncoderSelections(selected)
+
+	return nil
+}
+
+// restoredIndex returns the index of the slider the given encoder was on last time, if it's still around.
+// assumes the encoder state's lock is held
+func (sio *SerialIO) restoredIndex(id int) (int, bool) {
+	name, ok := sio.restoredSelections[id]
+	if !ok {
+		return 0, false
+	}
+
+	for idx := 0; idx < sio.sliderCount(); idx++ {
+		if key, _ := sio.sliderKeyByIndex(idx); key == name {
+			return idx, true
+		}
+	}
+
+	return 0, false
+}
+
+// resumeSelection puts the encoders the last run left somewhere back on their sliders, and tells a board that
+// just connected which slider its (first) encoder is on, along with that slider's volume, so its display picks up
+// where it left off. with board feedback on, the board gets all of that and more anyway
+func (sio *SerialIO) resumeSelection(logger *zap.SugaredLogger, transport Transport) {
+	sio.encoders.lock.Lock()
+
+	for id := range sio.restoredSelections {
+		sio.encoderByID(id)
+	}
+
+	sliderID := ""
+	if enc, ok := sio.encoders.encoders[0]; ok {
+		sliderID = enc.sliderName
+	}
+
+	sio.encoders.lock.Unlock()
+
+	if sliderID == "" || sio.deej.configManager.getBoardFeedback().Enabled {
+		return
+	}
+
+	mapping, err := sio.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return
+	}
+
+	muted := 0
+	if mapping.Muted {
+		muted = 1
+	}
+
+	line := fmt.Sprintf("sel:%s\nvol:%s:%d:%d\n", sliderID, sliderID, int(math.Round(float64(mapping.Volume)*100)), muted)
+	if _, err := transport.Write([]byte(line)); err != nil {
+		logger.Debugw("Failed to resume selection on board", "error", err)
+	}
+}
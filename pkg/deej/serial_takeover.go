@@ -0,0 +1,102 @@
+package deej
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// how close an analog slider must get to its stored volume to take it over, for sliders that jump past it
+const takeoverTolerance = 0.02
+
+// softTakeover holds back an analog slider whose volume changed from under it (i.e. by a profile import),
+// until the physical slider reaches that volume. Otherwise the volume would jump to wherever the slider
+// happens to be the moment it's touched
+type softTakeover struct {
+	volume float32
+
+	// which side of the volume the slider was first seen on: -1 below, 1 above, 0 not seen yet
+	side int
+
+	// when to give up waiting, if ever
+	expires time.Time
+}
+
+// armTakeovers holds back the sliders whose volume the latest config load changed. boards connecting later
+// are held back just the same, since they share the hub's takeovers
+func (sio *SerialIO) armTakeovers(logger *zap.SugaredLogger) {
+
+	// motorized faders are moved to their new volume instead
+	if sio.deej.configManager.getMotorizedFaders() {
+		return
+	}
+
+	sio.takeoverLock.Lock()
+	defer sio.takeoverLock.Unlock()
+
+	for _, key := range sio.deej.configManager.getMovedSliderKeys() {
+		mapping, err := sio.deej.configManager.getSliderMappingByKey(key)
+		if err != nil || mapping.Virtual {
+			continue
+		}
+
+		if sio.takeovers == nil {
+			sio.takeovers = map[string]*softTakeover{}
+		}
+
+		sio.takeovers[key] = &softTakeover{volume: mapping.Volume}
+
+		logger.Debugw("Waiting for slider to take over its new volume", "slider", key, "volume", mapping.Volume)
+	}
+}
+
+// holdUntilReached ignores a slider that's on its way to the given volume (i.e. pushed there by its motor)
+// until it gets there, or for the given timeout at most
+func (sio *SerialIO) holdUntilReached(sliderID string, volume float32, timeout time.Duration) {
+	sio.takeoverLock.Lock()
+	defer sio.takeoverLock.Unlock()
+
+	if sio.takeovers == nil {
+		sio.takeovers = map[string]*softTakeover{}
+	}
+
+	sio.takeovers[sliderID] = &softTakeover{volume: volume, expires: time.Now().Add(timeout)}
+}
+
+// holdForTakeover tells whether an analog slider's value should be ignored, because the slider hasn't reached
+// its stored volume yet. reaching or crossing it ends the takeover, and the slider is in control again
+func (sio *SerialIO) holdForTakeover(logger *zap.SugaredLogger, sliderID string, percent float32) bool {
+	hub := sio.eventHub()
+
+	hub.takeoverLock.Lock()
+	defer hub.takeoverLock.Unlock()
+
+	takeover, ok := hub.takeovers[sliderID]
+	if !ok {
+		return false
+	}
+
+	if !takeover.expires.IsZero() && time.Now().After(takeover.expires) {
+		delete(hub.takeovers, sliderID)
+		return false
+	}
+
+	diff := percent - takeover.volume
+
+	side := 1
+	if diff < 0 {
+		side = -1
+	}
+
+	if diff < -takeoverTolerance || diff > takeoverTolerance {
+		if takeover.side == 0 || takeover.side == side {
+			takeover.side = side
+			return true
+		}
+	}
+
+	delete(hub.takeovers, sliderID)
+	logger.Debugw("Slider took over its volume", "slider", sliderID, "volume", takeover.volume)
+
+	return false
+}
@@ -0,0 +1,246 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// serialTransport talks to a board over a serial port (COMx on Windows, /dev/ttyXXX on Linux)
+type serialTransport struct {
+	logger      *zap.SugaredLogger
+	connOptions serial.OpenOptions
+	conn        io.ReadWriteCloser
+	checksum    string
+
+	// set to record everything going over the port, or to play a capture back instead of opening it at all
+	// (see serial_capture.go)
+	capture    *serialCapture
+	replayPath string
+
+	// set once the board and deej agreed on the binary protocol (see serial_binary.go)
+	lock   sync.Mutex
+	binary *binaryProtocol
+}
+
+// newReplayTransport plays a capture back through the same line (and binary frame) handling as a real port
+func newReplayTransport(logger *zap.SugaredLogger, path string, connectionInfo ConnectionInfo) *serialTransport {
+	transport := newSerialTransport(logger, connectionInfo)
+	transport.connOptions.PortName = path
+	transport.replayPath = path
+
+	return transport
+}
+
+func newSerialTransport(logger *zap.SugaredLogger, connectionInfo ConnectionInfo) *serialTransport {
+
+	// set minimum read size according to platform (0 for windows, 1 for linux)
+	// this prevents a rare bug on windows where serial reads get congested,
+	// resulting in significant lag
+	minimumReadSize := connectionInfo.MinReadSize
+	if minimumReadSize == 0 && util.Linux() {
+		minimumReadSize = 1
+	}
+
+	dataBits := connectionInfo.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+
+	stopBits := connectionInfo.StopBits
+	if stopBits == 0 {
+		stopBits = 1
+	}
+
+	parityMode := serial.PARITY_NONE
+	switch connectionInfo.Parity {
+	case parityOdd:
+		parityMode = serial.PARITY_ODD
+	case parityEven:
+		parityMode = serial.PARITY_EVEN
+	}
+
+	return &serialTransport{
+		logger:   logger,
+		checksum: connectionInfo.Checksum,
+		connOptions: serial.OpenOptions{
+			PortName:          connectionInfo.SerialPort,
+			BaudRate:          connectionInfo.BaudRate,
+			DataBits:          dataBits,
+			StopBits:          stopBits,
+			ParityMode:        parityMode,
+			RTSCTSFlowControl: connectionInfo.RTSCTS,
+			MinimumReadSize:   minimumReadSize,
+		},
+	}
+}
+
+// validFraming puts framing settings that the serial port can't use back to their defaults, and complains about them
+func validFraming(logger *zap.SugaredLogger, connectionInfo ConnectionInfo) ConnectionInfo {
+	if connectionInfo.DataBits != 0 && (connectionInfo.DataBits < 5 || connectionInfo.DataBits > 8) {
+		logger.Warnw("Invalid data bits (use 5-8), using 8", "dataBits", connectionInfo.DataBits)
+		connectionInfo.DataBits = 0
+	}
+
+	if connectionInfo.StopBits > 2 {
+		logger.Warnw("Invalid stop bits (use 1 or 2), using 1", "stopBits", connectionInfo.StopBits)
+		connectionInfo.StopBits = 0
+	}
+
+	switch connectionInfo.Parity {
+	case "", parityNone, parityOdd, parityEven:
+	default:
+		logger.Warnw("Invalid parity, using none", "parity", connectionInfo.Parity,
+			"valid", []string{parityNone, parityOdd, parityEven})
+
+		connectionInfo.Parity = ""
+	}
+
+	return connectionInfo
+}
+
+func (st *serialTransport) Connect() error {
+	if st.replayPath != "" {
+		conn, err := openReplay(st.logger, st.replayPath)
+		if err != nil {
+			return fmt.Errorf("open replay: %w", err)
+		}
+
+		st.conn = conn
+		return nil
+	}
+
+	st.logger.Debugw("Attempting serial connection",
+		"comPort", st.connOptions.PortName,
+		"baudRate", st.connOptions.BaudRate,
+		"dataBits", st.connOptions.DataBits,
+		"stopBits", st.connOptions.StopBits,
+		"parity", st.connOptions.ParityMode,
+		"rtsCts", st.connOptions.RTSCTSFlowControl,
+		"minReadSize", st.connOptions.MinimumReadSize)
+
+	// on linux, find out up front whether the user may use the port at all, so a failure can be explained properly
+	accessErr := util.CheckSerialPortAccess(st.connOptions.PortName)
+
+	conn, err := serial.Open(st.connOptions)
+	if err != nil {
+
+		// busy and forbidden ports are retried until that changes, no need to log each attempt.
+		// note that a sandbox without device access usually hides the port entirely, rather than deny opening it
+		if util.IsSerialPortBusy(err) {
+			st.logger.Debugw("Serial port is busy", "error", err)
+		} else if accessErr != nil && (errors.Is(err, os.ErrPermission) || util.Sandbox() != "") {
+			st.logger.Debugw("No permission to open serial port", "error", err)
+			return fmt.Errorf("open serial connection: %w", accessErr)
+		} else {
+			st.logger.Warnw("Failed to open serial connection", "error", err)
+		}
+
+		return fmt.Errorf("open serial connection: %w", err)
+	}
+
+	st.conn = conn
+
+	if st.capture != nil {
+		st.conn = st.capture.wrap(conn, st.connOptions.PortName)
+	}
+
+	return nil
+}
+
+func (st *serialTransport) ReadLines(lines chan<- TransportLine) error {
+	reader := bufio.NewReader(st.conn)
+
+	for {
+
+		// binary frames are passed on as the lines they stand for
+		if protocol := st.binaryProtocol(); protocol != nil {
+			frame, err := reader.ReadBytes(0)
+			readAt := time.Now()
+
+			if err != nil {
+				return fmt.Errorf("read frame from serial: %w", err)
+			}
+
+			if text, verified := protocol.decode(frame[:len(frame)-1]); text != "" {
+				lines <- TransportLine{Text: text, ReadAt: readAt, Verified: verified}
+			}
+
+			continue
+		}
+
+		line, err := reader.ReadString('\n')
+		readAt := time.Now()
+
+		if err != nil {
+			return fmt.Errorf("read line from serial: %w", err)
+		}
+
+		if text, ok := verifyChecksum(st.checksum, line); ok && binaryOfferLinePattern.MatchString(text) {
+			st.acceptBinary()
+		}
+
+		lines <- TransportLine{Text: line, ReadAt: readAt}
+	}
+}
+
+// acceptBinary answers the board's offer of the binary protocol, and switches to it
+func (st *serialTransport) acceptBinary() {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if _, err := st.conn.Write([]byte(binaryOfferLine)); err != nil {
+		st.logger.Warnw("Failed to accept binary protocol, staying with lines", "error", err)
+		return
+	}
+
+	st.binary = newBinaryProtocol(st.checksum)
+}
+
+func (st *serialTransport) binaryProtocol() *binaryProtocol {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	return st.binary
+}
+
+func (st *serialTransport) Write(data []byte) (int, error) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	if st.binary == nil {
+		return st.conn.Write(data)
+	}
+
+	if _, err := st.conn.Write(st.binary.encode(data)); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (st *serialTransport) Close() error {
+	if err := st.conn.Close(); err != nil {
+		return fmt.Errorf("close serial connection: %w", err)
+	}
+
+	return nil
+}
+
+func (st *serialTransport) Name() string {
+	return st.connOptions.PortName
+}
+
+// DeviceID returns the usb serial number of the device behind the port, if there is one
+func (st *serialTransport) DeviceID() (string, error) {
+	return util.GetSerialPortDeviceID(st.connOptions.PortName)
+}
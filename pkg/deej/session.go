@@ -11,14 +11,31 @@ type Session interface {
 	GetVolume() float32
 	SetVolume(v float32) error
 
-	// TODO: future mute support
-	// GetMute() bool
-	// SetMute(m bool) error
+	GetMute() bool
+	SetMute(m bool) error
 
 	Key() string
 	Release()
 }
 
+// titledSession is implemented by sessions that can describe themselves beyond their process name,
+// i.e. with a display name set by the app or the titles of its windows. these are matched by title: targets
+type titledSession interface {
+	Titles() []string
+}
+
+// processTreeSession is implemented by sessions that know which processes their process descends from.
+// when child process aggregation is enabled, these are also matched by their ancestors' names
+type processTreeSession interface {
+	Ancestors() []string
+}
+
+// activitySession is implemented by sessions that can tell whether they're currently producing audio.
+// these are followed by the deej.active target
+type activitySession interface {
+	Active() bool
+}
+
 const (
 
 	// ideally these would share a common ground in baseSession
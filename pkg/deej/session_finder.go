@@ -1,8 +1,42 @@
 package deej
 
+import "strings"
+
 // SessionFinder represents an entity that can find all current audio sessions
 type SessionFinder interface {
 	GetAllSessions() ([]Session, error)
 
 	Release() error
 }
+
+// captureSessionFinder is a SessionFinder that can also tell which processes are capturing audio
+// (i.e. recording a microphone). Not every backend can, so it's checked for rather than required
+type captureSessionFinder interface {
+	GetCapturingProcesses() ([]string, error)
+}
+
+// processSessionFinder is a SessionFinder that can get just the sessions of the given processes, quicker than
+// getting all of them. it's used to restore volumes at startup before all sessions are in (see session_state.go)
+type processSessionFinder interface {
+	GetProcessSessions(names []string) ([]Session, error)
+}
+
+// filterSessions returns the sessions of the given processes, and releases all others
+func filterSessions(sessions []Session, names []string) []Session {
+	wanted := map[string]bool{}
+	for _, name := range names {
+		wanted[strings.ToLower(name)] = true
+	}
+
+	filtered := []Session{}
+
+	for _, session := range sessions {
+		if wanted[session.Key()] {
+			filtered = append(filtered, session)
+		} else {
+			session.Release()
+		}
+	}
+
+	return filtered
+}
@@ -3,24 +3,66 @@ package deej
 import (
 	"fmt"
 	"net"
+	"os"
+	"strconv"
 
 	"github.com/jfreymuth/pulse/proto"
 	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
 )
 
 type paSessionFinder struct {
 	logger        *zap.SugaredLogger
 	sessionLogger *zap.SugaredLogger
+	configManager *ConfigManager
 
+	// the server we're connected to, as configured at the time
+	server PulseServer
 	client *proto.Client
 	conn   net.Conn
 }
 
-func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
-	client, conn, err := proto.Connect("")
+func newSessionFinder(logger *zap.SugaredLogger, configManager *ConfigManager) (SessionFinder, error) {
+	sf := &paSessionFinder{
+		logger:        logger.Named("session_finder"),
+		sessionLogger: logger.Named("sessions"),
+		configManager: configManager,
+	}
+
+	sf.logger.Debug("Created PA session finder instance")
+
+	return sf, nil
+}
+
+// connect connects to the configured PulseAudio server, unless we're connected to it already. the config isn't
+// loaded yet when the session finder is created, and the server might change with it, so this happens on demand
+func (sf *paSessionFinder) connect() error {
+	server := sf.configManager.getPulseServer()
+	if sf.client != nil && server == sf.server {
+		return nil
+	}
+
+	if sf.conn != nil {
+		sf.logger.Info("PulseAudio server changed, reconnecting")
+
+		if err := sf.Release(); err != nil {
+			sf.logger.Debugw("Failed to release previous PulseAudio connection", "error", err)
+		}
+	}
+
+	// the client library reads the cookie from wherever libpulse would, which starts with PULSE_COOKIE
+	if server.CookieFile != "" {
+		if err := os.Setenv("PULSE_COOKIE", server.CookieFile); err != nil {
+			return fmt.Errorf("set PulseAudio cookie path: %w", err)
+		}
+	}
+
+	// an empty address means the default server (PULSE_SERVER, or the local one)
+	client, conn, err := proto.Connect(server.Address)
 	if err != nil {
-		logger.Warnw("Failed to establish PulseAudio connection", "error", err)
-		return nil, fmt.Errorf("establish PulseAudio connection: %w", err)
+		sf.logger.Warnw("Failed to establish PulseAudio connection", "error", err, "address", server.Address)
+		return fmt.Errorf("establish PulseAudio connection: %w", err)
 	}
 
 	request := proto.SetClientName{
@@ -31,22 +73,24 @@ func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
 	reply := proto.SetClientNameReply{}
 
 	if err := client.Request(&request, &reply); err != nil {
-		return nil, err
+		conn.Close()
+		return err
 	}
 
-	sf := &paSessionFinder{
-		logger:        logger.Named("session_finder"),
-		sessionLogger: logger.Named("sessions"),
-		client:        client,
-		conn:          conn,
-	}
+	sf.server = server
+	sf.client = client
+	sf.conn = conn
 
-	sf.logger.Debug("Created PA session finder instance")
+	sf.logger.Infow("Connected to PulseAudio server", "address", server.Address)
 
-	return sf, nil
+	return nil
 }
 
 func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
+	if err := sf.connect(); err != nil {
+		return nil, err
+	}
+
 	sessions := []Session{}
 
 	// get the master sink session
@@ -74,8 +118,44 @@ func (sf *paSessionFinder) GetAllSessions() ([]Session, error) {
 	return sessions, nil
 }
 
+// GetCapturingProcesses returns the names of processes recording from any source. corked (paused) streams don't count
+func (sf *paSessionFinder) GetCapturingProcesses() ([]string, error) {
+	if err := sf.connect(); err != nil {
+		return nil, err
+	}
+
+	request := proto.GetSourceOutputInfoList{}
+	reply := proto.GetSourceOutputInfoListReply{}
+
+	if err := sf.client.Request(&request, &reply); err != nil {
+		return nil, fmt.Errorf("get source output list: %w", err)
+	}
+
+	names := []string{}
+
+	for _, info := range reply {
+		if info.Corked {
+			continue
+		}
+
+		if name, ok := info.Properties["application.process.binary"]; ok {
+			names = append(names, name.String())
+		}
+	}
+
+	return names, nil
+}
+
 func (sf *paSessionFinder) Release() error {
-	if err := sf.conn.Close(); err != nil {
+	if sf.conn == nil {
+		return nil
+	}
+
+	conn := sf.conn
+	sf.client = nil
+	sf.conn = nil
+
+	if err := conn.Close(); err != nil {
 		sf.logger.Warnw("Failed to close PulseAudio connection", "error", err)
 		return fmt.Errorf("close PulseAudio connection: %w", err)
 	}
@@ -128,6 +208,12 @@ func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 		return fmt.Errorf("get sink input list: %w", err)
 	}
 
+	// snapshot all processes so sessions can be attributed to their parent apps. this is best-effort
+	processTree, err := util.NewProcessTree()
+	if err != nil {
+		sf.logger.Warnw("Failed to take process snapshot, sessions won't know their ancestors", "error", err)
+	}
+
 	for _, info := range reply {
 		name, ok := info.Properties["application.process.binary"]
 
@@ -138,8 +224,29 @@ func (sf *paSessionFinder) enumerateAndAddSessions(sessions *[]Session) error {
 			continue
 		}
 
+		// these are matched by title: targets
+		titles := []string{}
+		for _, property := range []string{"application.name", "media.name"} {
+			if title, ok := info.Properties[property]; ok {
+				titles = append(titles, title.String())
+			}
+		}
+
+		var ancestors []string
+		if pid, ok := info.Properties["application.process.id"]; ok {
+			if pid, err := strconv.Atoi(pid.String()); err == nil {
+				ancestors = processTree.AncestorNames(pid)
+			}
+		}
+
 		// create the deej session object
-		newSession := newPASession(sf.sessionLogger, sf.client, info.SinkInputIndex, info.Channels, name.String())
+		newSession := newPASession(sf.sessionLogger,
+			sf.client,
+			info.SinkInputIndex,
+			info.Channels,
+			name.String(),
+			titles,
+			ancestors)
 
 		// add it to our slice
 		*sessions = append(*sessions, newSession)
@@ -9,8 +9,11 @@ import (
 	"unsafe"
 
 	ole "github.com/go-ole/go-ole"
+	ps "github.com/mitchellh/go-ps"
 	wca "github.com/moutend/go-wca"
 	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
 )
 
 type wcaSessionFinder struct {
@@ -27,6 +30,9 @@ type wcaSessionFinder struct {
 	// our master input and output sessions
 	masterOut *masterSession
 	masterIn  *masterSession
+
+	// taken once per enumeration, to find each process session's ancestors
+	processTree util.ProcessTree
 }
 
 const (
@@ -42,7 +48,8 @@ const (
 	deviceSessionFormat = "device.%s"
 )
 
-func newSessionFinder(logger *zap.SugaredLogger) (SessionFinder, error) {
+// WCA has no settings of its own, so the config manager goes unused here
+func newSessionFinder(logger *zap.SugaredLogger, configManager *ConfigManager) (SessionFinder, error) {
 	sf := &wcaSessionFinder{
 		logger:        logger.Named("session_finder"),
 		sessionLogger: logger.Named("sessions"),
@@ -137,6 +144,12 @@ func (sf *wcaSessionFinder) GetAllSessions() ([]Session, error) {
 		sessions = append(sessions, sf.masterIn)
 	}
 
+	// snapshot all processes so sessions can be attributed to their parent apps. this is best-effort
+	sf.processTree, err = util.NewProcessTree()
+	if err != nil {
+		sf.logger.Warnw("Failed to take process snapshot, sessions won't know their ancestors", "error", err)
+	}
+
 	// enumerate all devices and make their "master" sessions bindable by friendly name;
 	// for output devices, this is also where we enumerate process sessions
 	if err := sf.enumerateAndAddSessions(&sessions); err != nil {
@@ -147,6 +160,165 @@ func (sf *wcaSessionFinder) GetAllSessions() ([]Session, error) {
 	return sessions, nil
 }
 
+// GetCapturingProcesses returns the names of processes with an active session on any input device.
+// it uses its own device enumerator, since it runs alongside session refreshes rather than as part of them
+func (sf *wcaSessionFinder) GetCapturingProcesses() ([]string, error) {
+
+	// S_FALSE only means COM was already initialized on this thread, which is fine (but still needs uninitializing)
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		const sFalse = 1
+		oleError := &ole.OleError{}
+
+		if !errors.As(err, &oleError) || oleError.Code() != sFalse {
+			return nil, fmt.Errorf("call CoInitializeEx: %w", err)
+		}
+	}
+	defer ole.CoUninitialize()
+
+	var deviceEnumerator *wca.IMMDeviceEnumerator
+
+	if err := wca.CoCreateInstance(
+		wca.CLSID_MMDeviceEnumerator,
+		0,
+		wca.CLSCTX_ALL,
+		wca.IID_IMMDeviceEnumerator,
+		&deviceEnumerator,
+	); err != nil {
+		return nil, fmt.Errorf("call CoCreateInstance: %w", err)
+	}
+	defer deviceEnumerator.Release()
+
+	var deviceCollection *wca.IMMDeviceCollection
+
+	if err := deviceEnumerator.EnumAudioEndpoints(wca.ECapture, wca.DEVICE_STATE_ACTIVE, &deviceCollection); err != nil {
+		return nil, fmt.Errorf("enumerate active capture endpoints: %w", err)
+	}
+	defer deviceCollection.Release()
+
+	var deviceCount uint32
+
+	if err := deviceCollection.GetCount(&deviceCount); err != nil {
+		return nil, fmt.Errorf("get capture device count: %w", err)
+	}
+
+	names := []string{}
+
+	for deviceIdx := uint32(0); deviceIdx < deviceCount; deviceIdx++ {
+		var endpoint *wca.IMMDevice
+
+		if err := deviceCollection.Item(deviceIdx, &endpoint); err != nil {
+			return nil, fmt.Errorf("get capture device %d: %w", deviceIdx, err)
+		}
+
+		deviceNames, err := sf.capturingProcessesOnEndpoint(endpoint)
+		endpoint.Release()
+
+		if err != nil {
+			return nil, fmt.Errorf("enumerate capture device %d sessions: %w", deviceIdx, err)
+		}
+
+		names = append(names, deviceNames...)
+	}
+
+	return names, nil
+}
+
+func (sf *wcaSessionFinder) capturingProcessesOnEndpoint(endpoint *wca.IMMDevice) ([]string, error) {
+	var audioSessionManager2 *wca.IAudioSessionManager2
+
+	if err := endpoint.Activate(wca.IID_IAudioSessionManager2, wca.CLSCTX_ALL, nil, &audioSessionManager2); err != nil {
+		return nil, fmt.Errorf("activate endpoint: %w", err)
+	}
+	defer audioSessionManager2.Release()
+
+	var sessionEnumerator *wca.IAudioSessionEnumerator
+
+	if err := audioSessionManager2.GetSessionEnumerator(&sessionEnumerator); err != nil {
+		return nil, fmt.Errorf("get session enumerator: %w", err)
+	}
+	defer sessionEnumerator.Release()
+
+	var sessionCount int
+
+	if err := sessionEnumerator.GetCount(&sessionCount); err != nil {
+		return nil, fmt.Errorf("get session count: %w", err)
+	}
+
+	names := []string{}
+
+	for sessionIdx := 0; sessionIdx < sessionCount; sessionIdx++ {
+		var audioSessionControl *wca.IAudioSessionControl
+
+		if err := sessionEnumerator.GetSession(sessionIdx, &audioSessionControl); err != nil {
+			return nil, fmt.Errorf("get session %d from enumerator: %w", sessionIdx, err)
+		}
+
+		dispatch, err := audioSessionControl.QueryInterface(wca.IID_IAudioSessionControl2)
+		audioSessionControl.Release()
+
+		if err != nil {
+			return nil, fmt.Errorf("query session %d IAudioSessionControl2: %w", sessionIdx, err)
+		}
+
+		audioSessionControl2 := (*wca.IAudioSessionControl2)(unsafe.Pointer(dispatch))
+
+		// inactive sessions stick around after a call ends, only an active one means the mic is in use
+		var state uint32
+		var pid uint32
+
+		if err := audioSessionControl2.GetState(&state); err == nil && state == audioSessionStateActive {
+			if err := audioSessionControl2.GetProcessId(&pid); err == nil && pid != 0 {
+				if process, err := ps.FindProcess(int(pid)); err == nil && process != nil {
+					names = append(names, process.Executable())
+				}
+			}
+		}
+
+		audioSessionControl2.Release()
+	}
+
+	return names, nil
+}
+
+// GetProcessSessions returns the given processes' sessions on the default output device, which is where
+// they're usually playing. it skips the process snapshot and the other devices, which take up most of the
+// time GetAllSessions does
+func (sf *wcaSessionFinder) GetProcessSessions(names []string) ([]Session, error) {
+
+	// S_FALSE only means COM was already initialized on this thread, which is fine (but still needs uninitializing)
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
+		const sFalse = 1
+		oleError := &ole.OleError{}
+
+		if !errors.As(err, &oleError) || oleError.Code() != sFalse {
+			return nil, fmt.Errorf("call CoInitializeEx: %w", err)
+		}
+	}
+	defer ole.CoUninitialize()
+
+	if err := sf.getDeviceEnumerator(); err != nil {
+		return nil, fmt.Errorf("get device enumerator: %w", err)
+	}
+
+	defaultOutputEndpoint, defaultInputEndpoint, err := sf.getDefaultAudioEndpoints()
+	if err != nil {
+		return nil, fmt.Errorf("get default audio endpoints: %w", err)
+	}
+	defer defaultOutputEndpoint.Release()
+
+	if defaultInputEndpoint != nil {
+		defaultInputEndpoint.Release()
+	}
+
+	sessions := []Session{}
+
+	if err := sf.enumerateAndAddProcessSessions(defaultOutputEndpoint, "default", &sessions); err != nil {
+		return nil, fmt.Errorf("enumerate default device sessions: %w", err)
+	}
+
+	return filterSessions(sessions, names), nil
+}
+
 func (sf *wcaSessionFinder) Release() error {
 
 	// skip unregistering the mmnotificationclient, as it's not implemented in go-wca
@@ -481,8 +653,27 @@ func (sf *wcaSessionFinder) enumerateAndAddProcessSessions(
 		// make it useful, again
 		simpleAudioVolume := (*wca.ISimpleAudioVolume)(unsafe.Pointer(dispatch))
 
+		// get its IAudioMeterInformation, which tells us whether it's making any noise. this one's optional
+		var audioMeterInformation *iAudioMeterInformation
+
+		dispatch, err = audioSessionControl2.QueryInterface(wca.IID_IAudioMeterInformation)
+		if err != nil {
+			sf.logger.Debugw("Failed to query session's IAudioMeterInformation",
+				"error", err,
+				"sessionIdx", sessionIdx)
+		} else {
+			audioMeterInformation = (*iAudioMeterInformation)(unsafe.Pointer(dispatch))
+		}
+
 		// create the deej session object
-		newSession, err := newWCASession(sf.sessionLogger, audioSessionControl2, simpleAudioVolume, pid, sf.eventCtx)
+		newSession, err := newWCASession(sf.sessionLogger,
+			audioSessionControl2,
+			simpleAudioVolume,
+			audioMeterInformation,
+			pid,
+			sf.processTree.AncestorNames(int(pid)),
+			sf.eventCtx)
+
 		if err != nil {
 
 			// this could just mean this process is already closed by now, and the session will be cleaned up later by the OS
@@ -500,6 +691,10 @@ func (sf *wcaSessionFinder) enumerateAndAddProcessSessions(
 			audioSessionControl2.Release()
 			simpleAudioVolume.Release()
 
+			if audioMeterInformation != nil {
+				audioMeterInformation.Release()
+			}
+
 			continue
 		}
 
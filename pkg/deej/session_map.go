@@ -19,10 +19,18 @@ type sessionMap struct {
 	m    map[string][]Session
 	lock sync.Locker
 
+	// sessions indexed by their ancestor processes' names, used for child process aggregation.
+	// these are the same sessions as in m, so they're never released through here
+	byAncestor map[string][]Session
+
 	sessionFinder SessionFinder
 
 	lastSessionRefresh time.Time
 	unmappedSessions   []Session
+
+	// the deej.active target's most recent result, which is only recomputed every so often
+	lastActiveCheck time.Time
+	lastActiveKeys  []string
 }
 
 const (
@@ -40,11 +48,26 @@ const (
 	// targets all currently unmapped sessions (experimental)
 	specialTargetAllUnmapped = "unmapped"
 
+	// targets whichever mapped app is currently producing audio
+	specialTargetActive = "active"
+
+	// targets sessions by their display name or window title rather than process name, i.e. "title:Minecraft".
+	// useful for launchers that run everything under a generic process (javaw.exe and friends)
+	specialTargetTitlePrefix = "title:"
+
+	// targets whichever game steam reports as running, i.e. "steam:current"
+	specialTargetSteamPrefix  = "steam:"
+	specialTargetSteamCurrent = "current"
+
 	// this threshold constant assumes that re-acquiring all sessions is a kind of expensive operation,
 	// and needs to be limited in some manner. this value was previously user-configurable through a config
 	// key "process_refresh_frequency", but exposing this type of implementation detail seems wrong now
 	minTimeBetweenSessionRefreshes = time.Second * 5
 
+	// checking every session's activity takes a round trip to the audio backend per session,
+	// so the deej.active target reuses its last result for this long
+	activeSessionCheckCooldown = time.Millisecond * 500
+
 	// determines whether the map should be refreshed when a slider moves.
 	// this is a bit greedy but allows us to ensure sessions are always re-acquired, which is
 	// especially important for process groups (because you can have one ongoing session
@@ -64,6 +87,7 @@ func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionF
 		deej:          deej,
 		logger:        logger,
 		m:             make(map[string][]Session),
+		byAncestor:    make(map[string][]Session),
 		lock:          &sync.Mutex{},
 		sessionFinder: sessionFinder,
 	}
@@ -74,6 +98,12 @@ func newSessionMap(deej *Deej, logger *zap.SugaredLogger, sessionFinder SessionF
 }
 
 func (m *sessionMap) initialize() error {
+
+	// getting all sessions can take a while, so apps that are still running get their volumes back first
+	if m.deej.configManager.getStartupVolumes() == startupVolumesRestore {
+		m.warmStart()
+	}
+
 	if err := m.getAndAddSessions(); err != nil {
 		m.logger.Warnw("Failed to get all sessions during session map initialization", "error", err)
 		return fmt.Errorf("get all sessions during init: %w", err)
@@ -81,10 +111,84 @@ func (m *sessionMap) initialize() error {
 
 	m.setupOnConfigReload()
 	m.setupOnSliderMove()
+	m.setupOnMuteToggle()
+
+	m.applyStartupVolumes()
 
 	return nil
 }
 
+// applyStartupVolumes reconciles the config's slider volumes with the sessions' actual ones,
+// according to the configured startup policy
+func (m *sessionMap) applyStartupVolumes() {
+
+	// the implicit slider (the only one there is, then) has no volume of its own to apply or restore,
+	// it picks up wherever the master volume is
+	if m.deej.configManager.isImplicitSlider(implicitSliderKey) {
+		sliderMapping, _ := m.deej.configManager.getSliderMappingByKey(implicitSliderKey)
+		m.adoptSliderVolume(implicitSliderKey, sliderMapping)
+
+		return
+	}
+
+	policy := m.deej.configManager.getStartupVolumes()
+	if policy == startupVolumesNone {
+		return
+	}
+
+	// the saved volumes belong to sessions rather than sliders
+	if policy == startupVolumesRestore {
+		m.restoreSessionState()
+		m.logger.Infow("Applied startup volume policy", "policy", policy)
+
+		return
+	}
+
+	sliderKeys, _ := m.deej.configManager.getSliderMappingKeys()
+
+	for _, key := range sliderKeys {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		switch policy {
+		case startupVolumesApply:
+			m.handleSliderMoveEvent(SliderMoveEvent{
+				SliderID:     key,
+				PercentValue: sliderMapping.Volume,
+			})
+		case startupVolumesAdopt:
+			m.adoptSliderVolume(key, sliderMapping)
+		}
+	}
+
+	m.logger.Infow("Applied startup volume policy", "policy", policy)
+}
+
+// adoptSliderVolume sets a slider's volume to its sessions' actual one. a slider can target several sessions
+// with different volumes, so it goes with the first one that's around
+func (m *sessionMap) adoptSliderVolume(key string, sliderMapping SliderMapping) {
+	for _, target := range sliderMapping.Targets {
+		sessions := m.getTargetSessions(target)
+		if len(sessions) == 0 {
+			continue
+		}
+
+		// with its group's master all the way down, there's no telling where the slider is
+		groupScale := m.deej.configManager.getGroupScale(key)
+		if groupScale == 0 {
+			return
+		}
+
+		volume := sliderMapping.position(sessions[0].GetVolume() / groupScale)
+		sliderMapping.Volume = util.QuantizeScalar(volume, m.deej.configManager.getQuantizationStep())
+		m.deej.configManager.UpdateSliderMappingByKey(key, sliderMapping)
+
+		return
+	}
+}
+
 func (m *sessionMap) release() error {
 	if err := m.sessionFinder.Release(); err != nil {
 		m.logger.Warnw("Failed to release session finder during session map release", "error", err)
@@ -129,26 +233,148 @@ func (m *sessionMap) setupOnConfigReload() {
 		for {
 			select {
 			case <-configReloadedChannel:
-				m.logger.Info("Detected config reload, attempting to re-acquire all audio sessions")
-				m.refreshSessions(false)
+
+				// changes wait for the next slider move while another user's session is in front of the screen
+				if m.deej.userSession.isPaused() {
+					m.logger.Info("Detected config reload while paused, not applying it yet")
+					continue
+				}
+
+				m.logger.Info("Detected config reload, re-applying changed slider mappings")
+				m.handleConfigReload()
+			}
+		}
+	}()
+}
+
+func (m *sessionMap) setupOnMuteToggle() {
+	muteEvents := m.deej.serial.SubscribeToMuteToggleEvents(PriorityAudio)
+
+	go func() {
+		for {
+			select {
+			case event := <-muteEvents.C:
+				m.handleMuteToggleEvent(event)
 			}
 		}
 	}()
 }
 
 func (m *sessionMap) setupOnSliderMove() {
-	sliderEventsChannel := m.deej.serial.SubscribeToSliderMoveEvents()
+	sliderEvents := m.deej.serial.SubscribeToSliderMoveEvents(PriorityAudio)
 
 	go func() {
 		for {
 			select {
-			case event := <-sliderEventsChannel:
+			case event := <-sliderEvents.C:
 				m.handleSliderMoveEvent(event)
 			}
 		}
 	}()
 }
 
+// handleConfigReload only touches sessions belonging to sliders whose mapping actually changed.
+// clearing the whole map and re-emitting every slider's volume causes audible blips on every config save
+func (m *sessionMap) handleConfigReload() {
+	changedKeys := m.deej.configManager.getChangedSliderKeys()
+
+	if len(changedKeys) == 0 {
+		m.logger.Debug("No slider mappings changed, leaving sessions untouched")
+		return
+	}
+
+	m.logger.Debugw("Slider mappings changed", "keys", changedKeys)
+
+	// changing targets can move sessions in or out of the unmapped set - if that happens,
+	// sliders targeting the unmapped sessions need to be re-applied as well
+	if m.recomputeUnmappedSessions() {
+		changedKeys = append(changedKeys, m.slidersWithTarget(specialTargetTransformPrefix+specialTargetAllUnmapped)...)
+		changedKeys = funk.UniqString(changedKeys)
+	}
+
+	for _, key := range changedKeys {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(key)
+
+		// removed sliders leave their sessions as they were
+		if err != nil {
+			continue
+		}
+
+		// and so does the implicit slider showing up, when the config's own are all gone
+		if m.deej.configManager.isImplicitSlider(key) {
+			m.adoptSliderVolume(key, sliderMapping)
+			continue
+		}
+
+		m.handleSliderMoveEvent(SliderMoveEvent{
+			SliderID:     key,
+			PercentValue: sliderMapping.Volume,
+		})
+
+		m.handleMuteToggleEvent(MuteToggleEvent{
+			SliderID: key,
+			Muted:    sliderMapping.Muted,
+		})
+	}
+}
+
+// recomputeUnmappedSessions re-evaluates which of the current sessions are unmapped against the current config,
+// returning true if the set of unmapped sessions has changed
+func (m *sessionMap) recomputeUnmappedSessions() bool {
+	m.lock.Lock()
+	sessions := []Session{}
+	for _, value := range m.m {
+		sessions = append(sessions, value...)
+	}
+	m.lock.Unlock()
+
+	unmappedSessions := []Session{}
+	for _, session := range sessions {
+		if !m.sessionMapped(session) {
+			unmappedSessions = append(unmappedSessions, session)
+		}
+	}
+
+	previouslyUnmapped := make(map[Session]bool, len(m.unmappedSessions))
+	for _, session := range m.unmappedSessions {
+		previouslyUnmapped[session] = true
+	}
+
+	changed := len(unmappedSessions) != len(m.unmappedSessions)
+	for _, session := range unmappedSessions {
+		if !previouslyUnmapped[session] {
+			changed = true
+			break
+		}
+	}
+
+	m.unmappedSessions = unmappedSessions
+
+	return changed
+}
+
+// slidersWithTarget returns the keys of all sliders that have the given (raw, unresolved) target
+func (m *sessionMap) slidersWithTarget(target string) []string {
+	keys := []string{}
+
+	sliderKeys, _ := m.deej.configManager.getSliderMappingKeys()
+	for _, key := range sliderKeys {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		for _, sliderTarget := range sliderMapping.Targets {
+			if strings.ToLower(sliderTarget) == target {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
+
 // performance: explain why force == true at every such use to avoid unintended forced refresh spams
 func (m *sessionMap) refreshSessions(force bool) {
 
@@ -190,8 +416,18 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 	for _, sliderMapping := range sliderMappings {
 		for _, target := range sliderMapping.Targets {
 
-			// ignore special transforms
-			if m.targetHasSpecialTransform(target) {
+			// title targets map whichever sessions they currently match
+			if m.targetIsTitle(target) {
+				if sessionTitleMatches(session, target[len(specialTargetTitlePrefix):]) {
+					matchFound = true
+					break
+				}
+
+				continue
+			}
+
+			// ignore special transforms, and steam games which are only known at resolution time
+			if m.targetHasSpecialTransform(target) || m.targetIsSteam(target) {
 				continue
 			}
 
@@ -203,6 +439,12 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 				// return
 				break
 			}
+
+			// with aggregation, a child of a mapped process is just as mapped
+			if m.deej.configManager.getAggregateChildren() && sessionDescendsFrom(session, target) {
+				matchFound = true
+				break
+			}
 		}
 	}
 
@@ -210,6 +452,9 @@ func (m *sessionMap) sessionMapped(session Session) bool {
 }
 
 func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
+	if event.trace != nil {
+		event.trace.dispatchedAt = time.Now()
+	}
 
 	// first of all, ensure our session map isn't moldy
 	if m.lastSessionRefresh.Add(maxTimeBetweenSessionRefreshes).Before(time.Now()) {
@@ -229,42 +474,60 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 	targetFound := false
 	adjustmentFailed := false
 
+	// a slider in a group only gets as loud as its group's master lets it
+	groupScale := m.deej.configManager.getGroupScale(event.SliderID)
+
 	// for each possible target for this slider...
 	for _, target := range sliderMapping.Targets {
 
-		// resolve the target name by cleaning it up and applying any special transformations.
-		// depending on the transformation applied, this can result in more than one target name
-		resolvedTargets := m.resolveTarget(target)
+		// find all sessions matching the target, by resolved name or by title
+		sessions := m.getTargetSessions(target)
 
-		// for each resolved target...
-		for _, resolvedTarget := range resolvedTargets {
+		// no sessions matching this target - move on
+		if len(sessions) == 0 {
+			continue
+		}
 
-			// check the map for matching sessions
-			sessions, ok := m.get(resolvedTarget)
+		targetFound = true
 
-			// no sessions matching this target - move on
-			if !ok {
-				continue
-			}
+		// targets may sit a fixed amount above or below the slider, to keep a preferred balance within the group
+		targetVolume := event.PercentValue
+		if offset := sliderMapping.offsetFor(target); offset != 0 {
+			targetVolume = util.QuantizeScalar(targetVolume+offset, m.deej.configManager.getQuantizationStep())
+		}
 
-			targetFound = true
+		// and the slider's position may stand for more (or less) volume than it looks like
+		targetVolume = sliderMapping.volume(targetVolume) * groupScale
 
-			// iterate all matching sessions and adjust the volume of each one
-			for _, session := range sessions {
-				if session.GetVolume() != event.PercentValue {
-					if err := session.SetVolume(event.PercentValue); err != nil {
-						m.logger.Warnw("Failed to set target session volume", "error", err)
-						adjustmentFailed = true
-					}
+		// iterate all matching sessions and adjust the volume of each one
+		for _, session := range sessions {
+			if session.GetVolume() != targetVolume {
+				if err := session.SetVolume(targetVolume); err != nil {
+					m.logger.Warnw("Failed to set target session volume", "error", err)
+					adjustmentFailed = true
 				}
 			}
 		}
 	}
 
+	if event.trace != nil {
+		event.trace.appliedAt = time.Now()
+		m.deej.latency.record(event.trace)
+
+		if m.deej.Verbose() {
+			m.logger.Debugw("Traced slider event",
+				"slider", event.SliderID,
+				"total", event.trace.appliedAt.Sub(event.trace.readAt))
+		}
+	}
+
+	// the sliders in this one's group are relative to it, so they follow along
+	m.applyGroupVolumes(event.SliderID)
+
 	// if we still haven't found a target or the volume adjustment failed, maybe look for the target again.
 	// processes could've opened since the last time this slider moved.
-	// if they haven't, the cooldown will take care to not spam it up
-	if !targetFound {
+	// if they haven't, the cooldown will take care to not spam it up. a group master may not have targets at all
+	if !targetFound && len(sliderMapping.Targets) > 0 {
 		m.refreshSessions(false)
 	} else if adjustmentFailed {
 
@@ -275,6 +538,114 @@ func (m *sessionMap) handleSliderMoveEvent(event SliderMoveEvent) {
 	}
 }
 
+// handleMuteToggleEvent mutes or unmutes every session the slider targets, and those of its group's sliders.
+// a slider whose group master is muted stays muted either way
+func (m *sessionMap) handleMuteToggleEvent(event MuteToggleEvent) {
+	sliderMapping, err := m.deej.configManager.getSliderMappingByKey(event.SliderID)
+	if err != nil {
+		m.logger.Error(err)
+		return
+	}
+
+	muted := event.Muted || m.deej.configManager.isGroupMuted(event.SliderID)
+
+	targetFound := false
+	adjustmentFailed := false
+
+	for _, target := range sliderMapping.Targets {
+		sessions := m.getTargetSessions(target)
+		if len(sessions) > 0 {
+			targetFound = true
+		}
+
+		for _, session := range sessions {
+			if session.GetMute() != muted {
+				if err := session.SetMute(muted); err != nil {
+					m.logger.Warnw("Failed to set target session mute", "error", err)
+					adjustmentFailed = true
+				}
+			}
+		}
+	}
+
+	// same as with volumes, the sessions we're after might have appeared (or gone stale) since the last refresh
+	if !targetFound && len(sliderMapping.Targets) > 0 {
+		m.refreshSessions(false)
+	} else if adjustmentFailed {
+		m.refreshSessions(true)
+	}
+
+	m.applyGroupMutes(event.SliderID)
+}
+
+// getTargetSessions returns all current sessions matching a single (raw, unresolved) slider target
+func (m *sessionMap) getTargetSessions(target string) []Session {
+
+	// title targets match against whatever the sessions say about themselves, not against map keys
+	if m.targetIsTitle(target) {
+		return m.getSessionsByTitle(target[len(specialTargetTitlePrefix):])
+	}
+
+	sessions := []Session{}
+
+	// resolve the target name by cleaning it up and applying any special transformations.
+	// depending on the transformation applied, this can result in more than one target name
+	for _, resolvedTarget := range m.resolveTarget(target) {
+
+		// check the map for matching sessions
+		if resolvedSessions, ok := m.get(resolvedTarget); ok {
+			sessions = append(sessions, resolvedSessions...)
+		}
+
+		// multi-process apps (browsers, electron) may play audio from a child process with another name
+		if m.deej.configManager.getAggregateChildren() {
+			sessions = append(sessions, m.getByAncestor(resolvedTarget)...)
+		}
+	}
+
+	return sessions
+}
+
+func (m *sessionMap) targetIsTitle(target string) bool {
+	return strings.HasPrefix(strings.ToLower(target), specialTargetTitlePrefix)
+}
+
+// getSessionsByTitle returns all sessions with a display name or window title containing the given text
+func (m *sessionMap) getSessionsByTitle(title string) []Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sessions := []Session{}
+
+	for _, value := range m.m {
+		for _, session := range value {
+			if sessionTitleMatches(session, title) {
+				sessions = append(sessions, session)
+			}
+		}
+	}
+
+	return sessions
+}
+
+// sessionTitleMatches returns true if any of the session's titles contains the given text (case-insensitive)
+func sessionTitleMatches(session Session, title string) bool {
+	titled, ok := session.(titledSession)
+	if !ok || title == "" {
+		return false
+	}
+
+	title = strings.ToLower(title)
+
+	for _, sessionTitle := range titled.Titles() {
+		if strings.Contains(strings.ToLower(sessionTitle), title) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m *sessionMap) targetHasSpecialTransform(target string) bool {
 	return strings.HasPrefix(target, specialTargetTransformPrefix)
 }
@@ -289,9 +660,35 @@ func (m *sessionMap) resolveTarget(target string) []string {
 		return m.applyTargetTransform(strings.TrimPrefix(target, specialTargetTransformPrefix))
 	}
 
+	if m.targetIsSteam(target) {
+		return m.resolveSteamTarget(strings.TrimPrefix(target, specialTargetSteamPrefix))
+	}
+
 	return []string{target}
 }
 
+func (m *sessionMap) targetIsSteam(target string) bool {
+	return strings.HasPrefix(strings.ToLower(target), specialTargetSteamPrefix)
+}
+
+func (m *sessionMap) resolveSteamTarget(steamTargetName string) []string {
+	switch steamTargetName {
+
+	// get the currently running game's processes
+	case specialTargetSteamCurrent:
+		gameProcessNames, err := util.GetSteamGameProcessNames()
+
+		// silently ignore errors here, as this is on deej's "hot path" (and it could just mean steam isn't installed)
+		if err != nil {
+			return nil
+		}
+
+		return gameProcessNames
+	}
+
+	return nil
+}
+
 func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 
 	// select the transformation based on its name
@@ -322,11 +719,112 @@ func (m *sessionMap) applyTargetTransform(specialTargetName string) []string {
 		}
 
 		return targetKeys
+
+	// get mapped sessions that are currently making noise
+	case specialTargetActive:
+		return m.activeSessionKeys()
 	}
 
 	return nil
 }
 
+// activeSessionKeys returns the keys of mapped app sessions that are currently producing audio.
+// if none are, it sticks with the last ones that were, so the knob doesn't go dead whenever the music pauses
+func (m *sessionMap) activeSessionKeys() []string {
+	m.lock.Lock()
+
+	if m.lastActiveCheck.Add(activeSessionCheckCooldown).After(time.Now()) {
+		defer m.lock.Unlock()
+		return m.lastActiveKeys
+	}
+
+	m.lastActiveCheck = time.Now()
+
+	candidates := []activitySession{}
+	for key, sessions := range m.m {
+
+		// system sounds are hardly "the app making noise"
+		if key == systemSessionName {
+			continue
+		}
+
+		for _, session := range sessions {
+			if activity, ok := session.(activitySession); ok {
+				candidates = append(candidates, activity)
+			}
+		}
+	}
+
+	m.lock.Unlock()
+
+	activeKeys := []string{}
+	for _, candidate := range candidates {
+		session := candidate.(Session)
+
+		if m.sessionMapped(session) && candidate.Active() {
+			activeKeys = append(activeKeys, session.Key())
+		}
+	}
+
+	if len(activeKeys) == 0 {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+
+		return m.lastActiveKeys
+	}
+
+	activeKeys = funk.UniqString(activeKeys)
+
+	m.lock.Lock()
+	m.lastActiveKeys = activeKeys
+	m.lock.Unlock()
+
+	return activeKeys
+}
+
+// sliderActive tells whether any of a slider's sessions is producing audio. sessions that can't tell count as
+// active, so sliders controlling them (i.e. master) never look idle
+func (m *sessionMap) sliderActive(sliderID string) bool {
+	sliderMapping, err := m.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return false
+	}
+
+	for _, target := range sliderMapping.Targets {
+		for _, session := range m.getTargetSessions(target) {
+			if activity, ok := session.(activitySession); !ok || activity.Active() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// sliderSessionVolume returns the volume of a slider's first session, as the slider would show it (without the
+// target's offset and its group master's share, and back along its curve and range). there's nothing to return
+// when none of the slider's targets has a session, or its group's master is all the way down
+func (m *sessionMap) sliderSessionVolume(sliderID string) (float32, bool) {
+	sliderMapping, err := m.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return 0, false
+	}
+
+	groupScale := m.deej.configManager.getGroupScale(sliderID)
+	if groupScale == 0 {
+		return 0, false
+	}
+
+	for _, target := range sliderMapping.Targets {
+		for _, session := range m.getTargetSessions(target) {
+			volume := sliderMapping.position(session.GetVolume()/groupScale) - sliderMapping.offsetFor(target)
+			return util.QuantizeScalar(volume, m.deej.configManager.getQuantizationStep()), true
+		}
+	}
+
+	return 0, false
+}
+
 func (m *sessionMap) add(value Session) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -339,6 +837,32 @@ func (m *sessionMap) add(value Session) {
 	} else {
 		m.m[key] = append(existing, value)
 	}
+
+	// also index it by its ancestors, skipping any that share its own name (i.e. chrome.exe under chrome.exe)
+	if treeSession, ok := value.(processTreeSession); ok {
+		for _, ancestor := range funk.UniqString(treeSession.Ancestors()) {
+			if ancestor != key {
+				m.byAncestor[ancestor] = append(m.byAncestor[ancestor], value)
+			}
+		}
+	}
+}
+
+func (m *sessionMap) getByAncestor(name string) []Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.byAncestor[name]
+}
+
+// sessionDescendsFrom returns true if the given process name is one of the session's ancestors
+func sessionDescendsFrom(session Session, name string) bool {
+	treeSession, ok := session.(processTreeSession)
+	if !ok {
+		return false
+	}
+
+	return funk.ContainsString(treeSession.Ancestors(), name)
 }
 
 func (m *sessionMap) get(key string) ([]Session, bool) {
@@ -349,6 +873,19 @@ func (m *sessionMap) get(key string) ([]Session, bool) {
 	return value, ok
 }
 
+// all returns every session deej currently knows about, mapped or not
+func (m *sessionMap) all() []Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sessions := []Session{}
+	for _, value := range m.m {
+		sessions = append(sessions, value...)
+	}
+
+	return sessions
+}
+
 func (m *sessionMap) clear() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -363,6 +900,8 @@ func (m *sessionMap) clear() {
 		delete(m.m, key)
 	}
 
+	m.byAncestor = make(map[string][]Session)
+
 	m.logger.Debug("Session map cleared")
 }
 
@@ -0,0 +1,47 @@
+package deej
+
+import (
+	"syscall"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+)
+
+// go-wca has the IID of IAudioMeterInformation but not the interface itself, so the one method we need
+// (the session's peak level) is called through its vtable here
+
+// go-wca's AudioSessionState constants are numbered from the wrong end (its "active" is really inactive),
+// so the one we compare against is spelled out as windows has it
+const audioSessionStateActive = 1
+
+type iAudioMeterInformation struct {
+	ole.IUnknown
+}
+
+type iAudioMeterInformationVtbl struct {
+	ole.IUnknownVtbl
+	GetPeakValue            uintptr
+	GetMeteringChannelCount uintptr
+	GetChannelsPeakValues   uintptr
+	QueryHardwareSupport    uintptr
+}
+
+func (v *iAudioMeterInformation) VTable() *iAudioMeterInformationVtbl {
+	return (*iAudioMeterInformationVtbl)(unsafe.Pointer(v.RawVTable))
+}
+
+// GetPeakValue gets the session's peak sample level over the last metering period, between 0 and 1
+func (v *iAudioMeterInformation) GetPeakValue(peak *float32) error {
+	hr, _, _ := syscall.Syscall(
+		v.VTable().GetPeakValue,
+		2,
+		uintptr(unsafe.Pointer(v)),
+		uintptr(unsafe.Pointer(peak)),
+		0)
+
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+
+	return nil
+}
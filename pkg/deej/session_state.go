@@ -0,0 +1,110 @@
+package deej
+
+// with the restore startup policy, deej remembers which sessions its sliders ended up controlling and at what
+// volume, so the next run can put them back right away. they're kept in the state file, next to the config
+type savedSession struct {
+	Process string  `yaml:"process"`
+	Slider  string  `yaml:"slider"`
+	Volume  float32 `yaml:"volume"`
+}
+
+// savedSessionVolumes returns the saved volumes by session key
+func (m *sessionMap) savedSessionVolumes() map[string]float32 {
+	volumes := map[string]float32{}
+	for _, session := range m.deej.configManager.getSavedSessions() {
+		volumes[session.Process] = session.Volume
+	}
+
+	return volumes
+}
+
+// warmStart puts the saved volumes back on the sessions that are still around, before the first full
+// enumeration. it only does anything with a session finder that can look up specific processes quickly
+func (m *sessionMap) warmStart() {
+	finder, ok := m.sessionFinder.(processSessionFinder)
+	if !ok {
+		return
+	}
+
+	volumes := m.savedSessionVolumes()
+	if len(volumes) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+
+	sessions, err := finder.GetProcessSessions(names)
+	if err != nil {
+		m.logger.Warnw("Failed to get sessions for warm start", "error", err)
+		return
+	}
+
+	for _, session := range sessions {
+		if err := session.SetVolume(volumes[session.Key()]); err != nil {
+			m.logger.Debugw("Failed to restore session volume", "session", session.Key(), "error", err)
+		}
+
+		session.Release()
+	}
+
+	m.logger.Infow("Restored saved session volumes ahead of enumeration", "sessions", len(sessions))
+}
+
+// restoreSessionState puts the saved volumes back on every session that's around, including any the warm start
+// didn't get to (i.e. on other devices)
+func (m *sessionMap) restoreSessionState() {
+	for name, volume := range m.savedSessionVolumes() {
+		sessions, _ := m.get(name)
+
+		for _, session := range sessions {
+			if session.GetVolume() == volume {
+				continue
+			}
+
+			if err := session.SetVolume(volume); err != nil {
+				m.logger.Debugw("Failed to restore session volume", "session", name, "error", err)
+			}
+		}
+	}
+}
+
+// persistState saves which sessions the sliders control right now, and at what volume, for the next run
+func (m *sessionMap) persistState() error {
+	if m.deej.configManager.getStartupVolumes() != startupVolumesRestore {
+		return nil
+	}
+
+	sessions := []savedSession{}
+	saved := map[string]bool{}
+
+	sliderKeys, _ := m.deej.configManager.getSliderMappingKeys()
+
+	for _, key := range sliderKeys {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(key)
+		if err != nil {
+			continue
+		}
+
+		for _, target := range sliderMapping.Targets {
+			for _, session := range m.getTargetSessions(target) {
+				if saved[session.Key()] {
+					continue
+				}
+
+				saved[session.Key()] = true
+				sessions = append(sessions, savedSession{
+					Process: session.Key(),
+					Slider:  key,
+					Volume:  session.GetVolume(),
+				})
+			}
+		}
+	}
+
+	m.deej.configManager.setSavedSessions(sessions)
+
+	return nil
+}
@@ -0,0 +1,204 @@
+package deej
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// virtualSession is an in-memory audio session, used to run deej without touching any real audio
+type virtualSession struct {
+	baseSession
+
+	lock   sync.Mutex
+	volume float32
+	muted  bool
+	active bool
+}
+
+// virtualSessionFinder hands out a fixed, user-controlled set of in-memory sessions. Sessions outlive
+// session map refreshes, so their volumes can be inspected after the fact
+type virtualSessionFinder struct {
+	logger        *zap.SugaredLogger
+	sessionLogger *zap.SugaredLogger
+
+	lock     sync.Mutex
+	sessions map[string]*virtualSession
+
+	// processes pretending to record audio, i.e. to be in a call
+	capturing map[string]bool
+}
+
+func newVirtualSessionFinder(logger *zap.SugaredLogger) *virtualSessionFinder {
+	sf := &virtualSessionFinder{
+		logger:        logger.Named("session_finder"),
+		sessionLogger: logger.Named("sessions"),
+		sessions:      map[string]*virtualSession{},
+		capturing:     map[string]bool{},
+	}
+
+	// there's always a master output and input, just like on a real system
+	sf.addSession(masterSessionName, 1.0)
+	sf.addSession(inputSessionName, 1.0)
+
+	sf.logger.Debug("Created virtual session finder instance")
+
+	return sf
+}
+
+func (sf *virtualSessionFinder) GetAllSessions() ([]Session, error) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	sessions := make([]Session, 0, len(sf.sessions))
+	for _, session := range sf.sessions {
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// GetProcessSessions returns the given processes' sessions. virtual sessions are never released, so the
+// others are left alone
+func (sf *virtualSessionFinder) GetProcessSessions(names []string) ([]Session, error) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	sessions := []Session{}
+
+	for _, name := range names {
+		if session, ok := sf.sessions[strings.ToLower(name)]; ok {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (sf *virtualSessionFinder) Release() error {
+	sf.logger.Debug("Released virtual session finder instance")
+	return nil
+}
+
+// GetCapturingProcesses returns the processes marked as capturing with setCapturing
+func (sf *virtualSessionFinder) GetCapturingProcesses() ([]string, error) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	names := make([]string, 0, len(sf.capturing))
+	for name := range sf.capturing {
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// setCapturing marks a process as capturing audio (or not). the process doesn't need a session
+func (sf *virtualSessionFinder) setCapturing(name string, capturing bool) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	if capturing {
+		sf.capturing[name] = true
+	} else {
+		delete(sf.capturing, name)
+	}
+}
+
+// addSession creates (or resets) a virtual session with the given name and volume
+func (sf *virtualSessionFinder) addSession(name string, volume float32) {
+	sf.lock.Lock()
+	defer sf.lock.Unlock()
+
+	s := &virtualSession{volume: volume}
+
+	s.name = name
+	s.humanReadableDesc = fmt.Sprintf("%s (virtual)", name)
+	s.master = name == masterSessionName || name == inputSessionName
+
+	s.logger = sf.sessionLogger.Named(strings.TrimSuffix(s.Key(), ".exe"))
+	s.logger.Debugw(sessionCreationLogMessage, "session", s)
+
+	sf.sessions[s.Key()] = s
+}
+
+// getVolume returns the current volume of the named virtual session
+func (sf *virtualSessionFinder) getVolume(name string) (float32, bool) {
+	sf.lock.Lock()
+	session, ok := sf.sessions[strings.ToLower(name)]
+	sf.lock.Unlock()
+
+	if !ok {
+		return 0, false
+	}
+
+	return session.GetVolume(), true
+}
+
+// setActive marks the named virtual session as playing (or not)
+func (sf *virtualSessionFinder) setActive(name string, active bool) bool {
+	sf.lock.Lock()
+	session, ok := sf.sessions[strings.ToLower(name)]
+	sf.lock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	session.lock.Lock()
+	session.active = active
+	session.lock.Unlock()
+
+	return true
+}
+
+func (s *virtualSession) Active() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.active
+}
+
+func (s *virtualSession) GetVolume() float32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.volume
+}
+
+func (s *virtualSession) SetVolume(v float32) error {
+	s.lock.Lock()
+	s.volume = v
+	s.lock.Unlock()
+
+	s.logger.Debugw("Adjusting session volume", "to", fmt.Sprintf("%.2f", v))
+
+	return nil
+}
+
+func (s *virtualSession) GetMute() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.muted
+}
+
+func (s *virtualSession) SetMute(m bool) error {
+	s.lock.Lock()
+	s.muted = m
+	s.lock.Unlock()
+
+	s.logger.Debugw("Adjusting session mute", "to", m)
+
+	return nil
+}
+
+func (s *virtualSession) Release() {
+	s.logger.Debug("Releasing audio session")
+}
+
+func (s *virtualSession) String() string {
+	return fmt.Sprintf(sessionStringFormat, s.humanReadableDesc, s.GetVolume())
+}
@@ -9,20 +9,29 @@ import (
 	ps "github.com/mitchellh/go-ps"
 	wca "github.com/moutend/go-wca"
 	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
 )
 
 var errNoSuchProcess = errors.New("No such process")
 var errRefreshSessions = errors.New("Trigger session refresh")
 
+// sessions peaking below this are considered silent, even if they're technically playing
+const activePeakThreshold = 0.001
+
 type wcaSession struct {
 	baseSession
 
 	pid         uint32
 	processName string
+	ancestors   []string
 
 	control *wca.IAudioSessionControl2
 	volume  *wca.ISimpleAudioVolume
 
+	// may be nil, in which case the session's state alone decides whether it's active
+	meter *iAudioMeterInformation
+
 	eventCtx *ole.GUID
 }
 
@@ -40,15 +49,19 @@ func newWCASession(
 	logger *zap.SugaredLogger,
 	control *wca.IAudioSessionControl2,
 	volume *wca.ISimpleAudioVolume,
+	meter *iAudioMeterInformation,
 	pid uint32,
+	ancestors []string,
 	eventCtx *ole.GUID,
 ) (*wcaSession, error) {
 
 	s := &wcaSession{
-		control:  control,
-		volume:   volume,
-		pid:      pid,
-		eventCtx: eventCtx,
+		control:   control,
+		volume:    volume,
+		meter:     meter,
+		pid:       pid,
+		ancestors: ancestors,
+		eventCtx:  eventCtx,
 	}
 
 	// special treatment for system sounds session
@@ -143,11 +156,75 @@ func (s *wcaSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *wcaSession) GetMute() bool {
+	var muted bool
+
+	if err := s.volume.GetMute(&muted); err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+	}
+
+	return muted
+}
+
+func (s *wcaSession) SetMute(m bool) error {
+	if err := s.volume.SetMute(m, s.eventCtx); err != nil {
+		s.logger.Warnw("Failed to set session mute", "error", err)
+		return fmt.Errorf("adjust session mute: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute", "to", m)
+
+	return nil
+}
+
+func (s *wcaSession) Titles() []string {
+	titles := util.GetWindowTitles(s.pid)
+
+	// apps may set a display name for their session, though most don't. indirect resource strings
+	// (starting with @) aren't worth resolving, they're never what a user would type in
+	var displayName string
+	if err := s.control.GetDisplayName(&displayName); err == nil && displayName != "" && !strings.HasPrefix(displayName, "@") {
+		titles = append(titles, displayName)
+	}
+
+	return titles
+}
+
+func (s *wcaSession) Ancestors() []string {
+	return s.ancestors
+}
+
+// Active reports whether the session is actually making noise. plenty of apps keep an active stream
+// around while silent (browsers, games in menus), so the peak meter has the final say when available
+func (s *wcaSession) Active() bool {
+	var state uint32
+
+	if err := s.control.GetState(&state); err != nil || state != audioSessionStateActive {
+		return false
+	}
+
+	if s.meter == nil {
+		return true
+	}
+
+	var peak float32
+
+	if err := s.meter.GetPeakValue(&peak); err != nil {
+		return true
+	}
+
+	return peak > activePeakThreshold
+}
+
 func (s *wcaSession) Release() {
 	s.logger.Debug("Releasing audio session")
 
 	s.volume.Release()
 	s.control.Release()
+
+	if s.meter != nil {
+		s.meter.Release()
+	}
 }
 
 func (s *wcaSession) String() string {
@@ -183,6 +260,35 @@ func (s *masterSession) SetVolume(v float32) error {
 	return nil
 }
 
+func (s *masterSession) GetMute() bool {
+	var muted bool
+
+	if err := s.volume.GetMute(&muted); err != nil {
+		s.logger.Warnw("Failed to get session mute", "error", err)
+	}
+
+	return muted
+}
+
+func (s *masterSession) SetMute(m bool) error {
+	if s.stale {
+		s.logger.Warnw("Session expired because default device has changed, triggering session refresh")
+		return errRefreshSessions
+	}
+
+	if err := s.volume.SetMute(m, s.eventCtx); err != nil {
+		s.logger.Warnw("Failed to set session mute",
+			"error", err,
+			"mute", m)
+
+		return fmt.Errorf("adjust session mute: %w", err)
+	}
+
+	s.logger.Debugw("Adjusting session mute", "to", m)
+
+	return nil
+}
+
 func (s *masterSession) Release() {
 	s.logger.Debug("Releasing audio session")
 
@@ -0,0 +1,64 @@
+package deej
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// how long (in seconds) deej waits for its subsystems to stop before giving up on them, unless the config says otherwise
+const defaultShutdownTimeout = 5
+
+// shutdownStep is one subsystem's part in stopping deej
+type shutdownStep struct {
+	name string
+	stop func() error
+}
+
+// shutdown runs the given steps in order, until the context is done. a step that's still running by then is
+// abandoned (along with all steps after it) and logged, so a wedged serial close or a stuck consumer can't keep
+// deej from exiting. it returns an error if any step failed or was abandoned
+func (d *Deej) shutdown(ctx context.Context, steps []shutdownStep) error {
+	logger := d.logger.Named("shutdown")
+
+	failed := []string{}
+	forced := []string{}
+
+	for idx, step := range steps {
+		started := time.Now()
+		done := make(chan error, 1)
+
+		go func(step shutdownStep) {
+			done <- step.stop()
+		}(step)
+
+		select {
+		case err := <-done:
+			if err != nil {
+				logger.Warnw("Subsystem failed to stop", "subsystem", step.name, "error", err)
+				failed = append(failed, step.name)
+			} else {
+				logger.Debugw("Subsystem stopped", "subsystem", step.name, "took", time.Since(started))
+			}
+
+		case <-ctx.Done():
+			for _, abandoned := range steps[idx:] {
+				forced = append(forced, abandoned.name)
+			}
+
+			logger.Warnw("Shutdown cut short, forcing remaining subsystems to stop",
+				"reason", ctx.Err(),
+				"stuck", step.name,
+				"forced", forced)
+
+			return fmt.Errorf("shutdown cut short (%w), forced: %s", ctx.Err(), strings.Join(forced, ", "))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to stop: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// a slider's color is how everything that draws it tells it apart: the tray's menus, the mini mixer's fader and
+// the board's (or a widget's) LEDs all show the same one. colors are hex, "#ff8800" or the short "#f80", and deej
+// keeps them as the long lowercase form so consumers only ever see one
+
+var sliderColorPattern = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// the tray's color swatches, which are drawn at the size menu item icons are
+const colorSwatchSize = 16
+
+// validateSliderColors puts the sliders' colors in their long form, and drops (and complains about) the ones
+// that aren't colors
+func validateSliderColors(logger *zap.SugaredLogger, mappings map[string]SliderMapping) {
+	for key, mapping := range mappings {
+		if mapping.Color == "" {
+			continue
+		}
+
+		color, ok := normalizeColor(mapping.Color)
+		if !ok {
+			logger.Warnw("Ignoring slider color, it must be a hex color like #ff8800", "slider", key, "color", mapping.Color)
+		}
+
+		mapping.Color = color
+		mappings[key] = mapping
+	}
+}
+
+// normalizeColor returns a hex color in its long lowercase form, or false if it isn't one
+func normalizeColor(color string) (string, bool) {
+	match := sliderColorPattern.FindStringSubmatch(strings.TrimSpace(color))
+	if match == nil {
+		return "", false
+	}
+
+	digits := strings.ToLower(match[1])
+	if len(digits) == 3 {
+		digits = string([]byte{digits[0], digits[0], digits[1], digits[1], digits[2], digits[2]})
+	}
+
+	return "#" + digits, true
+}
+
+// colorSwatchIcon draws a square of the given (normalized) color as an icon for a tray menu item. the tray takes
+// icons in .ico form on every platform, so it's one of those, holding a single uncompressed bitmap
+func colorSwatchIcon(color string) []byte {
+	rgb, err := strconv.ParseUint(strings.TrimPrefix(color, "#"), 16, 32)
+	if err != nil {
+		return nil
+	}
+
+	const (
+		size       = colorSwatchSize
+		headerSize = 6 + 16
+		infoSize   = 40
+		pixelsSize = size * size * 4
+
+		// one bit per pixel, rows padded to 4 bytes. all zeroes, since the alpha channel says what's drawn
+		maskSize = size * 4
+	)
+
+	buffer := &bytes.Buffer{}
+	write := func(values ...interface{}) {
+		for _, value := range values {
+			binary.Write(buffer, binary.LittleEndian, value)
+		}
+	}
+
+	// the file header, and the one image's entry in its directory
+	write(uint16(0), uint16(1), uint16(1))
+	write(uint8(size), uint8(size), uint8(0), uint8(0), uint16(1), uint16(32), uint32(infoSize+pixelsSize+maskSize), uint32(headerSize))
+
+	// the bitmap's header, whose height counts the mask as well
+	write(uint32(infoSize), int32(size), int32(size*2), uint16(1), uint16(32), uint32(0), uint32(pixelsSize+maskSize),
+		int32(0), int32(0), uint32(0), uint32(0))
+
+	// the pixels, as blue, green, red and alpha
+	for idx := 0; idx < size*size; idx++ {
+		write(uint8(rgb), uint8(rgb>>8), uint8(rgb>>16), uint8(0xff))
+	}
+
+	buffer.Write(make([]byte, maskSize))
+
+	return buffer.Bytes()
+}
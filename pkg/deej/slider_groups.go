@@ -0,0 +1,135 @@
+package deej
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// a slider can be part of another slider's group (i.e. music and video under media), making its volume relative
+// to the group master's: with media at half, music at full plays at half. masters can be in groups of their own,
+// and a master with no targets of its own just scales its group. muting a master mutes its whole group too
+
+// validateSliderGroups drops (and complains about) groups deej can't follow: ones whose master doesn't exist,
+// and ones that loop back around to the slider itself, leaving those sliders on their own
+func validateSliderGroups(logger *zap.SugaredLogger, mappings map[string]SliderMapping) {
+	keys := make([]string, 0, len(mappings))
+	for key := range mappings {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		mapping := mappings[key]
+		if mapping.Group == "" {
+			continue
+		}
+
+		if _, ok := mappings[mapping.Group]; !ok {
+			logger.Warnw("Ignoring slider group whose master doesn't exist", "slider", key, "group", mapping.Group)
+			mapping.Group = ""
+			mappings[key] = mapping
+
+			continue
+		}
+
+		// follow the masters up, until they run out or come back around
+		seen := map[string]bool{key: true}
+		for master := mapping.Group; master != ""; master = mappings[master].Group {
+			if seen[master] {
+				logger.Warnw("Ignoring slider group that contains itself", "slider", key, "group", mapping.Group)
+				mapping.Group = ""
+				mappings[key] = mapping
+
+				break
+			}
+
+			seen[master] = true
+		}
+	}
+}
+
+// getGroupScale returns what a slider's volume is multiplied by before it reaches its sessions: the volume of
+// its group's master, and of that one's master and so on. a slider that isn't in a group is at 1
+func (cm *ConfigManager) getGroupScale(key string) float32 {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	scale := float32(1)
+
+	for master := cm.Config.SliderMappings[key].Group; master != ""; {
+		mapping, ok := cm.Config.SliderMappings[master]
+		if !ok {
+			break
+		}
+
+		scale *= mapping.volume(mapping.Volume)
+		master = mapping.Group
+	}
+
+	return scale
+}
+
+// isGroupMuted tells whether any of a slider's masters (its group's, that one's and so on) is muted,
+// which keeps the slider muted along with them
+func (cm *ConfigManager) isGroupMuted(key string) bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	for master := cm.Config.SliderMappings[key].Group; master != ""; {
+		mapping, ok := cm.Config.SliderMappings[master]
+		if !ok {
+			break
+		}
+
+		if mapping.Muted {
+			return true
+		}
+
+		master = mapping.Group
+	}
+
+	return false
+}
+
+// getGroupMembers returns the keys of the sliders in the given slider's group, not counting their own groups' members
+func (cm *ConfigManager) getGroupMembers(key string) []string {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	members := []string{}
+	for _, memberKey := range cm.orderedSliderKeys {
+		if cm.Config.SliderMappings[memberKey].Group == key {
+			members = append(members, memberKey)
+		}
+	}
+
+	return members
+}
+
+// applyGroupVolumes sets the volumes of a group's sliders again, after its master moved. each of them does the
+// same for its own group, if it has one
+func (m *sessionMap) applyGroupVolumes(key string) {
+	for _, member := range m.deej.configManager.getGroupMembers(key) {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(member)
+		if err != nil {
+			continue
+		}
+
+		m.handleSliderMoveEvent(SliderMoveEvent{SliderID: member, PercentValue: sliderMapping.Volume})
+	}
+}
+
+// applyGroupMutes mutes or unmutes a group's sliders again, after its master was. each of them does the
+// same for its own group, if it has one
+func (m *sessionMap) applyGroupMutes(key string) {
+	for _, member := range m.deej.configManager.getGroupMembers(key) {
+		sliderMapping, err := m.deej.configManager.getSliderMappingByKey(member)
+		if err != nil {
+			continue
+		}
+
+		m.handleMuteToggleEvent(MuteToggleEvent{SliderID: member, Muted: sliderMapping.Muted})
+	}
+}
@@ -0,0 +1,88 @@
+package deej
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// a locked slider ignores the board: its moves and mute toggles are dropped until it's unlocked again, so a
+// volume that's been dialed in (i.e. the mic's) can't be knocked off by accident. software (the tray, the API,
+// rules) still moves it as usual. like its volume, the lock is kept in the state file and survives restarts, and
+// "locked: true" in the config locks it from the start
+
+// isSliderLocked tells whether the given slider ignores the board
+func (cm *ConfigManager) isSliderLocked(key string) bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	return cm.Config.SliderMappings[key].Locked
+}
+
+// setSliderLocked locks or unlocks a slider
+func (cm *ConfigManager) setSliderLocked(key string, locked bool) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	mapping, ok := cm.Config.SliderMappings[key]
+	if !ok {
+		return fmt.Errorf("slider mapping with key '%s' not found", key)
+	}
+
+	if mapping.Locked == locked {
+		return nil
+	}
+
+	mapping.Locked = locked
+	cm.Config.SliderMappings[key] = mapping
+	cm.recordSliderState(key)
+	cm.logger.Debugw("Updated slider lock", "key", key, "locked", locked)
+
+	return nil
+}
+
+// setSliderLocked locks or unlocks a slider, and lets the board (and the tray) know
+func (d *Deej) setSliderLocked(sliderID string, locked bool) error {
+	if err := d.configManager.setSliderLocked(sliderID, locked); err != nil {
+		return err
+	}
+
+	d.logger.Infow("Set slider lock", "slider", sliderID, "locked", locked)
+	d.feedback.stateChanged()
+
+	return nil
+}
+
+// toggleSliderLock locks a slider if it's unlocked and the other way around, and returns whether it's locked now
+func (d *Deej) toggleSliderLock(sliderID string) (bool, error) {
+	locked := !d.configManager.isSliderLocked(sliderID)
+
+	if err := d.setSliderLocked(sliderID, locked); err != nil {
+		return false, err
+	}
+
+	return locked, nil
+}
+
+// lockedOut tells whether input from the board for the given slider should be dropped, as the slider is locked
+func (sio *SerialIO) lockedOut(logger *zap.SugaredLogger, sliderID string) bool {
+	if !sio.deej.configManager.isSliderLocked(sliderID) {
+		return false
+	}
+
+	if sio.deej.Verbose() {
+		logger.Debugw("Slider is locked, ignoring board input", "slider", sliderID)
+	}
+
+	return true
+}
+
+// dispatchBoardSliderMove is dispatchSliderMove for moves that come from the board's own sliders and encoders,
+// which locked sliders ignore
+func (sio *SerialIO) dispatchBoardSliderMove(logger *zap.SugaredLogger, moveEvent SliderMoveEvent) {
+	if sio.lockedOut(logger, moveEvent.SliderID) {
+		return
+	}
+
+	sio.dispatchSliderMove(moveEvent)
+}
@@ -0,0 +1,71 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.uber.org/zap"
+)
+
+// a slider can have two preset volumes to flip between (i.e. music at 0.6 and 0.15, for when someone walks in).
+// the toggle goes to whichever of them the slider is further from, so it drops down from anywhere near the
+// higher one, and comes back up from anywhere near the lower one
+
+var errNoPresets = errors.New("the slider has no presets")
+
+// validateSliderPresets drops (and complains about) presets deej can't toggle between: anything but two
+// volumes, or volumes outside of 0-1
+func validateSliderPresets(logger *zap.SugaredLogger, mappings map[string]SliderMapping) {
+	for key, mapping := range mappings {
+		if len(mapping.Presets) == 0 {
+			continue
+		}
+
+		valid := len(mapping.Presets) == 2
+		for _, preset := range mapping.Presets {
+			if preset < 0 || preset > 1 {
+				valid = false
+			}
+		}
+
+		if !valid {
+			logger.Warnw("Ignoring slider presets, they must be two volumes between 0 and 1", "slider", key, "presets", mapping.Presets)
+			mapping.Presets = nil
+			mappings[key] = mapping
+		}
+	}
+}
+
+// nextPreset returns the preset the slider's toggle goes to from where it is now
+func (sm SliderMapping) nextPreset() (float32, error) {
+	if len(sm.Presets) != 2 {
+		return 0, errNoPresets
+	}
+
+	first, second := sm.Presets[0], sm.Presets[1]
+	if math.Abs(float64(sm.Volume-first)) > math.Abs(float64(sm.Volume-second)) {
+		return first, nil
+	}
+
+	return second, nil
+}
+
+// togglePreset moves a slider to the other one of its presets, and returns where it went
+func (d *Deej) togglePreset(sliderID string) (float32, error) {
+	mapping, err := d.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return 0, fmt.Errorf("get slider mapping: %w", err)
+	}
+
+	volume, err := mapping.nextPreset()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.SetSliderValue(sliderID, volume); err != nil {
+		return 0, fmt.Errorf("set slider volume: %w", err)
+	}
+
+	return volume, nil
+}
@@ -0,0 +1,130 @@
+package deej
+
+import (
+	"sort"
+
+	"go.uber.org/zap"
+)
+
+// StartupAction is something to do once deej is up, so the desk comes up the same way every time: select a slider
+// on the (first) encoder, switch to a profile, mute or unmute a slider, or set sliders' volumes. Each action
+// does exactly one of these, and they run in order
+type StartupAction struct {
+	Select  string             `yaml:"select,omitempty" doc:"Select this slider on the encoder"`
+	Profile string             `yaml:"profile,omitempty" doc:"Switch to this profile"`
+	Mute    string             `yaml:"mute,omitempty" doc:"Mute this slider"`
+	Unmute  string             `yaml:"unmute,omitempty" doc:"Unmute this slider"`
+	Volume  map[string]float32 `yaml:"volume,omitempty" doc:"Set these sliders' volumes (0-1)"`
+}
+
+// kinds returns how many things the action would do, which should be exactly one
+func (a StartupAction) kinds() int {
+	kinds := 0
+
+	for _, set := range []bool{a.Select != "", a.Profile != "", a.Mute != "", a.Unmute != "", len(a.Volume) > 0} {
+		if set {
+			kinds++
+		}
+	}
+
+	return kinds
+}
+
+// validStartupActions drops (and complains about) startup actions that do nothing, or more than one thing
+func validStartupActions(logger *zap.SugaredLogger, actions []StartupAction) []StartupAction {
+	valid := make([]StartupAction, 0, len(actions))
+
+	for idx, action := range actions {
+		if kinds := action.kinds(); kinds != 1 {
+			logger.Warnw("Ignoring startup action that doesn't do exactly one thing", "action", idx, "kinds", kinds)
+			continue
+		}
+
+		outOfRange := false
+		for sliderID, level := range action.Volume {
+			if level < 0 || level > 1 {
+				logger.Warnw("Ignoring startup action with a volume outside of 0-1", "action", idx, "slider", sliderID, "level", level)
+				outOfRange = true
+			}
+		}
+
+		if outOfRange {
+			continue
+		}
+
+		valid = append(valid, action)
+	}
+
+	return valid
+}
+
+// runStartupActions runs the config's startup actions, once everything they might touch is running.
+// an action that fails is logged and skipped, it doesn't stop the ones after it
+func (d *Deej) runStartupActions() {
+	actions := d.configManager.getStartupActions()
+	if len(actions) == 0 {
+		return
+	}
+
+	logger := d.logger.Named("startup")
+
+	for idx, action := range actions {
+		logger := logger.With("action", idx)
+
+		switch {
+		case action.Select != "":
+			if err := d.serial.selectSlider(0, action.Select); err != nil {
+				logger.Warnw("Failed to select slider", "slider", action.Select, "error", err)
+				continue
+			}
+
+			logger.Infow("Selected slider", "slider", action.Select)
+
+		case action.Profile != "":
+			if err := d.configManager.switchProfile(action.Profile); err != nil {
+				logger.Warnw("Failed to switch profile", "profile", action.Profile, "error", err)
+				continue
+			}
+
+			logger.Infow("Switched profile", "profile", action.Profile)
+
+		case action.Mute != "":
+			d.setMuted(logger, action.Mute, true)
+
+		case action.Unmute != "":
+			d.setMuted(logger, action.Unmute, false)
+
+		default:
+			sliderIDs := make([]string, 0, len(action.Volume))
+			for sliderID := range action.Volume {
+				sliderIDs = append(sliderIDs, sliderID)
+			}
+
+			sort.Strings(sliderIDs)
+
+			for _, sliderID := range sliderIDs {
+				if err := d.SetSliderValue(sliderID, action.Volume[sliderID]); err != nil {
+					logger.Warnw("Failed to set slider volume", "slider", sliderID, "error", err)
+					continue
+				}
+
+				logger.Infow("Set slider volume", "slider", sliderID, "volume", action.Volume[sliderID])
+			}
+		}
+	}
+}
+
+// setMuted mutes or unmutes a slider, unless it already is
+func (d *Deej) setMuted(logger *zap.SugaredLogger, sliderID string, muted bool) {
+	mapping, err := d.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		logger.Warnw("Failed to set slider mute", "slider", sliderID, "error", err)
+		return
+	}
+
+	if mapping.Muted != muted {
+		d.serial.toggleMute(logger, sliderID)
+	}
+
+	logger.Infow("Set slider mute", "slider", sliderID, "muted", muted)
+}
@@ -0,0 +1,45 @@
+package deej
+
+// Status is a point-in-time snapshot of deej's state, meant for anything that reports on it
+type Status struct {
+	Version     string
+	Build       BuildInfo
+	Connected   bool
+	SerialPort  string
+	DeviceID    string
+	LinkQuality LinkQuality
+
+	// the most recent boards connecting, going away and failing to connect, oldest first
+	ConnectionHistory []ConnectionHistoryEntry
+}
+
+// Status returns a snapshot of deej's current state
+func (d *Deej) Status() Status {
+	return Status{
+		Version:     d.buildInfo.Version(),
+		Build:       d.buildInfo,
+		Connected:   d.serial.connected,
+		SerialPort:  d.serial.portName(),
+		DeviceID:    d.serial.DeviceID(),
+		LinkQuality: d.serial.LinkQuality(),
+
+		ConnectionHistory: d.connectionLog.snapshot(),
+	}
+}
+
+// Stats holds deej's performance counters
+type Stats struct {
+	Latency LatencyStats
+
+	// slider events that lower priority consumers missed because they fell behind
+	DroppedSliderEvents uint64
+}
+
+// Stats returns a snapshot of deej's performance counters. Latency is only
+// sampled while trace_latency is enabled in the config
+func (d *Deej) Stats() Stats {
+	return Stats{
+		Latency:             d.latency.stats(),
+		DroppedSliderEvents: d.serial.events.droppedSliderMoveCount(),
+	}
+}
@@ -0,0 +1,65 @@
+package deej
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+
+	// status bars style the module by its class, i.e. to grey it out while muted
+	statusBarClassUnmuted = "unmuted"
+	statusBarClassMuted   = "muted"
+	statusBarClassOffline = "offline"
+
+	// a status bar doesn't need every step of a moving slider, so followers get a line at most this often
+	statusBarMinInterval = 100 * time.Millisecond
+
+	// how often a follower tries to reach deej again, while it isn't running
+	statusBarRetryInterval = 5 * time.Second
+)
+
+// StatusBarLine is the selected slider's state the way status bar modules take it: the JSON of Waybar's custom
+// modules, which other bars (i.e. Polybar) can pick the text out of. Without encoders, the first slider counts
+// as selected
+type StatusBarLine struct {
+	Text       string `json:"text"`
+	Tooltip    string `json:"tooltip"`
+	Class      string `json:"class"`
+	Percentage int    `json:"percentage"`
+
+	// the selected slider's name, for bars that pick an icon by it
+	Alt string `json:"alt"`
+}
+
+func (d *Deej) statusBarLine() StatusBarLine {
+	state := d.feedback.state()
+	line := StatusBarLine{Class: statusBarClassUnmuted}
+	tooltip := []string{}
+
+	for idx, slider := range state.Sliders {
+		level := d.formatPercent(slider.Volume)
+		if slider.Muted {
+			level += " (muted)"
+		}
+
+		tooltip = append(tooltip, fmt.Sprintf("%s: %s", slider.Name, level))
+
+		if slider.Name != state.Selected && (state.Selected != "" || idx != 0) {
+			continue
+		}
+
+		line.Text = fmt.Sprintf("%s %s", slider.Name, level)
+		line.Percentage = slider.Percent
+		line.Alt = slider.Name
+
+		if slider.Muted {
+			line.Class = statusBarClassMuted
+		}
+	}
+
+	line.Tooltip = strings.Join(tooltip, "\n")
+
+	return line
+}
@@ -0,0 +1,212 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+const (
+	telemetryPreviewFilename = "deej-usage-statistics.json"
+
+	// give the board a chance to connect before the first report, so it describes a running setup
+	telemetryFirstReportDelay = time.Minute
+	telemetryReportInterval   = 24 * time.Hour
+
+	// crashes older than this aren't counted
+	telemetryCrashWindow = 30 * 24 * time.Hour
+
+	telemetryRequestTimeout = 10 * time.Second
+)
+
+// TelemetryReport is everything deej's usage statistics contain. It's deliberately coarse: no
+// identifiers, no paths, no process or device names - nothing that could tell two users apart
+type TelemetryReport struct {
+	Version            string `json:"version"`
+	OS                 string `json:"os"`
+	Arch               string `json:"arch"`
+	Transport          string `json:"transport"`
+	AudioBackend       string `json:"audio_backend"`
+	SliderCount        int    `json:"slider_count"`
+	VirtualSliderCount int    `json:"virtual_slider_count"`
+	RemoteControl      bool   `json:"remote_control"`
+	RecentCrashCount   int    `json:"recent_crash_count"`
+}
+
+// telemetry periodically sends a TelemetryReport to the configured endpoint, if the user opted in.
+// Whether or not they did, every report is also written to the log directory, so they can see exactly what it contains
+type telemetry struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+	client *http.Client
+}
+
+func newTelemetry(deej *Deej, logger *zap.SugaredLogger) *telemetry {
+	logger = logger.Named("telemetry")
+
+	t := &telemetry{
+		deej:   deej,
+		logger: logger,
+		client: &http.Client{Timeout: telemetryRequestTimeout},
+	}
+
+	logger.Debug("Created telemetry instance")
+
+	return t
+}
+
+// run reports on a schedule until deej stops. the config is consulted before every report, so opting
+// out takes effect right away
+func (t *telemetry) run() {
+	timer := time.NewTimer(telemetryFirstReportDelay)
+	defer timer.Stop()
+
+	for range timer.C {
+		t.deej.wakeups.record("telemetry")
+
+		if err := t.report(); err != nil {
+			t.logger.Warnw("Failed to send usage statistics", "error", err)
+		}
+
+		timer.Reset(telemetryReportInterval)
+	}
+}
+
+// report builds and previews the current report, then sends it if the user opted in
+func (t *telemetry) report() error {
+	report := t.build()
+
+	if _, err := t.writePreview(report); err != nil {
+		t.logger.Warnw("Failed to write usage statistics preview", "error", err)
+	}
+
+	settings := t.deej.configManager.getTelemetry()
+	if !settings.Enabled {
+		t.logger.Debug("Usage statistics are disabled, not sending")
+		return nil
+	}
+
+	if settings.Endpoint == "" {
+		t.logger.Warn("Usage statistics are enabled without an endpoint, not sending")
+		return nil
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("marshal report: %w", err)
+	}
+
+	// self-hosted collectors tend to take a token in the url, which can be kept in the keychain
+	endpoint, err := resolveSecret(settings.Endpoint)
+	if err != nil {
+		return fmt.Errorf("resolve endpoint: %w", err)
+	}
+
+	response, err := t.client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post report: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("post report: unexpected status %s", response.Status)
+	}
+
+	t.logger.Infow("Sent usage statistics", "endpoint", settings.Endpoint, "report", report)
+
+	return nil
+}
+
+func (t *telemetry) build() TelemetryReport {
+	remote := t.deej.configManager.getRemoteControl()
+
+	return TelemetryReport{
+		Version:            t.deej.buildInfo.Version(),
+		OS:                 runtime.GOOS,
+		Arch:               runtime.GOARCH,
+		Transport:          t.deej.serial.connectedTransports(),
+		AudioBackend:       t.audioBackend(),
+		SliderCount:        t.deej.configManager.getSliderMappingCount(),
+		VirtualSliderCount: len(t.deej.configManager.getVirtualSliderKeys()),
+		RemoteControl:      remote.Listen != "" || remote.ForwardTo != "",
+		RecentCrashCount:   countRecentCrashes(),
+	}
+}
+
+func (t *telemetry) audioBackend() string {
+	if _, ok := t.deej.sessions.sessionFinder.(*virtualSessionFinder); ok {
+		return "virtual"
+	}
+
+	if util.Linux() {
+		return "pulseaudio"
+	}
+
+	return "wasapi"
+}
+
+// writePreview writes the report, exactly as it would be sent, to the log directory and returns its path
+func (t *telemetry) writePreview(report TelemetryReport) (string, error) {
+	if err := util.EnsureDirExists(logDirectory); err != nil {
+		return "", fmt.Errorf("ensure log directory exists: %w", err)
+	}
+
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal report: %w", err)
+	}
+
+	previewPath := filepath.Join(logDirectory, telemetryPreviewFilename)
+	if err := ioutil.WriteFile(previewPath, body, 0644); err != nil {
+		return "", fmt.Errorf("write preview: %w", err)
+	}
+
+	return previewPath, nil
+}
+
+// preview writes a fresh report to the log directory and opens it for the user to look at
+func (t *telemetry) preview() {
+	previewPath, err := t.writePreview(t.build())
+	if err != nil {
+		t.logger.Warnw("Failed to write usage statistics preview", "error", err)
+		return
+	}
+
+	editor := "notepad.exe"
+	if util.Linux() {
+		editor = "gedit"
+	}
+
+	if err := util.OpenExternal(t.logger, editor, previewPath); err != nil {
+		t.logger.Warnw("Failed to open usage statistics preview", "error", err)
+	}
+}
+
+// countRecentCrashes counts the crashlogs deej left behind lately
+func countRecentCrashes() int {
+	entries, err := ioutil.ReadDir(logDirectory)
+	if err != nil {
+		return 0
+	}
+
+	crashlogPrefix := strings.SplitN(crashlogFilename, "%", 2)[0]
+	count := 0
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), crashlogPrefix) && time.Since(entry.ModTime()) < telemetryCrashWindow {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,187 @@
+package deej
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+
+	// how long an expectation may take to come true, since sessions are adjusted asynchronously
+	testScriptExpectTimeout = time.Second
+
+	// how close a session's volume must be to the expected one
+	testScriptVolumeTolerance = 0.005
+)
+
+// RunTestScript runs deej's full input pipeline (line parsing, slider events, session mapping) against the
+// virtual audio backend, driven by a script instead of a board. It's meant for reproducible end-to-end checks.
+//
+// Each non-empty, non-comment (#) script line is one of:
+//
+//	session <name> [volume]   create a virtual audio session (default volume 1.0)
+//	play <name>               mark a virtual audio session as producing audio
+//	pause <name>              mark a virtual audio session as silent
+//	capture <process>         mark a process as recording audio (i.e. a conferencing app in a call)
+//	release <process>         mark a process as no longer recording audio
+//	send <line>               feed a raw line into the parser, as if the board had sent it
+//	sleep <duration>          wait, e.g. "sleep 200ms"
+//	expect <name> <volume>    fail unless the named session reaches the given volume
+//
+// The config is loaded as usual. An error is returned for the first failing line
+func (d *Deej) RunTestScript(path string) error {
+	logger := d.logger.Named("test_script")
+
+	virtualFinder, ok := d.sessions.sessionFinder.(*virtualSessionFinder)
+	if !ok {
+		return errors.New("test scripts require the virtual audio backend")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open test script: %w", err)
+	}
+	defer file.Close()
+
+	if err := d.configManager.Load(); err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	if err := d.sessions.initialize(); err != nil {
+		return fmt.Errorf("init session map: %w", err)
+	}
+
+	// meetings are detected in the background, as usual
+	go d.meetings.run()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		logger.Debugw("Running script line", "lineNumber", lineNumber, "line", line)
+
+		if err := d.runTestScriptLine(virtualFinder, line); err != nil {
+			logger.Warnw("Script line failed", "lineNumber", lineNumber, "line", line, "error", err)
+			return fmt.Errorf("line %d (%s): %w", lineNumber, line, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read test script: %w", err)
+	}
+
+	logger.Info("Test script passed")
+
+	return nil
+}
+
+func (d *Deej) runTestScriptLine(virtualFinder *virtualSessionFinder, line string) error {
+	fields := strings.Fields(line)
+	command, args := fields[0], fields[1:]
+
+	switch command {
+	case "session":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: session <name> [volume]")
+		}
+
+		volume := float32(1.0)
+		if len(args) == 2 {
+			parsed, err := strconv.ParseFloat(args[1], 32)
+			if err != nil {
+				return fmt.Errorf("parse volume: %w", err)
+			}
+
+			volume = float32(parsed)
+		}
+
+		virtualFinder.addSession(args[0], volume)
+
+		// performance: forcing is fine here, scripts are short and we need the new session mapped right away
+		d.sessions.refreshSessions(true)
+
+	case "play", "pause":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <name>", command)
+		}
+
+		if !virtualFinder.setActive(args[0], command == "play") {
+			return fmt.Errorf("no such session: %s", args[0])
+		}
+
+	case "capture", "release":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <process>", command)
+		}
+
+		virtualFinder.setCapturing(args[0], command == "capture")
+
+	case "send":
+		if len(args) != 1 {
+			return errors.New("usage: send <line>")
+		}
+
+		d.serial.handleLine(d.serial.logger, args[0]+"\n", time.Now())
+
+	case "sleep":
+		if len(args) != 1 {
+			return errors.New("usage: sleep <duration>")
+		}
+
+		duration, err := time.ParseDuration(args[0])
+		if err != nil {
+			return fmt.Errorf("parse duration: %w", err)
+		}
+
+		<-time.After(duration)
+
+	case "expect":
+		if len(args) != 2 {
+			return errors.New("usage: expect <name> <volume>")
+		}
+
+		expected, err := strconv.ParseFloat(args[1], 32)
+		if err != nil {
+			return fmt.Errorf("parse volume: %w", err)
+		}
+
+		var actual float32
+		deadline := time.Now().Add(testScriptExpectTimeout)
+
+		for {
+			volume, ok := virtualFinder.getVolume(args[0])
+			if !ok {
+				return fmt.Errorf("no such session: %s", args[0])
+			}
+
+			actual = volume
+			if math.Abs(float64(actual)-expected) < testScriptVolumeTolerance {
+				return nil
+			}
+
+			if time.Now().After(deadline) {
+				return fmt.Errorf("expected volume %.3f, got %.3f", expected, actual)
+			}
+
+			<-time.After(10 * time.Millisecond)
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	return nil
+}
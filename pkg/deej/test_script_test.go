@@ -0,0 +1,142 @@
+package deej
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRunTestScriptLineRejectsMalformedLines(t *testing.T) {
+	d := &Deej{}
+	virtualFinder := newVirtualSessionFinder(zap.NewNop().Sugar())
+
+	tests := []struct {
+		line string
+		err  string
+	}{
+		{"session", "usage: session <name> [volume]"},
+		{"session a 0.5 extra", "usage: session <name> [volume]"},
+		{"session a loud", "parse volume"},
+		{"play", "usage: play <name>"},
+		{"pause a b", "usage: pause <name>"},
+		{"play nope.exe", "no such session: nope.exe"},
+		{"capture", "usage: capture <process>"},
+		{"release a b", "usage: release <process>"},
+		{"send", "usage: send <line>"},
+		{"send 1 2", "usage: send <line>"},
+		{"sleep", "usage: sleep <duration>"},
+		{"sleep forever", "parse duration"},
+		{"expect a", "usage: expect <name> <volume>"},
+		{"expect a loud", "parse volume"},
+		{"expect nope.exe 0.5", "no such session: nope.exe"},
+		{"jump", "unknown command: jump"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.line, func(t *testing.T) {
+			err := d.runTestScriptLine(virtualFinder, test.line)
+			if err == nil {
+				t.Fatalf("expected an error containing %q, got none", test.err)
+			}
+
+			if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected an error containing %q, got %q", test.err, err)
+			}
+		})
+	}
+}
+
+func TestVirtualSessionFinderSessions(t *testing.T) {
+	sf := newVirtualSessionFinder(zap.NewNop().Sugar())
+
+	sf.addSession("Music.exe", 0.25)
+
+	tests := []struct {
+		name   string
+		volume float32
+		ok     bool
+	}{
+		{masterSessionName, 1.0, true},
+		{inputSessionName, 1.0, true},
+		{"music.exe", 0.25, true},
+		{"MUSIC.EXE", 0.25, true},
+		{"nope.exe", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			volume, ok := sf.getVolume(test.name)
+			if ok != test.ok || volume != test.volume {
+				t.Errorf("getVolume(%q) = %v, %v, want %v, %v", test.name, volume, ok, test.volume, test.ok)
+			}
+		})
+	}
+
+	if !sf.setActive("music.exe", true) {
+		t.Error("setActive on an existing session failed")
+	}
+
+	if sf.setActive("nope.exe", true) {
+		t.Error("setActive on a missing session succeeded")
+	}
+}
+
+// TestRunTestScript runs a script through the whole pipeline: the board's lines are parsed into slider moves,
+// which end up as volumes on the virtual sessions the config maps the sliders to
+func TestRunTestScript(t *testing.T) {
+	dir := t.TempDir()
+
+	config := `slider_mappings:
+  music:
+    targets: [music.exe]
+  master:
+    targets: [master]
+`
+
+	script := `# two sliders, classic firmware
+session music.exe 1.0
+send 512|1023
+expect music.exe 0.5
+expect master 1.0
+send 1023|0
+expect music.exe 1.0
+expect master 0.0
+# smoothed on the way, and all the way there once the board goes quiet
+send 512|256
+expect music.exe 0.5
+expect master 0.25
+`
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "script.txt"), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the config is read from the working directory, like deej does
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Chdir(wd)
+
+	d, err := NewDeej(zap.NewNop().Sugar(), Options{VirtualAudio: true})
+	if err != nil {
+		t.Fatalf("create deej: %v", err)
+	}
+
+	if err := d.RunTestScript("script.txt"); err != nil {
+		t.Fatalf("test script failed: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	testSignalTone      = "tone"
+	testSignalPinkNoise = "pink_noise"
+
+	testSignalSampleRate = 44100
+	testSignalDuration   = 2 * time.Second
+
+	// the usual calibration tone
+	testSignalToneFrequency = 1000
+
+	// even at full volume the signal peaks at half of full scale (-6 dBFS), which is plenty to calibrate with
+	testSignalPeak = 0.5
+
+	// fading in and out keeps the signal from starting and ending with a click
+	testSignalFade = 20 * time.Millisecond
+)
+
+// testSignalPlayer plays a short test signal (a tone or pink noise) at a slider's current level, so channels
+// can be calibrated without starting real media. only one plays at a time
+type testSignalPlayer struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	lock    sync.Mutex
+	playing bool
+	stopped chan bool
+}
+
+func newTestSignalPlayer(deej *Deej, logger *zap.SugaredLogger) *testSignalPlayer {
+	logger = logger.Named("test_signal")
+
+	tp := &testSignalPlayer{
+		deej:    deej,
+		logger:  logger,
+		stopped: make(chan bool),
+	}
+
+	logger.Debug("Created test signal player instance")
+
+	return tp
+}
+
+// play starts playing the signal in the background, at the slider's volume. the signal goes to device when
+// one is given, or to the first of the slider's targets that's an output device, or to the default output
+func (tp *testSignalPlayer) play(sliderID string, signal string, device string) error {
+	mapping, err := tp.deej.configManager.getSliderMappingByKey(sliderID)
+	if err != nil {
+		return fmt.Errorf("get slider mapping: %w", err)
+	}
+
+	if device == "" {
+		for _, target := range mapping.Targets {
+			if name, ok := testSignalOutputDevice(target); ok {
+				device = name
+				break
+			}
+		}
+	}
+
+	// a device the slider controls is already turned down to the slider's volume, so the signal is only
+	// scaled to it when it plays somewhere else. otherwise it'd be turned down twice
+	controlsDevice := false
+
+	for _, target := range mapping.Targets {
+		if name, ok := testSignalOutputDevice(target); (ok && strings.EqualFold(name, device)) || (device == "" && strings.EqualFold(target, masterSessionName)) {
+			controlsDevice = true
+			break
+		}
+	}
+
+	volume := mapping.Volume
+	if controlsDevice {
+		volume = 1
+	}
+
+	samples, err := testSignalSamples(signal, volume)
+	if err != nil {
+		return err
+	}
+
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	if tp.playing {
+		return errors.New("a test signal is already playing")
+	}
+
+	tp.playing = true
+
+	tp.logger.Infow("Playing test signal", "slider", sliderID, "signal", signal, "volume", mapping.Volume, "device", device)
+
+	go func() {
+		if err := playTestSignal(tp.deej.configManager, samples, device, tp.stopped); err != nil {
+			tp.logger.Warnw("Failed to play test signal", "signal", signal, "device", device, "error", err)
+		}
+
+		tp.lock.Lock()
+		tp.playing = false
+		tp.lock.Unlock()
+	}()
+
+	return nil
+}
+
+// stop cuts off a signal that's still playing
+func (tp *testSignalPlayer) stop() {
+	close(tp.stopped)
+}
+
+// testSignalSamples renders a signal as 16-bit mono samples, scaled to the given volume
+func testSignalSamples(signal string, volume float32) ([]int16, error) {
+	count := int(testSignalDuration.Seconds() * testSignalSampleRate)
+	fade := int(testSignalFade.Seconds() * testSignalSampleRate)
+
+	var next func(idx int) float64
+
+	switch signal {
+	case testSignalTone:
+		next = func(idx int) float64 {
+			return math.Sin(2 * math.Pi * testSignalToneFrequency * float64(idx) / testSignalSampleRate)
+		}
+
+	case testSignalPinkNoise:
+		next = newPinkNoise()
+
+	default:
+		return nil, fmt.Errorf("unknown test signal %q (use %s or %s)", signal, testSignalTone, testSignalPinkNoise)
+	}
+
+	samples := make([]int16, count)
+
+	for idx := range samples {
+		gain := float64(volume) * testSignalPeak
+
+		if idx < fade {
+			gain *= float64(idx) / float64(fade)
+		} else if remaining := count - idx; remaining < fade {
+			gain *= float64(remaining) / float64(fade)
+		}
+
+		value := math.Max(-1, math.Min(1, next(idx)*gain))
+		samples[idx] = int16(value * math.MaxInt16)
+	}
+
+	return samples, nil
+}
+
+// newPinkNoise returns a pink noise generator, filtering white noise down by 3 dB per octave (Paul Kellet's method)
+func newPinkNoise() func(idx int) float64 {
+	var b0, b1, b2, b3, b4, b5, b6 float64
+
+	return func(idx int) float64 {
+		white := rand.Float64()*2 - 1
+
+		b0 = 0.99886*b0 + white*0.0555179
+		b1 = 0.99332*b1 + white*0.0750759
+		b2 = 0.96900*b2 + white*0.1538520
+		b3 = 0.86650*b3 + white*0.3104856
+		b4 = 0.55000*b4 + white*0.5329522
+		b5 = -0.7616*b5 - white*0.0168980
+
+		pink := b0 + b1 + b2 + b3 + b4 + b5 + b6 + white*0.5362
+		b6 = white * 0.115926
+
+		// the filter's gain is roughly 5x, bring it back to about full scale
+		return pink * 0.2
+	}
+}
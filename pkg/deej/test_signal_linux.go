@@ -0,0 +1,81 @@
+package deej
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// testSignalOutputDevice tells whether a slider target is an output device. slider targets on Linux are apps
+// (and master, which is the default output anyway), so signals only go to a device when it's picked explicitly
+func testSignalOutputDevice(target string) (string, bool) {
+	return "", false
+}
+
+// playTestSignal plays the samples through PulseAudio's paplay (or PipeWire's pw-play, where that's all there is),
+// on the given sink or the default one. it returns once they're done playing, or stop is closed
+func playTestSignal(cm *ConfigManager, samples []int16, device string, stop <-chan bool) error {
+	raw := &bytes.Buffer{}
+	if err := binary.Write(raw, binary.LittleEndian, samples); err != nil {
+		return fmt.Errorf("encode samples: %w", err)
+	}
+
+	rate := strconv.Itoa(testSignalSampleRate)
+
+	var cmd *exec.Cmd
+
+	if _, err := exec.LookPath("paplay"); err == nil {
+		args := []string{"--raw", "--format=s16le", "--rate=" + rate, "--channels=1", "--client-name=deej"}
+
+		if device != "" {
+			args = append(args, "--device="+device)
+		}
+
+		// play on the server deej controls, which isn't necessarily the local one
+		if server := cm.getPulseServer(); server.Address != "" {
+			args = append(args, "--server="+server.Address)
+		}
+
+		cmd = exec.Command("paplay", args...)
+	} else if _, err := exec.LookPath("pw-play"); err == nil {
+		args := []string{"--format=s16", "--rate=" + rate, "--channels=1"}
+
+		if device != "" {
+			args = append(args, "--target="+device)
+		}
+
+		cmd = exec.Command("pw-play", append(args, "-")...)
+	} else {
+		return errors.New("neither paplay nor pw-play is installed")
+	}
+
+	stderr := &strings.Builder{}
+	cmd.Stdin = raw
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start %s: %w", cmd.Path, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s: %w (%s)", cmd.Path, err, strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+
+	case <-stop:
+		cmd.Process.Kill()
+		<-done
+
+		return nil
+	}
+}
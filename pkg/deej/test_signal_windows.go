@@ -0,0 +1,176 @@
+package deej
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	waveMapper     = 0xFFFFFFFF // WAVE_MAPPER, the default output
+	waveFormatPCM  = 1          // WAVE_FORMAT_PCM
+	waveHeaderDone = 0x1        // WHDR_DONE
+
+	// waveOut has no way of waiting on a buffer without a callback, so it's checked this often
+	testSignalPollInterval = 50 * time.Millisecond
+)
+
+var (
+	procWaveOutGetNumDevs      = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutGetNumDevs")
+	procWaveOutGetDevCapsW     = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutGetDevCapsW")
+	procWaveOutOpen            = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutOpen")
+	procWaveOutPrepareHeader   = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutPrepareHeader")
+	procWaveOutWrite           = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutWrite")
+	procWaveOutReset           = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutReset")
+	procWaveOutUnprepareHeader = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutUnprepareHeader")
+	procWaveOutClose           = syscall.NewLazyDLL("winmm.dll").NewProc("waveOutClose")
+)
+
+// waveOutCaps is WAVEOUTCAPSW
+type waveOutCaps struct {
+	mid           uint16
+	pid           uint16
+	driverVersion uint32
+	name          [32]uint16
+	formats       uint32
+	channels      uint16
+	reserved      uint16
+	support       uint32
+}
+
+// waveFormat is WAVEFORMATEX
+type waveFormat struct {
+	formatTag      uint16
+	channels       uint16
+	samplesPerSec  uint32
+	avgBytesPerSec uint32
+	blockAlign     uint16
+	bitsPerSample  uint16
+	size           uint16
+}
+
+// waveHeader is WAVEHDR
+type waveHeader struct {
+	data          uintptr
+	bufferLength  uint32
+	bytesRecorded uint32
+	user          uintptr
+	flags         uint32
+	loops         uint32
+	next          uintptr
+	reserved      uintptr
+}
+
+// testSignalOutputDevice tells whether a slider target is an output device, as device sessions are targeted
+// by their friendly name. waveOut cuts device names off at 31 characters, so they only need to start the same
+func testSignalOutputDevice(target string) (string, bool) {
+	index, ok := waveOutDeviceIndex(target)
+	if !ok {
+		return "", false
+	}
+
+	name, err := waveOutName(index)
+	if err != nil {
+		return "", false
+	}
+
+	return name, true
+}
+
+// playTestSignal plays the samples through the given output device, or the default one. it returns once
+// they're done playing, or stop is closed
+func playTestSignal(cm *ConfigManager, samples []int16, device string, stop <-chan bool) error {
+	deviceIndex := uintptr(waveMapper)
+
+	if device != "" {
+		index, ok := waveOutDeviceIndex(device)
+		if !ok {
+			return fmt.Errorf("no output device named %s", device)
+		}
+
+		deviceIndex = index
+	}
+
+	format := waveFormat{
+		formatTag:      waveFormatPCM,
+		channels:       1,
+		samplesPerSec:  testSignalSampleRate,
+		avgBytesPerSec: testSignalSampleRate * 2,
+		blockAlign:     2,
+		bitsPerSample:  16,
+	}
+
+	var handle uintptr
+
+	if result, _, _ := procWaveOutOpen.Call(uintptr(unsafe.Pointer(&handle)), deviceIndex,
+		uintptr(unsafe.Pointer(&format)), 0, 0, 0); result != 0 {
+
+		return fmt.Errorf("open output device: winmm error %d", result)
+	}
+
+	defer procWaveOutClose.Call(handle)
+
+	header := &waveHeader{
+		data:         uintptr(unsafe.Pointer(&samples[0])),
+		bufferLength: uint32(len(samples) * 2),
+	}
+
+	if result, _, _ := procWaveOutPrepareHeader.Call(handle, uintptr(unsafe.Pointer(header)), unsafe.Sizeof(*header)); result != 0 {
+		return fmt.Errorf("prepare buffer: winmm error %d", result)
+	}
+
+	defer procWaveOutUnprepareHeader.Call(handle, uintptr(unsafe.Pointer(header)), unsafe.Sizeof(*header))
+
+	if result, _, _ := procWaveOutWrite.Call(handle, uintptr(unsafe.Pointer(header)), unsafe.Sizeof(*header)); result != 0 {
+		return fmt.Errorf("play buffer: winmm error %d", result)
+	}
+
+	ticker := time.NewTicker(testSignalPollInterval)
+	defer ticker.Stop()
+
+	for header.flags&waveHeaderDone == 0 {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			procWaveOutReset.Call(handle)
+			runtime.KeepAlive(samples)
+
+			return nil
+		}
+	}
+
+	// winmm reads the samples right up until it's done with them
+	runtime.KeepAlive(samples)
+
+	return nil
+}
+
+func waveOutDeviceIndex(name string) (uintptr, bool) {
+	count, _, _ := procWaveOutGetNumDevs.Call()
+
+	for index := uintptr(0); index < count; index++ {
+		deviceName, err := waveOutName(index)
+		if err != nil || deviceName == "" {
+			continue
+		}
+
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(deviceName)) {
+			return index, true
+		}
+	}
+
+	return 0, false
+}
+
+func waveOutName(index uintptr) (string, error) {
+	var caps waveOutCaps
+
+	if result, _, _ := procWaveOutGetDevCapsW.Call(index, uintptr(unsafe.Pointer(&caps)), unsafe.Sizeof(caps)); result != 0 {
+		return "", fmt.Errorf("get device caps: winmm error %d", result)
+	}
+
+	return syscall.UTF16ToString(caps.name[:]), nil
+}
@@ -0,0 +1,98 @@
+package deej
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thoas/go-funk"
+)
+
+// Transport represents a connection to a board, over whatever it happens to be attached by.
+// Transports only move lines back and forth - SerialIO makes sense of them the same way regardless
+type Transport interface {
+	Connect() error
+
+	// ReadLines blocks, delivering every line read (including its trailing LF) to the given channel,
+	// until reading fails or the transport is closed. It returns the error that ended it
+	ReadLines(lines chan<- TransportLine) error
+
+	Write(data []byte) (int, error)
+
+	Close() error
+
+	// Name identifies the connection in logs and notifications, i.e. "COM4"
+	Name() string
+
+	// DeviceID returns a stable identifier of the connected board, if the transport can tell
+	DeviceID() (string, error)
+}
+
+// TransportLine is a single line read from the board, along with when it was read
+type TransportLine struct {
+	Text   string
+	ReadAt time.Time
+
+	// set by transports that already checked the line's checksum on their own, i.e. for binary frames
+	Verified bool
+}
+
+// transportKind names what a transport connects over, i.e. "serial" or "websocket"
+func transportKind(transport Transport) string {
+	switch t := transport.(type) {
+	case *serialTransport:
+		if t.replayPath != "" {
+			return "replay"
+		}
+
+		return "serial"
+	case *websocketTransport:
+		return "websocket"
+	case *mqttTransport:
+		return "mqtt"
+	case *ipcTransport:
+		return "ipc"
+	}
+
+	return "unknown"
+}
+
+func (sio *SerialIO) trackBoard(board *SerialIO, kind string) {
+	sio.boardsLock.Lock()
+	defer sio.boardsLock.Unlock()
+
+	if sio.boardTransports == nil {
+		sio.boardTransports = map[*SerialIO]string{}
+	}
+
+	sio.boardTransports[board] = kind
+}
+
+func (sio *SerialIO) untrackBoard(board *SerialIO) {
+	sio.boardsLock.Lock()
+	defer sio.boardsLock.Unlock()
+
+	delete(sio.boardTransports, board)
+}
+
+// connectedTransports lists what the hub's connected boards are connected over, i.e. "mqtt,serial",
+// or "none" when there are no boards
+func (sio *SerialIO) connectedTransports() string {
+	sio.boardsLock.Lock()
+	defer sio.boardsLock.Unlock()
+
+	kinds := []string{}
+	for _, kind := range sio.boardTransports {
+		if !funk.ContainsString(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+
+	if len(kinds) == 0 {
+		return "none"
+	}
+
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ",")
+}
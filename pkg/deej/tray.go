@@ -1,7 +1,10 @@
 package deej
 
 import (
+	"fmt"
+
 	"github.com/getlantern/systray"
+	"go.uber.org/zap"
 
 	"github.com/omriharel/deej/pkg/deej/icon"
 	"github.com/omriharel/deej/pkg/deej/util"
@@ -10,6 +13,9 @@ import (
 func (d *Deej) initializeTray(onDone func()) {
 	logger := d.logger.Named("tray")
 
+	// menus that follow deej's state stop following it when the tray goes away
+	stopWatching := []func(){}
+
 	onReady := func() {
 		logger.Debug("Tray instance ready")
 
@@ -17,18 +23,39 @@ func (d *Deej) initializeTray(onDone func()) {
 		systray.SetTitle("deej")
 		systray.SetTooltip("deej")
 
+		d.trayReady = true
+
 		editConfig := systray.AddMenuItem("Edit configuration", "Open config file with notepad")
 		editConfig.SetIcon(icon.EditConfig)
 
 		refreshSessions := systray.AddMenuItem("Re-scan audio sessions", "Manually refresh audio sessions if something's stuck")
 		refreshSessions.SetIcon(icon.RefreshSessions)
 
-		if d.version != "" {
-			systray.AddSeparator()
-			versionInfo := systray.AddMenuItem(d.version, "")
+		detectInvert := systray.AddMenuItem("Detect slider direction", "Move a slider up to figure out whether it should be inverted")
+		miniMixer := systray.AddMenuItem("Open mini mixer", "Show a small window with faders mirroring your board")
+
+		if stop := d.addProfileMenu(logger); stop != nil {
+			stopWatching = append(stopWatching, stop)
+		}
+
+		d.addVirtualSliderMenu(logger)
+
+		if stop := d.addSliderLockMenu(logger); stop != nil {
+			stopWatching = append(stopWatching, stop)
+		}
+
+		d.addTestSignalMenu(logger)
+
+		systray.AddSeparator()
+
+		if version := d.buildInfo.Version(); version != "" {
+			versionInfo := systray.AddMenuItem(fmt.Sprintf("Version %s", version), "")
 			versionInfo.Disable()
 		}
 
+		about := systray.AddMenuItem("About deej", "Show version and build details")
+		usageStatistics := systray.AddMenuItem("Preview usage statistics", "Show exactly what deej would send if usage statistics are enabled")
+
 		systray.AddSeparator()
 		quit := systray.AddMenuItem("Quit", "Stop deej and quit")
 
@@ -65,6 +92,33 @@ func (d *Deej) initializeTray(onDone func()) {
 					// performance: the reason that forcing a refresh here is okay is that users can't spam the
 					// right-click -> select-this-option sequence at a rate that's meaningful to performance
 					d.sessions.refreshSessions(true)
+
+				// about
+				case <-about.ClickedCh:
+					logger.Infow("About menu item clicked, showing build info", "buildInfo", d.buildInfo)
+
+					d.notifier.Notify("About deej", d.buildInfo.String())
+
+				// usage statistics preview
+				case <-usageStatistics.ClickedCh:
+					logger.Info("Usage statistics menu item clicked, opening preview")
+
+					d.telemetry.preview()
+
+				// detect slider direction
+				case <-detectInvert.ClickedCh:
+					logger.Info("Detect slider direction menu item clicked, starting invert assistant")
+
+					// this waits on the user, so don't block the menu while it does
+					go d.runInvertAssistant()
+
+				// mini mixer
+				case <-miniMixer.ClickedCh:
+					logger.Info("Mini mixer menu item clicked, opening mini mixer")
+
+					if err := d.miniMixer.open(); err != nil {
+						logger.Warnw("Failed to open mini mixer", "error", err)
+					}
 				}
 			}
 		}()
@@ -74,6 +128,10 @@ func (d *Deej) initializeTray(onDone func()) {
 	}
 
 	onExit := func() {
+		for _, stop := range stopWatching {
+			stop()
+		}
+
 		logger.Debug("Tray exited")
 	}
 
@@ -82,6 +140,206 @@ func (d *Deej) initializeTray(onDone func()) {
 	systray.Run(onReady, onExit)
 }
 
+// addProfileMenu shows the active profile with a submenu to switch to another one, and keeps it up to date as
+// the board (or anything else) switches between profiles. like the virtual slider menu, it's only there if the
+// config had profiles when the tray started, and lists the profiles it had then. it returns a function that stops
+// following the profile, or nil without the menu
+func (d *Deej) addProfileMenu(logger *zap.SugaredLogger) func() {
+	active, profiles := d.configManager.getProfiles()
+	if len(profiles) < 2 {
+		return nil
+	}
+
+	profileMenu := systray.AddMenuItem(fmt.Sprintf("Profile: %s", active), "Switch to another set of slider mappings")
+	profileItems := map[string]*systray.MenuItem{}
+
+	for _, name := range profiles {
+		profileItem := profileMenu.AddSubMenuItem(name, "")
+		if name == active {
+			profileItem.Check()
+		}
+
+		profileItems[name] = profileItem
+
+		go func(name string) {
+			for range profileItem.ClickedCh {
+				logger.Infow("Profile menu item clicked", "profile", name)
+
+				if err := d.configManager.switchProfile(name); err != nil {
+					logger.Warnw("Failed to switch profile", "profile", name, "error", err)
+				}
+			}
+		}(name)
+	}
+
+	shown := active
+
+	return d.watchTrayState(func() {
+		active, _ := d.configManager.getProfiles()
+		if active == shown {
+			return
+		}
+
+		profileMenu.SetTitle(fmt.Sprintf("Profile: %s", active))
+
+		if item, ok := profileItems[shown]; ok {
+			item.Uncheck()
+		}
+
+		if item, ok := profileItems[active]; ok {
+			item.Check()
+		}
+
+		shown = active
+	})
+}
+
+// addVirtualSliderMenu adds a submenu with a few preset levels per virtual slider. note that the menu
+// reflects the virtual sliders present when the tray started, as menu items can't be removed later
+func (d *Deej) addVirtualSliderMenu(logger *zap.SugaredLogger) {
+	virtualSliderKeys := d.configManager.getVirtualSliderKeys()
+	if len(virtualSliderKeys) == 0 {
+		return
+	}
+
+	virtualSliders := systray.AddMenuItem("Virtual sliders", "Move sliders that aren't bound to a hardware channel")
+
+	for _, key := range virtualSliderKeys {
+		sliderItem := d.addSliderMenuItem(virtualSliders, key)
+
+		for _, level := range virtualSliderTrayLevels {
+			levelItem := sliderItem.AddSubMenuItem(d.formatPercent(float32(level)/100), "")
+
+			go func(key string, level int) {
+				for range levelItem.ClickedCh {
+					logger.Infow("Virtual slider level menu item clicked", "slider", key, "level", level)
+
+					if err := d.SetSliderValue(key, float32(level)/100); err != nil {
+						logger.Warnw("Failed to set virtual slider value", "slider", key, "error", err)
+					}
+				}
+			}(key, level)
+		}
+	}
+}
+
+// addSliderLockMenu adds a submenu with a checkable item per slider, for locking it against the board. the checks
+// follow the sliders' locks however they're changed. like the test signal menu, it reflects the sliders present
+// when the tray started. it returns a function that stops following the locks, or nil without the menu
+func (d *Deej) addSliderLockMenu(logger *zap.SugaredLogger) func() {
+	sliderKeys, _ := d.configManager.getSliderMappingKeys()
+	if len(sliderKeys) == 0 {
+		return nil
+	}
+
+	sliderLocks := systray.AddMenuItem("Lock sliders", "Make sliders ignore the board, so they can't be moved by accident")
+	lockItems := map[string]*systray.MenuItem{}
+
+	for _, key := range sliderKeys {
+		lockItem := d.addSliderMenuItem(sliderLocks, key)
+		lockItems[key] = lockItem
+
+		go func(key string) {
+			for range lockItem.ClickedCh {
+				logger.Infow("Slider lock menu item clicked", "slider", key)
+
+				if _, err := d.toggleSliderLock(key); err != nil {
+					logger.Warnw("Failed to toggle slider lock", "slider", key, "error", err)
+				}
+			}
+		}(key)
+	}
+
+	updateChecks := func() {
+		for key, item := range lockItems {
+			if d.configManager.isSliderLocked(key) {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+	}
+
+	updateChecks()
+
+	return d.watchTrayState(updateChecks)
+}
+
+// addTestSignalMenu adds a submenu per slider for playing a test signal at its level. like the virtual slider
+// menu, it reflects the sliders present when the tray started
+func (d *Deej) addTestSignalMenu(logger *zap.SugaredLogger) {
+	sliderKeys, _ := d.configManager.getSliderMappingKeys()
+	if len(sliderKeys) == 0 {
+		return
+	}
+
+	testSignals := systray.AddMenuItem("Play test signal", "Play a tone or pink noise at a slider's level, to calibrate it")
+
+	for _, key := range sliderKeys {
+		sliderItem := d.addSliderMenuItem(testSignals, key)
+
+		for _, signal := range []struct{ name, title string }{
+			{testSignalTone, "Tone"},
+			{testSignalPinkNoise, "Pink noise"},
+		} {
+			signalItem := sliderItem.AddSubMenuItem(signal.title, "")
+
+			go func(key string, signal string) {
+				for range signalItem.ClickedCh {
+					logger.Infow("Test signal menu item clicked", "slider", key, "signal", signal)
+
+					if err := d.testSignals.play(key, signal, ""); err != nil {
+						logger.Warnw("Failed to play test signal", "slider", key, "error", err)
+					}
+				}
+			}(key, signal.name)
+		}
+	}
+}
+
+// watchTrayState calls update whenever deej's state changes, until the returned function is called
+func (d *Deej) watchTrayState(update func()) func() {
+	changed, stopWatching := d.feedback.watch()
+	stopped := make(chan bool)
+
+	go func() {
+		for {
+			select {
+			case <-changed:
+				update()
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stopWatching()
+		close(stopped)
+	}
+}
+
+// addSliderMenuItem adds a slider's item to one of the per-slider submenus, with a swatch of the slider's color
+// (if it has one) so it looks the same here as everywhere else
+func (d *Deej) addSliderMenuItem(parent *systray.MenuItem, key string) *systray.MenuItem {
+	item := parent.AddSubMenuItem(key, "")
+
+	if mapping, err := d.configManager.getSliderMappingByKey(key); err == nil && mapping.Color != "" {
+		item.SetIcon(colorSwatchIcon(mapping.Color))
+	}
+
+	return item
+}
+
+// setTrayTooltip updates the tray icon's tooltip, if we're running with one
+func (d *Deej) setTrayTooltip(tooltip string) {
+	if !d.trayReady {
+		return
+	}
+
+	systray.SetTooltip(tooltip)
+}
+
 func (d *Deej) stopTray() {
 	d.logger.Debug("Quitting tray")
 	systray.Quit()
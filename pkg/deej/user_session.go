@@ -0,0 +1,228 @@
+package deej
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// how often deej checks whether its OS session is still the one in front of the screen
+const userSessionPollInterval = 2 * time.Second
+
+// userSessionMonitor pauses deej while the OS session it runs in isn't the active one, i.e. after switching to
+// another user (fast user switching) or disconnecting a remote session. While paused, deej lets go of the board
+// so the other user's deej can take it, and leaves audio alone - it isn't this user's to control.
+// It also applies the lock screen policy while the session is locked, and undoes it on unlock
+type userSessionMonitor struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	lock   sync.Mutex
+	paused bool
+
+	// whether the board was connected (or being reconnected) when deej paused, and should be again on resume
+	hadBoard bool
+
+	// the lock screen policy in effect since the session locked (an empty string while it's unlocked),
+	// and the sliders it muted
+	lockPolicy string
+	lockMuted  []string
+}
+
+func newUserSessionMonitor(deej *Deej, logger *zap.SugaredLogger) *userSessionMonitor {
+	logger = logger.Named("user_session")
+
+	um := &userSessionMonitor{
+		deej:   deej,
+		logger: logger,
+	}
+
+	logger.Debug("Created user session monitor instance")
+
+	return um
+}
+
+// run checks on the session until deej stops. if the session can't be checked at all (i.e. deej doesn't run
+// in a logind session on Linux), deej is never paused
+func (um *userSessionMonitor) run() {
+	um.recoverLockScreenMutes()
+
+	if _, err := util.UserSessionActive(); err != nil {
+		um.logger.Debugw("Can't tell whether the user session is active, not watching it", "error", err)
+		return
+	}
+
+	ticker := time.NewTicker(userSessionPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		um.deej.wakeups.record("user_session")
+
+		active, err := util.UserSessionActive()
+		if err != nil {
+			um.logger.Debugw("Failed to check whether the user session is active", "error", err)
+			continue
+		}
+
+		if !active && !um.isPaused() {
+			um.pause()
+		} else if active && um.isPaused() {
+			um.resume()
+		}
+
+		if active {
+			um.checkLock()
+		}
+	}
+}
+
+// checkLock applies the lock screen policy when the session locks, and undoes it when it unlocks. the policy
+// is the one from the moment of locking, so changing it in the meantime doesn't leave anything behind
+func (um *userSessionMonitor) checkLock() {
+	locked, err := util.UserSessionLocked()
+	if err != nil {
+		um.logger.Debugw("Failed to check whether the user session is locked", "error", err)
+		return
+	}
+
+	um.lock.Lock()
+	lockPolicy := um.lockPolicy
+	um.lock.Unlock()
+
+	if locked && lockPolicy == "" {
+		um.locked(um.deej.configManager.getLockScreen())
+	} else if !locked && lockPolicy != "" {
+		um.unlocked(lockPolicy)
+	}
+}
+
+func (um *userSessionMonitor) locked(policy string) {
+	um.logger.Infow("User session locked", "policy", policy)
+
+	muted := []string{}
+
+	if policy == lockScreenMute {
+		sliderIDs, _ := um.deej.configManager.getSliderMappingKeys()
+
+		// sliders that were muted already stay muted after unlocking, too
+		for _, sliderID := range sliderIDs {
+			if mapping, err := um.deej.configManager.getSliderMappingByKey(sliderID); err == nil && !mapping.Muted {
+				muted = append(muted, sliderID)
+			}
+		}
+
+		// they're remembered before they're muted, so there's never a slider muted that the next start wouldn't unmute
+		um.deej.configManager.setLockScreenMuted(muted)
+
+		for _, sliderID := range muted {
+			um.deej.serial.toggleMute(um.logger, sliderID)
+		}
+	}
+
+	um.lock.Lock()
+	um.lockPolicy = policy
+	um.lockMuted = muted
+	um.lock.Unlock()
+}
+
+// unlocked puts back what the lock screen policy changed. sliders unmuted by hand while locked are left alone
+func (um *userSessionMonitor) unlocked(policy string) {
+	um.logger.Infow("User session unlocked", "policy", policy)
+
+	um.lock.Lock()
+	muted := um.lockMuted
+	um.lockPolicy = ""
+	um.lockMuted = nil
+	um.lock.Unlock()
+
+	um.unmute(muted)
+}
+
+// recoverLockScreenMutes unmutes the sliders the lock screen policy muted the last time deej ran, if it stopped
+// before the session unlocked. if the session is still locked, they're unmuted on unlock like any others
+func (um *userSessionMonitor) recoverLockScreenMutes() {
+	muted := um.deej.configManager.getLockScreenMuted()
+	if len(muted) == 0 {
+		return
+	}
+
+	if locked, err := util.UserSessionLocked(); err == nil && locked {
+		um.logger.Infow("Session is still locked since deej last ran, keeping its sliders muted", "sliders", muted)
+
+		um.lock.Lock()
+		um.lockPolicy = lockScreenMute
+		um.lockMuted = muted
+		um.lock.Unlock()
+
+		return
+	}
+
+	um.logger.Infow("Unmuting sliders the lock screen muted before deej last stopped", "sliders", muted)
+	um.unmute(muted)
+}
+
+// unmute unmutes the given sliders the lock screen policy muted, and forgets about them
+func (um *userSessionMonitor) unmute(muted []string) {
+	for _, sliderID := range muted {
+		if mapping, err := um.deej.configManager.getSliderMappingByKey(sliderID); err == nil && mapping.Muted {
+			um.deej.serial.toggleMute(um.logger, sliderID)
+		}
+	}
+
+	um.deej.configManager.setLockScreenMuted(nil)
+}
+
+// isPaused tells whether another session is in front of the screen, in which case deej shouldn't touch any audio
+func (um *userSessionMonitor) isPaused() bool {
+	um.lock.Lock()
+	defer um.lock.Unlock()
+
+	return um.paused
+}
+
+// ignoresInput tells whether slider moves and mutes should be dropped: while paused, and while the session is
+// locked with the freeze policy
+func (um *userSessionMonitor) ignoresInput() bool {
+	um.lock.Lock()
+	defer um.lock.Unlock()
+
+	return um.paused || um.lockPolicy == lockScreenFreeze
+}
+
+func (um *userSessionMonitor) pause() {
+	um.logger.Info("User session is no longer active, pausing")
+
+	um.lock.Lock()
+	um.paused = true
+	um.hadBoard = um.deej.serial.connected || um.deej.serial.reconnecting() || um.deej.serial.recovering()
+	um.lock.Unlock()
+
+	um.deej.serial.Stop()
+	um.deej.connections.stop()
+}
+
+// resume picks up where deej left off. audio sessions may have come and gone in the meantime, so they're
+// re-acquired, and the board is reconnected
+func (um *userSessionMonitor) resume() {
+	um.logger.Info("User session is active again, resuming")
+
+	um.lock.Lock()
+	um.paused = false
+	hadBoard := um.hadBoard
+	um.lock.Unlock()
+
+	um.deej.sessions.refreshSessions(true)
+	um.deej.connections.apply()
+
+	if !hadBoard {
+		return
+	}
+
+	if err := um.deej.serial.Start(); err != nil {
+		um.logger.Warnw("Failed to reconnect after resuming", "error", err)
+		um.deej.serial.reconnect()
+	}
+}
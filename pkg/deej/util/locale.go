@@ -0,0 +1,80 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NumberLocale describes how a locale writes decimal numbers and percentages
+type NumberLocale struct {
+	DecimalSeparator string
+
+	// whether the percent sign goes before the number, i.e. "%50" in turkish
+	PercentFirst bool
+
+	// what goes between the number and the percent sign, i.e. a no-break space in german ("50 %")
+	PercentSpacing string
+}
+
+const (
+	noBreakSpace       = "\u00a0"
+	narrowNoBreakSpace = "\u202f"
+)
+
+var defaultNumberLocale = NumberLocale{DecimalSeparator: "."}
+
+// conventions per language, as per CLDR. languages that aren't listed use the default (english) ones
+var numberLocales = map[string]NumberLocale{
+	"cs": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"da": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"de": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"el": {DecimalSeparator: ","},
+	"es": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"fi": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"fr": {DecimalSeparator: ",", PercentSpacing: narrowNoBreakSpace},
+	"hu": {DecimalSeparator: ","},
+	"id": {DecimalSeparator: ","},
+	"it": {DecimalSeparator: ","},
+	"nb": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"nl": {DecimalSeparator: ","},
+	"no": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"pl": {DecimalSeparator: ","},
+	"pt": {DecimalSeparator: ","},
+	"ro": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"ru": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"sv": {DecimalSeparator: ",", PercentSpacing: noBreakSpace},
+	"tr": {DecimalSeparator: ",", PercentFirst: true},
+	"uk": {DecimalSeparator: ","},
+	"vi": {DecimalSeparator: ","},
+}
+
+// LookupNumberLocale returns the number conventions for the given locale, in any of the usual notations
+// ("de", "de-DE", "de_DE.UTF-8"). An empty locale means the system's, and unknown ones fall back to english
+func LookupNumberLocale(locale string) NumberLocale {
+	if locale == "" {
+		locale = getSystemLocale()
+	}
+
+	language := strings.ToLower(locale)
+	if idx := strings.IndexAny(language, "-_.@"); idx != -1 {
+		language = language[:idx]
+	}
+
+	if numberLocale, ok := numberLocales[language]; ok {
+		return numberLocale
+	}
+
+	return defaultNumberLocale
+}
+
+// FormatPercent formats a scalar volume (i.e. 0.505) as a percentage with the given number of decimals ("50,5 %")
+func (l NumberLocale) FormatPercent(v float32, decimals int) string {
+	number := strconv.FormatFloat(float64(v)*100, 'f', decimals, 32)
+	number = strings.Replace(number, ".", l.DecimalSeparator, 1)
+
+	if l.PercentFirst {
+		return "%" + l.PercentSpacing + number
+	}
+
+	return number + l.PercentSpacing + "%"
+}
@@ -0,0 +1,105 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/go-ps"
+)
+
+// ancestors with these names are never reported - nearly everything descends from them,
+// so treating them as an app's parent would lump unrelated apps together
+var genericAncestorNames = map[string]bool{
+	"explorer.exe": true,
+	"svchost.exe":  true,
+	"services.exe": true,
+	"wininit.exe":  true,
+	"winlogon.exe": true,
+	"cmd.exe":      true,
+	"systemd":      true,
+	"init":         true,
+	"sh":           true,
+	"bash":         true,
+	"zsh":          true,
+	"fish":         true,
+}
+
+// ProcessTree is a snapshot of all running processes, used to look up process ancestry
+type ProcessTree map[int]ps.Process
+
+// NewProcessTree takes a snapshot of all running processes
+func NewProcessTree() (ProcessTree, error) {
+	processes, err := ps.Processes()
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+
+	tree := make(ProcessTree, len(processes))
+	for _, process := range processes {
+		tree[process.Pid()] = process
+	}
+
+	return tree, nil
+}
+
+// AncestorNames returns the lowercase executable names of the given process's ancestors, closest first.
+// It stops at the first generic system or shell process, and is safe to call on a nil tree
+func (t ProcessTree) AncestorNames(pid int) []string {
+	names := []string{}
+	visited := map[int]bool{pid: true}
+
+	process, ok := t[pid]
+	for ok {
+		parentPID := process.PPid()
+
+		// pid reuse can make the tree loop back on itself
+		if visited[parentPID] {
+			break
+		}
+		visited[parentPID] = true
+
+		if process, ok = t[parentPID]; !ok {
+			break
+		}
+
+		name := strings.ToLower(process.Executable())
+		if genericAncestorNames[name] {
+			break
+		}
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Descendants returns all processes descending from the given process, at any depth
+func (t ProcessTree) Descendants(pid int) []ps.Process {
+	children := map[int][]ps.Process{}
+	for _, process := range t {
+		if process.Pid() != process.PPid() {
+			children[process.PPid()] = append(children[process.PPid()], process)
+		}
+	}
+
+	descendants := []ps.Process{}
+	visited := map[int]bool{pid: true}
+	queue := []int{pid}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, child := range children[current] {
+			if visited[child.Pid()] {
+				continue
+			}
+
+			visited[child.Pid()] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child.Pid())
+		}
+	}
+
+	return descendants
+}
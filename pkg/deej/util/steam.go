@@ -0,0 +1,96 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const getSteamGameInternalCooldown = 2 * time.Second
+
+// process names belonging to steam itself rather than to whatever game it's running
+var steamProcessNames = map[string]bool{
+	"steam.exe":              true,
+	"steamservice.exe":       true,
+	"steamwebhelper.exe":     true,
+	"steamerrorreporter.exe": true,
+	"gameoverlayui.exe":      true,
+	"steam":                  true,
+	"steamwebhelper":         true,
+	"reaper":                 true,
+	"pressure-vessel-wrap":   true,
+	"pv-bwrap":               true,
+	"srt-bwrap":              true,
+	"steam-launch-wrapper":   true,
+}
+
+var (
+	steamGameLock           sync.Mutex
+	lastGetSteamGameResult  []string
+	lastGetSteamGameCall    time.Time
+	steamClientProcessNames = []string{"steam.exe", "steam"}
+)
+
+// GetSteamGameProcessNames returns the lowercase process names (including extension, if applicable) of the
+// game Steam currently reports as running, or nil if there isn't one. A game's processes are found by looking
+// for Steam's descendants, excluding Steam's own helper processes
+func GetSteamGameProcessNames() ([]string, error) {
+	steamGameLock.Lock()
+	defer steamGameLock.Unlock()
+
+	// this is called on deej's hot path, cache the result for a bit
+	now := time.Now()
+	if lastGetSteamGameCall.Add(getSteamGameInternalCooldown).After(now) {
+		return lastGetSteamGameResult, nil
+	}
+
+	lastGetSteamGameCall = now
+	lastGetSteamGameResult = nil
+
+	appID, err := getSteamRunningAppID()
+	if err != nil {
+		return nil, fmt.Errorf("get steam running app id: %w", err)
+	}
+
+	// steam reports 0 when no game is running
+	if appID == 0 {
+		return nil, nil
+	}
+
+	tree, err := NewProcessTree()
+	if err != nil {
+		return nil, fmt.Errorf("get process tree: %w", err)
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+
+	for pid, process := range tree {
+		if !containsString(steamClientProcessNames, strings.ToLower(process.Executable())) {
+			continue
+		}
+
+		for _, descendant := range tree.Descendants(pid) {
+			name := strings.ToLower(descendant.Executable())
+
+			if !steamProcessNames[name] && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	lastGetSteamGameResult = names
+	return names, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,8 +1,10 @@
 package util
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -52,6 +54,126 @@ func GetCurrentWindowProcessNames() ([]string, error) {
 	return getCurrentWindowProcessNames()
 }
 
+// GetWindowTitles returns the titles of all visible top-level windows owned by the given process.
+// This is currently only implemented for Windows
+func GetWindowTitles(pid uint32) []string {
+	return getWindowTitles(pid)
+}
+
+// GetSerialPortDeviceID returns a stable identifier (typically the USB serial number) of the device
+// behind the given serial port, if the platform and device expose one
+func GetSerialPortDeviceID(port string) (string, error) {
+	return getSerialPortDeviceID(port)
+}
+
+// ListSerialPorts returns the serial ports that a board could be connected to (USB serial devices on Linux,
+// every COM port on Windows)
+func ListSerialPorts() ([]string, error) {
+	return listSerialPorts()
+}
+
+// IsSerialPortBusy returns true if the given error (from opening a serial port) means
+// that something else already holds the port open
+func IsSerialPortBusy(err error) bool {
+	return isSerialPortBusy(err)
+}
+
+// GetSerialPortHolder returns the name of the process that most likely holds the given serial port open,
+// or an empty string if it can't tell
+func GetSerialPortHolder(port string) string {
+	return getSerialPortHolder(port)
+}
+
+// sandboxes deej knows how to run in (see Sandbox)
+const (
+	SandboxFlatpak = "flatpak"
+	SandboxSnap    = "snap"
+)
+
+// SerialPortAccessError explains why the current user can't open a serial port, and how to fix it
+type SerialPortAccessError struct {
+	Port  string
+	Group string
+	User  string
+
+	// the user is already in the group, but their current session started before they were added
+	NeedsRelogin bool
+
+	// set when it's the sandbox (and not the user's groups) that keeps deej away from the port
+	Sandbox string
+	AppID   string
+}
+
+func (e *SerialPortAccessError) Error() string {
+	if e.Sandbox != "" {
+		return fmt.Sprintf("no permission to access %s: the %s sandbox doesn't allow device access", e.Port, e.Sandbox)
+	}
+
+	return fmt.Sprintf("no permission to access %s: %s isn't in the %s group", e.Port, e.User, e.Group)
+}
+
+// Unwrap makes the error match os.ErrPermission
+func (e *SerialPortAccessError) Unwrap() error {
+	return os.ErrPermission
+}
+
+// Fix describes exactly what the user needs to do to get access to the port
+func (e *SerialPortAccessError) Fix() string {
+	switch e.Sandbox {
+	case SandboxFlatpak:
+		return fmt.Sprintf("Allow deej to access devices with \"flatpak override --user --device=all %s\", then restart it.",
+			e.AppID)
+	case SandboxSnap:
+		return fmt.Sprintf("Allow deej to access USB devices with \"sudo snap connect %s:raw-usb\".", e.AppID)
+	}
+
+	if e.NeedsRelogin {
+		return fmt.Sprintf("You were added to the %s group after logging in. Log out and back in (or reboot) for it to apply.",
+			e.Group)
+	}
+
+	return fmt.Sprintf("Add yourself to the %s group with \"sudo usermod -aG %s %s\", then log out and back in.",
+		e.Group, e.Group, e.User)
+}
+
+// CheckSerialPortAccess returns a *SerialPortAccessError if the current user isn't allowed to open
+// the given serial port. It returns nil if they are, or if it can't tell.
+// This is currently only implemented for Linux, where ttys belong to a group like dialout or uucp
+func CheckSerialPortAccess(port string) error {
+	return checkSerialPortAccess(port)
+}
+
+// Sandbox returns the kind of sandbox deej is running in (SandboxFlatpak, SandboxSnap),
+// or an empty string if it isn't sandboxed
+func Sandbox() string {
+	return sandbox()
+}
+
+// SendPortalNotification shows a notification through the desktop portal, which is
+// the only way to do so from some sandboxes. This is currently only implemented for Linux
+func SendPortalNotification(title string, message string) error {
+	return sendPortalNotification(title, message)
+}
+
+// ErrSecretNotFound is returned when there's no secret by the requested name
+var ErrSecretNotFound = errors.New("secret not found")
+
+// GetSecret returns the named secret from the OS keychain: the secret service (what libsecret uses) on Linux,
+// and a DPAPI-encrypted store that only the current Windows user can decrypt on Windows
+func GetSecret(name string) (string, error) {
+	return getSecret(name)
+}
+
+// SetSecret stores a secret in the OS keychain under the given name, replacing any previous one
+func SetSecret(name string, value string) error {
+	return setSecret(name, value)
+}
+
+// DeleteSecret removes the named secret from the OS keychain
+func DeleteSecret(name string) error {
+	return deleteSecret(name)
+}
+
 // OpenExternal spawns a detached window with the provided command and argument
 func OpenExternal(logger *zap.SugaredLogger, cmd string, arg string) error {
 
@@ -75,12 +197,64 @@ func OpenExternal(logger *zap.SugaredLogger, cmd string, arg string) error {
 	return nil
 }
 
+// OpenAppWindow opens a url in a browser window without tabs or toolbars, sized to the given dimensions.
+// It falls back to the default browser when there's no browser around that can do that
+func OpenAppWindow(logger *zap.SugaredLogger, url string, width int, height int) error {
+	return openAppWindow(logger, url, width, height)
+}
+
+// SetWindowAlwaysOnTop keeps the window with the given title above all other windows
+func SetWindowAlwaysOnTop(title string) error {
+	return setWindowAlwaysOnTop(title)
+}
+
+// ListenLocal accepts connections from other programs on this machine only, on a unix domain socket at the given
+// path on Linux and on a named pipe by the given name on Windows. Only the current user may connect
+func ListenLocal(path string) (net.Listener, error) {
+	return listenLocal(path)
+}
+
+// DefaultLocalSocketPath returns where ListenLocal listens unless told otherwise
+func DefaultLocalSocketPath() string {
+	return defaultLocalSocketPath()
+}
+
+// UserSessionActive tells whether the OS session deej runs in is the one in front of the screen, rather than
+// switched away from (i.e. with fast user switching) or disconnected. It returns an error if it can't tell
+func UserSessionActive() (bool, error) {
+	return userSessionActive()
+}
+
+// UserSessionLocked tells whether the OS session deej runs in is locked. It returns an error if it can't tell
+func UserSessionLocked() (bool, error) {
+	return userSessionLocked()
+}
+
 // NormalizeScalar "trims" the given float32 to 2 points of precision (e.g. 0.15442 -> 0.15)
 // This is used both for windows core audio volume levels and for cleaning up slider level values from serial
 func NormalizeScalar(v float32) float32 {
 	return float32(math.Floor(float64(v)*100) / 100.0)
 }
 
+// QuantizeScalar snaps the given float32 to the nearest multiple of step, clamped to [0, 1]
+// (e.g. 0.23000003 -> 0.23 with a step of 0.01, or 0.234 -> 0.235 with a step of 0.005).
+// A non-positive step leaves the value untouched, other than clamping it
+func QuantizeScalar(v float32, step float32) float32 {
+	if step > 0 {
+		v = float32(math.Round(float64(v)/float64(step)) * float64(step))
+	}
+
+	if v < 0 {
+		return 0
+	}
+
+	if v > 1 {
+		return 1
+	}
+
+	return v
+}
+
 // SignificantlyDifferent returns true if there's a significant enough volume difference between two given values
 func SignificantlyDifferent(old float32, new float32, noiseReductionLevel string) bool {
 
@@ -2,8 +2,662 @@ package util
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/godbus/dbus"
+	"go.uber.org/zap"
 )
 
+const (
+	sysfsTTYPath = "/sys/class/tty"
+	procfsPath   = "/proc"
+
+	// flatpak describes the sandbox it runs an app in here, including which devices it may access
+	flatpakInfoPath        = "/.flatpak-info"
+	flatpakAllDevicesEntry = "all"
+
+	// the snap interface that gives access to usb serial adapters
+	snapUSBInterface = "raw-usb"
+
+	// logind tracks which of a seat's sessions is in front of it
+	logindBusName         = "org.freedesktop.login1"
+	logindObjectPath      = "/org/freedesktop/login1"
+	logindGetSessionByPID = "org.freedesktop.login1.Manager.GetSessionByPID"
+	logindSessionActive   = "org.freedesktop.login1.Session.Active"
+	logindSessionLocked   = "org.freedesktop.login1.Session.LockedHint"
+
+	portalBusName         = "org.freedesktop.portal.Desktop"
+	portalObjectPath      = "/org/freedesktop/portal/desktop"
+	portalAddNotification = "org.freedesktop.portal.Notification.AddNotification"
+
+	// the secret service is what libsecret talks to (gnome-keyring, kwallet and keepassxc all provide it)
+	secretServiceBusName      = "org.freedesktop.secrets"
+	secretServiceObjectPath   = "/org/freedesktop/secrets"
+	secretServiceCollection   = "/org/freedesktop/secrets/aliases/default"
+	secretServiceInterface    = "org.freedesktop.Secret.Service"
+	secretServiceItemPrefix   = "org.freedesktop.Secret.Item."
+	secretServicePromptSignal = "org.freedesktop.Secret.Prompt.Completed"
+
+	// how long the user has to answer the keyring's unlock prompt
+	secretServicePromptTimeout = 2 * time.Minute
+)
+
+// a secret as the secret service passes it around: (session, parameters, value, content type)
+type secretServiceSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// usb serial devices show up as one of these (cdc_acm boards like the leonardo, usb-serial bridges like the ch340)
+var serialPortGlobs = []string{
+	"/dev/ttyACM*",
+	"/dev/ttyUSB*",
+}
+
+var flatpakDevicesPattern = regexp.MustCompile(`(?m)^devices=(.*)$`)
+
+// steam keeps its "registry" in a vdf file, relative to the home directory (native install, flatpak install)
+var steamRegistryPaths = []string{
+	".steam/registry.vdf",
+	".var/app/com.valvesoftware.Steam/.steam/registry.vdf",
+}
+
+var steamRunningAppIDPattern = regexp.MustCompile(`"RunningAppID"\s+"(\d+)"`)
+
 func getCurrentWindowProcessNames() ([]string, error) {
 	return nil, errors.New("Not implemented")
 }
+
+func getWindowTitles(pid uint32) []string {
+	return nil
+}
+
+func getSerialPortDeviceID(port string) (string, error) {
+
+	// sysfs links each tty to its interface, somewhere below the usb device that holds the serial number.
+	// how far below depends on the driver (cdc_acm vs. usb-serial bridges), so walk up until we find it
+	devicePath, err := filepath.EvalSymlinks(filepath.Join(sysfsTTYPath, filepath.Base(port), "device"))
+	if err != nil {
+		return "", fmt.Errorf("resolve sysfs device for %s: %w", port, err)
+	}
+
+	for dir := devicePath; strings.HasPrefix(dir, "/sys/devices/"); dir = filepath.Dir(dir) {
+		serial, err := ioutil.ReadFile(filepath.Join(dir, "serial"))
+		if err != nil {
+			continue
+		}
+
+		if id := strings.TrimSpace(string(serial)); id != "" {
+			return id, nil
+		}
+	}
+
+	return "", fmt.Errorf("no usb serial number found for %s", port)
+}
+
+func getSteamRunningAppID() (uint32, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return 0, fmt.Errorf("get home directory: %w", err)
+	}
+
+	for _, path := range steamRegistryPaths {
+		contents, err := ioutil.ReadFile(filepath.Join(home, path))
+		if err != nil {
+			continue
+		}
+
+		match := steamRunningAppIDPattern.FindSubmatch(contents)
+		if match == nil {
+			continue
+		}
+
+		appID, err := strconv.ParseUint(string(match[1]), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("parse running app id: %w", err)
+		}
+
+		return uint32(appID), nil
+	}
+
+	return 0, errors.New("steam registry not found")
+}
+
+func getSystemLocale() string {
+
+	// same precedence as libc: LC_ALL overrides LC_NUMERIC, which overrides LANG
+	for _, variable := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if value := os.Getenv(variable); value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func listSerialPorts() ([]string, error) {
+	ports := []string{}
+
+	for _, pattern := range serialPortGlobs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("list serial ports (%s): %w", pattern, err)
+		}
+
+		ports = append(ports, matches...)
+	}
+
+	return ports, nil
+}
+
+func isSerialPortBusy(err error) bool {
+
+	// ttys aren't exclusive by default, but the arduino ide (and most serial monitors) open them with TIOCEXCL
+	return errors.Is(err, syscall.EBUSY)
+}
+
+func getSerialPortHolder(port string) string {
+	portPath, err := filepath.EvalSymlinks(port)
+	if err != nil {
+		return ""
+	}
+
+	processDirs, err := ioutil.ReadDir(procfsPath)
+	if err != nil {
+		return ""
+	}
+
+	// look through every process' open files for the port. other users' processes can't be
+	// looked into, but whatever holds a user's serial port is almost always their own
+	for _, processDir := range processDirs {
+		pid, err := strconv.Atoi(processDir.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		fdPath := filepath.Join(procfsPath, processDir.Name(), "fd")
+
+		fds, err := ioutil.ReadDir(fdPath)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(fdPath, fd.Name())); err != nil || target != portPath {
+				continue
+			}
+
+			comm, err := ioutil.ReadFile(filepath.Join(procfsPath, processDir.Name(), "comm"))
+			if err != nil {
+				return ""
+			}
+
+			return strings.TrimSpace(string(comm))
+		}
+	}
+
+	return ""
+}
+
+func checkSerialPortAccess(port string) error {
+
+	// sandboxes map user and group ids around, so only the sandbox's own permissions tell us anything
+	switch sandbox() {
+	case SandboxFlatpak:
+		if flatpakHasDeviceAccess() {
+			return nil
+		}
+
+		return &SerialPortAccessError{Port: port, Sandbox: SandboxFlatpak, AppID: os.Getenv("FLATPAK_ID")}
+
+	case SandboxSnap:
+
+		// snapctl is always there inside a snap, and answers with its exit code
+		if exec.Command("snapctl", "is-connected", snapUSBInterface).Run() == nil {
+			return nil
+		}
+
+		return &SerialPortAccessError{Port: port, Sandbox: SandboxSnap, AppID: os.Getenv("SNAP_INSTANCE_NAME")}
+	}
+
+	info, err := os.Stat(port)
+	if err != nil {
+		return nil
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || os.Geteuid() == 0 {
+		return nil
+	}
+
+	// a port we own, or that anyone can use, is fine. so is one whose group we're in
+	mode := info.Mode().Perm()
+	if (int(stat.Uid) == os.Geteuid() && mode&0600 == 0600) || mode&0006 == 0006 {
+		return nil
+	}
+
+	groups, err := os.Getgroups()
+	if err != nil {
+		return nil
+	}
+
+	groups = append(groups, os.Getegid())
+	for _, gid := range groups {
+		if gid == int(stat.Gid) {
+			return nil
+		}
+	}
+
+	accessErr := &SerialPortAccessError{
+		Port:  port,
+		Group: strconv.Itoa(int(stat.Gid)),
+		User:  "$USER",
+	}
+
+	if group, err := user.LookupGroupId(accessErr.Group); err == nil {
+		accessErr.Group = group.Name
+	}
+
+	if current, err := user.Current(); err == nil {
+		accessErr.User = current.Username
+
+		// group changes only apply to new sessions, so the user might well have done the right thing already
+		if gids, err := current.GroupIds(); err == nil {
+			for _, gid := range gids {
+				if gid == strconv.Itoa(int(stat.Gid)) {
+					accessErr.NeedsRelogin = true
+				}
+			}
+		}
+	}
+
+	return accessErr
+}
+
+func sandbox() string {
+	if os.Getenv("FLATPAK_ID") != "" || FileExists(flatpakInfoPath) {
+		return SandboxFlatpak
+	}
+
+	if os.Getenv("SNAP") != "" {
+		return SandboxSnap
+	}
+
+	return ""
+}
+
+func flatpakHasDeviceAccess() bool {
+	info, err := ioutil.ReadFile(flatpakInfoPath)
+	if err != nil {
+		return false
+	}
+
+	// i.e. "devices=dri;all;"
+	match := flatpakDevicesPattern.FindSubmatch(info)
+	if match == nil {
+		return false
+	}
+
+	for _, device := range strings.Split(string(match[1]), ";") {
+		if device == flatpakAllDevicesEntry {
+			return true
+		}
+	}
+
+	return false
+}
+
+func userSessionActive() (bool, error) {
+	return getLogindSessionFlag(logindSessionActive)
+}
+
+// desktops (or screen lockers) tell logind when they lock the session
+func userSessionLocked() (bool, error) {
+	return getLogindSessionFlag(logindSessionLocked)
+}
+
+// getLogindSessionFlag returns one of the boolean properties of the logind session deej runs in
+func getLogindSessionFlag(property string) (bool, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false, fmt.Errorf("connect to system bus: %w", err)
+	}
+
+	var session dbus.ObjectPath
+	if err := conn.Object(logindBusName, logindObjectPath).
+		Call(logindGetSessionByPID, 0, uint32(os.Getpid())).Store(&session); err != nil {
+
+		return false, fmt.Errorf("get logind session: %w", err)
+	}
+
+	flag, err := conn.Object(logindBusName, session).GetProperty(property)
+	if err != nil {
+		return false, fmt.Errorf("get %s: %w", property, err)
+	}
+
+	value, ok := flag.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("unexpected %s value %v", property, flag)
+	}
+
+	return value, nil
+}
+
+func sendPortalNotification(title string, message string) error {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("connect to session bus: %w", err)
+	}
+
+	// notifications with the same id replace each other, and ours should stack
+	id := fmt.Sprintf("deej-%d", time.Now().UnixNano())
+	notification := map[string]dbus.Variant{
+		"title": dbus.MakeVariant(title),
+		"body":  dbus.MakeVariant(message),
+	}
+
+	call := conn.Object(portalBusName, portalObjectPath).Call(portalAddNotification, 0, id, notification)
+	if call.Err != nil {
+		return fmt.Errorf("add portal notification: %w", call.Err)
+	}
+
+	return nil
+}
+
+// secretService is a connection to the secret service, with a session to pass secrets through
+type secretService struct {
+	conn    *dbus.Conn
+	service dbus.BusObject
+	session dbus.ObjectPath
+}
+
+func openSecretService() (*secretService, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connect to session bus: %w", err)
+	}
+
+	ss := &secretService{
+		conn:    conn,
+		service: conn.Object(secretServiceBusName, secretServiceObjectPath),
+	}
+
+	// secrets only travel over the local session bus, so they're sent as they are rather than encrypted again
+	var output dbus.Variant
+	if err := ss.service.Call(secretServiceInterface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &ss.session); err != nil {
+		return nil, fmt.Errorf("open secret service session: %w", err)
+	}
+
+	return ss, nil
+}
+
+// find returns the items holding the named secret, unlocking them if needed
+func (ss *secretService) find(name string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+
+	if err := ss.service.Call(secretServiceInterface+".SearchItems", 0, secretAttributes(name)).
+		Store(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("search secrets: %w", err)
+	}
+
+	if len(locked) > 0 {
+		if err := ss.unlock(locked); err != nil {
+			return nil, err
+		}
+
+		unlocked = append(unlocked, locked...)
+	}
+
+	return unlocked, nil
+}
+
+// unlock unlocks the given items or collections, which may have the keyring ask the user for their password
+func (ss *secretService) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+
+	if err := ss.service.Call(secretServiceInterface+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("unlock keyring: %w", err)
+	}
+
+	return ss.prompt(prompt)
+}
+
+// prompt shows a prompt the secret service asked for (if any), and waits for the user to deal with it
+func (ss *secretService) prompt(prompt dbus.ObjectPath) error {
+	if prompt == "" || prompt == "/" {
+		return nil
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	ss.conn.Signal(signals)
+	defer ss.conn.RemoveSignal(signals)
+
+	match := "type='signal',interface='org.freedesktop.Secret.Prompt',member='Completed'"
+	if call := ss.conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, match); call.Err != nil {
+		return fmt.Errorf("watch keyring prompt: %w", call.Err)
+	}
+
+	if call := ss.conn.Object(secretServiceBusName, prompt).Call("org.freedesktop.Secret.Prompt.Prompt", 0, ""); call.Err != nil {
+		return fmt.Errorf("show keyring prompt: %w", call.Err)
+	}
+
+	timeout := time.After(secretServicePromptTimeout)
+
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != secretServicePromptSignal {
+				continue
+			}
+
+			// Completed carries whether the prompt was dismissed, and the result. without it, there's no telling
+			if len(signal.Body) == 0 {
+				return errors.New("keyring prompt completed without a result")
+			}
+
+			if dismissed, ok := signal.Body[0].(bool); ok && dismissed {
+				return errors.New("keyring prompt was dismissed")
+			}
+
+			return nil
+
+		case <-timeout:
+			return errors.New("timed out waiting for keyring prompt")
+		}
+	}
+}
+
+func secretAttributes(name string) map[string]string {
+	return map[string]string{
+		"application": "deej",
+		"deej-secret": name,
+	}
+}
+
+func getSecret(name string) (string, error) {
+	ss, err := openSecretService()
+	if err != nil {
+		return "", err
+	}
+
+	items, err := ss.find(name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(items) == 0 {
+		return "", ErrSecretNotFound
+	}
+
+	var secret secretServiceSecret
+	if err := ss.conn.Object(secretServiceBusName, items[0]).Call(secretServiceItemPrefix+"GetSecret", 0, ss.session).
+		Store(&secret); err != nil {
+		return "", fmt.Errorf("get secret: %w", err)
+	}
+
+	return string(secret.Value), nil
+}
+
+func setSecret(name string, value string) error {
+	ss, err := openSecretService()
+	if err != nil {
+		return err
+	}
+
+	if err := ss.unlock([]dbus.ObjectPath{secretServiceCollection}); err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		secretServiceItemPrefix + "Label":      dbus.MakeVariant("deej: " + name),
+		secretServiceItemPrefix + "Attributes": dbus.MakeVariant(secretAttributes(name)),
+	}
+
+	secret := secretServiceSecret{
+		Session:     ss.session,
+		Value:       []byte(value),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+
+	collection := ss.conn.Object(secretServiceBusName, secretServiceCollection)
+	if err := collection.Call("org.freedesktop.Secret.Collection.CreateItem", 0, properties, secret, true).
+		Store(&item, &prompt); err != nil {
+		return fmt.Errorf("create secret: %w", err)
+	}
+
+	return ss.prompt(prompt)
+}
+
+func deleteSecret(name string) error {
+	ss, err := openSecretService()
+	if err != nil {
+		return err
+	}
+
+	items, err := ss.find(name)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return ErrSecretNotFound
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+
+		if err := ss.conn.Object(secretServiceBusName, item).Call(secretServiceItemPrefix+"Delete", 0).
+			Store(&prompt); err != nil {
+			return fmt.Errorf("delete secret: %w", err)
+		}
+
+		if err := ss.prompt(prompt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chromium-based browsers can open a page as a standalone app window, firefox unfortunately can't
+var appWindowBrowsers = []string{
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"brave-browser",
+	"microsoft-edge",
+}
+
+func openAppWindow(logger *zap.SugaredLogger, url string, width int, height int) error {
+	for _, browser := range appWindowBrowsers {
+		if _, err := exec.LookPath(browser); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(browser, "--app="+url, fmt.Sprintf("--window-size=%d,%d", width, height))
+		if err := cmd.Start(); err != nil {
+			logger.Debugw("Failed to open app window", "browser", browser, "error", err)
+			continue
+		}
+
+		// don't leave a zombie behind when the window's closed
+		go cmd.Wait()
+
+		return nil
+	}
+
+	logger.Debug("No browser with app windows found, opening a regular one")
+
+	if err := exec.Command("xdg-open", url).Start(); err != nil {
+		return fmt.Errorf("open browser: %w", err)
+	}
+
+	return nil
+}
+
+// there's no telling every window manager to keep a window on top, but wmctrl covers all the EWMH compliant ones
+func setWindowAlwaysOnTop(title string) error {
+	if _, err := exec.LookPath("wmctrl"); err != nil {
+		return errors.New("wmctrl isn't installed")
+	}
+
+	if output, err := exec.Command("wmctrl", "-F", "-r", title, "-b", "add,above").CombinedOutput(); err != nil {
+		return fmt.Errorf("wmctrl: %w (%s)", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+func listenLocal(path string) (net.Listener, error) {
+
+	// a socket left behind by a deej that didn't exit cleanly is in the way, but one that's answering is taken
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("restrict socket permissions: %w", err)
+	}
+
+	return listener, nil
+}
+
+// the runtime dir is private to the user, otherwise the socket gets a per-user name in the temp dir
+func defaultLocalSocketPath() string {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "deej.sock")
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("deej-%d.sock", os.Getuid()))
+}
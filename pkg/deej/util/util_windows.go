@@ -1,22 +1,114 @@
 package util
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
 
 	"github.com/lxn/win"
 	"github.com/mitchellh/go-ps"
+	"go.uber.org/zap"
+	"golang.org/x/sys/windows/registry"
 )
 
 const (
 	getCurrentWindowInternalCooldown = time.Millisecond * 350
+	getWindowTitlesInternalCooldown  = time.Second
+
+	// window titles longer than this are truncated, which is fine for matching purposes
+	maxWindowTitleLength = 512
+
+	// every enumerated usb device lives under here, as VID_xxxx&PID_xxxx\<instance id>
+	usbEnumRegistryPath = `SYSTEM\CurrentControlSet\Enum\USB`
+
+	// LOCALE_NAME_MAX_LENGTH, including the terminating null
+	maxLocaleNameLength = 85
+
+	// every present COM port is a value under here, named after its driver's device and set to its port name
+	serialCommRegistryPath = `HARDWARE\DEVICEMAP\SERIALCOMM`
+
+	// steam keeps track of the running game here
+	steamRegistryPath = `Software\Valve\Steam`
+
+	// windows has no keychain to speak of, so secrets are DPAPI-encrypted (decryptable only by the same user
+	// on the same machine) and kept in this file, under the user's app data
+	secretsDirName  = "deej"
+	secretsFilename = "secrets.dat"
+
+	// CRYPTPROTECT_UI_FORBIDDEN, deej has nobody to show a prompt to
+	cryptProtectUIForbidden = 0x1
+
+	// local connections come in over a named pipe: duplex, blocking, in byte mode and from this machine only
+	defaultPipeName           = `\\.\pipe\deej`
+	pipeAccessDuplex          = 0x3
+	fileFlagFirstPipeInstance = 0x80000
+	pipeRejectRemoteClients   = 0x8
+	pipeUnlimitedInstances    = 255
+	pipeBufferSize            = 4096
+
+	// ConnectNamedPipe says so when the client connected before it was called, which is just as good
+	errorPipeConnected = syscall.Errno(535)
+
+	// WTS_CURRENT_SERVER_HANDLE, WTS_CURRENT_SESSION, WTSConnectState (of WTS_INFO_CLASS) and WTSActive
+	// (of WTS_CONNECTSTATE_CLASS). sessions that are switched away from or disconnected are in other states
+	wtsCurrentServerHandle = 0
+	wtsCurrentSession      = 0xFFFFFFFF
+	wtsConnectState        = 8
+	wtsActive              = 0
+
+	// DESKTOP_SWITCHDESKTOP, which the user's own desktop allows and the secure (lock screen) one doesn't
+	desktopSwitchDesktop = 0x0100
 )
 
+// processes that commonly hold COM ports open, lowercase. windows won't tell us who actually holds a port
+// without a kernel handle dump, so we settle for finding one of these running
+var knownSerialPortHolders = []string{
+	"deej.exe",
+	"serial-monitor.exe",
+	"arduino.exe",
+	"arduino ide.exe",
+	"putty.exe",
+	"coolterm.exe",
+	"realterm.exe",
+}
+
 var (
 	lastGetCurrentWindowResult []string
 	lastGetCurrentWindowCall   = time.Now()
+
+	// lxn/win doesn't wrap these
+	procGetWindowTextW           = syscall.NewLazyDLL("user32.dll").NewProc("GetWindowTextW")
+	procGetUserDefaultLocaleName = syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+	procLocalFree                = syscall.NewLazyDLL("kernel32.dll").NewProc("LocalFree")
+	procCryptProtectData         = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptProtectData")
+	procCryptUnprotectData       = syscall.NewLazyDLL("crypt32.dll").NewProc("CryptUnprotectData")
+	procCreateNamedPipeW         = syscall.NewLazyDLL("kernel32.dll").NewProc("CreateNamedPipeW")
+	procConnectNamedPipe         = syscall.NewLazyDLL("kernel32.dll").NewProc("ConnectNamedPipe")
+	procDisconnectNamedPipe      = syscall.NewLazyDLL("kernel32.dll").NewProc("DisconnectNamedPipe")
+	procWTSQuerySessionInfoW     = syscall.NewLazyDLL("wtsapi32.dll").NewProc("WTSQuerySessionInformationW")
+	procWTSFreeMemory            = syscall.NewLazyDLL("wtsapi32.dll").NewProc("WTSFreeMemory")
+	procOpenInputDesktop         = syscall.NewLazyDLL("user32.dll").NewProc("OpenInputDesktop")
+	procCloseDesktop             = syscall.NewLazyDLL("user32.dll").NewProc("CloseDesktop")
+
+	// the secrets file is read and rewritten as a whole
+	secretsLock sync.Mutex
+
+	// windows only allows creating a limited number of callbacks per process, so this one is created once
+	// and collects into windowTitlesByPID, which is guarded by windowTitlesLock
+	enumWindowTitlesCallback = syscall.NewCallback(collectWindowTitle)
+	windowTitlesLock         sync.Mutex
+	windowTitlesByPID        map[uint32][]string
+	lastGetWindowTitlesCall  time.Time
 )
 
 func getCurrentWindowProcessNames() ([]string, error) {
@@ -94,3 +186,565 @@ func getCurrentWindowProcessNames() ([]string, error) {
 	lastGetCurrentWindowResult = result
 	return result, nil
 }
+
+func getWindowTitles(pid uint32) []string {
+	windowTitlesLock.Lock()
+	defer windowTitlesLock.Unlock()
+
+	// this is called on deej's hot path, so enumerate all windows at most once per cooldown period
+	now := time.Now()
+	if lastGetWindowTitlesCall.Add(getWindowTitlesInternalCooldown).Before(now) {
+		lastGetWindowTitlesCall = now
+		windowTitlesByPID = map[uint32][]string{}
+
+		// a nil parent makes this equivalent to EnumWindows, i.e. iterate all top-level windows
+		win.EnumChildWindows(0, enumWindowTitlesCallback, 0)
+	}
+
+	return windowTitlesByPID[pid]
+}
+
+func collectWindowTitle(hwnd win.HWND, lParam uintptr) uintptr {
+	if !win.IsWindowVisible(hwnd) {
+		return 1
+	}
+
+	var pid uint32
+	win.GetWindowThreadProcessId(hwnd, &pid)
+
+	buf := make([]uint16, maxWindowTitleLength)
+	length, _, _ := procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+
+	if length > 0 {
+		windowTitlesByPID[pid] = append(windowTitlesByPID[pid], syscall.UTF16ToString(buf[:length]))
+	}
+
+	// indicates to the system to keep iterating
+	return 1
+}
+
+func getSerialPortDeviceID(port string) (string, error) {
+
+	// for devices that report a usb serial number, windows uses it as the device's instance id.
+	// find the instance whose device parameters point at our COM port, and that's our serial number
+	usbKey, err := registry.OpenKey(registry.LOCAL_MACHINE, usbEnumRegistryPath, registry.ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return "", fmt.Errorf("open usb enum registry key: %w", err)
+	}
+	defer usbKey.Close()
+
+	hardwareIDs, err := usbKey.ReadSubKeyNames(-1)
+	if err != nil {
+		return "", fmt.Errorf("enumerate usb hardware ids: %w", err)
+	}
+
+	for _, hardwareID := range hardwareIDs {
+		hardwareKey, err := registry.OpenKey(usbKey, hardwareID, registry.ENUMERATE_SUB_KEYS)
+		if err != nil {
+			continue
+		}
+
+		instanceIDs, err := hardwareKey.ReadSubKeyNames(-1)
+		hardwareKey.Close()
+
+		if err != nil {
+			continue
+		}
+
+		for _, instanceID := range instanceIDs {
+			paramsKey, err := registry.OpenKey(usbKey,
+				fmt.Sprintf(`%s\%s\Device Parameters`, hardwareID, instanceID),
+				registry.QUERY_VALUE)
+
+			if err != nil {
+				continue
+			}
+
+			portName, _, err := paramsKey.GetStringValue("PortName")
+			paramsKey.Close()
+
+			// instance ids containing '&' are made up by windows for devices without a serial number
+			if err == nil && strings.EqualFold(portName, port) && !strings.Contains(instanceID, "&") {
+				return instanceID, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no usb serial number found for %s", port)
+}
+
+func getSteamRunningAppID() (uint32, error) {
+	steamKey, err := registry.OpenKey(registry.CURRENT_USER, steamRegistryPath, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, fmt.Errorf("open steam registry key: %w", err)
+	}
+	defer steamKey.Close()
+
+	appID, _, err := steamKey.GetIntegerValue("RunningAppID")
+	if err != nil {
+		return 0, fmt.Errorf("read running app id: %w", err)
+	}
+
+	return uint32(appID), nil
+}
+
+func listSerialPorts() ([]string, error) {
+	serialCommKey, err := registry.OpenKey(registry.LOCAL_MACHINE, serialCommRegistryPath, registry.QUERY_VALUE)
+	if err != nil {
+
+		// the key only exists while at least one COM port does
+		if err == registry.ErrNotExist {
+			return []string{}, nil
+		}
+
+		return nil, fmt.Errorf("open serial comm registry key: %w", err)
+	}
+	defer serialCommKey.Close()
+
+	valueNames, err := serialCommKey.ReadValueNames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("enumerate serial comm values: %w", err)
+	}
+
+	ports := []string{}
+	for _, valueName := range valueNames {
+		if port, _, err := serialCommKey.GetStringValue(valueName); err == nil {
+			ports = append(ports, port)
+		}
+	}
+
+	return ports, nil
+}
+
+func isSerialPortBusy(err error) bool {
+
+	// COM ports are exclusive, so opening one that's in use is denied outright
+	return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+}
+
+func getSerialPortHolder(port string) string {
+	processes, err := ps.Processes()
+	if err != nil {
+		return ""
+	}
+
+	for _, process := range processes {
+		if process.Pid() == os.Getpid() {
+			continue
+		}
+
+		executable := strings.ToLower(process.Executable())
+		for _, holder := range knownSerialPortHolders {
+			if executable == holder {
+				return process.Executable()
+			}
+		}
+	}
+
+	return ""
+}
+
+func checkSerialPortAccess(port string) error {
+
+	// COM ports aren't restricted per user
+	return nil
+}
+
+func sandbox() string {
+	return ""
+}
+
+func userSessionActive() (bool, error) {
+	var state *int32
+	var size uint32
+
+	result, _, err := procWTSQuerySessionInfoW.Call(
+		wtsCurrentServerHandle,
+		wtsCurrentSession,
+		wtsConnectState,
+		uintptr(unsafe.Pointer(&state)),
+		uintptr(unsafe.Pointer(&size)))
+
+	if result == 0 {
+		return false, fmt.Errorf("WTSQuerySessionInformation: %w", err)
+	}
+
+	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(state)))
+
+	return *state == wtsActive, nil
+}
+
+// while the session is locked, the input desktop is the secure one, which only the system may open. UAC prompts
+// show on the secure desktop too, so they briefly look like a lock
+func userSessionLocked() (bool, error) {
+	desktop, _, _ := procOpenInputDesktop.Call(0, 0, desktopSwitchDesktop)
+	if desktop == 0 {
+		return true, nil
+	}
+
+	procCloseDesktop.Call(desktop)
+
+	return false, nil
+}
+
+func sendPortalNotification(title string, message string) error {
+	return errors.New("Not implemented")
+}
+
+func getSystemLocale() string {
+	buf := make([]uint16, maxLocaleNameLength)
+
+	length, _, _ := procGetUserDefaultLocaleName.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if length == 0 {
+		return ""
+	}
+
+	return syscall.UTF16ToString(buf)
+}
+
+// DATA_BLOB, how DPAPI takes and returns data
+type dataBlob struct {
+	size uint32
+	data *byte
+}
+
+func newDataBlob(data []byte) *dataBlob {
+	if len(data) == 0 {
+		return &dataBlob{}
+	}
+
+	return &dataBlob{size: uint32(len(data)), data: &data[0]}
+}
+
+// bytes copies the blob's data out and frees it, since DPAPI allocates its output with LocalAlloc
+func (b *dataBlob) bytes() []byte {
+	defer procLocalFree.Call(uintptr(unsafe.Pointer(b.data)))
+
+	result := make([]byte, b.size)
+	copy(result, (*[1 << 30]byte)(unsafe.Pointer(b.data))[:b.size:b.size])
+
+	return result
+}
+
+func protectData(data []byte) ([]byte, error) {
+	var output dataBlob
+
+	result, _, err := procCryptProtectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(data))),
+		0, 0, 0, 0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&output)))
+
+	if result == 0 {
+		return nil, fmt.Errorf("CryptProtectData: %w", err)
+	}
+
+	return output.bytes(), nil
+}
+
+func unprotectData(data []byte) ([]byte, error) {
+	var output dataBlob
+
+	result, _, err := procCryptUnprotectData.Call(
+		uintptr(unsafe.Pointer(newDataBlob(data))),
+		0, 0, 0, 0,
+		cryptProtectUIForbidden,
+		uintptr(unsafe.Pointer(&output)))
+
+	if result == 0 {
+		return nil, fmt.Errorf("CryptUnprotectData: %w", err)
+	}
+
+	return output.bytes(), nil
+}
+
+func secretsFilePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find app data directory: %w", err)
+	}
+
+	return filepath.Join(configDir, secretsDirName, secretsFilename), nil
+}
+
+// readSecrets returns every stored secret, still encrypted. assumes secretsLock is held
+func readSecrets() (map[string][]byte, error) {
+	secrets := map[string][]byte{}
+
+	path, err := secretsFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read secrets file: %w", err)
+	}
+
+	// byte slices come out of json as base64, which is just what's wanted here
+	if err := json.Unmarshal(raw, &secrets); err != nil {
+		return nil, fmt.Errorf("decode secrets file: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// writeSecrets replaces the secrets file with the given (encrypted) secrets. assumes secretsLock is held
+func writeSecrets(secrets map[string][]byte) error {
+	path, err := secretsFilePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("create secrets directory: %w", err)
+	}
+
+	raw, err := json.MarshalIndent(secrets, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode secrets file: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, raw, 0600); err != nil {
+		return fmt.Errorf("write secrets file: %w", err)
+	}
+
+	return nil
+}
+
+func getSecret(name string) (string, error) {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	value, err := unprotectData(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret: %w", err)
+	}
+
+	return string(value), nil
+}
+
+func setSecret(name string, value string) error {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := protectData([]byte(value))
+	if err != nil {
+		return fmt.Errorf("encrypt secret: %w", err)
+	}
+
+	secrets[name] = encrypted
+
+	return writeSecrets(secrets)
+}
+
+func deleteSecret(name string) error {
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := secrets[name]; !ok {
+		return ErrSecretNotFound
+	}
+
+	delete(secrets, name)
+
+	return writeSecrets(secrets)
+}
+
+func openAppWindow(logger *zap.SugaredLogger, url string, width int, height int) error {
+
+	// edge comes with every windows version deej supports, and can open a page as a standalone app window
+	edge := exec.Command("cmd.exe", "/C", "start", "", "msedge",
+		"--app="+url, fmt.Sprintf("--window-size=%d,%d", width, height))
+
+	err := edge.Run()
+	if err == nil {
+		return nil
+	}
+
+	logger.Debugw("Failed to open app window, opening a regular browser", "error", err)
+
+	if err := exec.Command("cmd.exe", "/C", "start", "", url).Run(); err != nil {
+		return fmt.Errorf("open browser: %w", err)
+	}
+
+	return nil
+}
+
+func setWindowAlwaysOnTop(title string) error {
+	titlePtr, err := syscall.UTF16PtrFromString(title)
+	if err != nil {
+		return fmt.Errorf("convert window title: %w", err)
+	}
+
+	hwnd := win.FindWindow(nil, titlePtr)
+	if hwnd == 0 {
+		return fmt.Errorf("no window titled %q", title)
+	}
+
+	if !win.SetWindowPos(hwnd, win.HWND_TOPMOST, 0, 0, 0, 0, win.SWP_NOMOVE|win.SWP_NOSIZE|win.SWP_NOACTIVATE) {
+		return errors.New("SetWindowPos failed")
+	}
+
+	return nil
+}
+
+// pipeListener accepts clients on a named pipe. every client gets its own instance of the pipe,
+// and there's always one instance waiting for the next client
+type pipeListener struct {
+	name string
+
+	lock   sync.Mutex
+	next   syscall.Handle
+	closed bool
+}
+
+// pipeConn is a single client's instance of the pipe
+type pipeConn struct {
+	*os.File
+	handle syscall.Handle
+	addr   pipeAddr
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a pipeAddr) String() string {
+	return string(a)
+}
+
+func listenLocal(path string) (net.Listener, error) {
+
+	// only the first instance may be created with this flag, so it fails if another deej is listening already
+	handle, err := createPipeInstance(path, fileFlagFirstPipeInstance)
+	if err != nil {
+		if errors.Is(err, syscall.ERROR_ACCESS_DENIED) {
+			return nil, fmt.Errorf("%s is already in use", path)
+		}
+
+		return nil, err
+	}
+
+	return &pipeListener{name: path, next: handle}, nil
+}
+
+func defaultLocalSocketPath() string {
+	return defaultPipeName
+}
+
+func createPipeInstance(name string, flags uint32) (syscall.Handle, error) {
+	namePtr, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, fmt.Errorf("convert pipe name: %w", err)
+	}
+
+	handle, _, err := procCreateNamedPipeW.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(pipeAccessDuplex|flags),
+		pipeRejectRemoteClients,
+		pipeUnlimitedInstances,
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0)
+
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return 0, fmt.Errorf("create named pipe: %w", err)
+	}
+
+	return syscall.Handle(handle), nil
+}
+
+// Accept waits for a client to connect to the waiting instance, and creates the next one
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	pl.lock.Lock()
+	handle := pl.next
+	pl.lock.Unlock()
+
+	if result, _, err := procConnectNamedPipe.Call(uintptr(handle), 0); result == 0 && err != errorPipeConnected {
+		return nil, fmt.Errorf("connect named pipe: %w", err)
+	}
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	// the client may well have been Close, letting this go
+	if pl.closed {
+		syscall.CloseHandle(handle)
+		return nil, errors.New("listener closed")
+	}
+
+	next, err := createPipeInstance(pl.name, 0)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	pl.next = next
+
+	return &pipeConn{File: os.NewFile(uintptr(handle), pl.name), handle: handle, addr: pipeAddr(pl.name)}, nil
+}
+
+// Close stops accepting clients. Accept is most likely waiting for one, so Close connects as one to let it go
+func (pl *pipeListener) Close() error {
+	pl.lock.Lock()
+	if pl.closed {
+		pl.lock.Unlock()
+		return nil
+	}
+
+	pl.closed = true
+	pl.lock.Unlock()
+
+	client, err := os.OpenFile(pl.name, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unblock pending accept: %w", err)
+	}
+
+	return client.Close()
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+	return pipeAddr(pl.name)
+}
+
+// Close disconnects the client first, since a blocked read would otherwise hold up closing the handle
+func (pc *pipeConn) Close() error {
+	procDisconnectNamedPipe.Call(uintptr(pc.handle))
+	return pc.File.Close()
+}
+
+func (pc *pipeConn) LocalAddr() net.Addr {
+	return pc.addr
+}
+
+func (pc *pipeConn) RemoteAddr() net.Addr {
+	return pc.addr
+}
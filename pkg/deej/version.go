@@ -0,0 +1,53 @@
+package deej
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// BuildInfo describes the running build of deej, as injected by the build process (see the build scripts)
+type BuildInfo struct {
+	GitCommit  string
+	VersionTag string
+	BuildType  string
+	BuildDate  string
+}
+
+// Version returns a short version string such as "release-v0.9.10", or "dev-a1b2c3d" for untagged builds.
+// It's empty if the build process didn't inject any version info
+func (bi BuildInfo) Version() string {
+	if bi.BuildType == "" || (bi.VersionTag == "" && bi.GitCommit == "") {
+		return ""
+	}
+
+	identifier := bi.GitCommit
+	if bi.VersionTag != "" {
+		identifier = bi.VersionTag
+	}
+
+	return fmt.Sprintf("%s-%s", bi.BuildType, identifier)
+}
+
+// String returns everything there is to know about the build, on one line
+func (bi BuildInfo) String() string {
+	version := bi.Version()
+	if version == "" {
+		version = "unknown version"
+	}
+
+	description := version
+	if bi.GitCommit != "" {
+		description += fmt.Sprintf(", commit %s", bi.GitCommit)
+	}
+
+	if bi.BuildDate != "" {
+		description += fmt.Sprintf(", built %s", bi.BuildDate)
+	}
+
+	return fmt.Sprintf("deej %s (%s/%s)", description, runtime.GOOS, runtime.GOARCH)
+}
+
+// BuildInfo returns the build info deej was created with
+func (d *Deej) BuildInfo() BuildInfo {
+	return d.buildInfo
+}
@@ -0,0 +1,28 @@
+package deej
+
+import (
+	"fmt"
+
+	"github.com/omriharel/deej/pkg/deej/util"
+)
+
+// levels offered for each virtual slider in the tray menu, in percents
+var virtualSliderTrayLevels = []int{0, 25, 50, 75, 100}
+
+// SetSliderValue moves a slider from software, exactly as if its hardware channel had moved.
+// This is the only way to move virtual sliders, but works just as well for hardware ones
+func (d *Deej) SetSliderValue(sliderID string, value float32) error {
+	return d.injectSliderMove(SliderMoveEvent{SliderID: sliderID, PercentValue: value})
+}
+
+// injectSliderMove dispatches a slider event that didn't come from the board
+func (d *Deej) injectSliderMove(event SliderMoveEvent) error {
+	if _, err := d.configManager.getSliderMappingByKey(event.SliderID); err != nil {
+		return fmt.Errorf("get slider mapping: %w", err)
+	}
+
+	event.PercentValue = util.QuantizeScalar(event.PercentValue, d.configManager.getQuantizationStep())
+	d.serial.dispatchSliderMove(event)
+
+	return nil
+}
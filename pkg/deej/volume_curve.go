@@ -0,0 +1,200 @@
+package deej
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// volume curves: how far a slider is pushed isn't how loud it sounds, so a slider can have its position
+// shaped on the way to its sessions' volume. log rises quickly and levels off, exponential starts slow and
+// picks up towards the top (where linear leaves the bottom half of the travel nearly silent)
+const (
+	curveLinear      = "linear"
+	curveLog         = "log"
+	curveExponential = "exponential"
+)
+
+// VolumeCurve shapes a slider's position into its sessions' volume: either one of the named curves, or custom
+// points (position, volume) to draw straight lines between. In the config it's the curve's name
+// (i.e. `curve: log`), or a list of points (i.e. `curve: [[0, 0], [0.5, 0.2], [1, 1]]`)
+type VolumeCurve struct {
+	Name   string
+	Points [][2]float32
+}
+
+// UnmarshalYAML reads a curve from either its name or its points
+func (c *VolumeCurve) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&c.Points)
+	}
+
+	return value.Decode(&c.Name)
+}
+
+// MarshalYAML writes a curve back the way it was read, with points on a single line
+func (c VolumeCurve) MarshalYAML() (interface{}, error) {
+	if len(c.Points) == 0 {
+		return c.Name, nil
+	}
+
+	node := &yaml.Node{}
+	if err := node.Encode(c.Points); err != nil {
+		return nil, fmt.Errorf("encode curve points: %w", err)
+	}
+
+	node.Style = yaml.FlowStyle
+
+	return node, nil
+}
+
+func (c VolumeCurve) equals(other VolumeCurve) bool {
+	if c.Name != other.Name || len(c.Points) != len(other.Points) {
+		return false
+	}
+
+	for idx, point := range c.Points {
+		if point != other.Points[idx] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validate checks that the curve is one deej knows, and that custom points can be followed back from a volume
+// to a slider position: both within 0-1, with positions going up and volumes never going down
+func (c VolumeCurve) validate() error {
+	if len(c.Points) == 0 {
+		switch c.Name {
+		case "", curveLinear, curveLog, curveExponential:
+			return nil
+		}
+
+		return fmt.Errorf("unknown curve %s", c.Name)
+	}
+
+	if len(c.Points) < 2 {
+		return errors.New("a custom curve needs at least two points")
+	}
+
+	for idx, point := range c.Points {
+		if point[0] < 0 || point[0] > 1 || point[1] < 0 || point[1] > 1 {
+			return fmt.Errorf("point %d is outside of 0-1", idx)
+		}
+
+		if idx > 0 && (point[0] <= c.Points[idx-1][0] || point[1] < c.Points[idx-1][1]) {
+			return fmt.Errorf("point %d doesn't come after the one before it", idx)
+		}
+	}
+
+	return nil
+}
+
+// apply turns a slider position into the volume it stands for
+func (c VolumeCurve) apply(position float32) float32 {
+	if len(c.Points) > 0 {
+		return interpolate(c.Points, position, 0, 1)
+	}
+
+	switch c.Name {
+	case curveLog:
+		return float32(math.Log10(1 + 9*float64(position)))
+	case curveExponential:
+		return float32((math.Pow(10, float64(position)) - 1) / 9)
+	}
+
+	return position
+}
+
+// invert turns a volume back into the slider position that stands for it, for volumes that changed
+// outside of deej. a custom curve that's flat at that volume gives the first position that reaches it
+func (c VolumeCurve) invert(volume float32) float32 {
+	if len(c.Points) > 0 {
+		return interpolate(c.Points, volume, 1, 0)
+	}
+
+	switch c.Name {
+	case curveLog:
+		return float32((math.Pow(10, float64(volume)) - 1) / 9)
+	case curveExponential:
+		return float32(math.Log10(1 + 9*float64(volume)))
+	}
+
+	return volume
+}
+
+// interpolate draws straight lines between points, and reads the value at x from them. from and to pick which of
+// each point's coordinates are x and which are read. before the first point and after the last, it's flat
+func interpolate(points [][2]float32, x float32, from int, to int) float32 {
+	if x <= points[0][from] {
+		return points[0][to]
+	}
+
+	for idx := 1; idx < len(points); idx++ {
+		previous, point := points[idx-1], points[idx]
+		if x > point[from] {
+			continue
+		}
+
+		if point[from] == previous[from] {
+			return previous[to]
+		}
+
+		return previous[to] + (x-previous[from])/(point[from]-previous[from])*(point[to]-previous[to])
+	}
+
+	return points[len(points)-1][to]
+}
+
+// volume turns a slider position into the volume its sessions get: along the slider's curve,
+// then into its range
+func (sm SliderMapping) volume(position float32) float32 {
+	low, high := sm.volumeRange()
+
+	return low + sm.Curve.apply(position)*(high-low)
+}
+
+// position turns a session's volume back into the slider position it stands for. volumes outside of the
+// slider's range end up at either end of it
+func (sm SliderMapping) position(volume float32) float32 {
+	low, high := sm.volumeRange()
+
+	scaled := (volume - low) / (high - low)
+	if scaled < 0 {
+		scaled = 0
+	} else if scaled > 1 {
+		scaled = 1
+	}
+
+	return sm.Curve.invert(scaled)
+}
+
+func (sm SliderMapping) volumeRange() (float32, float32) {
+	if sm.Max == 0 {
+		return sm.Min, 1
+	}
+
+	return sm.Min, sm.Max
+}
+
+// validateVolumeShapes drops (and complains about) slider curves deej can't follow, leaving those sliders
+// linear, and ranges that don't make sense, leaving those sliders with the full range
+func validateVolumeShapes(logger *zap.SugaredLogger, mappings map[string]SliderMapping) {
+	for key, mapping := range mappings {
+		if err := mapping.Curve.validate(); err != nil {
+			logger.Warnw("Ignoring invalid slider curve, using linear", "slider", key, "error", err)
+			mapping.Curve = VolumeCurve{}
+		}
+
+		if low, high := mapping.volumeRange(); low < 0 || high > 1 || low >= high {
+			logger.Warnw("Ignoring invalid slider range, using 0-1", "slider", key, "min", mapping.Min, "max", mapping.Max)
+			mapping.Min, mapping.Max = 0, 0
+		}
+
+		mappings[key] = mapping
+	}
+}
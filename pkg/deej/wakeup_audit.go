@@ -0,0 +1,79 @@
+package deej
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// how often the wakeup audit reports (and resets) its counts
+const wakeupAuditInterval = time.Minute
+
+// wakeupAudit counts how often deej's background loops wake up, by source. It's an engineering aid:
+// an idle deej (nothing moving, nothing reloading) should report no wakeups at all, and anything
+// that does show up is a timer or loop that keeps the CPU from sleeping
+type wakeupAudit struct {
+	logger  *zap.SugaredLogger
+	enabled bool
+
+	lock   sync.Mutex
+	counts map[string]int
+}
+
+func newWakeupAudit(logger *zap.SugaredLogger, enabled bool) *wakeupAudit {
+	logger = logger.Named("wakeups")
+
+	wa := &wakeupAudit{
+		logger:  logger,
+		enabled: enabled,
+		counts:  map[string]int{},
+	}
+
+	logger.Debugw("Created wakeup audit instance", "enabled", enabled)
+
+	return wa
+}
+
+// record counts a single wakeup of the given source. it does nothing unless the audit is enabled
+func (wa *wakeupAudit) record(source string) {
+	if wa == nil || !wa.enabled {
+		return
+	}
+
+	wa.lock.Lock()
+	defer wa.lock.Unlock()
+
+	wa.counts[source]++
+}
+
+// run logs the wakeups per minute for as long as deej runs. the audit's own timer is the one
+// wakeup it doesn't count, and it only exists while the audit is enabled
+func (wa *wakeupAudit) run() {
+	if !wa.enabled {
+		return
+	}
+
+	wa.logger.Info("Auditing background wakeups, leave deej idle to see what keeps it busy")
+
+	ticker := time.NewTicker(wakeupAuditInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		wa.lock.Lock()
+		counts := wa.counts
+		wa.counts = map[string]int{}
+		wa.lock.Unlock()
+
+		total := 0
+		for _, count := range counts {
+			total += count
+		}
+
+		wa.logger.Infow("Wakeups in the last minute",
+			"total", total,
+			"bySource", counts,
+			"goroutines", runtime.NumGoroutine())
+	}
+}
@@ -0,0 +1,262 @@
+package deej
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// where boards connect to, i.e. ws://192.168.1.10:8765/deej
+const websocketPath = "/deej"
+
+// websocketTransport talks to a single board over a WebSocket connection it opened to deej.
+// Boards send the same lines as they would over serial, one or more per text message
+type websocketTransport struct {
+	conn     *websocket.Conn
+	name     string
+	deviceID string
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newWebsocketTransport(conn *websocket.Conn, deviceID string) *websocketTransport {
+	return &websocketTransport{
+		conn:     conn,
+		name:     "ws:" + conn.RemoteAddr().String(),
+		deviceID: deviceID,
+		closed:   make(chan struct{}),
+	}
+}
+
+// Connect does nothing, the board is connected by the time there's a transport for it
+func (wt *websocketTransport) Connect() error {
+	return nil
+}
+
+func (wt *websocketTransport) ReadLines(lines chan<- TransportLine) error {
+	for {
+		messageType, message, err := wt.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		readAt := time.Now()
+
+		if messageType != websocket.TextMessage {
+			continue
+		}
+
+		// every line is handed over with its LF, like a serial board would send it
+		for _, line := range strings.SplitAfter(string(message), "\n") {
+			if line == "" {
+				continue
+			}
+
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+
+			lines <- TransportLine{Text: line, ReadAt: readAt}
+		}
+	}
+}
+
+func (wt *websocketTransport) Write(data []byte) (int, error) {
+	if err := wt.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// Close closes the connection. it's safe to call more than once, which happens when deej closes
+// the connection and the board's read loop notices
+func (wt *websocketTransport) Close() error {
+	var err error
+
+	wt.closeOnce.Do(func() {
+		err = wt.conn.Close()
+		close(wt.closed)
+	})
+
+	return err
+}
+
+func (wt *websocketTransport) Name() string {
+	return wt.name
+}
+
+func (wt *websocketTransport) DeviceID() (string, error) {
+	if wt.deviceID == "" {
+		return "", errors.New("board didn't pass an id")
+	}
+
+	return wt.deviceID, nil
+}
+
+// websocketServer accepts boards over the network, i.e. ESP32 and ESP8266 based ones on Wi-Fi.
+// Every board gets its own SerialIO, delivering its slider events along with the main board's
+type websocketServer struct {
+	deej     *Deej
+	logger   *zap.SugaredLogger
+	server   *http.Server
+	upgrader websocket.Upgrader
+
+	boardsLock sync.Mutex
+	boards     map[*SerialIO]bool
+}
+
+func newWebsocketServer(deej *Deej, logger *zap.SugaredLogger) *websocketServer {
+	logger = logger.Named("websocket")
+
+	ws := &websocketServer{
+		deej:   deej,
+		logger: logger,
+		boards: map[*SerialIO]bool{},
+
+		upgrader: websocket.Upgrader{CheckOrigin: checkBoardOrigin},
+	}
+
+	logger.Debug("Created websocket server instance")
+
+	return ws
+}
+
+// checkBoardOrigin lets boards in, which aren't browsers and don't send an origin, but keeps out web pages
+// from elsewhere: anything the machine's browser has open could otherwise connect and pose as a board
+func checkBoardOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+
+	switch originURL.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+
+	return false
+}
+
+// start accepts boards on the configured address, until stop is called. it does nothing without an address
+func (ws *websocketServer) start(settings WebSocket) error {
+	if settings.Listen == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", settings.Listen)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", settings.Listen, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(websocketPath, ws.handleBoard)
+
+	ws.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := ws.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			ws.logger.Warnw("Websocket server stopped unexpectedly", "error", err)
+		}
+	}()
+
+	ws.logger.Infow("Accepting boards over websocket",
+		"address", listener.Addr().String(),
+		"path", websocketPath,
+		"token", settings.Token != "")
+
+	return nil
+}
+
+// stop disconnects all boards and stops accepting new ones
+func (ws *websocketServer) stop() {
+	if ws.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+
+	// connections that were upgraded aren't the http server's anymore, so they're closed separately
+	if err := ws.server.Shutdown(ctx); err != nil {
+		ws.logger.Warnw("Failed to shut down websocket server", "error", err)
+	}
+
+	ws.boardsLock.Lock()
+	defer ws.boardsLock.Unlock()
+
+	for board := range ws.boards {
+		board.Stop()
+	}
+}
+
+// handleBoard upgrades a board's request and handles its lines for as long as it stays connected.
+// boards can pass their ID as an "id" query parameter, or introduce themselves later like serial boards do
+func (ws *websocketServer) handleBoard(w http.ResponseWriter, r *http.Request) {
+	if !ws.authorized(r) {
+		ws.logger.Warnw("Refused board with wrong token", "remote", r.RemoteAddr)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ws.logger.Debugw("Failed to upgrade board connection", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+
+	transport := newWebsocketTransport(conn, r.URL.Query().Get("id"))
+	board := newBoardIO(ws.deej, ws.logger, ws.deej.serial)
+
+	if err := board.StartTransport(transport); err != nil {
+		ws.logger.Warnw("Failed to start board", "remote", r.RemoteAddr, "error", err)
+		transport.Close()
+		return
+	}
+
+	ws.boardsLock.Lock()
+	ws.boards[board] = true
+	ws.boardsLock.Unlock()
+
+	<-transport.closed
+
+	ws.boardsLock.Lock()
+	delete(ws.boards, board)
+	ws.boardsLock.Unlock()
+}
+
+// authorized checks the board's token, if the config asks for one
+func (ws *websocketServer) authorized(r *http.Request) bool {
+	expected := ws.deej.configManager.getWebSocket().Token
+	if expected == "" {
+		return true
+	}
+
+	expected, err := resolveSecret(expected)
+	if err != nil {
+		ws.logger.Warnw("Failed to resolve websocket token", "error", err)
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(r.URL.Query().Get("token"))) == 1
+}
@@ -0,0 +1,323 @@
+package deej
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+
+	// widgets say hello at least this often to keep getting the state, so one that's gone (i.e. Rainmeter was
+	// closed without saying bye) isn't sent to forever
+	widgetSubscriptionTimeout = time.Minute
+
+	// widgets redraw on every message, and don't need every step of a moving slider to do it
+	widgetMinInterval = 50 * time.Millisecond
+
+	// the longest datagram deej reads, which is plenty for a handful of commands
+	widgetMaxDatagramSize = 4096
+
+	// there's rarely more than one or two widgets, and every one of them gets a datagram on every change
+	widgetMaxSubscribers = 16
+)
+
+// widgetBridge is a two-way mixer for desktop widgets (i.e. a Rainmeter skin), over UDP. A widget sends "hello"
+// (or "hello:<token>", with a token in the config) and gets deej's state in return, the same lines board feedback
+// sends by default ("sel:master", then "vol:master:50:0" per slider), and again whenever it changes. It sends
+// "volume:<slider>:<0-100>" and "mute:<slider>[:<0|1>]" to change things, and "bye" when it's done. With a token,
+// only widgets that said hello with it can change things. A datagram may hold several lines
+type widgetBridge struct {
+	deej   *Deej
+	logger *zap.SugaredLogger
+
+	conn        *net.UDPConn
+	stopChannel chan bool
+
+	// the widgets that said hello, by their address, and when they last did
+	subscribersLock sync.Mutex
+	subscribers     map[string]*widgetSubscriber
+}
+
+type widgetSubscriber struct {
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
+func newWidgetBridge(deej *Deej, logger *zap.SugaredLogger) *widgetBridge {
+	logger = logger.Named("widget")
+
+	wb := &widgetBridge{
+		deej:        deej,
+		logger:      logger,
+		stopChannel: make(chan bool),
+		subscribers: map[string]*widgetSubscriber{},
+	}
+
+	logger.Debug("Created widget bridge instance")
+
+	return wb
+}
+
+// start takes datagrams from widgets and sends them the state, until stop is called. it does nothing
+// without an address to listen on
+func (wb *widgetBridge) start(settings Widget) error {
+	if settings.Listen == "" {
+		return nil
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", settings.Listen)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", settings.Listen, err)
+	}
+
+	// widgets can't be told apart by anything but their token, and anyone on the network could change volumes
+	if !addr.IP.IsLoopback() && settings.Token == "" {
+		return fmt.Errorf("refusing to listen on %s without a token, only loopback addresses can do without one", settings.Listen)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", settings.Listen, err)
+	}
+
+	wb.conn = conn
+
+	go wb.receive()
+	go wb.broadcast()
+
+	wb.logger.Infow("Accepting desktop widgets", "address", conn.LocalAddr())
+
+	return nil
+}
+
+// stop lets go of the socket, and of every widget with it
+func (wb *widgetBridge) stop() {
+	if wb.conn == nil {
+		return
+	}
+
+	close(wb.stopChannel)
+
+	if err := wb.conn.Close(); err != nil {
+		wb.logger.Warnw("Failed to close widget socket", "error", err)
+	}
+}
+
+func (wb *widgetBridge) receive() {
+	buf := make([]byte, widgetMaxDatagramSize)
+
+	for {
+		n, addr, err := wb.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-wb.stopChannel:
+			default:
+				wb.logger.Warnw("Stopped accepting desktop widgets", "error", err)
+			}
+
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				wb.handleLine(addr, line)
+			}
+		}
+	}
+}
+
+// handleLine acts on a single line from a widget
+func (wb *widgetBridge) handleLine(addr *net.UDPAddr, line string) {
+	parts := strings.Split(line, ":")
+
+	switch {
+	case line == "hello" || strings.HasPrefix(line, "hello:"):
+		if !wb.authorized(strings.TrimPrefix(strings.TrimPrefix(line, "hello"), ":")) {
+			wb.logger.Warnw("Refused widget with wrong token", "widget", addr)
+			return
+		}
+
+		if !wb.subscribe(addr) {
+			wb.logger.Warnw("Refused widget, too many are subscribed already", "widget", addr, "max", widgetMaxSubscribers)
+			return
+		}
+
+		// a widget says hello when it (re)loads, so it gets the state right away instead of on the next change
+		if message, err := wb.deej.feedback.render(defaultBoardFeedbackFormat); err == nil {
+			wb.send(addr, message)
+		}
+
+	case line == "bye":
+		wb.subscribersLock.Lock()
+		delete(wb.subscribers, addr.String())
+		wb.subscribersLock.Unlock()
+
+		wb.logger.Debugw("Widget unsubscribed", "widget", addr)
+
+	case (parts[0] == "volume" || parts[0] == "mute") && !wb.mayChange(addr):
+		wb.logger.Warnw("Refused command from widget that didn't say hello with the token", "widget", addr, "line", line)
+
+	case parts[0] == "volume" && len(parts) == 3:
+		percent, err := strconv.ParseFloat(parts[2], 32)
+		if err != nil || percent < 0 || percent > 100 {
+			wb.logger.Warnw("Got invalid volume command", "widget", addr, "line", line)
+			return
+		}
+
+		if err := wb.deej.SetSliderValue(parts[1], float32(percent/100)); err != nil {
+			wb.logger.Warnw("Failed to set slider volume", "slider", parts[1], "error", err)
+		}
+
+	case parts[0] == "mute" && (len(parts) == 2 || len(parts) == 3):
+		if len(parts) == 3 && parts[2] != "0" && parts[2] != "1" {
+			wb.logger.Warnw("Got invalid mute command", "widget", addr, "line", line)
+			return
+		}
+
+		mapping, err := wb.deej.configManager.getSliderMappingByKey(parts[1])
+		if err != nil {
+			wb.logger.Warnw("Got mute command for unknown slider", "widget", addr, "slider", parts[1])
+			return
+		}
+
+		// without a value, it's a mute button
+		if len(parts) == 2 || (parts[2] == "1") != mapping.Muted {
+			wb.deej.serial.toggleMute(wb.logger, parts[1])
+		}
+
+	default:
+		wb.logger.Warnw("Got unknown widget command", "widget", addr, "line", line)
+	}
+}
+
+// authorized checks the token a widget said hello with, if the config asks for one
+func (wb *widgetBridge) authorized(presented string) bool {
+	expected := wb.deej.configManager.getWidget().Token
+	if expected == "" {
+		return true
+	}
+
+	expected, err := resolveSecret(expected)
+	if err != nil {
+		wb.logger.Warnw("Failed to resolve widget token", "error", err)
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(presented)) == 1
+}
+
+// subscribe starts (or keeps) sending the state to a widget, unless there are too many already
+func (wb *widgetBridge) subscribe(addr *net.UDPAddr) bool {
+	wb.subscribersLock.Lock()
+	defer wb.subscribersLock.Unlock()
+
+	_, known := wb.subscribers[addr.String()]
+	if !known && len(wb.subscribers) >= widgetMaxSubscribers {
+		wb.forgetQuietSubscribers()
+
+		if len(wb.subscribers) >= widgetMaxSubscribers {
+			return false
+		}
+	}
+
+	wb.subscribers[addr.String()] = &widgetSubscriber{addr: addr, lastSeen: time.Now()}
+
+	if !known {
+		wb.logger.Debugw("Widget subscribed", "widget", addr)
+	}
+
+	return true
+}
+
+// mayChange tells whether a widget may change volumes and mutes. with a token in the config, only widgets
+// that said hello with it (recently) may
+func (wb *widgetBridge) mayChange(addr *net.UDPAddr) bool {
+	if wb.deej.configManager.getWidget().Token == "" {
+		return true
+	}
+
+	wb.subscribersLock.Lock()
+	defer wb.subscribersLock.Unlock()
+
+	subscriber, ok := wb.subscribers[addr.String()]
+
+	return ok && time.Since(subscriber.lastSeen) <= widgetSubscriptionTimeout
+}
+
+// broadcast sends the state to every widget whenever it changes, for as long as deej runs
+func (wb *widgetBridge) broadcast() {
+	changed, stopWatching := wb.deej.feedback.watch()
+	defer stopWatching()
+
+	var lastSent string
+
+	for {
+		select {
+		case <-wb.stopChannel:
+			return
+		case <-changed:
+		}
+
+		wb.deej.wakeups.record("widget")
+
+		message, err := wb.deej.feedback.render(defaultBoardFeedbackFormat)
+		if err != nil {
+			wb.logger.Warnw("Failed to render widget state", "error", err)
+			continue
+		}
+
+		// widgets that said hello since got this already
+		if message != lastSent {
+			for _, addr := range wb.activeSubscribers() {
+				wb.send(addr, message)
+			}
+
+			lastSent = message
+		}
+
+		// whatever changes meanwhile is picked up by the next message
+		select {
+		case <-wb.stopChannel:
+			return
+		case <-time.After(widgetMinInterval):
+		}
+	}
+}
+
+// activeSubscribers returns the widgets that said hello recently, and forgets about the rest
+func (wb *widgetBridge) activeSubscribers() []*net.UDPAddr {
+	wb.subscribersLock.Lock()
+	defer wb.subscribersLock.Unlock()
+
+	wb.forgetQuietSubscribers()
+
+	active := make([]*net.UDPAddr, 0, len(wb.subscribers))
+	for _, subscriber := range wb.subscribers {
+		active = append(active, subscriber.addr)
+	}
+
+	return active
+}
+
+// forgetQuietSubscribers unsubscribes the widgets that haven't said hello in a while. assumes the lock is held
+func (wb *widgetBridge) forgetQuietSubscribers() {
+	for key, subscriber := range wb.subscribers {
+		if time.Since(subscriber.lastSeen) > widgetSubscriptionTimeout {
+			wb.logger.Debugw("Widget went quiet, unsubscribing it", "widget", subscriber.addr)
+			delete(wb.subscribers, key)
+		}
+	}
+}
+
+func (wb *widgetBridge) send(addr *net.UDPAddr, message string) {
+	if _, err := wb.conn.WriteToUDP([]byte(message), addr); err != nil {
+		wb.logger.Debugw("Failed to send state to widget", "widget", addr, "error", err)
+	}
+}